@@ -80,6 +80,35 @@ func makeDocument(sessionObj *js.Object, docObj **js.Object) (built bool, err er
 	return built, err
 }
 
+// setNotice shows text in a notice above the editor, or hides the notice if
+// text is empty.
+func setNotice(text string) {
+	doc := js.Global.Get("document")
+	notice := doc.Call("getElementById", "notice")
+	if notice == nil {
+		if text == "" {
+			return
+		}
+		notice = doc.Call("createElement", "div")
+		notice.Set("id", "notice")
+		style := notice.Get("style")
+		style.Set("position", "fixed")
+		style.Set("top", "0")
+		style.Set("right", "0")
+		style.Set("zIndex", "10")
+		style.Set("padding", "0.5em 1em")
+		style.Set("background", "#ffd")
+		style.Set("border", "1px solid #cc9")
+		doc.Get("body").Call("appendChild", notice)
+	}
+	notice.Set("textContent", text)
+	if text == "" {
+		notice.Get("style").Set("display", "none")
+	} else {
+		notice.Get("style").Set("display", "block")
+	}
+}
+
 func main() {
 	// js.Debugger()
 
@@ -165,9 +194,34 @@ func main() {
 	apiEndPoint := aceDiv.Get("dataset").Get("vppApi")
 	vaporpadName := aceDiv.Get("dataset").Get("vppName")
 
-	state = ot.NewController(adapter, adapter)
+	// restore any state saved by an earlier session so that edits made
+	// while offline survive a reload
+	store := ace.LocalStorage("focus:" + vaporpadName.String())
+	if saved, err := store.LoadState(); err != nil || saved == nil {
+		state = ot.NewController(adapter, adapter)
+	} else if state, err = ot.RestoreController(saved, adapter, adapter); err != nil {
+		alert.String(fmt.Sprintf("unable to restore saved state: %s", err))
+		state = ot.NewController(adapter, adapter)
+	}
+	// controllers saved while recovering keep the base that they were
+	// recovering from, so recovering them again replays only local edits
+	if state.IsBroken() {
+		setNotice("resyncing...")
+		state.Recover()
+	}
+	state.AttachStore(store)
+	state.AttachSite(ace.Site("focus:site"))
+
+	// resync shows a notice and reopens the doc at a fresh revision; the
+	// controller replays our pending edits once the doc arrives.
+	var connSender *ace.ReconnectingSocketSender
+	resync := func() {
+		setNotice("resyncing...")
+		state.Recover()
+		connSender.Reopen()
+	}
 
-	connSender := ace.NewReconnectingSocketSender(apiEndPoint.String(), func(e *js.Object) {
+	connSender = ace.NewReconnectingSocketSender(apiEndPoint.String(), func(e *js.Object) {
 		m := msg.Msg{}
 
 		err := json.Unmarshal([]byte(e.Get("data").String()), &m)
@@ -182,18 +236,60 @@ func main() {
 			panic("unknown message")
 		case msg.C_OPEN_RESP:
 			adapter.AttachFd(m.Fd)
+			state.Resubmit()
 		case msg.C_WRITE_RESP:
-			state.OnServerAck(m.Rev, m.Ops)
+			adapter.Check(state.OnServerAck(m.Rev, m.Ops))
 		case msg.C_WRITE:
-			state.OnServerWrite(m.Rev, m.Ops)
+			recovering := state.IsBroken()
+			adapter.Check(state.OnServerWrite(m.Rev, m.Site, m.Ops))
+			if recovering && !state.IsBroken() {
+				setNotice("")
+			}
+		case msg.C_ERROR:
+			// the server rejected our write, so our state no longer
+			// matches the server's; resync instead of dying.
+			alert.String(fmt.Sprintf("server rejected write at rev %d: %s", m.Rev, m.Err))
+			resync()
+		case msg.C_RESYNC:
+			// our copy of the doc has diverged from the server's; reloading
+			// would only restore it, so resync instead.
+			resync()
 		}
 	}, func() msg.Msg {
 		return msg.Msg{
 			Cmd:  msg.C_OPEN,
 			Name: vaporpadName.String(),
 			Rev:  state.ServerRev(),
+			Site: state.Site(),
+			Seq:  state.Seq(),
 		}
 	})
 
 	adapter.AttachSocket(state, connSender)
+	adapter.AttachRecovery(func(err error) {
+		resync()
+	})
+
+	// route undo + redo through the controller instead of ACE's undo manager
+	commands := editor.Get("commands")
+	commands.Call("addCommand", map[string]interface{}{
+		"name": "undo",
+		"bindKey": map[string]interface{}{
+			"win": "Ctrl-Z",
+			"mac": "Command-Z",
+		},
+		"exec": func() {
+			adapter.Undo()
+		},
+	})
+	commands.Call("addCommand", map[string]interface{}{
+		"name": "redo",
+		"bindKey": map[string]interface{}{
+			"win": "Ctrl-Shift-Z|Ctrl-Y",
+			"mac": "Command-Shift-Z|Command-Y",
+		},
+		"exec": func() {
+			adapter.Redo()
+		},
+	})
 }
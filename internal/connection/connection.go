@@ -4,13 +4,22 @@
 package connection
 
 import (
+	"encoding/json"
 	"sync"
 	"time"
 
+	"github.com/juju/errors"
+
 	im "github.com/mstone/focus/internal/msgs"
 	"github.com/mstone/focus/msg"
+	"github.com/mstone/focus/ot"
+	"github.com/mstone/focus/ot/interop"
 )
 
+// codecWindow is the number of revs before the latest at which codec
+// clients may still write.
+const codecWindow = 256
+
 type WebSocket interface {
 	ReadJSON(v interface{}) error
 	WriteJSON(v interface{}) error
@@ -29,9 +38,32 @@ type conn struct {
 	fds    map[chan interface{}]int
 	srvr   chan interface{}
 	nextFd int
+	// codec, if non-nil, encodes and decodes the ops of the client's
+	// messages, which it converts with the help of texts.
+	codec interop.Codec
+	texts map[int]*mirror
+}
+
+// mirror holds the text of a doc open on a codec conn at each rev that the
+// client may write at.
+type mirror struct {
+	rev  int
+	revs map[int]*ot.Doc
+}
+
+// codecMsg is a msg.Msg whose ops are encoded by a codec.
+type codecMsg struct {
+	msg.Msg
+	Ops json.RawMessage `json:",omitempty"`
 }
 
 func New(srvr chan interface{}, ws WebSocket) chan interface{} {
+	return NewCodec(srvr, ws, nil)
+}
+
+// NewCodec is like New, but the client's ops are encoded by codec, if it is
+// non-nil. Codec clients may only open text docs, at rev 0.
+func NewCodec(srvr chan interface{}, ws WebSocket, codec interop.Codec) chan interface{} {
 	c := &conn{
 		mu:     sync.Mutex{},
 		msgs:   make(chan interface{}),
@@ -40,6 +72,8 @@ func New(srvr chan interface{}, ws WebSocket) chan interface{} {
 		fds:    map[chan interface{}]int{},
 		srvr:   srvr,
 		nextFd: 0,
+		codec:  codec,
+		texts:  map[int]*mirror{},
 	}
 	go c.readLoop()
 	go c.writeLoop()
@@ -85,11 +119,121 @@ func (c *conn) setDoc(fd int, doc chan interface{}) {
 	c.fds[doc] = fd
 }
 
+// decode returns the ops of a codec client's write at rev to the doc open
+// on fd.
+func (c *conn) decode(fd int, rev int, data []byte) (ot.Ops, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	mr, ok := c.texts[fd]
+	if !ok {
+		return nil, errors.Errorf("bad codec write fd: %d", fd)
+	}
+	d, ok := mr.revs[rev]
+	if !ok {
+		return nil, errors.Errorf("bad codec write rev; rev: %d, latest rev: %d", rev, mr.rev)
+	}
+	// clients write at increasing revs, so earlier revs are no longer needed
+	for r := range mr.revs {
+		if r < rev {
+			delete(mr.revs, r)
+		}
+	}
+	text, err := interop.TextOf(d)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	ops, err := c.codec.Decode(text, data)
+	return ops, errors.Trace(err)
+}
+
+// encode advances the mirror of the doc open on fd to rev by applying ops
+// and returns ops, encoded for a codec client.
+func (c *conn) encode(fd int, rev int, ops ot.Ops) (json.RawMessage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	mr, ok := c.texts[fd]
+	if !ok {
+		return nil, errors.Errorf("bad codec fd: %d", fd)
+	}
+	d := mr.revs[mr.rev].Snapshot()
+	text, err := interop.TextOf(d)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := d.Apply(ops); err != nil {
+		return nil, errors.Trace(err)
+	}
+	mr.revs[rev] = d
+	mr.rev = rev
+	for r := range mr.revs {
+		if r < rev-codecWindow {
+			delete(mr.revs, r)
+		}
+	}
+	data, err := c.codec.Encode(text, ops)
+	return data, errors.Trace(err)
+}
+
+// write writes m to the client, encoding its ops with c's codec, if any.
+func (c *conn) write(m msg.Msg) error {
+	if c.codec == nil || m.Cmd != msg.C_WRITE && m.Cmd != msg.C_WRITE_RESP {
+		return c.ws.WriteJSON(m)
+	}
+	data, err := c.encode(m.Fd, m.Rev, m.Ops)
+	if err != nil {
+		return c.ws.WriteJSON(msg.Msg{
+			Cmd: msg.C_ERROR,
+			Fd:  m.Fd,
+			Rev: m.Rev,
+			Err: err.Error(),
+		})
+	}
+	m.Ops = nil
+	return c.ws.WriteJSON(codecMsg{Msg: m, Ops: data})
+}
+
+// read reads a message from the client, decoding the ops of its writes with
+// c's codec, if any.
+func (c *conn) read() (msg.Msg, error) {
+	if c.codec == nil {
+		m := msg.Msg{}
+		err := c.ws.ReadJSON(&m)
+		return m, err
+	}
+	cm := codecMsg{}
+	if err := c.ws.ReadJSON(&cm); err != nil {
+		return msg.Msg{}, err
+	}
+	m := cm.Msg
+	// codec clients can't compute the hashes of docs
+	m.Hash = ""
+	if m.Cmd == msg.C_WRITE {
+		ops, err := c.decode(m.Fd, m.Rev, cm.Ops)
+		if err != nil {
+			return m, errors.Annotatef(err, "bad codec write")
+		}
+		m.Ops = ops
+	}
+	return m, nil
+}
+
 func (c *conn) onVppOpen(m msg.Msg) {
+	if c.codec != nil && (m.Rev != 0 || m.Type != "" && m.Type != msg.DT_TEXT) {
+		c.msgs <- im.Openresp{
+			Err:  errors.Errorf("codec clients may only open text docs at rev 0"),
+			Fd:   c.allocFd(),
+			Name: m.Name,
+		}
+		return
+	}
+
 	srvrReplyChan := make(chan im.Allocdocresp)
 	c.srvr <- im.Allocdoc{
 		Reply: srvrReplyChan,
 		Name:  m.Name,
+		Type:  m.Type,
 	}
 
 	srvrResp := <-srvrReplyChan
@@ -100,10 +244,18 @@ func (c *conn) onVppOpen(m msg.Msg) {
 	fd := c.allocFd()
 	doc := srvrResp.Doc
 	c.setDoc(fd, doc)
+	if c.codec != nil {
+		c.mu.Lock()
+		c.texts[fd] = &mirror{revs: map[int]*ot.Doc{0: ot.NewDoc()}}
+		c.mu.Unlock()
+	}
 
 	doc <- im.Open{
 		Conn: c.msgs,
 		Name: m.Name,
+		Type: m.Type,
+		Site: m.Site,
+		Seq:  m.Seq,
 		Fd:   fd,
 		Rev:  m.Rev,
 	}
@@ -118,15 +270,28 @@ func (c *conn) onVppWrite(m msg.Msg) {
 		Conn: c.msgs,
 		Rev:  m.Rev,
 		Hash: m.Hash,
+		Seq:  m.Seq,
 		Ops:  m.Ops.Clone(),
+		Json: m.Json.Clone(),
 	}
 }
 
 func (c *conn) readLoop() {
 	for {
-		m := msg.Msg{}
-
-		if err := c.ws.ReadJSON(&m); err != nil {
+		m, err := c.read()
+		if m.Cmd == msg.C_WRITE && err != nil {
+			doc, ok := c.getDoc(m.Fd)
+			if !ok {
+				panic("conn got WRITE with bad fd")
+			}
+			c.msgs <- im.Writeresp{
+				Doc: doc,
+				Rev: m.Rev,
+				Err: err,
+			}
+			continue
+		}
+		if err != nil {
 			c.Close() // BUG(mistone): errcheck?
 			return
 		}
@@ -146,9 +311,19 @@ func (c *conn) writeLoop() {
 	for m := range c.msgs {
 		switch v := m.(type) {
 		case im.Openresp:
+			if v.Err != nil {
+				c.ws.WriteJSON(msg.Msg{
+					Cmd:  msg.C_ERROR,
+					Name: v.Name,
+					Fd:   v.Fd,
+					Err:  v.Err.Error(),
+				})
+				continue
+			}
 			c.ws.WriteJSON(msg.Msg{
 				Cmd:  msg.C_OPEN_RESP,
 				Name: v.Name,
+				Type: v.Type,
 				Fd:   v.Fd,
 			})
 		case im.Writeresp:
@@ -156,22 +331,44 @@ func (c *conn) writeLoop() {
 			if !ok {
 				panic("conn got WRITERESP with bad doc")
 			}
+			if v.Err != nil {
+				c.ws.WriteJSON(msg.Msg{
+					Cmd: msg.C_ERROR,
+					Fd:  fd,
+					Rev: v.Rev,
+					Err: v.Err.Error(),
+				})
+				continue
+			}
+			c.write(msg.Msg{
+				Cmd:  msg.C_WRITE_RESP,
+				Fd:   fd,
+				Rev:  v.Rev,
+				Ops:  v.Ops.Clone(),
+				Json: v.Json.Clone(),
+			})
+		case im.Resync:
+			fd, ok := c.getFd(v.Doc)
+			if !ok {
+				panic("conn got RESYNC with bad doc")
+			}
 			c.ws.WriteJSON(msg.Msg{
-				Cmd: msg.C_WRITE_RESP,
+				Cmd: msg.C_RESYNC,
 				Fd:  fd,
 				Rev: v.Rev,
-				Ops: v.Ops.Clone(),
 			})
 		case im.Write:
 			fd, ok := c.getFd(v.Doc)
 			if !ok {
 				panic("conn got WRITE with bad doc")
 			}
-			c.ws.WriteJSON(msg.Msg{
-				Cmd: msg.C_WRITE,
-				Fd:  fd,
-				Rev: v.Rev,
-				Ops: v.Ops.Clone(),
+			c.write(msg.Msg{
+				Cmd:  msg.C_WRITE,
+				Fd:   fd,
+				Rev:  v.Rev,
+				Site: v.Site,
+				Ops:  v.Ops.Clone(),
+				Json: v.Json.Clone(),
 			})
 		}
 	}
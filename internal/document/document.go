@@ -8,7 +8,9 @@ import (
 	log "gopkg.in/inconshreveable/log15.v2"
 
 	im "github.com/mstone/focus/internal/msgs"
+	"github.com/mstone/focus/msg"
 	"github.com/mstone/focus/ot"
+	"github.com/mstone/focus/ot/jsonot"
 )
 
 // struct doc represents a vaporpad (like a file)
@@ -17,21 +19,75 @@ type doc struct {
 	srvr    chan interface{}
 	store   chan interface{}
 	name    string
+	typ     string // msg.DT_TEXT or msg.DT_JSON
 	storeid int64
-	conns   map[chan interface{}]struct{}
+	conns   map[chan interface{}]*peer
 	hist    []ot.Ops
+	sites   []string       // sites[i] wrote rev i+1, of either hist or jhist
+	seqs    []int          // seqs[i] is the seq of the write at rev i+1, or 0
+	last    map[string]int // the rev of each site's latest write; see applied
 	comp    ot.Ops
+	revs    []*ot.Doc      // revs[i] is the body at rev i, or nil if evicted; see evict()
+	digests map[int]string // digests of recent revs, by rev; see digest()
+	spans   [][]ot.Span    // spans[l-1][i] composes hist[i<<l:(i+1)<<l]; see suffix()
+
+	// for docs of type msg.DT_JSON, the counterparts of hist, comp, and revs
+	jhist []jsonot.Ops
+	jcomp jsonot.Ops
+	jrevs []*jsonot.Doc
+}
+
+// peer is what a doc knows about one of its open conns.
+type peer struct {
+	site string
+	// catchup is the single write that caught the conn up from rev 0 to
+	// catchupRev when it opened, until the conn writes at a later rev; see
+	// transform.
+	catchup    ot.Ops
+	catchupRev int
 }
 
-func New(srvr chan interface{}, store chan interface{}, name string) (chan interface{}, error) {
+// digestWindow is the number of recent revs whose digests are kept for
+// checking the hashes of incoming writes.
+const digestWindow = 256
+
+// spanLevels is the number of levels of composed spans of hist that are
+// cached; the spans of the top level compose digestWindow writes apiece.
+const spanLevels = 8
+
+// normType returns the doc type named by typ, in which the empty type means
+// msg.DT_TEXT.
+func normType(typ string) string {
+	if typ == "" {
+		return msg.DT_TEXT
+	}
+	return typ
+}
+
+// New returns the msgs chan of a new doc actor for the doc called name,
+// loading its history from store. typ is the type of the doc to create if
+// store has no such doc; stored docs keep their stored type.
+func New(srvr chan interface{}, store chan interface{}, name string, typ string) (chan interface{}, error) {
+	typ = normType(typ)
+	if typ != msg.DT_TEXT && typ != msg.DT_JSON {
+		return nil, errors.Errorf("bad doc type: %q", typ)
+	}
 	d := &doc{
-		msgs:  make(chan interface{}),
-		srvr:  srvr,
-		store: store,
-		name:  name,
-		conns: map[chan interface{}]struct{}{},
-		hist:  []ot.Ops{},
-		comp:  ot.Ops{},
+		msgs:    make(chan interface{}),
+		srvr:    srvr,
+		store:   store,
+		name:    name,
+		typ:     typ,
+		conns:   map[chan interface{}]*peer{},
+		last:    map[string]int{},
+		spans:   make([][]ot.Span, spanLevels),
+		hist:    []ot.Ops{},
+		comp:    ot.Ops{},
+		revs:    []*ot.Doc{ot.NewDoc()},
+		digests: map[int]string{},
+		jhist:   []jsonot.Ops{},
+		jcomp:   jsonot.Ops{},
+		jrevs:   []*jsonot.Doc{jsonot.NewDoc()},
 	}
 	go d.readLoop()
 
@@ -47,20 +103,55 @@ func New(srvr chan interface{}, store chan interface{}, name string) (chan inter
 	}
 	if respLoad.Ok {
 		d.storeid = respLoad.StoreId
+		d.typ = normType(respLoad.Type)
+		for _, ops := range respLoad.JsonHistory {
+			err := d.push(ops)
+			if err != nil {
+				log.Error("unable to apply doc hist", "err", err)
+				return nil, err
+			}
+		}
 		d.hist = respLoad.History
-		for _, ops := range d.hist {
+		d.sites = respLoad.Sites
+		d.seqs = respLoad.Seqs
+		for len(d.sites) < d.rev() {
+			d.sites = append(d.sites, "")
+		}
+		for len(d.seqs) < len(d.sites) {
+			d.seqs = append(d.seqs, 0)
+		}
+		for i, site := range d.sites {
+			if site != "" {
+				d.last[site] = i + 1
+			}
+		}
+		for i, ops := range d.hist {
 			comp, err := ot.Compose(d.comp, ops)
 			if err != nil {
 				log.Error("unable to compose doc hist", "err", err)
 				return nil, err
 			}
 			d.comp = comp
+			body := d.body().Snapshot()
+			err = body.Apply(ops)
+			if err != nil {
+				log.Error("unable to apply doc hist", "err", err)
+				return nil, err
+			}
+			d.revs = append(d.revs, body)
+			d.evict()
+			err = d.addSpans(i + 1)
+			if err != nil {
+				log.Error("unable to compose doc hist", "err", err)
+				return nil, err
+			}
 		}
 	} else {
 		repl := make(chan im.Storedocresp, 1)
 		d.store <- im.Storedoc{
 			Reply: repl,
 			Name:  d.name,
+			Type:  d.typ,
 		}
 		resp := <-repl
 		if resp.Err != nil {
@@ -73,26 +164,173 @@ func New(srvr chan interface{}, store chan interface{}, name string) (chan inter
 	return d.msgs, nil
 }
 
+// body returns the current body of the doc.
+func (d *doc) body() *ot.Doc {
+	return d.revs[len(d.revs)-1]
+}
+
+// jbody returns the current body of a doc of type msg.DT_JSON.
+func (d *doc) jbody() *jsonot.Doc {
+	return d.jrevs[len(d.jrevs)-1]
+}
+
 func (d *doc) Body() string {
-	doc := ot.NewDoc()
-	doc.Apply(d.comp)
-	return doc.String()
+	if d.typ == msg.DT_JSON {
+		return d.jbody().String()
+	}
+	return d.body().String()
+}
+
+// bodyAt returns the body at rev, which must be at most d.rev() and recent
+// enough for its digest to be kept.
+func (d *doc) bodyAt(rev int) interface {
+	Hash() string
+	String() string
+} {
+	if d.typ == msg.DT_JSON {
+		return d.jrevs[rev]
+	}
+	return d.revs[rev]
+}
+
+// textAt returns the body at rev, which must be at most d.rev(), rebuilding
+// it from the history if it has been evicted.
+func (d *doc) textAt(rev int) (*ot.Doc, error) {
+	if body := d.revs[rev]; body != nil {
+		return body, nil
+	}
+	body := ot.NewDoc()
+	for i, ops := range d.hist[:rev] {
+		if err := body.Apply(ops); err != nil {
+			return nil, errors.Annotatef(err, "unable to rebuild rev %d", i+1)
+		}
+	}
+	return body, nil
 }
 
-func (d *doc) openDescription(fd int, clientRev int, conn chan interface{}) {
-	d.conns[conn] = struct{}{}
+// jsonAt is the counterpart of textAt for docs of type msg.DT_JSON.
+func (d *doc) jsonAt(rev int) (*jsonot.Doc, error) {
+	if body := d.jrevs[rev]; body != nil {
+		return body, nil
+	}
+	body := jsonot.NewDoc()
+	for i, ops := range d.jhist[:rev] {
+		if err := body.Apply(ops); err != nil {
+			return nil, errors.Annotatef(err, "unable to rebuild rev %d", i+1)
+		}
+	}
+	return body, nil
+}
+
+// evict drops the body of the rev that has just left the digest window.
+// Writes based on older revs are rare, so their bodies are rebuilt when
+// needed rather than kept forever.
+func (d *doc) evict() {
+	if r := len(d.revs) - 2 - digestWindow; r >= 0 {
+		d.revs[r] = nil
+	}
+	if r := len(d.jrevs) - 2 - digestWindow; r >= 0 {
+		d.jrevs[r] = nil
+	}
+}
+
+// addSpans caches the spans of hist that end at rev, which must be the
+// latest rev to have been added, and drops the spans below the top level that
+// have left the digest window.
+func (d *doc) addSpans(rev int) error {
+	for l := 1; l <= spanLevels && rev%(1<<l) == 0; l++ {
+		h := 1 << (l - 1)
+		s, err := d.span(l-1, rev-2*h).Append(d.span(l-1, rev-h))
+		if err != nil {
+			return errors.Annotatef(err, "unable to compose revs %d to %d", rev-2*h+1, rev)
+		}
+		d.spans[l-1] = append(d.spans[l-1], s)
+	}
+	if r := rev - digestWindow; r > 0 {
+		for l := 1; l < spanLevels && r%(1<<l) == 0; l++ {
+			d.spans[l-1][r>>l-1] = ot.Span{}
+		}
+	}
+	return nil
+}
+
+// span returns the span at level l of the writes after rev; see addSpans.
+func (d *doc) span(l, rev int) ot.Span {
+	if l == 0 {
+		return ot.NewSpan(d.hist[rev], d.sites[rev])
+	}
+	return d.spans[l-1][rev>>l]
+}
+
+// suffix returns the span of the writes after rev, composed of the largest
+// cached spans that fit. Writes based on revs in the digest window cost at
+// most two spans per level to compose.
+func (d *doc) suffix(rev int) (ot.Span, error) {
+	ret := ot.Span{}
+	for rev < len(d.hist) {
+		l := spanLevels
+		for ; l > 0; l-- {
+			if rev%(1<<l) == 0 && rev+(1<<l) <= len(d.hist) && d.span(l, rev).Len() > 0 {
+				break
+			}
+		}
+		var err error
+		ret, err = ret.Append(d.span(l, rev))
+		if err != nil {
+			return ot.Span{}, errors.Annotatef(err, "unable to compose revs after %d", rev)
+		}
+		rev += 1 << l
+	}
+	return ret, nil
+}
+
+// rev returns the current rev of the doc.
+func (d *doc) rev() int {
+	if d.typ == msg.DT_JSON {
+		return len(d.jhist)
+	}
+	return len(d.hist)
+}
+
+func (d *doc) openDescription(fd int, clientRev int, typ string, site string, seq int, conn chan interface{}) {
+	if typ = normType(typ); typ != d.typ {
+		conn <- im.Openresp{
+			Err:  errors.Errorf("doc type mismatch; requested: %q, doc: %q", typ, d.typ),
+			Doc:  d.msgs,
+			Fd:   fd,
+			Name: d.name,
+		}
+		return
+	}
+
+	p := &peer{site: site}
+	d.conns[conn] = p
 
 	// if serverRev < rev, panic?
-	serverRev := len(d.hist)
+	serverRev := d.rev()
 	opsForClient := ot.Ops{}
+	var jsonForClient jsonot.Ops
 	var err error
 
-	if clientRev == 0 {
-		opsForClient = d.comp.Clone()
-	} else {
-		if clientRev < serverRev {
-			opsForClient, err = ot.ComposeAll(d.hist[clientRev : serverRev-1])
+	// acked is the rev of the client's pending write, if we have applied it
+	acked := 0
+	if d.typ == msg.DT_TEXT && clientRev >= 0 && d.applied(site, clientRev, seq) {
+		acked = d.last[site]
+	}
+
+	switch {
+	case d.typ == msg.DT_JSON:
+		opsForClient = nil
+		jsonForClient = d.jcomp.Clone()
+		if clientRev != 0 && clientRev < serverRev {
+			jsonForClient, err = jsonot.ComposeAll(d.jhist[clientRev:serverRev])
 		}
+	case clientRev == 0 && acked == 0:
+		opsForClient = d.comp.Clone()
+		p.catchup = opsForClient
+		p.catchupRev = serverRev
+	case clientRev < 0 || clientRev > serverRev:
+		err = errors.Errorf("bad open rev; rev: %d, server rev: %d", clientRev, serverRev)
 	}
 
 	m := im.Openresp{
@@ -100,14 +338,42 @@ func (d *doc) openDescription(fd int, clientRev int, conn chan interface{}) {
 		Doc:  d.msgs,
 		Fd:   fd,
 		Name: d.name,
+		Type: d.typ,
 	}
 	conn <- m
 
-	if err == nil {
+	switch {
+	case err != nil:
+	case d.typ == msg.DT_TEXT && (clientRev != 0 || acked != 0) && clientRev < serverRev:
+		// SUBTLE: clients reopening at old revs may resubmit pending writes
+		// that we will transform over each later write in turn, ordering
+		// inserts by the writes' sites. So that the clients can order their
+		// pending inserts alike, they catch up one write at a time, each
+		// with its site. If we already applied the pending write, the
+		// client gets its ack in its place and we ignore the resubmitted
+		// copy; see applied.
+		for rev := clientRev; rev < serverRev; rev++ {
+			if rev+1 == acked {
+				conn <- im.Writeresp{
+					Doc: d.msgs,
+					Rev: acked,
+					Ops: d.hist[rev].Clone(),
+				}
+				continue
+			}
+			conn <- im.Write{
+				Doc:  d.msgs,
+				Rev:  rev + 1,
+				Site: d.sites[rev],
+				Ops:  d.hist[rev].Clone(),
+			}
+		}
+	default:
 		m2 := im.Write{
-			Doc: d.msgs,
-			Rev: serverRev,
-			Ops: opsForClient, // danger; commutativity violation?
+			Doc:  d.msgs,
+			Rev:  serverRev,
+			Ops:  opsForClient, // danger; commutativity violation?
+			Json: jsonForClient,
 		}
 		conn <- m2
 	}
@@ -119,58 +385,185 @@ func (d *doc) readLoop() {
 		default:
 			panic("doc read unknown message")
 		case im.Open:
-			d.openDescription(v.Fd, v.Rev, v.Conn)
+			d.openDescription(v.Fd, v.Rev, v.Type, v.Site, v.Seq, v.Conn)
 		case im.Readall:
 			v.Reply <- im.Readallresp{
 				Name: d.name,
 				Body: d.Body(),
-				Rev:  len(d.hist),
+				Rev:  d.rev(),
 			}
 		case im.Write:
-			// BUG(mistone): need to figure out how to handle transform errors!
-			rev, ops, _ := d.transform(v.Rev, v.Ops.Clone())
+			if d.typ == msg.DT_JSON {
+				d.onJsonWrite(v)
+				continue
+			}
+			p := d.peer(v.Conn)
+			if d.applied(p.site, v.Rev, v.Seq) {
+				log.Info("ignoring resubmitted write", "obj", "doc", "name", d.name, "rev", v.Rev, "site", p.site, "seq", v.Seq)
+				continue
+			}
+			if d.diverged(v.Rev, v.Hash, v.Ops) {
+				v.Conn <- im.Resync{
+					Doc: d.msgs,
+					Rev: len(d.hist),
+				}
+				continue
+			}
+			rev, ops, err := d.transform(v.Rev, p, v.Seq, v.Ops.Clone())
+			if err != nil {
+				log.Error("rejecting write", "obj", "doc", "name", d.name, "rev", v.Rev, "ops", v.Ops, "err", err)
+				v.Conn <- im.Writeresp{
+					Doc: d.msgs,
+					Rev: v.Rev,
+					Err: err,
+				}
+				continue
+			}
 			// BUG(mistone): need to figure out how to handle store write errors!
-			_ = d.record(v.Conn, rev, ops)
+			_ = d.record(v.Conn, rev, p.site, v.Seq, ops, nil)
 			// log15.Info("recv", "obj", "doc", "rev", v.Rev, "hash", v.Hash, "ops", v.Ops, "docrev", len(d.hist), "dochist", d.Body(), "nrev", rev, "tops", ops)
-			d.broadcast(v.Conn, rev, ops)
+			d.broadcast(v.Conn, rev, p.site, ops, nil)
 		}
 	}
 }
 
-func (d *doc) transform(rev int, clientOps ot.Ops) (int, ot.Ops, error) {
-	var err error
+// applied reports whether the write numbered seq by site, which the client
+// based on rev, is the latest write that d recorded for site, which d can
+// only have applied after rev. Clients resubmit their pending write with the
+// same seq when they reopen d, so such writes are duplicates.
+func (d *doc) applied(site string, rev int, seq int) bool {
+	if site == "" || seq == 0 {
+		return false
+	}
+	w, ok := d.last[site]
+	return ok && w > rev && d.seqs[w-1] == seq
+}
+
+// wrote records that site wrote the latest rev as its write numbered seq.
+func (d *doc) wrote(site string, seq int) {
+	d.sites = append(d.sites, site)
+	d.seqs = append(d.seqs, seq)
+	if site != "" {
+		d.last[site] = len(d.sites)
+	}
+}
+
+// peer returns what d knows about conn, which is nothing if conn has not
+// opened d.
+func (d *doc) peer(conn chan interface{}) *peer {
+	if p, ok := d.conns[conn]; ok {
+		return p
+	}
+	return &peer{}
+}
+
+// digest returns the digest of the body at rev, if rev is recent enough for
+// its digest to be kept.
+func (d *doc) digest(rev int) (string, bool) {
+	if rev < 0 || rev > d.rev() || rev < d.rev()-digestWindow {
+		return "", false
+	}
+	if h, ok := d.digests[rev]; ok {
+		return h, true
+	}
+	h := d.bodyAt(rev).Hash()
+	d.digests[rev] = h
+	for r := range d.digests {
+		if r < d.rev()-digestWindow {
+			delete(d.digests, r)
+		}
+	}
+	return h, true
+}
 
-	// extract concurrent ops
-	concurrentServerOps := []ot.Ops{}
-	if rev < len(d.hist) {
-		concurrentServerOps = d.hist[rev:]
+// diverged reports whether hash shows that the client that sent a write based
+// on rev holds a different body at rev than we do. Writes without hashes and
+// writes based on revs too old to have digests are given the benefit of the
+// doubt.
+func (d *doc) diverged(rev int, hash string, ops interface{}) bool {
+	if hash == "" {
+		return false
+	}
+	h, ok := d.digest(rev)
+	if !ok {
+		log.Warn("unable to check write hash", "obj", "doc", "name", d.name, "rev", rev, "docrev", d.rev())
+		return false
+	}
+	if h == hash {
+		return false
 	}
+	log.Error("diverged write", "obj", "doc", "name", d.name, "rev", rev, "docrev", d.rev(), "hash", hash, "dochash", h, "ops", ops)
+	log.Debug("diverged write body", "obj", "doc", "name", d.name, "rev", rev, "body", d.bodyAt(rev).String())
+	return true
+}
 
-	// BUG(mistone): ot.Transform DOES NOT CALCULATE PUSHOUTS.
-	// // compose concurrent ops
-	// serverOps := ot.Ops{}
-	// for _, concurrentOp := range concurrentServerOps {
-	// 	serverOps = ot.Compose(serverOps, concurrentOp)
-	// }
+// transform validates clientOps, written by the conn described by p as its
+// write numbered seq, against the body at rev, rebases them onto the current
+// body, and records the result.
+func (d *doc) transform(rev int, p *peer, seq int, clientOps ot.Ops) (int, ot.Ops, error) {
+	var err error
 
-	// // produce transformed ops
-	// forServer, _ := ot.Transform(clientOps, serverOps)
+	if rev < 0 || rev > len(d.hist) {
+		return 0, nil, errors.Errorf("bad write rev; rev: %d, server rev: %d", rev, len(d.hist))
+	}
+	base, err := d.textAt(rev)
+	if err != nil {
+		return 0, nil, errors.Trace(err)
+	}
+	err = base.Validate(clientOps)
+	if err != nil {
+		return 0, nil, errors.Annotatef(err, "invalid write at rev %d", rev)
+	}
 
-	clientOps2 := ot.Ops{}
-	for _, concurrentOp := range concurrentServerOps {
-		clientOps2, _, err = ot.Transform(clientOps, concurrentOp)
+	// SUBTLE: clients that open at rev 0 catch up in a single write, without
+	// a site, over which they rebase any writes they made before it arrived.
+	// Since rebasing over a composition can order inserts differently than
+	// rebasing over its parts, we rebase such writes over the same write.
+	switch {
+	case rev < p.catchupRev && rev != 0:
+		return 0, nil, errors.Errorf("bad write rev; rev: %d, caught up to rev: %d", rev, p.catchupRev)
+	case rev < p.catchupRev:
+		clientOps, _, err = ot.TransformSites(clientOps, p.catchup, p.site, "")
 		if err != nil {
 			return 0, nil, errors.Trace(err)
 		}
-		clientOps = clientOps2.Clone()
+		rev = p.catchupRev
+	case p.catchup != nil:
+		// the conn has seen the catch-up, so it will never write at rev 0
+		p.catchup = nil
+		p.catchupRev = 0
 	}
-	forServer := clientOps
 
-	// update history
-	d.hist = append(d.hist, forServer)
+	// rebase clientOps over the writes since rev, in a single transform
+	// where ot.TransformSpan can
+	concurrent, err := d.suffix(rev)
+	if err != nil {
+		return 0, nil, errors.Trace(err)
+	}
+	forServer, _, err := ot.TransformSpan(clientOps, p.site, concurrent, d.hist[rev:], d.sites[rev:len(d.hist)])
+	if err != nil {
+		return 0, nil, errors.Trace(err)
+	}
 
 	// update composed ops for new conns
-	d.comp, err = ot.Compose(d.comp, forServer)
+	comp, err := ot.Compose(d.comp, forServer)
+	if err != nil {
+		return 0, nil, errors.Trace(err)
+	}
+
+	body := d.body().Snapshot()
+	err = body.Apply(forServer)
+	if err != nil {
+		return 0, nil, errors.Trace(err)
+	}
+
+	// update history
+	d.hist = append(d.hist, forServer)
+	d.wrote(p.site, seq)
+	d.comp = comp
+	d.revs = append(d.revs, body)
+	d.evict()
+	err = d.addSpans(len(d.hist))
 	if err != nil {
 		return 0, nil, errors.Trace(err)
 	}
@@ -180,14 +573,95 @@ func (d *doc) transform(rev int, clientOps ot.Ops) (int, ot.Ops, error) {
 	return rev, forServer, nil
 }
 
-func (d *doc) record(conn chan interface{}, rev int, ops ot.Ops) error {
+// onJsonWrite handles a write to a doc of type msg.DT_JSON.
+func (d *doc) onJsonWrite(v im.Write) {
+	if d.diverged(v.Rev, v.Hash, v.Json) {
+		v.Conn <- im.Resync{
+			Doc: d.msgs,
+			Rev: d.rev(),
+		}
+		return
+	}
+	site := d.peer(v.Conn).site
+	rev, ops, err := d.transformJson(v.Rev, v.Json.Clone())
+	if err != nil {
+		log.Error("rejecting write", "obj", "doc", "name", d.name, "rev", v.Rev, "ops", v.Json, "err", err)
+		v.Conn <- im.Writeresp{
+			Doc: d.msgs,
+			Rev: v.Rev,
+			Err: err,
+		}
+		return
+	}
+	d.wrote(site, v.Seq)
+	_ = d.record(v.Conn, rev, site, v.Seq, nil, ops)
+	d.broadcast(v.Conn, rev, site, nil, ops)
+}
+
+// transformJson is the counterpart of transform for docs of type
+// msg.DT_JSON.
+func (d *doc) transformJson(rev int, clientOps jsonot.Ops) (int, jsonot.Ops, error) {
+	if rev < 0 || rev > len(d.jhist) {
+		return 0, nil, errors.Errorf("bad write rev; rev: %d, server rev: %d", rev, len(d.jhist))
+	}
+	if clientOps == nil {
+		clientOps = jsonot.Ops{}
+	}
+	base, err := d.jsonAt(rev)
+	if err != nil {
+		return 0, nil, errors.Trace(err)
+	}
+	err = base.Validate(clientOps)
+	if err != nil {
+		return 0, nil, errors.Annotatef(err, "invalid write at rev %d", rev)
+	}
+
+	for _, concurrentOps := range d.jhist[rev:] {
+		clientOps, _, err = jsonot.Transform(clientOps, concurrentOps)
+		if err != nil {
+			return 0, nil, errors.Trace(err)
+		}
+	}
+
+	err = d.push(clientOps)
+	if err != nil {
+		return 0, nil, errors.Trace(err)
+	}
+	return len(d.jhist), clientOps, nil
+}
+
+// push applies ops to the current body of a doc of type msg.DT_JSON and
+// appends them to its history.
+func (d *doc) push(ops jsonot.Ops) error {
+	comp, err := jsonot.Compose(d.jcomp, ops)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	next := d.jbody().Snapshot()
+	err = next.Apply(ops)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	d.jhist = append(d.jhist, ops)
+	d.jcomp = comp
+	d.jrevs = append(d.jrevs, next)
+	d.evict()
+	return nil
+}
+
+// record stores ops, or json for docs of type msg.DT_JSON, written by site as
+// its write numbered seq.
+func (d *doc) record(conn chan interface{}, rev int, site string, seq int, ops ot.Ops, json jsonot.Ops) error {
 	repl := make(chan im.Storewriteresp, 1)
 	d.store <- im.Storewrite{
 		Reply: repl,
 		DocId: d.storeid,
 		// AuthorId: ...
-		Rev: rev,
-		Ops: ops,
+		Rev:  rev,
+		Site: site,
+		Seq:  seq,
+		Ops:  ops,
+		Json: json,
 	}
 	resp := <-repl
 	if resp.Err != nil {
@@ -197,20 +671,23 @@ func (d *doc) record(conn chan interface{}, rev int, ops ot.Ops) error {
 	return nil
 }
 
-func (d *doc) broadcast(conn chan interface{}, rev int, ops ot.Ops) {
+func (d *doc) broadcast(conn chan interface{}, rev int, site string, ops ot.Ops, json jsonot.Ops) {
 	send := func(pconn chan interface{}) {
 		if pconn == conn {
 			m := im.Writeresp{
-				Doc: d.msgs,
-				Rev: rev,
-				Ops: ops.Clone(),
+				Doc:  d.msgs,
+				Rev:  rev,
+				Ops:  ops.Clone(),
+				Json: json.Clone(),
 			}
 			pconn <- m
 		} else {
 			m := im.Write{
-				Doc: d.msgs,
-				Rev: rev,
-				Ops: ops.Clone(),
+				Doc:  d.msgs,
+				Rev:  rev,
+				Site: site,
+				Ops:  ops.Clone(),
+				Json: json.Clone(),
 			}
 			pconn <- m
 		}
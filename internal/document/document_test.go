@@ -5,8 +5,431 @@ package document
 
 import (
 	"testing"
+
+	im "github.com/mstone/focus/internal/msgs"
+	"github.com/mstone/focus/msg"
+	"github.com/mstone/focus/ot"
+	"github.com/mstone/focus/ot/jsonot"
 )
 
 func TestDoc(t *testing.T) {
 
 }
+
+// fakeStore answers store requests without persisting anything.
+func fakeStore() chan interface{} {
+	store := make(chan interface{})
+	go func() {
+		for m := range store {
+			switch v := m.(type) {
+			case im.Loaddoc:
+				v.Reply <- im.Loaddocresp{Ok: false}
+			case im.Storedoc:
+				v.Reply <- im.Storedocresp{StoreId: 1}
+			case im.Storewrite:
+				v.Reply <- im.Storewriteresp{}
+			}
+		}
+	}()
+	return store
+}
+
+func TestRejectInvalidWrites(t *testing.T) {
+	d, err := New(nil, fakeStore(), "/invalid", msg.DT_TEXT)
+	if err != nil {
+		t.Fatalf("unable to create doc, err: %q", err)
+	}
+
+	conn := make(chan interface{}, 10)
+	d <- im.Open{Conn: conn, Name: "/invalid", Fd: 1, Rev: 0}
+	<-conn // Openresp
+	<-conn // Write
+
+	write := func(rev int, ops ot.Ops) im.Writeresp {
+		d <- im.Write{Conn: conn, Rev: rev, Ops: ops}
+		m := <-conn
+		resp, ok := m.(im.Writeresp)
+		if !ok {
+			t.Fatalf("expected Writeresp, got %#v", m)
+		}
+		return resp
+	}
+
+	resp := write(0, ot.Is("hello"))
+	if resp.Err != nil || resp.Rev != 1 {
+		t.Fatalf("expected valid write to be accepted, got rev: %d, err: %q", resp.Rev, resp.Err)
+	}
+
+	invalid := []struct {
+		Rev int
+		Ops ot.Ops
+	}{
+		{1, ot.C(ot.Rs(6))},
+		{1, ot.C(ot.Rs(2), ot.Ds(4))},
+		{1, ot.C(ot.Rs(4))},
+		{1, ot.C(ot.Rs(1), ot.Ws(nil), ot.Rs(3))},
+		{1, ot.Ops{ot.Op{Tag: ot.O_RETAIN, Size: -5}}},
+		{2, ot.C(ot.Rs(5))},
+		{-1, ot.C(ot.Rs(5))},
+	}
+	for idx, c := range invalid {
+		resp := write(c.Rev, c.Ops)
+		if resp.Err == nil {
+			t.Fatalf("write %d: expected error for rev: %d, ops: %s", idx, c.Rev, c.Ops)
+		}
+		t.Logf("write %d: rejected with err: %s", idx, resp.Err)
+	}
+
+	// writes based on old revs are still validated against those revs
+	resp = write(0, ot.Is("x"))
+	if resp.Err != nil || resp.Rev != 2 {
+		t.Fatalf("expected stale write to be accepted, got rev: %d, err: %q", resp.Rev, resp.Err)
+	}
+
+	reply := make(chan im.Readallresp, 1)
+	d <- im.Readall{Reply: reply}
+	ra := <-reply
+	if ra.Rev != 2 || ra.Body != "[x h e l l o]" {
+		t.Fatalf("unexpected doc state, rev: %d, body: %s", ra.Rev, ra.Body)
+	}
+}
+
+func TestResyncOnDivergence(t *testing.T) {
+	d, err := New(nil, fakeStore(), "/resync", msg.DT_TEXT)
+	if err != nil {
+		t.Fatalf("unable to create doc, err: %q", err)
+	}
+
+	conn := make(chan interface{}, 10)
+	d <- im.Open{Conn: conn, Name: "/resync", Fd: 1, Rev: 0}
+	<-conn // Openresp
+	<-conn // Write
+
+	d <- im.Write{Conn: conn, Rev: 0, Hash: ot.NewDoc().Hash(), Ops: ot.Is("hello")}
+	if resp, ok := (<-conn).(im.Writeresp); !ok || resp.Err != nil || resp.Rev != 1 {
+		t.Fatalf("expected write with matching hash to be accepted, got %#v", resp)
+	}
+
+	d <- im.Write{Conn: conn, Rev: 1, Hash: ot.NewDoc().Hash(), Ops: ot.C(ot.Rs(5), ot.Is("!"))}
+	m := <-conn
+	resync, ok := m.(im.Resync)
+	if !ok || resync.Rev != 1 {
+		t.Fatalf("expected Resync at rev 1 for diverged write, got %#v", m)
+	}
+
+	d <- im.Write{Conn: conn, Rev: 1, Ops: ot.C(ot.Rs(5), ot.Is("!"))}
+	if resp, ok := (<-conn).(im.Writeresp); !ok || resp.Err != nil || resp.Rev != 2 {
+		t.Fatalf("expected write without hash to be accepted, got %#v", resp)
+	}
+}
+
+func TestReopenAtRev(t *testing.T) {
+	d, err := New(nil, fakeStore(), "/reopen", msg.DT_TEXT)
+	if err != nil {
+		t.Fatalf("unable to create doc, err: %q", err)
+	}
+
+	conn := make(chan interface{}, 10)
+	d <- im.Open{Conn: conn, Name: "/reopen", Fd: 1, Rev: 0}
+	<-conn // Openresp
+	<-conn // Write
+
+	for rev, ops := range []ot.Ops{ot.Is("ab"), ot.C(ot.Rs(2), ot.Is("c")), ot.C(ot.Rs(3), ot.Is("d"))} {
+		d <- im.Write{Conn: conn, Rev: rev, Ops: ops}
+		if resp, ok := (<-conn).(im.Writeresp); !ok || resp.Err != nil {
+			t.Fatalf("expected write %d to be accepted, got %#v", rev, resp)
+		}
+	}
+
+	// a client reopening at rev 1 catches up on all of the later writes
+	conn2 := make(chan interface{}, 10)
+	d <- im.Open{Conn: conn2, Name: "/reopen", Fd: 1, Rev: 1}
+	if resp, ok := (<-conn2).(im.Openresp); !ok || resp.Err != nil {
+		t.Fatalf("expected Openresp, got %#v", resp)
+	}
+	doc := ot.NewDoc()
+	if err := doc.Apply(ot.Is("ab")); err != nil {
+		t.Fatalf("apply failed, err: %q", err)
+	}
+	// one write at a time, so that the client can transform any pending
+	// write of its own over each of them as we do
+	for _, rev := range []int{2, 3} {
+		m, ok := (<-conn2).(im.Write)
+		if !ok || m.Rev != rev {
+			t.Fatalf("expected Write at rev %d, got %#v", rev, m)
+		}
+		if err := doc.Apply(m.Ops); err != nil {
+			t.Fatalf("apply failed, err: %q", err)
+		}
+	}
+	if doc.String() != "[a b c d]" {
+		t.Fatalf("expected catch-up to [a b c d], got %s", doc.String())
+	}
+}
+
+func TestSiteOrder(t *testing.T) {
+	// concurrent inserts at the same position are ordered by site, whichever
+	// arrives first
+	for _, c := range []struct {
+		First, Second string
+		Expected      string
+	}{
+		{"a", "b", "[x y]"},
+		{"b", "a", "[y x]"},
+	} {
+		d, err := New(nil, fakeStore(), "/sites", msg.DT_TEXT)
+		if err != nil {
+			t.Fatalf("unable to create doc, err: %q", err)
+		}
+		conns := []chan interface{}{make(chan interface{}, 10), make(chan interface{}, 10)}
+		for i, site := range []string{c.First, c.Second} {
+			d <- im.Open{Conn: conns[i], Name: "/sites", Site: site, Fd: 1, Rev: 0}
+			<-conns[i] // Openresp
+			<-conns[i] // Write
+		}
+
+		d <- im.Write{Conn: conns[0], Rev: 0, Ops: ot.Is("x")}
+		<-conns[0] // Writeresp
+		if m, ok := (<-conns[1]).(im.Write); !ok || m.Site != c.First {
+			t.Fatalf("expected Write from site %q, got %#v", c.First, m)
+		}
+		d <- im.Write{Conn: conns[1], Rev: 0, Ops: ot.Is("y")}
+		<-conns[1] // Writeresp
+
+		reply := make(chan im.Readallresp, 1)
+		d <- im.Readall{Reply: reply}
+		if ra := <-reply; ra.Body != c.Expected {
+			t.Fatalf("expected %s with sites %q, %q, got %s", c.Expected, c.First, c.Second, ra.Body)
+		}
+	}
+}
+
+func TestJsonDoc(t *testing.T) {
+	d, err := New(nil, fakeStore(), "/json", msg.DT_JSON)
+	if err != nil {
+		t.Fatalf("unable to create doc, err: %q", err)
+	}
+
+	conn := make(chan interface{}, 10)
+	d <- im.Open{Conn: conn, Name: "/json", Type: msg.DT_TEXT, Fd: 1, Rev: 0}
+	if resp, ok := (<-conn).(im.Openresp); !ok || resp.Err == nil {
+		t.Fatalf("expected open with mismatched type to be rejected, got %#v", resp)
+	}
+
+	d <- im.Open{Conn: conn, Name: "/json", Type: msg.DT_JSON, Fd: 1, Rev: 0}
+	if resp, ok := (<-conn).(im.Openresp); !ok || resp.Err != nil || resp.Type != msg.DT_JSON {
+		t.Fatalf("expected open to be accepted, got %#v", resp)
+	}
+	<-conn // Write
+
+	write := func(rev int, ops jsonot.Ops) im.Writeresp {
+		d <- im.Write{Conn: conn, Rev: rev, Json: ops}
+		m := <-conn
+		resp, ok := m.(im.Writeresp)
+		if !ok {
+			t.Fatalf("expected Writeresp, got %#v", m)
+		}
+		return resp
+	}
+
+	resp := write(0, jsonot.Ops{jsonot.Set(jsonot.Path{"l"}, []interface{}{"a"})})
+	if resp.Err != nil || resp.Rev != 1 {
+		t.Fatalf("expected valid write to be accepted, got rev: %d, err: %q", resp.Rev, resp.Err)
+	}
+	if resp = write(1, jsonot.Ops{jsonot.Delete(jsonot.Path{"l", 1})}); resp.Err == nil {
+		t.Fatalf("expected invalid write to be rejected")
+	}
+	resp = write(1, jsonot.Ops{jsonot.Insert(jsonot.Path{"l", 0}, "b")})
+	if resp.Err != nil || resp.Rev != 2 {
+		t.Fatalf("expected valid write to be accepted, got rev: %d, err: %q", resp.Rev, resp.Err)
+	}
+	// concurrent with the insert above
+	resp = write(1, jsonot.Ops{jsonot.Insert(jsonot.Path{"l", 1}, "c")})
+	if resp.Err != nil || resp.Rev != 3 || resp.Json.String() != `[{"I":"c","P":["l",2]}]` {
+		t.Fatalf("expected transformed write, got rev: %d, ops: %s, err: %q", resp.Rev, resp.Json, resp.Err)
+	}
+
+	reply := make(chan im.Readallresp, 1)
+	d <- im.Readall{Reply: reply}
+	ra := <-reply
+	if ra.Rev != 3 || ra.Body != `{"l":["b","a","c"]}` {
+		t.Fatalf("unexpected doc state, rev: %d, body: %s", ra.Rev, ra.Body)
+	}
+
+	d <- im.Write{Conn: conn, Rev: 3, Hash: jsonot.Hash(map[string]interface{}{}), Json: jsonot.Ops{}}
+	if m, ok := (<-conn).(im.Resync); !ok || m.Rev != 3 {
+		t.Fatalf("expected Resync at rev 3 for diverged write, got %#v", m)
+	}
+}
+
+func TestCatchupOrder(t *testing.T) {
+	d, err := New(nil, fakeStore(), "/catchup", msg.DT_TEXT)
+	if err != nil {
+		t.Fatalf("unable to create doc, err: %q", err)
+	}
+	conn := make(chan interface{}, 10)
+	d <- im.Open{Conn: conn, Name: "/catchup", Site: "b", Fd: 1, Rev: 0}
+	<-conn // Openresp
+	<-conn // Write
+	d <- im.Write{Conn: conn, Rev: 0, Ops: ot.Is("x")}
+	<-conn // Writeresp
+
+	// a client at site "a" that opens at rev 0 catches up in a single write
+	// without a site, which it orders before its own pending "y"
+	conn2 := make(chan interface{}, 10)
+	d <- im.Open{Conn: conn2, Name: "/catchup", Site: "a", Fd: 1, Rev: 0}
+	<-conn2 // Openresp
+	if m, ok := (<-conn2).(im.Write); !ok || m.Rev != 1 || m.Site != "" {
+		t.Fatalf("expected catch-up Write at rev 1, got %#v", m)
+	}
+	d <- im.Write{Conn: conn2, Rev: 0, Ops: ot.Is("y")}
+	if resp, ok := (<-conn2).(im.Writeresp); !ok || resp.Err != nil || resp.Rev != 2 {
+		t.Fatalf("expected write to be accepted, got %#v", resp)
+	}
+	d <- im.Write{Conn: conn2, Rev: 2, Ops: ot.C(ot.Rs(2), ot.Is("z"))}
+	if resp, ok := (<-conn2).(im.Writeresp); !ok || resp.Err != nil || resp.Rev != 3 {
+		t.Fatalf("expected write to be accepted, got %#v", resp)
+	}
+
+	reply := make(chan im.Readallresp, 1)
+	d <- im.Readall{Reply: reply}
+	if ra := <-reply; ra.Body != "[x y z]" {
+		t.Fatalf("expected [x y z], got %s", ra.Body)
+	}
+}
+
+func TestOldRevs(t *testing.T) {
+	d, err := New(nil, fakeStore(), "/old", msg.DT_TEXT)
+	if err != nil {
+		t.Fatalf("unable to create doc, err: %q", err)
+	}
+
+	conn := make(chan interface{}, 10)
+	d <- im.Open{Conn: conn, Name: "/old", Fd: 1, Rev: 0}
+	<-conn // Openresp
+	<-conn // Write
+
+	write := func(rev int, ops ot.Ops) im.Writeresp {
+		d <- im.Write{Conn: conn, Rev: rev, Ops: ops}
+		resp, ok := (<-conn).(im.Writeresp)
+		if !ok {
+			t.Fatalf("expected Writeresp")
+		}
+		return resp
+	}
+
+	n := digestWindow + 10
+	for i := 0; i < n; i++ {
+		if resp := write(i, ot.NewInsert(i, i, "a")); resp.Err != nil {
+			t.Fatalf("write %d failed, err: %q", i, resp.Err)
+		}
+	}
+
+	// the body at rev 1 has been evicted, but writes based on it are still
+	// validated against it
+	if resp := write(1, ot.C(ot.Rs(2))); resp.Err == nil {
+		t.Fatalf("expected write of the wrong length to be rejected")
+	}
+	if resp := write(1, ot.NewInsert(1, 0, "x")); resp.Err != nil || resp.Rev != n+1 {
+		t.Fatalf("expected old write to be accepted, got rev: %d, err: %q", resp.Rev, resp.Err)
+	}
+}
+
+func TestResubmit(t *testing.T) {
+	d, err := New(nil, fakeStore(), "/resubmit", msg.DT_TEXT)
+	if err != nil {
+		t.Fatalf("unable to create doc, err: %q", err)
+	}
+
+	a, b := make(chan interface{}, 10), make(chan interface{}, 10)
+	d <- im.Open{Conn: a, Name: "/resubmit", Site: "a", Fd: 1, Rev: 0}
+	d <- im.Open{Conn: b, Name: "/resubmit", Site: "b", Fd: 1, Rev: 0}
+	for _, conn := range []chan interface{}{a, b} {
+		<-conn // Openresp
+		<-conn // Write
+	}
+
+	// a's first write is acked; b's write and a's second write are applied,
+	// but a loses the connection before it hears of them
+	d <- im.Write{Conn: a, Rev: 0, Seq: 1, Ops: ot.Is("ab")}
+	<-a // Writeresp
+	<-b // Write
+	d <- im.Write{Conn: b, Rev: 1, Seq: 1, Ops: ot.C(ot.Rs(2), ot.Is("c"))}
+	<-b // Writeresp
+	d <- im.Write{Conn: a, Rev: 1, Seq: 2, Ops: ot.C(ot.Is("x"), ot.Rs(2))}
+	<-b // Write
+
+	// reopening at rev 1 with the pending write's seq, a catches up on b's
+	// write and gets the ack of its own in its place
+	a2 := make(chan interface{}, 10)
+	d <- im.Open{Conn: a2, Name: "/resubmit", Site: "a", Seq: 2, Fd: 1, Rev: 1}
+	if resp, ok := (<-a2).(im.Openresp); !ok || resp.Err != nil {
+		t.Fatalf("expected Openresp, got %#v", resp)
+	}
+	if m, ok := (<-a2).(im.Write); !ok || m.Rev != 2 || m.Site != "b" {
+		t.Fatalf("expected Write from b at rev 2, got %#v", m)
+	}
+	if m, ok := (<-a2).(im.Writeresp); !ok || m.Rev != 3 || m.Ops.String() != ot.C(ot.Is("x"), ot.Rs(3)).String() {
+		t.Fatalf("expected Writeresp at rev 3, got %#v", m)
+	}
+
+	// the resubmitted write is ignored rather than applied twice
+	d <- im.Write{Conn: a2, Rev: 1, Seq: 2, Ops: ot.C(ot.Is("x"), ot.Rs(2))}
+	reply := make(chan im.Readallresp, 1)
+	d <- im.Readall{Reply: reply}
+	if ra := <-reply; ra.Body != "[x a b c]" || ra.Rev != 3 || len(a2) != 0 {
+		t.Fatalf("expected resubmitted write to be ignored, got %s at rev %d", ra.Body, ra.Rev)
+	}
+
+	// clients reopening at rev 0 with an applied write catch up one write
+	// at a time, too, so that they get its ack
+	a3 := make(chan interface{}, 10)
+	d <- im.Open{Conn: a3, Name: "/resubmit", Site: "a", Seq: 2, Fd: 1, Rev: 0}
+	<-a3 // Openresp
+	for _, rev := range []int{1, 2} {
+		if m, ok := (<-a3).(im.Write); !ok || m.Rev != rev {
+			t.Fatalf("expected Write at rev %d, got %#v", rev, m)
+		}
+	}
+	if m, ok := (<-a3).(im.Writeresp); !ok || m.Rev != 3 {
+		t.Fatalf("expected Writeresp at rev 3, got %#v", m)
+	}
+
+	// new writes with their own seqs are applied
+	d <- im.Write{Conn: a2, Rev: 3, Seq: 3, Ops: ot.C(ot.Rs(4), ot.Is("y"))}
+	if m, ok := (<-a2).(im.Writeresp); !ok || m.Rev != 4 {
+		t.Fatalf("expected Writeresp at rev 4, got %#v", m)
+	}
+}
+
+func TestSuffix(t *testing.T) {
+	d := &doc{spans: make([][]ot.Span, spanLevels)}
+	n := 2*digestWindow + 37
+	for i := 0; i < n; i++ {
+		d.hist = append(d.hist, ot.NewInsert(i, i/2, "a"))
+		d.sites = append(d.sites, string('a'+rune(i%3)))
+		if err := d.addSpans(len(d.hist)); err != nil {
+			t.Fatalf("addSpans failed, rev: %d, err: %q", i+1, err)
+		}
+	}
+
+	// suffixes of evicted and cached spans alike compose the writes after
+	// their revs
+	expected := ot.Ops{}
+	for rev := n; rev >= 0; rev-- {
+		if rev < n {
+			var err error
+			expected, err = ot.Compose(d.hist[rev], expected)
+			if err != nil {
+				t.Fatalf("compose failed, rev: %d, err: %q", rev, err)
+			}
+		}
+		s, err := d.suffix(rev)
+		if err != nil {
+			t.Fatalf("suffix failed, rev: %d, err: %q", rev, err)
+		}
+		if s.Len() != n-rev || s.Ops.String() != expected.String() {
+			t.Fatalf("bad suffix at rev %d; got %d writes: %s, expected: %s", rev, s.Len(), s.Ops, expected)
+		}
+	}
+}
@@ -5,6 +5,7 @@ package server
 
 import (
 	"github.com/mstone/focus/ot"
+	"github.com/mstone/focus/ot/jsonot"
 )
 
 /*
@@ -26,6 +27,9 @@ cl ---- WRITE  --->  conn
                      conn ------ Write -------->  doc
                      conn <----- Writeresp -----  doc
 cl <-- WRITERESP --  conn
+                     (or, for a diverged write)
+                     conn <----- Resync --------  doc
+cl <-- RESYNC -----  conn
 
 */
 
@@ -33,6 +37,7 @@ cl <-- WRITERESP --  conn
 type Allocdoc struct {
 	Reply chan Allocdocresp
 	Name  string
+	Type  string
 }
 
 type Allocdocresp struct {
@@ -47,16 +52,21 @@ type Loaddoc struct {
 }
 
 type Loaddocresp struct {
-	Err     error
-	Ok      bool
-	StoreId int64
-	History []ot.Ops
+	Err         error
+	Ok          bool
+	StoreId     int64
+	Type        string
+	History     []ot.Ops
+	JsonHistory []jsonot.Ops // for docs of type msg.DT_JSON
+	Sites       []string     // Sites[i] is the site that wrote the history's rev i+1
+	Seqs        []int        // Seqs[i] is the seq of that write, or 0 if unknown
 }
 
 // processed by store for doc
 type Storedoc struct {
 	Reply chan Storedocresp
 	Name  string
+	Type  string
 }
 
 type Storedocresp struct {
@@ -68,30 +78,49 @@ type Storedocresp struct {
 type Open struct {
 	Conn chan interface{}
 	Name string
+	Type string
+	Site string
+	Seq  int // the seq of the opening client's pending write, if any
 	Fd   int
 	Rev  int
 }
 
 type Openresp struct {
-	Err  error
+	Err  error // non-nil if the open was rejected
 	Doc  chan interface{}
 	Name string
+	Type string
 	Fd   int
 }
 
-// processed by doc for conn and by conn for doc
+// processed by doc for conn and by conn for doc; writes to docs of type
+// msg.DT_JSON carry Json instead of Ops. Writes sent to conns carry the site
+// of the conn that wrote them; docs take the sites of incoming writes from
+// the conns' Opens.
 type Write struct {
 	Conn chan interface{}
 	Doc  chan interface{}
 	Rev  int
 	Hash string
+	Site string
+	Seq  int // see ot.Sender
 	Ops  ot.Ops
+	Json jsonot.Ops
 }
 
 type Writeresp struct {
+	Doc  chan interface{}
+	Rev  int
+	Ops  ot.Ops
+	Json jsonot.Ops
+	Err  error // non-nil if the write was rejected
+}
+
+// processed by conn for doc; sent instead of a Writeresp when the hash of a
+// write shows that the writing client has diverged from the doc
+type Resync struct {
 	Doc chan interface{}
 	Rev int
-	Ops ot.Ops
 }
 
 // processed by doc for tests
@@ -110,8 +139,11 @@ type Storewrite struct {
 	Reply chan Storewriteresp
 	DocId int64
 	// AuthorId int64
-	Rev int
-	Ops ot.Ops
+	Rev  int
+	Site string
+	Seq  int
+	Ops  ot.Ops
+	Json jsonot.Ops // stored instead of Ops if non-nil
 }
 
 type Storewriteresp struct {
@@ -0,0 +1,110 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package server
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/mstone/focus/msg"
+	"github.com/mstone/focus/ot"
+	"github.com/mstone/focus/ot/interop"
+	"github.com/mstone/focus/store"
+)
+
+// wireMsg is a VPP message as a codec client sees it.
+type wireMsg struct {
+	Cmd  msg.Cmd
+	Name string          `json:",omitempty"`
+	Type string          `json:",omitempty"`
+	Fd   int             `json:",omitempty"`
+	Rev  int             `json:",omitempty"`
+	Err  string          `json:",omitempty"`
+	Ops  json.RawMessage `json:",omitempty"`
+}
+
+func TestCodec(t *testing.T) {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("unable to open driver, err: %q", err)
+	}
+	focusStore := store.New(db)
+	if err := focusStore.Reset(); err != nil {
+		t.Fatalf("unable to reset store, err: %q", err)
+	}
+	focusSrv, err := New(focusStore.Msgs())
+	if err != nil {
+		t.Fatalf("err: %s ", err)
+	}
+
+	send := func(w *ws, m interface{}) {
+		w.SetWriteTimeout(writeTimeout)
+		defer w.CancelWriteTimeout()
+		if err := w.WriteJSON(m); err != nil {
+			t.Fatalf("unable to send %+v, err: %q", m, err)
+		}
+	}
+	recv := func(w *ws, m interface{}) {
+		w.SetReadTimeout(readTimeout)
+		defer w.CancelReadTimeout()
+		if err := w.ReadJSON(m); err != nil {
+			t.Fatalf("unable to read, err: %q", err)
+		}
+	}
+
+	// a VPP client writes "a😀b"
+	v, v2 := NewWSPair()
+	focusSrv.Connect(v2)
+	send(v, msg.Msg{Cmd: msg.C_OPEN, Name: "/codec", Site: "v"})
+	m := msg.Msg{}
+	recv(v, &m)
+	recv(v, &m)
+	send(v, msg.Msg{Cmd: msg.C_WRITE, Fd: m.Fd, Rev: 0, Ops: ot.Is("a😀b")})
+	recv(v, &m)
+	if m.Cmd != msg.C_WRITE_RESP || m.Rev != 1 {
+		t.Fatalf("expected ack at rev 1, got %+v", m)
+	}
+
+	// a text0 client catches up and inserts "x" after 😀, which takes two
+	// UTF-16 code units
+	codec, _ := interop.Lookup(interop.CD_TEXT0)
+	c, c2 := NewWSPair()
+	focusSrv.ConnectCodec(c2, codec)
+	send(c, wireMsg{Cmd: msg.C_OPEN, Name: "/codec"})
+	w := wireMsg{}
+	recv(c, &w)
+	fd := w.Fd
+	recv(c, &w)
+	if w.Cmd != msg.C_WRITE || w.Rev != 1 || string(w.Ops) != `[{"p":0,"i":"a😀b"}]` {
+		t.Fatalf("unexpected catch-up: %+v, ops: %s", w, w.Ops)
+	}
+	send(c, wireMsg{Cmd: msg.C_WRITE, Fd: fd, Rev: 1, Ops: json.RawMessage(`[{"p":3,"i":"x"}]`)})
+	recv(c, &w)
+	if w.Cmd != msg.C_WRITE_RESP || w.Rev != 2 || string(w.Ops) != `[{"p":3,"i":"x"}]` {
+		t.Fatalf("unexpected ack: %+v, ops: %s", w, w.Ops)
+	}
+
+	// the VPP client receives the write as runes
+	recv(v, &m)
+	if exp := ot.C(ot.Rs(2), ot.Is("x"), ot.Rs(1)); m.Cmd != msg.C_WRITE || m.Rev != 2 || !reflect.DeepEqual(m.Ops, exp) {
+		t.Fatalf("expected write %s at rev 2, got %+v", exp.String(), m)
+	}
+
+	// writes that split surrogate pairs are rejected
+	send(c, wireMsg{Cmd: msg.C_WRITE, Fd: fd, Rev: 2, Ops: json.RawMessage(`[{"p":2,"i":"y"}]`)})
+	recv(c, &w)
+	if w.Cmd != msg.C_ERROR || w.Rev != 2 {
+		t.Fatalf("expected error, got %+v", w)
+	}
+
+	// codec clients can't reopen at later revs
+	send(c, wireMsg{Cmd: msg.C_OPEN, Name: "/codec", Rev: 2})
+	recv(c, &w)
+	if w.Cmd != msg.C_ERROR {
+		t.Fatalf("expected error, got %+v", w)
+	}
+}
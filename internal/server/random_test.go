@@ -137,6 +137,7 @@ type client struct {
 	numRecv int
 	l       log.Logger
 	hist    []msg.Msg
+	flushAt time.Time // when to flush writes held for the send window
 }
 
 func (c *client) sendRandomOps() {
@@ -144,7 +145,9 @@ func (c *client) sendRandomOps() {
 	ops := c.doc.GetRandomOps(numChars)
 
 	c.doc.Apply(ops)
-	c.st.OnClientWrite(ops.Clone())
+	if err := c.st.OnClientWrite(ops.Clone()); err != nil {
+		panic("client unable to write: " + err.Error())
+	}
 	// c.l.Info("genn", "ops", ops, "docsize", size, "doc", c.doc.String(), "docp", fmt.Sprintf("%p", c.doc), "clnhist", c.doc.String(), "clnst", c.st)
 }
 
@@ -152,12 +155,13 @@ func (c *client) String() string {
 	return fmt.Sprintf("%s", c.clname)
 }
 
-func (c *client) Send(rev int, hash string, ops ot.Ops) {
+func (c *client) Send(rev int, hash string, seq int, ops ot.Ops) {
 	c.ws.SetWriteTimeout(writeTimeout)
 	m := msg.Msg{
 		Cmd:  msg.C_WRITE,
 		Rev:  rev,
 		Hash: hash,
+		Seq:  seq,
 		Ops:  ops.Clone(),
 	}
 	// c.l.Info("send", "num", c.numSend, "rev", rev, "ops", ops)
@@ -176,13 +180,25 @@ func (c *client) Recv(ops ot.Ops) {
 
 func (c *client) onWriteResp(m msg.Msg) {
 	// c.l.Info("recv", "num", c.numRecv, "kind", "ack1", "rev", m.Rev, "ops", m.Ops, "clnhist", c.doc.String(), "clnst", c.st)
-	c.st.OnServerAck(m.Rev, m.Ops)
+	if err := c.st.OnServerAck(m.Rev, m.Ops); err != nil {
+		panic("client unable to apply WRITE_RESP: " + err.Error())
+	}
 	// c.l.Info("recv", "num", c.numRecv, "kind", "ack2", "rev", m.Rev, "ops", m.Ops, "clnhist", c.doc.String(), "clnst", c.st)
 }
 
+// flush sends any writes held for the send window.
+func (c *client) flush() {
+	c.flushAt = time.Time{}
+	if err := c.st.Flush(); err != nil {
+		panic("client unable to flush: " + err.Error())
+	}
+}
+
 func (c *client) onWrite(m msg.Msg) {
 	// c.l.Info("recv", "num", c.numRecv, "kind", "wrt1", "rev", m.Rev, "ops", m.Ops, "clnhist", c.doc.String(), "clnst", c.st)
-	c.st.OnServerWrite(m.Rev, m.Ops.Clone())
+	if err := c.st.OnServerWrite(m.Rev, m.Site, m.Ops.Clone()); err != nil {
+		panic("client unable to apply WRITE: " + err.Error())
+	}
 	// c.l.Info("recv", "num", c.numRecv, "kind", "wrt2", "rev", m.Rev, "ops", m.Ops, "clnhist", c.doc.String(), "clnst", c.st)
 }
 
@@ -198,12 +214,20 @@ Loop:
 			c.sendRandomOps()
 			round++
 		}
+		if !c.flushAt.IsZero() && !time.Now().Before(c.flushAt) {
+			c.flush()
+		}
 
 		m := msg.Msg{}
 		c.ws.SetReadTimeout(readTimeout)
 		err := c.ws.ReadJSON(&m)
 		c.ws.CancelReadTimeout()
 		if err != nil {
+			if !c.flushAt.IsZero() {
+				// nothing is in flight; send what we are holding
+				c.flush()
+				continue
+			}
 			log.Error("client unable to read response", "err", err)
 			break Loop
 		}
@@ -217,7 +241,10 @@ Loop:
 	}
 }
 
-func testOnce(t *testing.T, iteration int) {
+// testOnce runs numClients random clients against a fresh server. Clients
+// hold their writes for window before sending them; see
+// ot.Controller.AttachWindow.
+func testOnce(t *testing.T, iteration int, window time.Duration) {
 	var err error
 	log.Crit("boot")
 
@@ -260,6 +287,12 @@ func testOnce(t *testing.T, iteration int) {
 			hist:   []msg.Msg{},
 		}
 		c.st = ot.NewController(c, c)
+		c.st.AttachSite(c.clname)
+		if window > 0 {
+			c.st.AttachWindow(window, func(d time.Duration) {
+				c.flushAt = time.Now().Add(d)
+			})
+		}
 		c.l = log.New(
 			"obj", "cln",
 			"client", log.Lazy{c.String},
@@ -275,6 +308,7 @@ func testOnce(t *testing.T, iteration int) {
 		err = conn.WriteJSON(msg.Msg{
 			Cmd:  msg.C_OPEN,
 			Name: vpName,
+			Site: c.st.Site(),
 		})
 		conn.CancelWriteTimeout()
 		if err != nil {
@@ -336,6 +370,12 @@ func TestRandom(t *testing.T) {
 	flag.IntVar(&iterations, "iterations", 10, "number of iterations to run tests")
 
 	for i := 0; i < iterations; i++ {
-		testOnce(t, i)
+		testOnce(t, i, 0)
+	}
+}
+
+func TestRandomWindow(t *testing.T) {
+	for i := 0; i < 5; i++ {
+		testOnce(t, i, 5*time.Millisecond)
 	}
 }
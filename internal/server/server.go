@@ -9,6 +9,7 @@ import (
 	"github.com/mstone/focus/internal/connection"
 	"github.com/mstone/focus/internal/document"
 	im "github.com/mstone/focus/internal/msgs"
+	"github.com/mstone/focus/ot/interop"
 )
 
 type Server struct {
@@ -27,14 +28,14 @@ func New(store chan interface{}) (*Server, error) {
 	return s, nil
 }
 
-func (s *Server) onAllocDoc(w chan im.Allocdocresp, name string) {
+func (s *Server) onAllocDoc(w chan im.Allocdocresp, name string, typ string) {
 	var d chan interface{}
 	var ok bool
 	var err error
 
 	d, ok = s.names[name]
 	if !ok {
-		d, err = document.New(s.msgs, s.store, name)
+		d, err = document.New(s.msgs, s.store, name, typ)
 		if err != nil {
 			log.Error("unable to create document", "name", name, "err", err)
 			w <- im.Allocdocresp{
@@ -56,12 +57,18 @@ func (s *Server) Connect(ws connection.WebSocket) (chan interface{}, error) {
 	return c, nil
 }
 
+// ConnectCodec is like Connect, but the client's ops are encoded by codec.
+func (s *Server) ConnectCodec(ws connection.WebSocket, codec interop.Codec) (chan interface{}, error) {
+	c := connection.NewCodec(s.msgs, ws, codec)
+	return c, nil
+}
+
 func (s *Server) readLoop() {
 	for m := range s.msgs {
 		switch v := m.(type) {
 		default:
 		case im.Allocdoc:
-			s.onAllocDoc(v.Reply, v.Name)
+			s.onAllocDoc(v.Reply, v.Name, v.Type)
 		}
 	}
 }
@@ -24,13 +24,14 @@ type cl struct {
 	num      int
 }
 
-func (c *cl) Send(rev int, hash string, ops ot.Ops) {
+func (c *cl) Send(rev int, hash string, seq int, ops ot.Ops) {
 	c.t.Logf("S: %d, rev: %d, ops: %s", c.num, rev, ops)
 	m := msg.Msg{
 		Cmd:  msg.C_WRITE,
 		Fd:   0,
 		Rev:  rev,
 		Hash: hash,
+		Seq:  seq,
 		Ops:  ops,
 	}
 	c.wsa.WriteJSON(m)
@@ -83,16 +84,22 @@ func TestStatic(t *testing.T) {
 
 	send := func(i int, ops ot.Ops) {
 		cls[i].doc.Apply(ops)
-		cls[i].st.OnClientWrite(ops)
+		if err := cls[i].st.OnClientWrite(ops); err != nil {
+			t.Fatalf("client %d write failed, err: %q", i, err)
+		}
 	}
 	recv := func(i int) {
 		m := msg.Msg{}
 		cls[i].wsa.ReadJSON(&m)
 		switch m.Cmd {
 		case msg.C_WRITE_RESP:
-			cls[i].st.OnServerAck(m.Rev, m.Ops)
+			if err := cls[i].st.OnServerAck(m.Rev, m.Ops); err != nil {
+				t.Fatalf("client %d ack failed, err: %q", i, err)
+			}
 		case msg.C_WRITE:
-			cls[i].st.OnServerWrite(m.Rev, m.Ops)
+			if err := cls[i].st.OnServerWrite(m.Rev, m.Site, m.Ops); err != nil {
+				t.Fatalf("client %d write failed, err: %q", i, err)
+			}
 		}
 	}
 	recvFlight := func() {
@@ -39,6 +39,7 @@ import (
 	"github.com/mstone/focus/js/alert"
 	"github.com/mstone/focus/msg"
 	"github.com/mstone/focus/ot"
+	"github.com/mstone/focus/ot/crdt"
 )
 
 type Lengther interface {
@@ -49,19 +50,43 @@ type Sender interface {
 	Send(msg []byte)
 }
 
+// Engine merges the editor's writes with remote writes, which it delivers to
+// the Adapter via Recv(). Both ot.Controller and crdt.Replica are Engines.
+type Engine interface {
+	OnClientWrite(ops ot.Ops) error
+	Undo() error
+	Redo() error
+	IsBroken() bool
+}
+
+var (
+	_ Engine = (*ot.Controller)(nil)
+	_ Engine = (*crdt.Replica)(nil)
+)
+
+// objectRune stands in, both in ACE and in Adapter.text, for a branch
+// inserted by a remote write so that both keep counting the positions that
+// ops count in.
+const objectRune = '\uFFFC'
+
 type Adapter struct {
-	mu       sync.Mutex
-	conn     Sender
-	session  Lengther
-	doc      Document
-	state    *ot.Controller
+	mu      sync.Mutex
+	conn    Sender
+	session Lengther
+	doc     Document
+	// text mirrors doc in the rune positions that ops count in; its line
+	// index converts them to and from ACE's rows and UTF-16 columns.
+	text     *ot.Doc
+	state    Engine
 	suppress bool
 	fd       int
+	onBroken func(err error)
 }
 
 func NewAdapter() *Adapter {
 	return &Adapter{
-		mu: sync.Mutex{},
+		mu:   sync.Mutex{},
+		text: ot.NewDoc(),
 	}
 }
 
@@ -73,13 +98,14 @@ func (a *Adapter) IsSuppressed() bool {
 	return a.suppress
 }
 
-func (a *Adapter) Send(rev int, hash string, ops ot.Ops) {
+func (a *Adapter) Send(rev int, hash string, seq int, ops ot.Ops) {
 	if !a.suppress {
 		jsOps, _ := json.Marshal(msg.Msg{
 			Cmd:  msg.C_WRITE,
 			Fd:   a.fd,
 			Rev:  rev,
 			Hash: hash,
+			Seq:  seq,
 			Ops:  ops,
 		})
 		alert.Golang(fmt.Sprintf("sending jsops: %s", jsOps))
@@ -99,40 +125,51 @@ func (a *Adapter) Recv(ops ot.Ops) {
 		a.suppress = false
 	}()
 
-	if len(ops) == 0 {
-		return
-	}
-	if len(ops) != 1 || !ops[0].IsWith() {
-		alert.String("recv err; ops len != 1 || ops[0] != With(...)")
-		panic("bad ops len")
-	}
-	o := ops[0]
-
-	for _, op := range o.Kids {
+	for _, op := range ops {
 		switch {
 		case op.IsZero():
 			continue
 		case op.IsInsert():
-			rowcol := NewRowCol(a.doc, pos)
+			rowcol := NewRowCol(a.text, pos)
+			str := string(objectRune)
+			if op.Body.HasRunes() {
+				str = ot.AsString(op.Body.Runes())
+			}
 			alert.String(fmt.Sprintf("insert(%d, %q)", pos, op.Body.String()))
-			a.doc.Insert(rowcol, ot.AsString([]rune{op.Body.Leaf}))
+			a.doc.Insert(rowcol, str)
+			a.mirror(ot.NewInsert(a.text.Len(), pos, str))
 			pos += op.Len()
 			continue
 		case op.IsRetain():
+			// SUBTLE: ACE can't render attributes, so attribute-patching
+			// retains are treated as plain retains (and attributed
+			// inserts as plain inserts, above).
 			alert.String(fmt.Sprintf("retain(%d)", op.Size))
 			pos += op.Size
 			continue
 		case op.IsDelete():
-			startEnd := NewStartEnd(a.doc, pos, pos-op.Size)
+			startEnd := NewStartEnd(a.text, pos, pos-op.Size)
 			alert.String(fmt.Sprintf("remove(%d, %d)", pos, pos-op.Size))
 			a.doc.Remove(startEnd)
+			a.mirror(ot.NewDelete(a.text.Len(), pos, -op.Size))
 		case op.IsWith():
 			alert.String("recv err; got inner with op; exiting")
 			panic(2)
+		case op.IsMove(), op.IsPlace():
+			alert.String("recv err; got move op; exiting")
+			panic(2)
 		}
 	}
 }
 
+// mirror applies ops, which describe a change just made to the editor, to
+// a.text.
+func (a *Adapter) mirror(ops ot.Ops) {
+	if err := a.text.Apply(ops); err != nil {
+		alert.String(fmt.Sprintf("mirror err: %s", err))
+	}
+}
+
 func (a *Adapter) AttachFd(fd int) {
 	a.fd = fd
 }
@@ -143,11 +180,40 @@ func (a *Adapter) AttachEditor(session Lengther, doc Document) {
 	doc.SetOnChange(a.OnChange)
 }
 
-func (a *Adapter) AttachSocket(state *ot.Controller, conn Sender) {
+func (a *Adapter) AttachSocket(state Engine, conn Sender) {
 	a.state = state
 	a.conn = conn
 }
 
+// AttachRecovery sets the function that Check calls when the engine breaks;
+// it should start the engine's recovery and reopen the doc.
+func (a *Adapter) AttachRecovery(onBroken func(err error)) {
+	a.onBroken = onBroken
+}
+
+// Check reports err, as returned by the engine, and starts recovery if err
+// has broken the engine.
+func (a *Adapter) Check(err error) {
+	if err == nil {
+		return
+	}
+	alert.String(fmt.Sprintf("engine error: %s", err))
+	if a.onBroken != nil && a.state.IsBroken() {
+		a.onBroken(err)
+	}
+}
+
+// Undo reverts the most recent local edit via the engine so that the
+// reverting edit is rebased over any concurrent remote edits.
+func (a *Adapter) Undo() {
+	go func() { a.Check(a.state.Undo()) }()
+}
+
+// Redo reapplies the most recently undone local edit via the engine.
+func (a *Adapter) Redo() {
+	go func() { a.Check(a.state.Redo()) }()
+}
+
 // RowCall(...)
 //   -> getAllLines() -> Array[Line]
 // StartEnd(...)
@@ -167,14 +233,15 @@ func (a *Adapter) OnChange(change *js.Object) bool {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	length := a.session.Length()
-	alert.String(fmt.Sprintf("session len: %d", length))
+	// SUBTLE: a.text still holds the text from before the change, so
+	// positions on either side of it convert there.
+	length := a.text.Len()
 
 	data := change.Get("data")
 	alert.JSON(data)
 
 	action := data.Get("action").String()
-	textRange := NewRange(a.doc, NewJSStartEnd(data.Get("range")))
+	textRange := NewRange(a.text, NewJSStartEnd(data.Get("range")))
 
 	start := textRange.Start()
 	end := textRange.End()
@@ -183,17 +250,11 @@ func (a *Adapter) OnChange(change *js.Object) bool {
 	switch action {
 	case "insertText":
 		str := data.Get("text").String()
-		oldLen := length - (end - start)
-		alert.String(fmt.Sprintf("newInsert(%d, %d, %q)", oldLen, start, str))
-		ops = ot.NewInsert(oldLen, start, str)
+		alert.String(fmt.Sprintf("newInsert(%d, %d, %q)", length, start, str))
+		ops = ot.NewInsert(length, start, str)
 	case "removeText":
-		// BUG(mistone): end is bogus after delete when \n in text...
-		// Repro: abc\ndef<<<^H
-		text := data.Get("text").String()
-		textLen := len(text)
-		oldLen := length + textLen
-		alert.String(fmt.Sprintf("newDelete(%d, %d, %d)", oldLen, start, textLen))
-		ops = ot.NewDelete(oldLen, start, textLen)
+		alert.String(fmt.Sprintf("newDelete(%d, %d, %d)", length, start, end-start))
+		ops = ot.NewDelete(length, start, end-start)
 	case "insertLines":
 		linesObj := data.Get("lines")
 		numLines := linesObj.Length()
@@ -202,9 +263,7 @@ func (a *Adapter) OnChange(change *js.Object) bool {
 			lines[i] = linesObj.Index(i).String() + "\n"
 		}
 		str := strings.Join(lines, "")
-		numRunes := utf8.RuneCountInString(str)
-		oldLen := length - numRunes
-		ops = ot.NewInsert(oldLen, start, str)
+		ops = ot.NewInsert(length, start, str)
 	case "removeLines":
 		linesObj := data.Get("lines")
 		numLines := linesObj.Length()
@@ -214,14 +273,17 @@ func (a *Adapter) OnChange(change *js.Object) bool {
 		}
 		str := strings.Join(lines, "")
 		numRunes := utf8.RuneCountInString(str)
-		oldLen := length + numRunes
-		ops = ot.NewDelete(oldLen, start, numRunes)
+		ops = ot.NewDelete(length, start, numRunes)
+	}
+
+	a.mirror(ops)
+	if n := a.session.Length(); n != a.text.Counts().UTF16 {
+		alert.String(fmt.Sprintf("mirror diverged; session len: %d, mirror len: %d", n, a.text.Counts().UTF16))
 	}
 
-	ops = ot.Ws(ops)
 	alert.String("sending ops")
 	alert.Golang(ops)
 
-	go a.state.OnClientWrite(ops)
+	go func() { a.Check(a.state.OnClientWrite(ops)) }()
 	return true
 }
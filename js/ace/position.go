@@ -5,6 +5,8 @@ package ace
 
 import (
 	"github.com/gopherjs/gopherjs/js"
+
+	"github.com/mstone/focus/ot/textpos"
 )
 
 type Position interface {
@@ -35,22 +37,10 @@ func (j JSPosition) JS() *js.Object {
 	return j.pos
 }
 
-func NewRowCol(doc Document, pos int) Position {
-	var row, col int
-	lines := doc.GetAllLines()
-	// alert.String("lines")
-	// alert.JSON(lines)
-	for i := 0; i < lines.Length(); i++ {
-		lineLen := lines.Index(i).Length()
-		if pos <= lineLen {
-			row = i
-			col = pos
-			break
-		} else {
-			pos -= lineLen + 1
-		}
-	}
+// NewRowCol returns the position of the rune offset pos in text.
+func NewRowCol(text textpos.Text, pos int) Position {
+	p := textpos.ToPos(text, pos)
 	obj := JSPosition{js.Global.Get("Object").New()}
-	obj.Set(row, col)
+	obj.Set(p.Line, p.Col)
 	return obj
 }
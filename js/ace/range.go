@@ -3,33 +3,25 @@
 
 package ace
 
-import ()
+import (
+	"github.com/mstone/focus/ot/textpos"
+)
 
+// Range converts the ends of an ACE range to rune offsets in text.
 type Range struct {
-	doc Document
-	se  StartEnd
+	text textpos.Text
+	se   StartEnd
 }
 
-func NewRange(doc Document, se StartEnd) *Range {
+func NewRange(text textpos.Text, se StartEnd) *Range {
 	return &Range{
-		doc: doc,
-		se:  se,
+		text: text,
+		se:   se,
 	}
 }
 
 func (r *Range) asLinearIndex(pos Position) int {
-	// add the lengths of the lines before startRow, plus
-	// startCol, plus 1 * startRow for the line delimiters
-	row, col := pos.Row(), pos.Col()
-
-	linesBefore := r.doc.GetLines(0, row-1)
-	// alert.JSON(linesBefore)
-
-	idx := col + row
-	for i := 0; i < linesBefore.Length(); i++ {
-		idx += linesBefore.Index(i).Length()
-	}
-	return idx
+	return textpos.FromPos(r.text, textpos.Pos{Line: pos.Row(), Col: pos.Col()})
 }
 
 func (r *Range) Start() int {
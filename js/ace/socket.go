@@ -59,6 +59,12 @@ func (r *ReconnectingSocketSender) onOpen(e *js.Object) {
 	r.conn.Call("send", jsOps)
 }
 
+// Reopen closes the current connection so that a new one is opened, and the
+// doc reopened with a fresh open message, in its place.
+func (r *ReconnectingSocketSender) Reopen() {
+	r.conn.Call("close")
+}
+
 func (r *ReconnectingSocketSender) Send(msg []byte) {
 	r.conn.Call("send", msg)
 }
@@ -5,6 +5,8 @@ package ace
 
 import (
 	"github.com/gopherjs/gopherjs/js"
+
+	"github.com/mstone/focus/ot/textpos"
 )
 
 type StartEnd interface {
@@ -35,9 +37,11 @@ func (j JSStartEnd) JS() *js.Object {
 	return j.obj
 }
 
-func NewStartEnd(doc Document, start, end int) StartEnd {
+// NewStartEnd returns the range between the rune offsets start and end in
+// text.
+func NewStartEnd(text textpos.Text, start, end int) StartEnd {
 	ret := JSStartEnd{js.Global.Get("Object").New()}
-	ret.Set(NewRowCol(doc, start), NewRowCol(doc, end))
+	ret.Set(NewRowCol(text, start), NewRowCol(text, end))
 	return ret
 }
 
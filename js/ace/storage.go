@@ -0,0 +1,54 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// LocalStorage is an ot.StateStore that saves state in the browser's
+// localStorage under its key.
+type LocalStorage string
+
+func (l LocalStorage) SaveState(state []byte) (err error) {
+	defer func() {
+		// setItem throws, e.g., when the storage quota is exceeded
+		if r := recover(); r != nil {
+			if jsErr, ok := r.(*js.Error); ok {
+				err = jsErr
+				return
+			}
+			panic(r)
+		}
+	}()
+	js.Global.Get("localStorage").Call("setItem", string(l), string(state))
+	return nil
+}
+
+func (l LocalStorage) LoadState() ([]byte, error) {
+	state := js.Global.Get("localStorage").Call("getItem", string(l))
+	if state == nil {
+		return nil, nil
+	}
+	return []byte(state.String()), nil
+}
+
+// Site returns this browser's stable site id, for ot.Controller.AttachSite,
+// generating one and saving it under key on first use.
+func Site(key string) string {
+	l := LocalStorage(key)
+	if site, err := l.LoadState(); err == nil && len(site) > 0 {
+		return string(site)
+	}
+	bs := make([]byte, 16)
+	if _, err := rand.Read(bs); err != nil {
+		panic(err)
+	}
+	site := hex.EncodeToString(bs)
+	l.SaveState([]byte(site)) // on failure, the site lasts until reload
+	return site
+}
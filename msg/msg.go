@@ -7,8 +7,17 @@ package msg
 
 import (
 	"github.com/mstone/focus/ot"
+	"github.com/mstone/focus/ot/jsonot"
 )
 
+// Cmd says what a Msg does. Reopens with C_OPEN at a Rev below the doc's
+// latest are caught up with each later write as a separate C_WRITE, with the
+// Site that wrote it, rather than with one composed C_WRITE, so that clients
+// can order their pending inserts as the server will; opens at Rev 0 are
+// still caught up with one composed C_WRITE, unless the Seq of the opening
+// client's pending write shows that the server already applied it. The
+// server then acks that write with a C_WRITE_RESP in place of its C_WRITE
+// and ignores the client's resubmitted copy.
 type Cmd int
 
 const (
@@ -17,6 +26,8 @@ const (
 	C_OPEN_RESP
 	C_WRITE
 	C_WRITE_RESP
+	C_ERROR
+	C_RESYNC
 )
 
 func (c Cmd) String() string {
@@ -31,16 +42,33 @@ func (c Cmd) String() string {
 		return "WRITE"
 	case C_WRITE_RESP:
 		return "WRITE_RESP"
+	case C_ERROR:
+		return "ERROR"
+	case C_RESYNC:
+		return "RESYNC"
 	default:
 		panic("unknown cmd type")
 	}
 }
 
+// Doc types, requested by C_OPEN and confirmed by C_OPEN_RESP. Docs of type
+// DT_TEXT are edited with Ops; docs of type DT_JSON are edited with Json. The
+// empty type means DT_TEXT.
+const (
+	DT_TEXT = "text"
+	DT_JSON = "json"
+)
+
 type Msg struct {
 	Cmd  Cmd
-	Name string `json:",omitempty"`
-	Fd   int    `json:",omitempty"`
-	Rev  int    `json:",omitempty"`
-	Hash string `json:",omitempty"`
-	Ops  ot.Ops `json:",omitempty"`
+	Name string     `json:",omitempty"`
+	Type string     `json:",omitempty"`
+	Fd   int        `json:",omitempty"`
+	Rev  int        `json:",omitempty"`
+	Hash string     `json:",omitempty"`
+	Site string     `json:",omitempty"` // the opening or writing client's id; see ot.TransformSites
+	Seq  int        `json:",omitempty"` // the number of the opening client's pending write or of a write; see ot.Sender
+	Ops  ot.Ops     `json:",omitempty"`
+	Json jsonot.Ops `json:",omitempty"`
+	Err  string     `json:",omitempty"`
 }
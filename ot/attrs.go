@@ -0,0 +1,110 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Attrs annotate trees with rich-text attributes like "bold", "link",
+// "heading", or "author".
+//
+// On trees, Attrs hold the attributes of the tree. On retain and With ops,
+// Attrs are a patch to apply to the retained trees: each key is set to its
+// value, except that keys with empty values are removed.
+//
+// Like text runs, Attrs are shared between clones and must never be modified
+// in place.
+type Attrs map[string]string
+
+// Equal reports whether a and b hold the same attributes.
+func (a Attrs) Equal(b Attrs) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (a Attrs) String() string {
+	ks := make([]string, 0, len(a))
+	for k, v := range a {
+		ks = append(ks, fmt.Sprintf("%s=%q", k, v))
+	}
+	sort.Strings(ks)
+	return fmt.Sprintf("{%s}", strings.Join(ks, " "))
+}
+
+// merge returns the attributes of a overwritten by those of b. When apply is
+// set, keys with empty values are removed from the result; otherwise, they are
+// kept so that the result is itself a patch.
+func (a Attrs) merge(b Attrs, apply bool) Attrs {
+	if len(b) == 0 {
+		return a
+	}
+	ret := Attrs{}
+	for k, v := range a {
+		ret[k] = v
+	}
+	for k, v := range b {
+		ret[k] = v
+	}
+	if apply {
+		for k, v := range ret {
+			if v == "" {
+				delete(ret, k)
+			}
+		}
+	}
+	if len(ret) == 0 {
+		return nil
+	}
+	return ret
+}
+
+// Apply returns the attributes that result from patching a with b.
+func (a Attrs) Apply(b Attrs) Attrs {
+	return a.merge(b, true)
+}
+
+// Compose returns a single patch equivalent to patching with a and then b.
+func (a Attrs) Compose(b Attrs) Attrs {
+	return a.merge(b, false)
+}
+
+// Transform returns the part of b that should still be applied after a
+// concurrent patch, a, which wins all conflicts.
+func (a Attrs) Transform(b Attrs) Attrs {
+	if len(a) == 0 {
+		return b
+	}
+	ret := Attrs{}
+	for k, v := range b {
+		if _, ok := a[k]; !ok {
+			ret[k] = v
+		}
+	}
+	if len(ret) == 0 {
+		return nil
+	}
+	return ret
+}
+
+// invert returns the patch that restores a after it has been patched with b.
+func (a Attrs) invert(b Attrs) Attrs {
+	if len(b) == 0 {
+		return nil
+	}
+	ret := Attrs{}
+	for k := range b {
+		ret[k] = a[k]
+	}
+	return ret
+}
@@ -0,0 +1,211 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+var (
+	bold   = Attrs{"bold": "true"}
+	unbold = Attrs{"bold": ""}
+	ital   = Attrs{"italic": "true"}
+	link   = Attrs{"link": "http://a"}
+)
+
+func TestAttrsApply(t *testing.T) {
+	d := NewDoc()
+	d.Apply(C(Is("hello")))
+	d.Apply(C(Rs(1), Fs(3, bold), Rs(1)))
+	d.Apply(C(Fs(2, ital), Rs(3)))
+	d.Apply(C(Rs(2), Fs(1, unbold), Rs(2)))
+
+	body := d.Body()
+	expected := Trees{
+		Leaf('h').WithAttrs(ital),
+		Leaf('e').WithAttrs(Attrs{"bold": "true", "italic": "true"}),
+		Leaf('l'),
+		Leaf('l').WithAttrs(bold),
+		Leaf('o'),
+	}
+	if !reflect.DeepEqual(body.Kids, expected) {
+		t.Fatalf("bad attrs; got %s, expected %s", body.Kids.String(), expected.String())
+	}
+
+	d.Apply(C(Rs(5), Ia("!!", link)))
+	d.Apply(C(Rs(6), Fs(1, link)))
+	body = d.Body()
+	if l := body.Kids.Last(); !l.IsText() || AsString(l.Text) != "!!" || !l.Attrs.Equal(link) {
+		t.Fatalf("expected packed, linked text run; got %s", body.Kids.String())
+	}
+
+	// patching a branch's attributes via retains and With ops
+	d = NewDoc()
+	d.Apply(C(Ops{It(Branch(Trees{Text(AsRunes("ab"))}))}))
+	d.Apply(Fs(1, Attrs{"heading": "1"}))
+	d.Apply(Ops{Wf(C(Fs(1, bold), Rs(1)), Attrs{"align": "center"})})
+	body = d.Body()
+	expected = Trees{Branch(Trees{Leaf('a').WithAttrs(bold), Leaf('b')}).WithAttrs(Attrs{"heading": "1", "align": "center"})}
+	if !reflect.DeepEqual(body.Kids, expected) {
+		t.Fatalf("bad branch attrs; got %s, expected %s", body.Kids.String(), expected.String())
+	}
+}
+
+func TestAttrsCompose(t *testing.T) {
+	cases := []ComposeCase{
+		{
+			A: [2]Ops{C(Fs(2, bold), Rs(1)), C(Rs(1), Fs(2, ital))},
+			B: C(Fs(1, bold), Fs(1, Attrs{"bold": "true", "italic": "true"}), Fs(1, ital)),
+		},
+		{
+			A: [2]Ops{C(Fs(2, bold)), C(Fs(2, unbold))},
+			B: C(Fs(2, unbold)),
+		},
+		{
+			A: [2]Ops{C(Is("ab")), C(Fs(1, bold), Rs(1))},
+			B: C(Ia("a", bold), Is("b")),
+		},
+		{
+			A: [2]Ops{C(Ia("ab", bold)), C(Fs(2, unbold))},
+			B: C(Is("ab")),
+		},
+		{
+			A: [2]Ops{C(Fs(1, bold)), Ops{Wf(Ds(1), ital)}},
+			B: Ops{Wf(Ds(1), Attrs{"bold": "true", "italic": "true"})},
+		},
+		{
+			A: [2]Ops{C(Ia("abc", ital)), C(Rs(2), Ds(1))},
+			B: C(Ia("ab", ital)),
+		},
+		{
+			A: [2]Ops{C(Ia("a", bold)), C(Rs(1), Ia("b", bold))},
+			B: C(Ia("ab", bold)),
+		},
+	}
+
+	doComposeTable(t, cases)
+}
+
+func TestAttrsTransform(t *testing.T) {
+	// as wins conflicts; i.e., as is the later writer
+	cases := []struct {
+		A, B, A1, B1 Ops
+	}{
+		{
+			A:  C(Fs(2, bold)),
+			B:  C(Fs(2, unbold)),
+			A1: C(Fs(2, bold)),
+			B1: C(Rs(2)),
+		},
+		{
+			A:  C(Fs(2, bold)),
+			B:  C(Rs(1), Fs(1, Attrs{"bold": "", "italic": "true"})),
+			A1: C(Fs(2, bold)),
+			B1: C(Rs(1), Fs(1, ital)),
+		},
+		{
+			A:  C(Fs(1, bold)),
+			B:  C(Ds(1)),
+			A1: C(),
+			B1: C(Ds(1)),
+		},
+		{
+			A:  C(Fs(1, bold)),
+			B:  Ws(Ds(1)),
+			A1: C(Fs(1, bold)),
+			B1: Ws(Ds(1)),
+		},
+		{
+			A:  Ops{Wf(Ds(1), bold)},
+			B:  C(Fs(1, Attrs{"bold": "", "italic": "true"})),
+			A1: Ops{Wf(Ds(1), bold)},
+			B1: C(Fs(1, ital)),
+		},
+	}
+
+	for idx, c := range cases {
+		a1, b1, err := Transform(c.A, c.B)
+		if err != nil {
+			t.Fatalf("transform %d failed, err: %q", idx, err)
+		}
+		if !reflect.DeepEqual(a1, c.A1) || !reflect.DeepEqual(b1, c.B1) {
+			t.Fatalf("transform %d failed;\n\tA: %s\n\tB: %s\n\ta1: %s, expected %s\n\tb1: %s, expected %s", idx, c.A, c.B, a1, c.A1, b1, c.B1)
+		}
+	}
+}
+
+func TestAttrsConverge(t *testing.T) {
+	base := C(Is("ab"), Ops{It(Branch(Trees{Text(AsRunes("xyz"))}))}, Is("cd"))
+	edits := []Ops{
+		C(Fs(3, bold), Rs(2)),
+		C(Rs(1), Fs(3, unbold), Ds(1)),
+		C(Rs(2), Ws(C(Fs(2, link), Is("!"), Rs(1))), Fs(2, ital)),
+		C(Rs(2), Ops{Wf(C(Rs(1), Ds(1), Fs(1, bold)), Attrs{"heading": "2"})}, Rs(2)),
+		C(Is("new"), Fs(1, Attrs{"italic": "", "link": "http://b"}), Rs(4)),
+	}
+
+	for i, a := range edits {
+		for j, b := range edits {
+			a1, b1, err := Transform(a, b)
+			if err != nil {
+				t.Fatalf("transform %d, %d failed, err: %q", i, j, err)
+			}
+			d1, d2 := NewDoc(), NewDoc()
+			for _, os := range []Ops{base, a, b1} {
+				if err := d1.Apply(os); err != nil {
+					t.Fatalf("apply %d, %d failed, os: %s, err: %q", i, j, os, err)
+				}
+			}
+			for _, os := range []Ops{base, b, a1} {
+				if err := d2.Apply(os); err != nil {
+					t.Fatalf("apply %d, %d failed, os: %s, err: %q", i, j, os, err)
+				}
+			}
+			if !reflect.DeepEqual(d1.Body(), d2.Body()) {
+				t.Fatalf("docs diverged, %d, %d;\n\td1: %s\n\td2: %s", i, j, d1.String(), d2.String())
+			}
+
+			// inverting an attribute patch restores the old attributes
+			d3 := NewDoc()
+			d3.Apply(base)
+			d3.Apply(a)
+			before := d3.Body()
+			inv, err := d3.Invert(b1)
+			if err != nil {
+				t.Fatalf("invert %d, %d failed, err: %q", i, j, err)
+			}
+			d3.Apply(b1)
+			d3.Apply(inv)
+			if !reflect.DeepEqual(d3.Body(), before) {
+				t.Fatalf("invert %d, %d failed;\n\tbefore: %s\n\tafter: %s", i, j, before.String(), d3.String())
+			}
+		}
+	}
+}
+
+func TestAttrsJSON(t *testing.T) {
+	table := []JSONCase{
+		{
+			A: C(Fs(2, bold), Rs(1), Ia("hi", link)),
+			B: `[{"R":2,"A":{"bold":"true"}},1,{"I":"hi","A":{"link":"http://a"}}]`,
+		},
+		{
+			A: C(Fs(1, unbold), Ops{Wf(Ds(1), ital)}, Ops{Wf(nil, ital)}),
+			B: `[{"R":1,"A":{"bold":""}},{"W":[-1],"A":{"italic":"true"}},{"W":[],"A":{"italic":"true"}}]`,
+		},
+		{
+			A: Ops{It(Branch(Trees{Leaf('a'), Text(AsRunes("bc")).WithAttrs(bold), Branch(nil).WithAttrs(ital)}).WithAttrs(link))},
+			B: `[{"I":["a",{"T":"bc","A":{"bold":"true"}},{"T":[],"A":{"italic":"true"}}],"A":{"link":"http://a"}}]`,
+		},
+	}
+
+	doJSONTable(t, table)
+
+	var os Ops
+	if err := json.Unmarshal([]byte(`[{"R":1,"I":"a"}]`), &os); err == nil {
+		t.Fatalf("expected error unmarshaling ambiguous op, got %s", os)
+	}
+}
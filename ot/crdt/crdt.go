@@ -0,0 +1,501 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+// Package crdt implements a replicated growable array (RGA), a sequence CRDT
+// for plain text. Unlike ot.Controller, replicas need no server to order
+// their writes: replicas that have received the same writes, in any order,
+// hold the same text.
+//
+// Replicas take client writes as ot.Ops and deliver remote writes to their
+// clients as ot.Ops, via ot.Receiver, so that editors can run against either
+// engine; only the writes that replicas exchange with each other differ.
+// Replicas send their writes as []Op via Sender, rather than as (rev, hash,
+// ot.Ops) via ot.Sender, since they have no revisions to count and no server
+// doc to hash, so the transport that carries them must be wired up
+// separately from an editor adapter's ot.Sender; e.g., ace.Adapter drives a
+// Replica as its Engine and receives its writes, but sends only ot.Ops.
+package crdt
+
+import (
+	"fmt"
+
+	"github.com/juju/errors"
+
+	"github.com/mstone/focus/ot"
+)
+
+// Id names an element of a replica's sequence by the Lamport clock of the
+// write that inserted it and the site that wrote it.
+type Id struct {
+	Clock int
+	Site  string
+}
+
+// less orders ids by clock and then by site.
+func (id Id) less(o Id) bool {
+	if id.Clock != o.Clock {
+		return id.Clock < o.Clock
+	}
+	return id.Site < o.Site
+}
+
+func (id Id) isZero() bool {
+	return id == Id{}
+}
+
+func (id Id) String() string {
+	return fmt.Sprintf("%d@%s", id.Clock, id.Site)
+}
+
+type OpTag int
+
+const (
+	R_INSERT OpTag = iota
+	R_DELETE
+)
+
+// Op is a write exchanged between replicas. Inserts add the element Id,
+// holding Rune, after the element Ref, or at the start of the sequence if Ref
+// is zero; deletes remove the element Id.
+type Op struct {
+	Tag  OpTag
+	Id   Id
+	Ref  Id   `json:",omitempty"`
+	Rune rune `json:",omitempty"`
+}
+
+func (o Op) String() string {
+	switch o.Tag {
+	case R_INSERT:
+		return fmt.Sprintf("I%s%s>%s", ot.AsString([]rune{o.Rune}), o.Id, o.Ref)
+	case R_DELETE:
+		return fmt.Sprintf("D%s", o.Id)
+	default:
+		return fmt.Sprintf("E%#v", o)
+	}
+}
+
+// Sender sends writes to the other replicas; it is the counterpart of
+// ot.Sender.
+type Sender interface {
+	Send(ops []Op)
+}
+
+type elem struct {
+	id      Id
+	ref     Id
+	r       rune
+	deleted bool
+}
+
+// Replica is one replica of an RGA. Replicas keep their elements, including
+// deleted ones, in a slice, so writes cost time linear in the size of the
+// sequence.
+type Replica struct {
+	site    string
+	clock   int
+	elems   []elem
+	known   map[Id]bool
+	pending []Op // remote writes waiting for the elements they refer to
+	conn    Sender
+	client  ot.Receiver
+	doc     *ot.Doc // the client's text, for inverting client writes
+	undo    []ot.Ops
+	redo    []ot.Ops
+}
+
+// NewReplica returns an empty replica that writes as site, which must be
+// unique among replicas, sends its writes to sender, and delivers remote
+// writes to receiver.
+func NewReplica(site string, sender Sender, receiver ot.Receiver) *Replica {
+	return &Replica{
+		site:   site,
+		known:  map[Id]bool{},
+		conn:   sender,
+		client: receiver,
+		doc:    ot.NewDoc(),
+	}
+}
+
+func (r *Replica) Site() string {
+	return r.site
+}
+
+// Text returns the replica's current text.
+func (r *Replica) Text() string {
+	rs := []rune{}
+	for _, e := range r.elems {
+		if !e.deleted {
+			rs = append(rs, e.r)
+		}
+	}
+	return string(rs)
+}
+
+func (r *Replica) String() string {
+	return fmt.Sprintf("Rga[%s, %d, %q, %d pending]", r.site, r.clock, r.Text(), len(r.pending))
+}
+
+// State returns writes that bring a replica that has none of r's writes up
+// to date with r.
+func (r *Replica) State() []Op {
+	ops := make([]Op, 0, len(r.elems))
+	for _, e := range r.elems {
+		ops = append(ops, Op{Tag: R_INSERT, Id: e.id, Ref: e.ref, Rune: e.r})
+	}
+	for _, e := range r.elems {
+		if e.deleted {
+			ops = append(ops, Op{Tag: R_DELETE, Id: e.id})
+		}
+	}
+	return ops
+}
+
+// OnClientWrite records ops, which the client has already applied locally,
+// on the undo stack and sends them to the other replicas. Since replicas
+// hold plain text, ops may only retain, delete, and insert runes, without
+// attributes.
+func (r *Replica) OnClientWrite(ops ot.Ops) error {
+	ops, err := ot.Normalize(ops.Clone())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := r.check(ops); err != nil {
+		return errors.Trace(err)
+	}
+	inv, err := r.doc.Invert(ops)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	r.undo = append(r.undo, inv)
+	r.redo = nil
+	return errors.Trace(r.write(ops))
+}
+
+// Undo reverts the most recent client write that has not yet been undone, as
+// rebased over any intervening remote writes. The reverting ops are
+// delivered to the client via Recv() and are sent like any other write.
+func (r *Replica) Undo() error {
+	if len(r.undo) == 0 {
+		return nil
+	}
+	ops := r.undo[len(r.undo)-1]
+	r.undo = r.undo[:len(r.undo)-1]
+	inv, err := r.doc.Invert(ops)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	r.redo = append(r.redo, inv)
+	r.client.Recv(ops.Clone())
+	return errors.Trace(r.write(ops))
+}
+
+// Redo reapplies the most recently undone client write.
+func (r *Replica) Redo() error {
+	if len(r.redo) == 0 {
+		return nil
+	}
+	ops := r.redo[len(r.redo)-1]
+	r.redo = r.redo[:len(r.redo)-1]
+	inv, err := r.doc.Invert(ops)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	r.undo = append(r.undo, inv)
+	r.client.Recv(ops.Clone())
+	return errors.Trace(r.write(ops))
+}
+
+func (r *Replica) CanUndo() bool {
+	return len(r.undo) > 0
+}
+
+func (r *Replica) CanRedo() bool {
+	return len(r.redo) > 0
+}
+
+// IsBroken reports false: replicas reject bad writes without changing.
+func (r *Replica) IsBroken() bool {
+	return false
+}
+
+// check returns an error unless ops apply to the client's text and hold
+// only plain text.
+func (r *Replica) check(ops ot.Ops) error {
+	for _, o := range ops {
+		switch {
+		case o.IsZero(), o.IsDelete():
+		case o.IsRetain() && len(o.Attrs) == 0:
+		case (o.IsInsertText() || o.IsInsertLeaf()) && len(o.Body.Attrs) == 0:
+		default:
+			return errors.Errorf("check failed, non-text op: %s", o.String())
+		}
+	}
+	return errors.Trace(r.doc.Validate(ops))
+}
+
+// write applies ops, which have been checked, to r and sends the
+// corresponding writes to the other replicas.
+func (r *Replica) write(ops ot.Ops) error {
+	if err := r.doc.Apply(ops); err != nil {
+		return errors.Trace(err)
+	}
+	ret := []Op{}
+	i := 0       // the index in r.elems of the cursor
+	last := Id{} // the element before the cursor
+	next := func() int {
+		for r.elems[i].deleted {
+			i++
+		}
+		i++
+		return i - 1
+	}
+	for _, o := range ops {
+		switch {
+		case o.IsRetain():
+			for n := 0; n < o.Len(); n++ {
+				last = r.elems[next()].id
+			}
+		case o.IsDelete():
+			for n := 0; n < o.Len(); n++ {
+				k := next()
+				r.elems[k].deleted = true
+				last = r.elems[k].id
+				ret = append(ret, Op{Tag: R_DELETE, Id: last})
+			}
+		case o.IsInsert():
+			for _, c := range runes(o.Body) {
+				r.clock++
+				op := Op{Tag: R_INSERT, Id: Id{r.clock, r.site}, Ref: last, Rune: c}
+				// SUBTLE: op.Id is the greatest id yet, so it lands just
+				// after last, before any deleted elements that follow.
+				k, err := r.insert(op)
+				if err != nil {
+					return errors.Trace(err)
+				}
+				i = k + 1
+				last = op.Id
+				ret = append(ret, op)
+			}
+		}
+	}
+	if len(ret) > 0 {
+		r.conn.Send(ret)
+	}
+	return nil
+}
+
+// runes returns the runes of t, a leaf or a text run.
+func runes(t ot.Tree) []rune {
+	if t.Tag == ot.T_LEAF {
+		return []rune{t.Leaf}
+	}
+	return t.Text
+}
+
+// insert adds the element inserted by op, whose ref r holds, and returns its
+// index.
+func (r *Replica) insert(op Op) (int, error) {
+	i := 0
+	if !op.Ref.isZero() {
+		k, err := r.find(op.Ref)
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		i = k + 1
+	}
+	// concurrent inserts after the same element go in decreasing order of
+	// id; the elements inserted after those with greater ids have greater
+	// ids still.
+	for i < len(r.elems) && op.Id.less(r.elems[i].id) {
+		i++
+	}
+	r.elems = append(r.elems, elem{})
+	copy(r.elems[i+1:], r.elems[i:])
+	r.elems[i] = elem{id: op.Id, ref: op.Ref, r: op.Rune}
+	r.known[op.Id] = true
+	if op.Id.Clock > r.clock {
+		r.clock = op.Id.Clock
+	}
+	return i, nil
+}
+
+// find returns the index of the element id.
+func (r *Replica) find(id Id) (int, error) {
+	for i, e := range r.elems {
+		if e.id == id {
+			return i, nil
+		}
+	}
+	return 0, errors.Errorf("find failed, unknown id: %s", id)
+}
+
+// visible returns the number of elements before index i that are not
+// deleted.
+func (r *Replica) visible(i int) int {
+	n := 0
+	for _, e := range r.elems[:i] {
+		if !e.deleted {
+			n++
+		}
+	}
+	return n
+}
+
+// OnRemoteWrite applies ops, written by another replica, delivering their
+// effect to the client. Writes may arrive in any order and more than once;
+// writes that refer to elements that r has yet to receive wait for them. If
+// ops fail to apply, r is left as it was and the client receives nothing.
+func (r *Replica) OnRemoteWrite(ops []Op) error {
+	for _, op := range ops {
+		if op.Tag != R_INSERT && op.Tag != R_DELETE || op.Id.isZero() {
+			return errors.Errorf("bad remote write, bad op: %s", op)
+		}
+		// an insert's id is greater than those of the elements that its
+		// writer held, including its ref
+		if op.Tag == R_INSERT && !op.Ref.isZero() && !op.Ref.less(op.Id) {
+			return errors.Errorf("bad remote write, insert before its ref: %s", op)
+		}
+	}
+	saved := r.save()
+	deltas, err := r.integrateAll(ops)
+	if err != nil {
+		r.restore(saved)
+		return errors.Trace(err)
+	}
+	for _, delta := range deltas {
+		r.client.Recv(delta)
+	}
+	return nil
+}
+
+// integrateAll adds ops to r's pending writes, applies those that r now
+// holds the elements for, and returns their effects on the client's text.
+func (r *Replica) integrateAll(ops []Op) ([]ot.Ops, error) {
+	deltas := []ot.Ops{}
+	r.pending = append(r.pending, ops...)
+	for progress := true; progress; {
+		progress = false
+		rest := []Op{}
+		for _, op := range r.pending {
+			delta, ok, err := r.integrate(op)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			if !ok {
+				rest = append(rest, op)
+				continue
+			}
+			progress = true
+			if delta == nil {
+				continue
+			}
+			if err := r.rebase(delta); err != nil {
+				return nil, errors.Trace(err)
+			}
+			deltas = append(deltas, delta)
+		}
+		r.pending = rest
+	}
+	return deltas, nil
+}
+
+// integrate applies op, if r holds the elements that it refers to, and
+// returns its effect on the client's text, or nil if it has none.
+func (r *Replica) integrate(op Op) (ot.Ops, bool, error) {
+	switch op.Tag {
+	case R_INSERT:
+		if r.known[op.Id] {
+			return nil, true, nil
+		}
+		if !op.Ref.isZero() && !r.known[op.Ref] {
+			return nil, false, nil
+		}
+		n := r.doc.Len()
+		i, err := r.insert(op)
+		if err != nil {
+			return nil, false, errors.Trace(err)
+		}
+		return ot.NewInsert(n, r.visible(i), string(op.Rune)), true, nil
+	default:
+		if !r.known[op.Id] {
+			return nil, false, nil
+		}
+		i, err := r.find(op.Id)
+		if err != nil {
+			return nil, false, errors.Trace(err)
+		}
+		if r.elems[i].deleted {
+			return nil, true, nil
+		}
+		r.elems[i].deleted = true
+		return ot.NewDelete(r.doc.Len(), r.visible(i), 1), true, nil
+	}
+}
+
+// rebase applies ops, which are about to be delivered to the client, to the
+// client's text and rebases the undo and redo stacks over them.
+func (r *Replica) rebase(ops ot.Ops) error {
+	if err := r.doc.Apply(ops); err != nil {
+		return errors.Trace(err)
+	}
+	var err error
+	if r.undo, err = rebaseStack(r.undo, ops); err != nil {
+		return errors.Trace(err)
+	}
+	if r.redo, err = rebaseStack(r.redo, ops); err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// replicaState holds the parts of a replica that remote writes change.
+type replicaState struct {
+	clock   int
+	elems   []elem
+	known   map[Id]bool
+	pending []Op
+	doc     *ot.Doc
+	undo    []ot.Ops
+	redo    []ot.Ops
+}
+
+// save copies the state that OnRemoteWrite may change, for restore.
+func (r *Replica) save() replicaState {
+	known := make(map[Id]bool, len(r.known))
+	for id := range r.known {
+		known[id] = true
+	}
+	return replicaState{
+		clock:   r.clock,
+		elems:   append([]elem(nil), r.elems...),
+		known:   known,
+		pending: append([]Op(nil), r.pending...),
+		doc:     r.doc.Snapshot(),
+		undo:    append([]ot.Ops(nil), r.undo...),
+		redo:    append([]ot.Ops(nil), r.redo...),
+	}
+}
+
+func (r *Replica) restore(s replicaState) {
+	r.clock = s.clock
+	r.elems = s.elems
+	r.known = s.known
+	r.pending = s.pending
+	r.doc = s.doc
+	r.undo = s.undo
+	r.redo = s.redo
+}
+
+// rebaseStack transforms each entry of an undo or redo stack, top first, so
+// that the stack applies to the text after ops.
+func rebaseStack(stack []ot.Ops, ops ot.Ops) ([]ot.Ops, error) {
+	var err error
+	for i := len(stack) - 1; i >= 0; i-- {
+		stack[i], ops, err = ot.Transform(stack[i], ops)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return stack, nil
+}
@@ -0,0 +1,236 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package crdt_test
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/mstone/focus/ot"
+	"github.com/mstone/focus/ot/crdt"
+)
+
+// peer is a replica together with its client's text and the writes that it
+// has sent.
+type peer struct {
+	*crdt.Replica
+	doc  *ot.Doc
+	sent [][]crdt.Op
+}
+
+func newPeer(site string) *peer {
+	p := &peer{doc: ot.NewDoc()}
+	p.Replica = crdt.NewReplica(site, p, p)
+	return p
+}
+
+func (p *peer) Send(ops []crdt.Op) {
+	p.sent = append(p.sent, ops)
+}
+
+func (p *peer) Recv(ops ot.Ops) {
+	if err := p.doc.Apply(ops); err != nil {
+		panic(err)
+	}
+}
+
+func (p *peer) write(t *testing.T, ops ot.Ops) {
+	if err := p.doc.Apply(ops); err != nil {
+		t.Fatalf("apply failed, ops: %s, err: %q", ops.String(), err)
+	}
+	if err := p.OnClientWrite(ops); err != nil {
+		t.Fatalf("write failed, ops: %s, err: %q", ops.String(), err)
+	}
+}
+
+func (p *peer) recv(t *testing.T, ops []crdt.Op) {
+	if err := p.OnRemoteWrite(ops); err != nil {
+		t.Fatalf("remote write failed, err: %q", err)
+	}
+}
+
+// check checks that p's client holds p's text.
+func (p *peer) check(t *testing.T) {
+	text := ot.NewDoc()
+	if s := p.Text(); s != "" {
+		text.Apply(ot.NewInsert(0, 0, s))
+	}
+	if p.doc.String() != text.String() {
+		t.Fatalf("client of %s diverged, client: %s", p, p.doc.String())
+	}
+}
+
+func TestConcurrentInserts(t *testing.T) {
+	a, b := newPeer("a"), newPeer("b")
+	a.write(t, ot.NewInsert(0, 0, "xy"))
+	b.recv(t, a.sent[0])
+
+	// concurrent inserts at the same position don't interleave
+	a.write(t, ot.NewInsert(2, 1, "ab"))
+	b.write(t, ot.NewInsert(2, 1, "cd"))
+	b.recv(t, a.sent[1])
+	a.recv(t, b.sent[0])
+	if a.Text() != "xcdaby" || b.Text() != "xcdaby" {
+		t.Fatalf("expected convergence, got %s and %s", a, b)
+	}
+	a.check(t)
+	b.check(t)
+
+	// deletes of the same rune, and of runes next to concurrent inserts,
+	// commute
+	a.write(t, ot.NewDelete(6, 0, 2))
+	b.write(t, ot.NewDelete(6, 1, 1))
+	b.write(t, ot.NewInsert(5, 1, "z"))
+	a.recv(t, b.sent[1])
+	a.recv(t, b.sent[2])
+	b.recv(t, a.sent[2])
+	if a.Text() != "zdaby" || b.Text() != "zdaby" {
+		t.Fatalf("expected convergence, got %s and %s", a, b)
+	}
+	a.check(t)
+	b.check(t)
+}
+
+func TestUndo(t *testing.T) {
+	a, b := newPeer("a"), newPeer("b")
+	a.write(t, ot.NewInsert(0, 0, "ab"))
+	b.recv(t, a.sent[0])
+	b.write(t, ot.NewInsert(2, 0, "x"))
+	a.recv(t, b.sent[0])
+
+	// undo reverts a's own write, rebased over b's
+	if err := a.Undo(); err != nil {
+		t.Fatalf("undo failed, err: %q", err)
+	}
+	b.recv(t, a.sent[1])
+	if a.Text() != "x" || b.Text() != "x" {
+		t.Fatalf("unexpected undo, got %s and %s", a, b)
+	}
+	if err := a.Redo(); err != nil {
+		t.Fatalf("redo failed, err: %q", err)
+	}
+	b.recv(t, a.sent[2])
+	if a.Text() != "xab" || b.Text() != "xab" {
+		t.Fatalf("unexpected redo, got %s and %s", a, b)
+	}
+	a.check(t)
+	b.check(t)
+
+	if err := a.OnClientWrite(ot.Ops{ot.R(3), ot.W(nil)}); err == nil {
+		t.Fatalf("expected non-text write to fail")
+	}
+}
+
+func TestState(t *testing.T) {
+	a, b := newPeer("a"), newPeer("b")
+	a.write(t, ot.NewInsert(0, 0, "abc"))
+	a.write(t, ot.NewDelete(3, 1, 1))
+	b.write(t, ot.NewInsert(0, 0, "x"))
+
+	// a joining replica catches up from a's state and still merges b's
+	// concurrent write
+	c := newPeer("c")
+	c.recv(t, a.State())
+	c.recv(t, b.sent[0])
+	a.recv(t, b.sent[0])
+	if c.Text() != a.Text() {
+		t.Fatalf("expected convergence, got %s and %s", a, c)
+	}
+	c.check(t)
+}
+
+func TestBadRemoteWrite(t *testing.T) {
+	a, b := newPeer("a"), newPeer("b")
+	a.write(t, ot.NewInsert(0, 0, "ab"))
+	b.recv(t, a.sent[0])
+	before := b.String()
+
+	// a bad op rejects the whole write, including the ops before it
+	bad := [][]crdt.Op{
+		{{Tag: crdt.R_INSERT, Id: crdt.Id{Clock: 3, Site: "a"}, Rune: 'x'}, {Tag: crdt.R_DELETE}},
+		{{Tag: crdt.R_DELETE, Id: crdt.Id{Clock: 1, Site: "a"}}, {Tag: crdt.R_INSERT, Id: crdt.Id{Clock: 1, Site: "c"}, Ref: crdt.Id{Clock: 2, Site: "a"}, Rune: 'y'}},
+	}
+	for _, ops := range bad {
+		if err := b.OnRemoteWrite(ops); err == nil {
+			t.Fatalf("expected remote write to fail, ops: %v", ops)
+		}
+		if b.String() != before {
+			t.Fatalf("failed remote write changed replica, got %s, expected %s", b, before)
+		}
+		b.check(t)
+	}
+}
+
+// TestConverge checks that replicas that make random concurrent writes and
+// receive each other's writes in random order, some more than once, end up
+// with the same text.
+func TestConverge(t *testing.T) {
+	seed := time.Now().UnixNano()
+	rng := rand.New(rand.NewSource(seed))
+	alphabet := []rune("ab\ncé😀")
+	// the peer helpers fail without knowing the seed
+	defer func() {
+		if t.Failed() {
+			t.Logf("seed: %d", seed)
+		}
+	}()
+
+	for iter := 0; iter < 50; iter++ {
+		peers := []*peer{newPeer("a"), newPeer("b"), newPeer("c")}
+		// queues[i] holds the writes sent to peers[i] but not yet delivered
+		queues := make([][][]crdt.Op, len(peers))
+
+		deliver := func(i int, all bool) {
+			q := queues[i]
+			rng.Shuffle(len(q), func(x, y int) { q[x], q[y] = q[y], q[x] })
+			n := len(q)
+			if !all {
+				n = rng.Intn(len(q) + 1)
+			}
+			for _, ops := range q[:n] {
+				peers[i].recv(t, ops)
+				peers[i].check(t)
+			}
+			queues[i] = q[n:]
+		}
+
+		for step := 0; step < 40; step++ {
+			i := rng.Intn(len(peers))
+			p := peers[i]
+			if rng.Intn(3) == 0 {
+				deliver(i, false)
+				continue
+			}
+			n := p.doc.Len()
+			var ops ot.Ops
+			if n > 0 && rng.Intn(3) == 0 {
+				pos := rng.Intn(n)
+				ops = ot.NewDelete(n, pos, 1+rng.Intn(n-pos))
+			} else {
+				ops = ot.NewInsert(n, rng.Intn(n+1), string(alphabet[rng.Intn(len(alphabet))]))
+			}
+			p.write(t, ops)
+			sent := p.sent[len(p.sent)-1]
+			for j := range peers {
+				if j == i {
+					continue
+				}
+				queues[j] = append(queues[j], sent)
+				if rng.Intn(4) == 0 {
+					queues[j] = append(queues[j], sent)
+				}
+			}
+		}
+
+		for i := range peers {
+			deliver(i, true)
+		}
+		for _, p := range peers[1:] {
+			if p.Text() != peers[0].Text() {
+				t.Fatalf("replicas diverged, seed: %d, iter: %d, got %s and %s", seed, iter, peers[0], p)
+			}
+		}
+	}
+}
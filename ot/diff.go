@@ -0,0 +1,414 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot
+
+import (
+	"encoding/json"
+
+	"github.com/juju/errors"
+)
+
+// Diff returns a minimal op list that turns the text a into the text b.
+//
+// Like the ops passed to Apply, the result is wrapped in a With op; i.e.,
+// Apply(Diff(a, b)[0], &t) turns t == AsRuneTree(a) into AsRuneTree(b), and
+// Doc.Apply(Diff(a, b)[0].Kids) does the same for docs.
+func Diff(a, b string) Ops {
+	return DiffTree(AsRuneTree(a), AsRuneTree(b))
+}
+
+// DiffTree returns a With-wrapped op list that turns the branch a into the
+// branch b. Kids are diffed with Myers' algorithm, one position at a time,
+// after their common prefix and suffix are stripped; branches that are replaced by other branches of the same type are diffed
+// recursively to produce nested With ops. Since ops cannot change node types,
+// the type of a itself is left alone.
+func DiffTree(a, b Tree) Ops {
+	if !a.IsBranch() || !b.IsBranch() {
+		panic(errors.Errorf("DiffTree failed, expected branches; a: %s, b: %s", a.String(), b.String()))
+	}
+	return Ops{Wf(diffKids(a.Kids, b.Kids), diffAttrs(a.Attrs, b.Attrs))}
+}
+
+// diffAttrs returns the patch that turns the attributes a into b.
+func diffAttrs(a, b Attrs) Attrs {
+	ret := Attrs{}
+	for k, v := range b {
+		if w, ok := a[k]; !ok || w != v {
+			ret[k] = v
+		}
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			ret[k] = ""
+		}
+	}
+	if len(ret) == 0 {
+		return nil
+	}
+	return ret
+}
+
+// atom is a single position of a branch: either one rune of a leaf or text
+// run, or a whole branch. Atoms point into the kids that they were made from
+// so that large docs don't need a tree per rune.
+type atom struct {
+	kid *Tree  // the leaf, text run, or branch that holds the position
+	r   rune   // for runes, the rune at the position
+	key string // for branches, a canonical encoding used for comparisons
+}
+
+// atomize returns the atoms of the positions [from, to) of kids.
+func atomize(kids Trees, from, to int) []atom {
+	ret := make([]atom, 0, to-from)
+	pos := 0
+	for i := 0; i < len(kids) && pos < to; i++ {
+		k := &kids[i]
+		n := k.Len()
+		if pos+n <= from {
+			pos += n
+			continue
+		}
+		switch {
+		case k.Tag == T_LEAF:
+			ret = append(ret, atom{kid: k, r: k.Leaf})
+		case k.Tag == T_TEXT:
+			lo, hi := 0, n
+			if from > pos {
+				lo = from - pos
+			}
+			if to < pos+n {
+				hi = to - pos
+			}
+			for _, r := range k.Text[lo:hi] {
+				ret = append(ret, atom{kid: k, r: r})
+			}
+		default:
+			bs, err := json.Marshal(k)
+			if err != nil {
+				panic(errors.Annotatef(err, "atomize failed, bad kid: %s", k.String()))
+			}
+			ret = append(ret, atom{kid: k, key: string(bs)})
+		}
+		pos += n
+	}
+	return ret
+}
+
+func (a *atom) equal(b *atom) bool {
+	if a.kid.IsBranch() || b.kid.IsBranch() {
+		return a.kid.IsBranch() && b.kid.IsBranch() && a.key == b.key
+	}
+	return a.r == b.r && a.kid.Attrs.Equal(b.kid.Attrs)
+}
+
+// tree returns the position as a tree: a leaf with the attributes of its run,
+// or the branch itself.
+func (a *atom) tree() Tree {
+	if a.kid.IsBranch() {
+		return *a.kid
+	}
+	return Leaf(a.r).WithAttrs(a.kid.Attrs)
+}
+
+// sameTree reports whether the trees a and b are identical.
+func sameTree(a, b *Tree) bool {
+	if a.Tag != b.Tag || a.Leaf != b.Leaf || a.Type != b.Type || !a.Attrs.Equal(b.Attrs) ||
+		len(a.Text) != len(b.Text) || len(a.Kids) != len(b.Kids) {
+		return false
+	}
+	for i := range a.Text {
+		if a.Text[i] != b.Text[i] {
+			return false
+		}
+	}
+	for i := range a.Kids {
+		if !sameTree(&a.Kids[i], &b.Kids[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// common returns the number of positions, up to max, that a and b share at
+// their starts or, if back is set, at their ends. It walks the kids in place,
+// so that diffs of large docs with small edits only atomize the edited middle.
+func common(a, b Trees, back bool, max int) int {
+	kid := func(ts Trees, i int) *Tree {
+		if back {
+			return &ts[len(ts)-1-i]
+		}
+		return &ts[i]
+	}
+	// runes returns the runes of t that are left after the first k, in walk
+	// order; leaves are runs of one rune
+	runes := func(t *Tree, k int) []rune {
+		rs := t.Text
+		if t.Tag == T_LEAF {
+			rs = []rune{t.Leaf}
+		}
+		if back {
+			return rs[:len(rs)-k]
+		}
+		return rs[k:]
+	}
+
+	n := 0
+	i, j := 0, 0 // the current kids of a and b
+	x, y := 0, 0 // the runes of the current kids that have been walked
+	for n < max && i < len(a) && j < len(b) {
+		ka, kb := kid(a, i), kid(b, j)
+		switch {
+		case ka.Tag == T_TEXT && len(ka.Text) == 0:
+			i++
+		case kb.Tag == T_TEXT && len(kb.Text) == 0:
+			j++
+		case ka.IsBranch() || kb.IsBranch():
+			if !ka.IsBranch() || !kb.IsBranch() || !sameTree(ka, kb) {
+				return n
+			}
+			n, i, j = n+1, i+1, j+1
+		default:
+			if !ka.Attrs.Equal(kb.Attrs) {
+				return n
+			}
+			ra, rb := runes(ka, x), runes(kb, y)
+			l := len(ra)
+			if len(rb) < l {
+				l = len(rb)
+			}
+			if max-n < l {
+				l = max - n
+			}
+			for k := 0; k < l; k++ {
+				p, q := k, k
+				if back {
+					p, q = len(ra)-1-k, len(rb)-1-k
+				}
+				if ra[p] != rb[q] {
+					return n + k
+				}
+			}
+			n, x, y = n+l, x+l, y+l
+			if l == len(ra) {
+				i, x = i+1, 0
+			}
+			if l == len(rb) {
+				j, y = j+1, 0
+			}
+		}
+	}
+	return n
+}
+
+type editTag int
+
+const (
+	e_EQUAL editTag = iota
+	e_DELETE
+	e_INSERT
+)
+
+type edit struct {
+	tag editTag
+	n   int
+}
+
+// differ computes a shortest edit script between two atom sequences using the
+// linear-space variant of Myers' O(ND) algorithm.
+type differ struct {
+	as, bs []atom
+	script []edit
+}
+
+func (d *differ) emit(tag editTag, n int) {
+	if n == 0 {
+		return
+	}
+	if l := len(d.script); l > 0 && d.script[l-1].tag == tag {
+		d.script[l-1].n += n
+		return
+	}
+	d.script = append(d.script, edit{tag, n})
+}
+
+func (d *differ) diff(a0, a1, b0, b1 int) {
+	pre := 0
+	for a0+pre < a1 && b0+pre < b1 && d.as[a0+pre].equal(&d.bs[b0+pre]) {
+		pre++
+	}
+	d.emit(e_EQUAL, pre)
+	a0, b0 = a0+pre, b0+pre
+
+	suf := 0
+	for a0 < a1-suf && b0 < b1-suf && d.as[a1-suf-1].equal(&d.bs[b1-suf-1]) {
+		suf++
+	}
+	a1, b1 = a1-suf, b1-suf
+
+	switch {
+	case a0 == a1:
+		d.emit(e_INSERT, b1-b0)
+	case b0 == b1:
+		d.emit(e_DELETE, a1-a0)
+	default:
+		x, y, ok := d.bisect(a0, a1, b0, b1)
+		if ok {
+			d.diff(a0, x, b0, y)
+			d.diff(x, a1, y, b1)
+		} else {
+			d.emit(e_DELETE, a1-a0)
+			d.emit(e_INSERT, b1-b0)
+		}
+	}
+
+	d.emit(e_EQUAL, suf)
+}
+
+// bisect finds the middle snake of the edit graph between as[a0:a1] and
+// bs[b0:b1] and returns the point at which to split the problem.
+func (d *differ) bisect(a0, a1, b0, b1 int) (int, int, bool) {
+	n, m := a1-a0, b1-b0
+	maxD := (n + m + 1) / 2
+	off := maxD
+	v1 := make([]int, 2*maxD+2)
+	v2 := make([]int, 2*maxD+2)
+	for i := range v1 {
+		v1[i], v2[i] = -1, -1
+	}
+	v1[off+1], v2[off+1] = 0, 0
+	delta := n - m
+	front := delta%2 != 0
+	k1start, k1end, k2start, k2end := 0, 0, 0, 0
+
+	for D := 0; D < maxD; D++ {
+		// walk the front path one step
+		for k1 := -D + k1start; k1 <= D-k1end; k1 += 2 {
+			k1off := off + k1
+			var x1 int
+			if k1 == -D || (k1 != D && v1[k1off-1] < v1[k1off+1]) {
+				x1 = v1[k1off+1]
+			} else {
+				x1 = v1[k1off-1] + 1
+			}
+			y1 := x1 - k1
+			for x1 < n && y1 < m && d.as[a0+x1].equal(&d.bs[b0+y1]) {
+				x1++
+				y1++
+			}
+			v1[k1off] = x1
+			switch {
+			case x1 > n:
+				k1end += 2
+			case y1 > m:
+				k1start += 2
+			case front:
+				k2off := off + delta - k1
+				if k2off >= 0 && k2off < len(v2) && v2[k2off] != -1 {
+					if x1 >= n-v2[k2off] {
+						return a0 + x1, b0 + y1, true
+					}
+				}
+			}
+		}
+
+		// walk the reverse path one step
+		for k2 := -D + k2start; k2 <= D-k2end; k2 += 2 {
+			k2off := off + k2
+			var x2 int
+			if k2 == -D || (k2 != D && v2[k2off-1] < v2[k2off+1]) {
+				x2 = v2[k2off+1]
+			} else {
+				x2 = v2[k2off-1] + 1
+			}
+			y2 := x2 - k2
+			for x2 < n && y2 < m && d.as[a1-x2-1].equal(&d.bs[b1-y2-1]) {
+				x2++
+				y2++
+			}
+			v2[k2off] = x2
+			switch {
+			case x2 > n:
+				k2end += 2
+			case y2 > m:
+				k2start += 2
+			case !front:
+				k1off := off + delta - k2
+				if k1off >= 0 && k1off < len(v1) && v1[k1off] != -1 {
+					x1 := v1[k1off]
+					y1 := off + x1 - k1off
+					if x1 >= n-x2 {
+						return a0 + x1, b0 + y1, true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// diffKids returns the ops that turn the kids a into the kids b.
+func diffKids(a, b Trees) Ops {
+	n, m := a.Len(), b.Len()
+	max := n
+	if m < max {
+		max = m
+	}
+	pre := common(a, b, false, max)
+	suf := common(a, b, true, max-pre)
+	d := &differ{
+		as: atomize(a, pre, n-suf),
+		bs: atomize(b, pre, m-suf),
+	}
+	d.diff(0, len(d.as), 0, len(d.bs))
+
+	ret := Ops{}
+	ret.Retain(pre)
+	i, j := 0, 0
+	for k := 0; k < len(d.script); {
+		if d.script[k].tag == e_EQUAL {
+			ret.Retain(d.script[k].n)
+			i += d.script[k].n
+			j += d.script[k].n
+			k++
+			continue
+		}
+
+		// collect the hunk of deletes and inserts between equal runs
+		dels, ins := d.as[i:i], d.bs[j:j]
+		for ; k < len(d.script) && d.script[k].tag != e_EQUAL; k++ {
+			switch d.script[k].tag {
+			case e_DELETE:
+				dels = d.as[i-len(dels) : i+d.script[k].n]
+				i += d.script[k].n
+			case e_INSERT:
+				ins = d.bs[j-len(ins) : j+d.script[k].n]
+				j += d.script[k].n
+			}
+		}
+
+		// pair up replaced branches of the same type so that they can be diffed
+		// recursively
+		for len(dels) > 0 || len(ins) > 0 {
+			switch {
+			case len(dels) > 0 && len(ins) > 0 && dels[0].kid.IsBranch() && ins[0].kid.IsBranch() && dels[0].kid.Type == ins[0].kid.Type:
+				ret.FormatWith(diffKids(dels[0].kid.Kids, ins[0].kid.Kids), diffAttrs(dels[0].kid.Attrs, ins[0].kid.Attrs))
+				dels, ins = dels[1:], ins[1:]
+			case len(dels) > 0 && (len(ins) == 0 || !dels[0].kid.IsBranch()):
+				ret.Delete(1)
+				dels = dels[1:]
+			default:
+				t := ins[0].tree()
+				ret.Insert(t.Clone())
+				ins = ins[1:]
+			}
+		}
+	}
+	ret.Retain(suf)
+
+	ret, err := Normalize(ret)
+	if err != nil {
+		panic(errors.Annotatef(err, "diffKids failed, unable to normalize ops"))
+	}
+	return ret
+}
@@ -0,0 +1,164 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type DiffCase struct {
+	A, B string
+	Ops  Ops
+}
+
+func TestDiff(t *testing.T) {
+	cases := []DiffCase{
+		{"", "", Ws(Ops{})},
+		{"abc", "abc", Ws(Rs(3))},
+		{"", "abc", Ws(Is("abc"))},
+		{"abc", "", Ws(Ds(3))},
+		{"abc", "abd", Ws(C(Rs(2), Is("d"), Ds(1)))},
+		{"abc", "xabc", Ws(C(Is("x"), Rs(3)))},
+		{"hello world", "hello, world!", Ws(C(Rs(5), Is(","), Rs(6), Is("!")))},
+		{"abcabba", "cbabac", nil},
+	}
+	for idx, c := range cases {
+		ops := Diff(c.A, c.B)
+		if c.Ops != nil && !reflect.DeepEqual(ops, c.Ops) {
+			t.Errorf("diff %d failed; a: %q, b: %q, got: %s, expected: %s", idx, c.A, c.B, ops, c.Ops)
+		}
+		checkDiff(t, c.A, c.B, ops)
+	}
+}
+
+// lcs returns the length of the longest common subsequence of a and b.
+func lcs(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	for i := range a {
+		cur := make([]int, len(b)+1)
+		for j := range b {
+			switch {
+			case a[i] == b[j]:
+				cur[j+1] = prev[j] + 1
+			case prev[j+1] > cur[j]:
+				cur[j+1] = prev[j+1]
+			default:
+				cur[j+1] = cur[j]
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func checkDiff(t *testing.T, a, b string, ops Ops) {
+	root := AsRuneTree(a)
+	if err := Validate(ops[0].Kids, root); err != nil {
+		t.Fatalf("diff invalid; a: %q, b: %q, ops: %s, err: %q", a, b, ops, err)
+	}
+	if err := Apply(ops[0], &root); err != nil {
+		t.Fatalf("diff apply failed; a: %q, b: %q, ops: %s, err: %q", a, b, ops, err)
+	}
+	expected := AsRuneTree(b)
+	if root.String() != expected.String() {
+		t.Fatalf("diff mismatch; a: %q, b: %q, ops: %s, got: %s", a, b, ops, root.String())
+	}
+
+	// minimality: every rune outside the LCS is deleted or inserted exactly once
+	ra, rb := AsRunes(a), AsRunes(b)
+	l := lcs(ra, rb)
+	dels, ins := 0, 0
+	for _, o := range ops[0].Kids {
+		switch {
+		case o.IsDelete():
+			dels += o.Len()
+		case o.IsInsert():
+			ins += o.Len()
+		}
+	}
+	if dels != len(ra)-l || ins != len(rb)-l {
+		t.Fatalf("diff not minimal; a: %q, b: %q, ops: %s, lcs: %d", a, b, ops, l)
+	}
+}
+
+func TestRandomDiff(t *testing.T) {
+	alphabet := []rune("abcé")
+	randString := func() string {
+		rs := make([]rune, RandIntn(30))
+		for i := range rs {
+			rs[i] = alphabet[RandIntn(len(alphabet))]
+		}
+		return string(rs)
+	}
+	for i := 0; i < 2000; i++ {
+		a, b := randString(), randString()
+		checkDiff(t, a, b, Diff(a, b))
+	}
+}
+
+func TestDiffTree(t *testing.T) {
+	// [a [b c] d] -> [a [b x c] {k="v"}[] d]
+	a := Branch(Trees{Leaf('a'), Branch(Trees{Text(AsRunes("bc"))}), Leaf('d')})
+	b := Branch(Trees{
+		Leaf('a'),
+		Branch(Trees{Text(AsRunes("bxc"))}),
+		Branch(nil).WithAttrs(Attrs{"k": "v"}),
+		Leaf('d'),
+	})
+
+	ops := DiffTree(a, b)
+	expected := Ws(C(Rs(1), Ws(C(Rs(1), Is("x"), Rs(1))), Ops{It(Branch(nil).WithAttrs(Attrs{"k": "v"}))}, Rs(1)))
+	if !reflect.DeepEqual(ops, expected) {
+		t.Errorf("DiffTree failed; got: %s, expected: %s", ops, expected)
+	}
+
+	// replaced branches are diffed recursively, including their attributes
+	c := Branch(Trees{Leaf('a'), Branch(Trees{Text(AsRunes("bc"))}).WithAttrs(Attrs{"heading": "1"}), Leaf('d')})
+	ops = DiffTree(a, c)
+	expected = Ws(C(Rs(1), Ops{Wf(Rs(2), Attrs{"heading": "1"})}, Rs(1)))
+	if !reflect.DeepEqual(ops, expected) {
+		t.Errorf("DiffTree failed; got: %s, expected: %s", ops, expected)
+	}
+
+	// runs are compared by position, however they are split
+	e := Branch(Trees{Text(AsRunes("ab")), Leaf('c'), Text(AsRunes("de"))})
+	f := Branch(Trees{Text(AsRunes("abc")), Leaf('x'), Text(AsRunes("d")), Leaf('e')})
+	ops = DiffTree(e, f)
+	expected = Ws(C(Rs(3), Is("x"), Rs(2)))
+	if !reflect.DeepEqual(ops, expected) {
+		t.Errorf("DiffTree failed; got: %s, expected: %s", ops, expected)
+	}
+
+	for _, pair := range [][2]Tree{{a, b}, {b, a}, {a, c}, {c, b}, {e, f}, {f, e}} {
+		root := pair[0].Clone()
+		ops := DiffTree(pair[0], pair[1])
+		if err := Validate(ops[0].Kids, root); err != nil {
+			t.Fatalf("DiffTree invalid; ops: %s, err: %q", ops, err)
+		}
+		if err := Apply(ops[0], &root); err != nil {
+			t.Fatalf("DiffTree apply failed; ops: %s, err: %q", ops, err)
+		}
+		if root.String() != pair[1].String() {
+			t.Fatalf("DiffTree mismatch; ops: %s, got: %s, expected: %s", ops, root.String(), pair[1].String())
+		}
+	}
+}
+
+func benchmarkDiffTree(b *testing.B, size int) {
+	// a doc of size runes and a copy with one rune changed in the middle
+	rs := []rune(strings.Repeat("x", size))
+	a := Branch(Trees{Text(rs)})
+	rs2 := append([]rune(nil), rs...)
+	rs2[size/2] = 'y'
+	c := Branch(Trees{Text(rs2)})
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		DiffTree(a, c)
+	}
+}
+
+func BenchmarkDiffTree1K(b *testing.B) { benchmarkDiffTree(b, 1<<10) }
+func BenchmarkDiffTree1M(b *testing.B) { benchmarkDiffTree(b, 1<<20) }
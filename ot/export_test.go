@@ -0,0 +1,15 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot
+
+// Exports for the tests in package ot_test, which cannot be part of package ot
+// because they use package ottest.
+var (
+	Compose1      = compose1
+	Compose1Rec   = compose1Rec
+	Transform1    = func(as, bs Ops) (Ops, Ops, error) { return transform1(as, bs, true) }
+	Transform1Rec = transform1Rec
+	NormalizeRec  = normalizeRec
+	Rebasable     = rebasable
+)
@@ -0,0 +1,44 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/juju/errors"
+)
+
+// Hash returns a digest of the contents of t: the hex-encoded SHA-256 of the
+// JSON encoding of t after its branches have been packed, recursively. Trees
+// with equal contents have equal hashes however their text happens to be split
+// into runs.
+func Hash(t Tree) string {
+	bs, err := json.Marshal(Canonical(t))
+	if err != nil {
+		panic(errors.Annotatef(err, "Hash failed, bad tree: %#v", t))
+	}
+	sum := sha256.Sum256(bs)
+	return hex.EncodeToString(sum[:])
+}
+
+// Canonical returns a copy of t in which each branch has been packed,
+// recursively, so that trees with equal contents compare equal however their
+// text happens to be split into runs.
+func Canonical(t Tree) Tree {
+	if !t.IsBranch() {
+		return t
+	}
+	kids := make(Trees, len(t.Kids))
+	for k, v := range t.Kids {
+		kids[k] = Canonical(v)
+	}
+	return Branch(kids).WithAttrs(t.Attrs).WithType(t.Type)
+}
+
+// Hash returns the digest of d's body; see Hash.
+func (d *Doc) Hash() string {
+	return Hash(d.Body())
+}
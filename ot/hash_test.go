@@ -0,0 +1,94 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot
+
+import (
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	bold := Attrs{"bold": "true"}
+
+	// equal contents, differently split
+	same := []Tree{
+		Branch(Trees{Text(AsRunes("abc")), Branch(Trees{Leaf('d')})}),
+		{Tag: T_BRANCH, Kids: Trees{Leaf('a'), Text(AsRunes("bc")), {Tag: T_BRANCH, Kids: Trees{Leaf('d')}}}},
+		{Tag: T_BRANCH, Kids: Trees{Text(AsRunes("ab")), Leaf('c'), Branch(Trees{Text(AsRunes("d"))})}},
+	}
+	for i := range same {
+		if Hash(same[i]) != Hash(same[0]) {
+			t.Errorf("hash %d differs; t: %s", i, same[i].String())
+		}
+	}
+
+	// different contents
+	differ := []Tree{
+		Branch(Trees{Text(AsRunes("abc")), Branch(nil)}),
+		Branch(Trees{Text(AsRunes("abd")), Branch(Trees{Leaf('d')})}),
+		Branch(Trees{Text(AsRunes("abc")), Leaf('d')}),
+		Branch(Trees{Text(AsRunes("abc")), Branch(Trees{Leaf('d')}).WithAttrs(bold)}),
+		Branch(Trees{Text(AsRunes("ab")), Leaf('c').WithAttrs(bold), Branch(Trees{Leaf('d')})}),
+	}
+	seen := map[string]int{Hash(same[0]): -1}
+	for i, d := range differ {
+		h := Hash(d)
+		if j, ok := seen[h]; ok {
+			t.Errorf("hash %d collides with %d; t: %s", i, j, d.String())
+		}
+		seen[h] = i
+	}
+}
+
+func TestDocHash(t *testing.T) {
+	d1 := NewDoc()
+	d2 := NewDoc()
+	if d1.Hash() != d2.Hash() {
+		t.Fatalf("empty docs hash differently")
+	}
+
+	d1.Apply(Is("hello"))
+	d1.Apply(C(Rs(5), Is(" world")))
+	d2.Apply(Is("hello world"))
+	if d1.Hash() != d2.Hash() {
+		t.Fatalf("equal docs hash differently; d1: %s, d2: %s", d1.String(), d2.String())
+	}
+
+	d2.Apply(C(Rs(10), Ds(1)))
+	if d1.Hash() == d2.Hash() {
+		t.Fatalf("different docs hash equally; d1: %s, d2: %s", d1.String(), d2.String())
+	}
+}
+
+func TestControllerHash(t *testing.T) {
+	c := &testClient{doc: NewDoc()}
+	st := NewController(c, c)
+	server := NewDoc()
+
+	check := func() {
+		if got := c.sent[len(c.sent)-1].Hash; got != server.Hash() {
+			t.Fatalf("sent hash %q, expected %q of server doc %s", got, server.Hash(), server.String())
+		}
+	}
+
+	c.write(st, NewInsert(0, 0, "a"))
+	check()
+	c.write(st, NewInsert(1, 1, "b"))
+	server.Apply(NewInsert(0, 0, "a"))
+	if err := st.OnServerAck(1, NewInsert(0, 0, "a")); err != nil {
+		t.Fatalf("ack failed, err: %q", err)
+	}
+	check()
+
+	// the cached hash follows server writes
+	server.Apply(NewInsert(1, 0, "x"))
+	if err := st.OnServerWrite(2, "", NewInsert(1, 0, "x")); err != nil {
+		t.Fatalf("write failed, err: %q", err)
+	}
+	if err := st.OnServerAck(3, NewInsert(2, 2, "b")); err != nil {
+		t.Fatalf("ack failed, err: %q", err)
+	}
+	server.Apply(NewInsert(2, 2, "b"))
+	c.write(st, NewInsert(3, 3, "c"))
+	check()
+}
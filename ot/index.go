@@ -0,0 +1,139 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot
+
+// Bias says which way an index moves when text is inserted exactly at it.
+type Bias int
+
+const (
+	// B_BEFORE indexes stick to the position to their right, so inserts at
+	// the index land before it and the index moves past them.
+	B_BEFORE Bias = iota
+	// B_AFTER indexes stick to the position to their left, so inserts at the
+	// index land after it and the index stays put.
+	B_AFTER
+)
+
+// Path locates an index in a nested document: each element but the last is
+// the position of a branch within its parent, and the last element is an
+// index within the innermost branch.
+type Path []int
+
+// Range is a selection between two indexes of the same branch.
+type Range struct {
+	Start, End int
+}
+
+// TransformIndex returns the index corresponding to pos after ops have been
+// applied. Indexes within deleted spans move to the start of the span; moves
+// count as deletes at their old positions and inserts at their new ones.
+func TransformIndex(pos int, ops Ops, sticky Bias) int {
+	ret := pos
+	in := 0
+	for _, o := range ops {
+		if in > pos {
+			break
+		}
+		switch {
+		case o.IsInsert(), o.IsPlace():
+			if in < pos || sticky == B_BEFORE {
+				ret += o.Len()
+			}
+		case o.IsRetain(), o.IsWith():
+			in += o.Len()
+		case o.IsDelete(), o.IsMove():
+			n := o.Len()
+			switch {
+			case pos >= in+n:
+				ret -= n
+			case pos > in:
+				ret -= pos - in
+			}
+			in += n
+		}
+	}
+	return ret
+}
+
+// TransformRange returns the range corresponding to r after ops have been
+// applied.
+//
+// Inserts at the ends of a non-empty range land outside of it; empty ranges
+// (e.g., carets) are transformed like single indexes with bias sticky.
+func TransformRange(r Range, ops Ops, sticky Bias) Range {
+	if r.Start == r.End {
+		n := TransformIndex(r.Start, ops, sticky)
+		return Range{n, n}
+	}
+	ret := Range{
+		Start: TransformIndex(r.Start, ops, B_BEFORE),
+		End:   TransformIndex(r.End, ops, B_AFTER),
+	}
+	if ret.End < ret.Start {
+		ret.End = ret.Start
+	}
+	return ret
+}
+
+// TransformPath returns the path corresponding to p after ops have been
+// applied, descending through the With ops that modify the branches along p
+// and following the branches that are moved. It returns false if any of the
+// branches along p has been deleted.
+func TransformPath(p Path, ops Ops, sticky Bias) (Path, bool) {
+	if len(p) == 0 {
+		return nil, false
+	}
+	if len(p) == 1 {
+		return Path{TransformIndex(p[0], ops, sticky)}, true
+	}
+
+	pos := p[0]
+	ret := Path{TransformIndex(pos, ops, B_BEFORE)}
+	in := 0
+	for _, o := range ops {
+		if o.IsInsert() || o.IsPlace() || o.IsZero() {
+			continue
+		}
+		if pos < in+o.Len() {
+			switch {
+			case o.IsDelete():
+				return nil, false
+			case o.IsMove():
+				rest := p[1:]
+				if len(o.Kids) > 0 {
+					var ok bool
+					if rest, ok = TransformPath(p[1:], o.Kids, sticky); !ok {
+						return nil, false
+					}
+				}
+				return append(Path{placeIndex(o.Id, ops)}, rest...), true
+			case o.IsWith():
+				rest, ok := TransformPath(p[1:], o.Kids, sticky)
+				if !ok {
+					return nil, false
+				}
+				return append(ret, rest...), true
+			default:
+				return append(ret, p[1:]...), true
+			}
+		}
+		in += o.Len()
+	}
+	return append(ret, p[1:]...), true
+}
+
+// placeIndex returns the index of the place of the move identified by id in
+// the output of ops.
+func placeIndex(id int, ops Ops) int {
+	out := 0
+	for _, o := range ops {
+		if o.IsPlace() && o.Id == id {
+			break
+		}
+		if o.IsRetain() || o.IsInsert() || o.IsWith() || o.IsPlace() {
+			out += o.Len()
+		}
+	}
+	return out
+}
@@ -0,0 +1,147 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+type IndexCase struct {
+	Pos    int
+	Ops    Ops
+	Before int
+	After  int
+}
+
+func TestTransformIndex(t *testing.T) {
+	cases := []IndexCase{
+		{2, C(Rs(2), Is("xy"), Rs(2)), 4, 2},
+		{2, C(Rs(1), Is("xy"), Rs(3)), 4, 4},
+		{2, C(Rs(3), Is("xy"), Rs(1)), 2, 2},
+		{2, C(Rs(1), Ds(2), Rs(1)), 1, 1},
+		{2, C(Ds(2), Rs(2)), 0, 0},
+		{2, C(Rs(2), Ds(2)), 2, 2},
+		{2, C(Ds(1), Is("abc"), Rs(3)), 4, 4},
+		{2, C(Ws(Ds(1)), Rs(1), Is("a"), Rs(2)), 3, 2},
+		{4, C(Rs(2), Is("a"), Rs(2)), 5, 5},
+		{4, C(Rs(4), Is("a")), 5, 4},
+		{0, C(Is("a"), Rs(4)), 1, 0},
+	}
+
+	for idx, c := range cases {
+		if n := TransformIndex(c.Pos, c.Ops, B_BEFORE); n != c.Before {
+			t.Errorf("index %d failed; TransformIndex(%d, %s, B_BEFORE) -> %d != expected %d", idx, c.Pos, c.Ops, n, c.Before)
+		}
+		if n := TransformIndex(c.Pos, c.Ops, B_AFTER); n != c.After {
+			t.Errorf("index %d failed; TransformIndex(%d, %s, B_AFTER) -> %d != expected %d", idx, c.Pos, c.Ops, n, c.After)
+		}
+	}
+}
+
+func TestTransformRange(t *testing.T) {
+	ops := C(Rs(1), Is("a"), Rs(2), Is("b"), Rs(1))
+
+	if r := TransformRange(Range{1, 3}, ops, B_BEFORE); r != (Range{2, 4}) {
+		t.Errorf("expected inserts at ends to land outside range; got %v", r)
+	}
+	if r := TransformRange(Range{1, 1}, ops, B_AFTER); r != (Range{1, 1}) {
+		t.Errorf("expected caret to stay put; got %v", r)
+	}
+	if r := TransformRange(Range{1, 1}, ops, B_BEFORE); r != (Range{2, 2}) {
+		t.Errorf("expected caret to move past insert; got %v", r)
+	}
+	if r := TransformRange(Range{1, 3}, C(Ds(4)), B_BEFORE); r != (Range{0, 0}) {
+		t.Errorf("expected deleted range to collapse; got %v", r)
+	}
+}
+
+func TestTransformPath(t *testing.T) {
+	// [a [b c [d e]] f]
+	ops := C(Is("x"), Rs(1), Ws(C(Ds(1), Rs(1), Ws(C(Is("y"), Rs(2))))), Rs(1))
+
+	cases := []struct {
+		P      Path
+		Q      Path
+		Exists bool
+	}{
+		{Path{0}, Path{1}, true},
+		{Path{1, 0}, Path{2, 0}, true},
+		{Path{1, 2, 1}, Path{2, 1, 2}, true},
+		{Path{1, 2, 0}, Path{2, 1, 1}, true},
+		{Path{1, 1, 0}, Path{2, 0, 0}, true},
+		{Path{2}, Path{3}, true},
+		{Path{2, 0}, Path{3, 0}, true},
+	}
+	for idx, c := range cases {
+		q, ok := TransformPath(c.P, ops, B_BEFORE)
+		if ok != c.Exists || !reflect.DeepEqual(q, c.Q) {
+			t.Errorf("path %d failed; TransformPath(%v) -> %v, %t != expected %v, %t", idx, c.P, q, ok, c.Q, c.Exists)
+		}
+	}
+
+	if _, ok := TransformPath(Path{1, 0, 0}, ops, B_BEFORE); ok {
+		t.Errorf("expected path into deleted branch to vanish")
+	}
+}
+
+// uniqueDoc returns a doc holding n distinct runes that GetRandomOps will never
+// insert.
+func uniqueDoc(n int) *Doc {
+	rs := make([]rune, n)
+	for i := range rs {
+		rs[i] = rune(0x4e00 + i)
+	}
+	d := NewDoc()
+	d.Apply(Ir(rs))
+	return d
+}
+
+// runeIndex returns the index of r in d's body, or -1.
+func runeIndex(d *Doc, r rune) int {
+	n := 0
+	for _, k := range d.Body().Kids {
+		for _, c := range k.Runes() {
+			if c == r {
+				return n
+			}
+			n++
+		}
+	}
+	return -1
+}
+
+func TestRandomTransformIndex(t *testing.T) {
+	for i := 0; i < 500; i++ {
+		size := 1 + rand.Intn(20)
+		d := uniqueDoc(size)
+		ops := d.GetRandomOps(1 + rand.Intn(10))
+		pos := rand.Intn(size + 1)
+		before := TransformIndex(pos, ops, B_BEFORE)
+		after := TransformIndex(pos, ops, B_AFTER)
+
+		d2 := d.Snapshot()
+		err := d2.Apply(ops)
+		if err != nil {
+			t.Fatalf("apply failed, ops: %s, err: %q", ops, err)
+		}
+
+		if before < 0 || before > d2.Len() || after < 0 || after > before {
+			t.Fatalf("index out of range; pos: %d, ops: %s, before: %d, after: %d, len: %d", pos, ops, before, after, d2.Len())
+		}
+		// with B_BEFORE, indexes stay just before the rune to their right...
+		if pos < size {
+			if n := runeIndex(d2, rune(0x4e00+pos)); n >= 0 && n != before {
+				t.Fatalf("B_BEFORE failed; pos: %d, ops: %s, got: %d, expected: %d", pos, ops, before, n)
+			}
+		}
+		// ...and with B_AFTER, just after the rune to their left.
+		if pos > 0 {
+			if n := runeIndex(d2, rune(0x4e00+pos-1)); n >= 0 && n+1 != after {
+				t.Fatalf("B_AFTER failed; pos: %d, ops: %s, got: %d, expected: %d", pos, ops, after, n+1)
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package interop
+
+import (
+	"encoding/json"
+
+	"github.com/juju/errors"
+
+	"github.com/mstone/focus/ot"
+)
+
+// Codec names, as selected by the codec query parameter of the VPP endpoint.
+const (
+	CD_OTJS  = "otjs"
+	CD_TEXT0 = "text0"
+)
+
+// Codec encodes and decodes the ops of VPP messages in a foreign format.
+type Codec interface {
+	// Encode returns the JSON encoding of ops, which apply to text.
+	Encode(text []rune, ops ot.Ops) ([]byte, error)
+	// Decode returns the Ops encoded by data, which apply to text.
+	Decode(text []rune, data []byte) (ot.Ops, error)
+}
+
+// Lookup returns the codec named name.
+func Lookup(name string) (Codec, error) {
+	switch name {
+	case CD_OTJS:
+		return textOpCodec{}, nil
+	case CD_TEXT0:
+		return text0Codec{}, nil
+	default:
+		return nil, errors.Errorf("unknown codec: %q", name)
+	}
+}
+
+type textOpCodec struct{}
+
+func (textOpCodec) Encode(text []rune, ops ot.Ops) ([]byte, error) {
+	op, err := ToTextOp(text, ops)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return json.Marshal(op)
+}
+
+func (textOpCodec) Decode(text []rune, data []byte) (ot.Ops, error) {
+	var op TextOp
+	if err := json.Unmarshal(data, &op); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return FromTextOp(text, op)
+}
+
+type text0Codec struct{}
+
+func (text0Codec) Encode(text []rune, ops ot.Ops) ([]byte, error) {
+	op, err := ToText0(text, ops)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return json.Marshal(op)
+}
+
+func (text0Codec) Decode(text []rune, data []byte) (ot.Ops, error) {
+	var op Text0
+	if err := json.Unmarshal(data, &op); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return FromText0(text, op)
+}
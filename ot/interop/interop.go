@@ -0,0 +1,337 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+// Package interop converts the Ops of plain-text docs to and from the text
+// operations of ot.js and of ShareJS's text0 type, so that tools that speak
+// those formats can edit focus docs.
+//
+// Both formats count positions and lengths in UTF-16 code units, as
+// JavaScript strings do, while Ops count runes, so conversions need the text
+// that the ops apply to. Ops may retain, delete, and insert only runes; the
+// attributes of retains and inserts are dropped, since neither format can
+// carry them.
+package interop
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/juju/errors"
+
+	"github.com/mstone/focus/ot"
+	"github.com/mstone/focus/ot/textpos"
+)
+
+// TextOp is an ot.js TextOperation: a list of retains (positive ints),
+// deletes (negative ints), and inserts (strings) that together span the
+// text that they apply to.
+type TextOp []interface{}
+
+func (op *TextOp) UnmarshalJSON(data []byte) error {
+	var raws []json.RawMessage
+	if err := json.Unmarshal(data, &raws); err != nil {
+		return errors.Trace(err)
+	}
+	ret := make(TextOp, 0, len(raws))
+	for _, raw := range raws {
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return errors.Trace(err)
+		}
+		switch x := v.(type) {
+		case json.Number:
+			n, err := x.Int64()
+			if err != nil {
+				return errors.Annotatef(err, "bad text op component: %s", raw)
+			}
+			ret = append(ret, int(n))
+		case string:
+			ret = append(ret, x)
+		default:
+			return errors.Errorf("bad text op component: %s", raw)
+		}
+	}
+	*op = ret
+	return nil
+}
+
+// check returns an error unless each component of op is a non-zero int or a
+// non-empty string.
+func (op TextOp) check() error {
+	for _, x := range op {
+		switch v := x.(type) {
+		case int:
+			if v == 0 {
+				return errors.Errorf("bad text op, empty retain")
+			}
+		case string:
+			if v == "" {
+				return errors.Errorf("bad text op, empty insert")
+			}
+		default:
+			return errors.Errorf("bad text op, bad component: %#v", x)
+		}
+	}
+	return nil
+}
+
+// push appends x to op, merging it into the last component if they are
+// alike, as ot.js does. (Retains with different attributes would otherwise
+// be left apart.)
+func (op TextOp) push(x interface{}) TextOp {
+	if len(op) > 0 {
+		switch last := op[len(op)-1].(type) {
+		case int:
+			if v, ok := x.(int); ok && (v > 0) == (last > 0) {
+				op[len(op)-1] = last + v
+				return op
+			}
+		case string:
+			if v, ok := x.(string); ok {
+				op[len(op)-1] = last + v
+				return op
+			}
+		}
+	}
+	return append(op, x)
+}
+
+// BaseLen returns the length, in UTF-16 code units, of the text that op
+// applies to.
+func (op TextOp) BaseLen() int {
+	n := 0
+	for _, x := range op {
+		if v, ok := x.(int); ok {
+			n += abs(v)
+		}
+	}
+	return n
+}
+
+// TargetLen returns the length, in UTF-16 code units, of the text that op
+// produces.
+func (op TextOp) TargetLen() int {
+	n := 0
+	for _, x := range op {
+		switch v := x.(type) {
+		case int:
+			if v > 0 {
+				n += v
+			}
+		case string:
+			n += utf16Len([]rune(v))
+		}
+	}
+	return n
+}
+
+// Text0Component is one component of a ShareJS text0 op: it inserts I or
+// deletes D at position P of the text as left by the preceding components.
+type Text0Component struct {
+	P int    `json:"p"`
+	I string `json:"i,omitempty"`
+	D string `json:"d,omitempty"`
+}
+
+// Text0 is a ShareJS text0 op, whose components apply in turn.
+type Text0 []Text0Component
+
+// TextOf returns the runes of d, which must be a plain-text doc.
+func TextOf(d *ot.Doc) ([]rune, error) {
+	rs := []rune{}
+	for _, t := range d.Body().Kids {
+		switch t.Tag {
+		case ot.T_LEAF:
+			rs = append(rs, t.Leaf)
+		case ot.T_TEXT:
+			rs = append(rs, t.Text...)
+		default:
+			return nil, errors.Errorf("doc is not plain text, kid: %s", t.String())
+		}
+	}
+	return rs, nil
+}
+
+// FromTextOp returns the Ops that do what op does to text.
+func FromTextOp(text []rune, op TextOp) (ot.Ops, error) {
+	if err := op.check(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if n, m := op.BaseLen(), utf16Len(text); n != m {
+		return nil, errors.Errorf("bad text op, base len: %d, text len: %d", n, m)
+	}
+	ops := ot.Ops{}
+	c := cursor{text: text}
+	for _, x := range op {
+		switch v := x.(type) {
+		case int:
+			n, err := c.advance(abs(v))
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			if v > 0 {
+				ops = append(ops, ot.R(n))
+			} else {
+				ops = append(ops, ot.D(n))
+			}
+		case string:
+			ops = append(ops, ot.Is(v)...)
+		}
+	}
+	return ot.Normalize(ops)
+}
+
+// ToTextOp returns the TextOp that does what ops do to text.
+func ToTextOp(text []rune, ops ot.Ops) (TextOp, error) {
+	ops, err := normalize(text, ops)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	ret := TextOp{}
+	i := 0
+	for _, o := range ops {
+		switch {
+		case o.IsRetain():
+			ret = ret.push(utf16Len(text[i : i+o.Size]))
+			i += o.Size
+		case o.IsDelete():
+			ret = ret.push(-utf16Len(text[i : i-o.Size]))
+			i -= o.Size
+		case o.IsInsert():
+			ret = ret.push(string(runes(o.Body)))
+		}
+	}
+	return ret, nil
+}
+
+// FromText0 returns the Ops that do what op does to text. The text of each
+// delete must match the text that it deletes.
+func FromText0(text []rune, op Text0) (ot.Ops, error) {
+	cur := ot.CloneRunes(text)
+	ret, err := ot.Normalize(ot.Rs(len(cur)))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, c := range op {
+		i, err := (&cursor{text: cur}).advance(c.P)
+		if err != nil {
+			return nil, errors.Annotatef(err, "bad text0 position: %d", c.P)
+		}
+		var step ot.Ops
+		switch {
+		case c.I != "" && c.D == "":
+			rs := []rune(c.I)
+			step = ot.NewInsert(len(cur), i, c.I)
+			cur = append(cur[:i], append(rs, cur[i:]...)...)
+		case c.D != "" && c.I == "":
+			rs := []rune(c.D)
+			if i+len(rs) > len(cur) || string(cur[i:i+len(rs)]) != c.D {
+				return nil, errors.Errorf("bad text0 delete, text mismatch at %d: %q", c.P, c.D)
+			}
+			step = ot.NewDelete(len(cur), i, len(rs))
+			cur = append(cur[:i], cur[i+len(rs):]...)
+		default:
+			return nil, errors.Errorf("bad text0 component: %+v", c)
+		}
+		if step, err = ot.Normalize(step); err != nil {
+			return nil, errors.Trace(err)
+		}
+		if ret, err = ot.Compose(ret, step); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return ret, nil
+}
+
+// ToText0 returns the Text0 that does what ops do to text.
+func ToText0(text []rune, ops ot.Ops) (Text0, error) {
+	ops, err := normalize(text, ops)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	ret := Text0{}
+	i, p := 0, 0
+	for _, o := range ops {
+		switch {
+		case o.IsRetain():
+			p += utf16Len(text[i : i+o.Size])
+			i += o.Size
+		case o.IsDelete():
+			ret = append(ret, Text0Component{P: p, D: string(text[i : i-o.Size])})
+			i -= o.Size
+		case o.IsInsert():
+			rs := runes(o.Body)
+			ret = append(ret, Text0Component{P: p, I: string(rs)})
+			p += utf16Len(rs)
+		}
+	}
+	return ret, nil
+}
+
+// normalize normalizes ops, checking that they span text and that they
+// retain, delete, and insert only runes.
+func normalize(text []rune, ops ot.Ops) (ot.Ops, error) {
+	ops, err := ot.Normalize(ops)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	n := 0
+	for _, o := range ops {
+		switch {
+		case o.IsRetain(), o.IsDelete():
+			n += o.Len()
+		case o.IsInsertText(), o.IsInsertLeaf():
+		default:
+			return nil, errors.Errorf("non-text op: %s", o.String())
+		}
+	}
+	if n != len(text) {
+		return nil, errors.Errorf("ops span %d runes, text has %d", n, len(text))
+	}
+	return ops, nil
+}
+
+// runes returns the runes of t, a leaf or a text run.
+func runes(t ot.Tree) []rune {
+	if t.Tag == ot.T_LEAF {
+		return []rune{t.Leaf}
+	}
+	return t.Text
+}
+
+func utf16Len(rs []rune) int {
+	return textpos.Of(rs).UTF16
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// cursor walks text, counting runes.
+type cursor struct {
+	text []rune
+	i    int
+}
+
+// advance moves c forward by n UTF-16 code units and returns the number of
+// runes that it passed.
+func (c *cursor) advance(n int) (int, error) {
+	if n < 0 {
+		return 0, errors.Errorf("bad position, negative offset: %d", n)
+	}
+	start := c.i
+	for u := 0; u < n; c.i++ {
+		if c.i >= len(c.text) {
+			return 0, errors.Errorf("bad position, past the end of the text")
+		}
+		if u += textpos.OfRune(c.text[c.i]).UTF16; u > n {
+			return 0, errors.Errorf("bad position, within a surrogate pair")
+		}
+	}
+	return c.i - start, nil
+}
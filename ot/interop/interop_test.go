@@ -0,0 +1,151 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package interop_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/mstone/focus/ot"
+	"github.com/mstone/focus/ot/interop"
+)
+
+func TestTextOp(t *testing.T) {
+	// 😀 takes two UTF-16 code units
+	text := []rune("a😀bc")
+	cases := []struct {
+		Ops  ot.Ops
+		JSON string
+		Back ot.Ops // Ops, without attributes
+	}{
+		{ot.Rs(4), `[5]`, ot.Rs(4)},
+		{ot.C(ot.Rs(1), ot.Is("x"), ot.Ds(1), ot.Rs(2)), `[1,"x",-2,2]`, nil},
+		{ot.C(ot.Is("😀"), ot.Ds(4)), `["😀",-5]`, nil},
+		{ot.C(ot.Rs(2), ot.Fs(2, ot.Attrs{"bold": "true"})), `[5]`, ot.Rs(4)},
+	}
+	for _, c := range cases {
+		op, err := interop.ToTextOp(text, c.Ops)
+		if err != nil {
+			t.Fatalf("ToTextOp(%s) failed, err: %q", c.Ops.String(), err)
+		}
+		js, _ := json.Marshal(op)
+		if string(js) != c.JSON {
+			t.Errorf("ToTextOp(%s): expected %s, got %s", c.Ops.String(), c.JSON, js)
+		}
+		if op.BaseLen() != 5 {
+			t.Errorf("BaseLen(%s): expected 5, got %d", js, op.BaseLen())
+		}
+
+		var op2 interop.TextOp
+		if err := json.Unmarshal([]byte(c.JSON), &op2); err != nil {
+			t.Fatalf("unmarshal of %s failed, err: %q", c.JSON, err)
+		}
+		ops, err := interop.FromTextOp(text, op2)
+		if err != nil {
+			t.Fatalf("FromTextOp(%s) failed, err: %q", c.JSON, err)
+		}
+		if c.Back == nil {
+			c.Back = c.Ops
+		}
+		if !reflect.DeepEqual(ops, c.Back) {
+			t.Errorf("FromTextOp(%s): expected %s, got %s", c.JSON, c.Back.String(), ops.String())
+		}
+	}
+
+	doc := ot.NewDoc()
+	doc.Apply(ot.NewInsert(0, 0, "a😀bc"))
+	op := interop.TextOp{1, "x", -2, 2}
+	ops, _ := interop.FromTextOp(text, op)
+	doc.Apply(ops)
+	if got, _ := interop.TextOf(doc); string(got) != "axbc" || op.TargetLen() != 4 {
+		t.Errorf("unexpected result, got %q, target len: %d", string(got), op.TargetLen())
+	}
+
+	for _, bad := range []string{`[4]`, `[6]`, `[2,-3]`, `[0,5]`, `["",5]`, `[1.5]`, `[true]`} {
+		var op interop.TextOp
+		if err := json.Unmarshal([]byte(bad), &op); err != nil {
+			continue
+		}
+		if _, err := interop.FromTextOp(text, op); err == nil {
+			t.Errorf("expected FromTextOp(%s) to fail", bad)
+		}
+	}
+	if _, err := interop.ToTextOp(text, ot.Rs(3)); err == nil {
+		t.Errorf("expected short ops to fail")
+	}
+	if _, err := interop.ToTextOp(text, ot.C(ot.Rs(4), ot.Ops{ot.It(ot.Branch(nil))})); err == nil {
+		t.Errorf("expected branch insert to fail")
+	}
+}
+
+func TestText0(t *testing.T) {
+	text := []rune("a😀bc")
+	ops := ot.C(ot.Rs(1), ot.Is("x"), ot.Ds(1), ot.Rs(1), ot.Is("y"), ot.Rs(1))
+	op, err := interop.ToText0(text, ops)
+	if err != nil {
+		t.Fatalf("ToText0 failed, err: %q", err)
+	}
+	js, _ := json.Marshal(op)
+	if exp := `[{"p":1,"i":"x"},{"p":2,"d":"😀"},{"p":3,"i":"y"}]`; string(js) != exp {
+		t.Errorf("ToText0: expected %s, got %s", exp, js)
+	}
+	back, err := interop.FromText0(text, op)
+	if err != nil {
+		t.Fatalf("FromText0 failed, err: %q", err)
+	}
+	if !reflect.DeepEqual(back, ops) {
+		t.Errorf("FromText0: expected %s, got %s", ops.String(), back.String())
+	}
+
+	// components apply in turn, so later positions see earlier components
+	op = interop.Text0{{P: 0, I: "xy"}, {P: 1, D: "y"}, {P: 5, I: "z"}}
+	back, err = interop.FromText0(text, op)
+	if err != nil {
+		t.Fatalf("FromText0 failed, err: %q", err)
+	}
+	if exp := ot.C(ot.Is("x"), ot.Rs(3), ot.Is("z"), ot.Rs(1)); !reflect.DeepEqual(back, exp) {
+		t.Errorf("FromText0: expected %s, got %s", exp.String(), back.String())
+	}
+
+	bads := []interop.Text0{
+		{{P: 2, I: "x"}},         // within a surrogate pair
+		{{P: 6, I: "x"}},         // past the end
+		{{P: 0, D: "b"}},         // mismatched delete
+		{{P: 4, D: "cd"}},        // delete past the end
+		{{P: 0}},                 // neither insert nor delete
+		{{P: 0, I: "x", D: "a"}}, // both
+		{{P: -1, I: "x"}},        // negative position
+	}
+	for _, bad := range bads {
+		if _, err := interop.FromText0(text, bad); err == nil {
+			t.Errorf("expected FromText0(%+v) to fail", bad)
+		}
+	}
+}
+
+func TestCodec(t *testing.T) {
+	text := []rune("ab")
+	ops := ot.C(ot.Rs(1), ot.Is("😀"), ot.Rs(1))
+	for _, name := range []string{interop.CD_OTJS, interop.CD_TEXT0} {
+		codec, err := interop.Lookup(name)
+		if err != nil {
+			t.Fatalf("lookup of %q failed, err: %q", name, err)
+		}
+		data, err := codec.Encode(text, ops)
+		if err != nil {
+			t.Fatalf("%s encode failed, err: %q", name, err)
+		}
+		back, err := codec.Decode(text, data)
+		if err != nil {
+			t.Fatalf("%s decode of %s failed, err: %q", name, data, err)
+		}
+		if !reflect.DeepEqual(back, ops) {
+			t.Errorf("%s round trip: expected %s, got %s via %s", name, ops.String(), back.String(), data)
+		}
+	}
+	if _, err := interop.Lookup("sharejs"); err == nil {
+		t.Errorf("expected unknown codec to fail")
+	}
+}
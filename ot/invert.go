@@ -0,0 +1,109 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot
+
+import (
+	"github.com/juju/errors"
+)
+
+// Invert returns the inverse of ops with respect to before, the branch that ops
+// were (or will be) applied to; i.e., applying ops and then Invert(ops, before)
+// to before leaves it unchanged.
+//
+// Deletes become inserts of the deleted subtrees, inserts become deletes,
+// attribute patches are replaced by patches that restore the previous
+// attributes, With ops are inverted recursively against the subtree that
+// they modify, and moves are inverted by moving the kid back.
+func Invert(ops Ops, before Tree) (Ops, error) {
+	if !before.IsBranch() {
+		return nil, errors.Errorf("Invert failed, expected branch; ops: %s, before: %s", ops.String(), before.String())
+	}
+	return invert(ops, before.Kids)
+}
+
+// kidSeq is a sequence of kids that ops can be inverted against; it lets Doc
+// invert ops against its rope without flattening it.
+type kidSeq interface {
+	Len() int
+	String() string
+	slice(i, j int) Trees
+}
+
+func (ts Trees) slice(i, j int) Trees {
+	_, r := ts.splitAt(i)
+	r, _ = r.splitAt(j - i)
+	return r
+}
+
+func invert(ops Ops, kids kidSeq) (Ops, error) {
+	ret := Ops{}
+	size := kids.Len()
+	pos := 0
+
+	mv, err := moved(ops, kids, false)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	moves := movesOf(ops)
+
+	for _, o := range ops {
+		switch {
+		case o.IsZero():
+			continue
+		case o.IsRetain():
+			if pos+o.Len() > size {
+				return nil, errors.Errorf("invert failed, retain past end; pos: %d, o: %s, kids: %s", pos, o.String(), kids.String())
+			}
+			if len(o.Attrs) == 0 {
+				ret.Retain(o.Len())
+			} else {
+				for _, k := range kids.slice(pos, pos+o.Len()) {
+					ret.Format(k.Len(), k.Attrs.invert(o.Attrs))
+				}
+			}
+			pos += o.Len()
+		case o.IsDelete():
+			if pos+o.Len() > size {
+				return nil, errors.Errorf("invert failed, delete past end; pos: %d, o: %s, kids: %s", pos, o.String(), kids.String())
+			}
+			for _, k := range kids.slice(pos, pos+o.Len()) {
+				ret.Insert(k.Clone())
+			}
+			pos += o.Len()
+		case o.IsInsert():
+			ret.Delete(o.Len())
+		case o.IsWith():
+			if pos >= size {
+				return nil, errors.Errorf("invert failed, with past end; pos: %d, o: %s, kids: %s", pos, o.String(), kids.String())
+			}
+			k := kids.slice(pos, pos+1)[0]
+			kc, err := Invert(o.Kids, k)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			ret.FormatWith(kc, k.Attrs.invert(o.Attrs))
+			pos++
+		case o.IsMove():
+			ret = append(ret, P(o.Id))
+			pos++
+		case o.IsPlace():
+			m, ok := moves[o.Id]
+			if !ok {
+				return nil, errors.Errorf("invert failed, place without move; o: %s", o.String())
+			}
+			k := mv[o.Id]
+			var kc Ops
+			if len(m.Kids) > 0 {
+				if kc, err = Invert(m.Kids, k); err != nil {
+					return nil, errors.Trace(err)
+				}
+			}
+			ret = append(ret, Mf(o.Id, kc, k.Attrs.invert(m.Attrs)))
+		default:
+			return nil, errors.Errorf("invert failed, bad op: %s", o.String())
+		}
+	}
+
+	return Normalize(ret)
+}
@@ -0,0 +1,147 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot
+
+import (
+	"reflect"
+	"testing"
+)
+
+type InvertCase struct {
+	A Ops
+	B Tree
+	C Ops
+}
+
+func doInvertTable(t *testing.T, cases []InvertCase) {
+	for idx, c := range cases {
+		t.Logf("invert %d, inverting A: %s, B: %s, expecting C: %s", idx, c.A, c.B.String(), c.C)
+		x, err := Invert(c.A, c.B)
+		if err != nil {
+			t.Fatalf("invert %d failed, err: %q", idx, err)
+		}
+		if !reflect.DeepEqual(x, c.C) {
+			t.Fatalf("invert %d failed;\n\tA: %s\n\tB: %s\n\tC: %s\n\tx: %s", idx, c.A, c.B.String(), c.C, x)
+		}
+	}
+}
+
+func TestInvert(t *testing.T) {
+	table := []InvertCase{
+		{
+			A: C(Rs(1), Is("b"), Rs(1)),
+			B: AsRuneTree("ac"),
+			C: C(Rs(1), Ds(1), Rs(1)),
+		},
+		{
+			A: C(Rs(1), Ds(2)),
+			B: AsRuneTree("abc"),
+			C: C(Rs(1), Is("bc")),
+		},
+		{
+			A: C(Is("x"), Ds(2)),
+			B: AsRuneTree("ab"),
+			C: C(Is("ab"), Ds(1)),
+		},
+		{
+			A: C(Rs(1), Ws(C(Ds(1), Is("z"))), Ds(1)),
+			B: Branch(Trees{Leaf('a'), AsRuneTree("xy"), Leaf('c')}),
+			C: C(Rs(1), Ws(C(Is("x"), Ds(1))), Is("c")),
+		},
+	}
+
+	doInvertTable(t, table)
+}
+
+func TestInvertErrors(t *testing.T) {
+	cases := []struct {
+		A Ops
+		B Tree
+	}{
+		{C(Rs(3)), AsRuneTree("ab")},
+		{C(Rs(1), Ds(2)), AsRuneTree("ab")},
+		{C(Ws(Rs(1))), AsRuneTree("ab")},
+		{C(Rs(2), Ws(nil)), AsRuneTree("ab")},
+		{C(Rs(1)), Leaf('a')},
+	}
+	for idx, c := range cases {
+		_, err := Invert(c.A, c.B)
+		if err == nil {
+			t.Fatalf("invert error %d: expected error for A: %s, B: %s", idx, c.A, c.B.String())
+		}
+	}
+}
+
+func testOneInvert(t *testing.T) {
+	d := NewDoc()
+	for i := 0; i < 8; i++ {
+		d.Apply(d.GetRandomOps(4))
+	}
+	before := d.Body()
+
+	ops := d.GetRandomOps(4)
+	inv, err := Invert(ops, before)
+	if err != nil {
+		t.Fatalf("Invert fail, ops: %s, before: %s, err: %q", ops, before.String(), err)
+	}
+
+	// Compose(ops, Invert(ops)) acts as the identity
+	cs, err := Compose(ops, inv)
+	if err != nil {
+		t.Fatalf("Compose fail, ops: %s, inv: %s, err: %q", ops, inv, err)
+	}
+	d1 := NewDoc()
+	d1.body = ropeLeaf(before.Kids.Clone())
+	err = d1.Apply(cs)
+	if err != nil {
+		t.Fatalf("Apply fail, cs: %s, err: %q", cs, err)
+	}
+	if d1.String() != before.String() {
+		t.Fatalf("Invert fail: compose not identity\n\tops: %s\n\tinv: %s\n\tcs: %s\n\t%q\n\t%q", ops, inv, cs, before.String(), d1.String())
+	}
+
+	// applying ops then Invert(ops) restores the document
+	d2 := NewDoc()
+	d2.body = ropeLeaf(before.Kids.Clone())
+	d2.Apply(ops)
+	d2.Apply(inv)
+	if d2.String() != before.String() {
+		t.Fatalf("Invert fail: apply not identity\n\tops: %s\n\tinv: %s\n\t%q\n\t%q", ops, inv, before.String(), d2.String())
+	}
+}
+
+func TestRandomInvert(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		testOneInvert(t)
+	}
+}
+
+func TestRandomInvertNested(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		kid := NewDoc()
+		for j := 0; j < 4; j++ {
+			kid.Apply(kid.GetRandomOps(4))
+		}
+		before := Branch(Trees{Leaf('a'), kid.Body(), Leaf('b')})
+
+		ops := C(Rs(1), Ws(kid.GetRandomOps(4)), Ds(1))
+		inv, err := Invert(ops, before)
+		if err != nil {
+			t.Fatalf("Invert fail, ops: %s, before: %s, err: %q", ops, before.String(), err)
+		}
+
+		cs, err := Compose(ops, inv)
+		if err != nil {
+			t.Fatalf("Compose fail, ops: %s, inv: %s, err: %q", ops, inv, err)
+		}
+		after := before.Clone()
+		err = Apply(W(cs), &after)
+		if err != nil {
+			t.Fatalf("Apply fail, cs: %s, err: %q", cs, err)
+		}
+		if !reflect.DeepEqual(after, before) {
+			t.Fatalf("Invert fail: compose not identity\n\tops: %s\n\tinv: %s\n\tcs: %s\n\t%s\n\t%s", ops, inv, cs, before.String(), after.String())
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot_test
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mstone/focus/ot"
+	"github.com/mstone/focus/ot/ottest"
+)
+
+// sameOps compares ops like reflect.DeepEqual except that nil and empty lists
+// are considered equal.
+func sameOps(a, b ot.Ops) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func TestIterativeMatchesRecursive(t *testing.T) {
+	seed := time.Now().UnixNano()
+	r := rand.New(rand.NewSource(seed))
+	c := ottest.DefaultConfig
+	c.Moves = false // the recursive implementations predate moves
+
+	for i := 0; i < 2000; i++ {
+		seq := c.Sequential(r, 2)
+		a, b := seq.Ops[0], seq.Ops[1]
+
+		got, err1 := ot.Compose1(a, b)
+		expected, err2 := ot.Compose1Rec(a, b)
+		if (err1 == nil) != (err2 == nil) || !sameOps(got, expected) {
+			t.Fatalf("compose1 mismatch; seed: %d, case: %s\n\tgot: %s, err: %v\n\texpected: %s, err: %v", seed, seq, got, err1, expected, err2)
+		}
+
+		n1, err1 := ot.Normalize(got)
+		n2, err2 := ot.NormalizeRec(got)
+		if (err1 == nil) != (err2 == nil) || !sameOps(n1, n2) {
+			t.Fatalf("normalize mismatch; seed: %d, ops: %s\n\tgot: %s\n\texpected: %s", seed, got, n1, n2)
+		}
+
+		con := c.Concurrent(r, 2)
+		a, b = con.Ops[0], con.Ops[1]
+
+		gotA, gotB, err1 := ot.Transform1(a, b)
+		expA, expB, err2 := ot.Transform1Rec(a, b)
+		if (err1 == nil) != (err2 == nil) || !sameOps(gotA, expA) || !sameOps(gotB, expB) {
+			t.Fatalf("transform1 mismatch; seed: %d, case: %s\n\tgot: %s, %s, err: %v\n\texpected: %s, %s, err: %v", seed, con, gotA, gotB, err1, expA, expB, err2)
+		}
+
+		n1, _ = ot.Normalize(a)
+		n2, _ = ot.NormalizeRec(a)
+		if !sameOps(n1, n2) {
+			t.Fatalf("normalize mismatch; seed: %d, ops: %s\n\tgot: %s\n\texpected: %s", seed, a, n1, n2)
+		}
+	}
+}
+
+// paste returns ops that insert n runes one op at a time, as a large paste
+// arrives from the editor, after retaining pre positions.
+func paste(pre, n int) ot.Ops {
+	ops := make(ot.Ops, 0, n+1)
+	if pre > 0 {
+		ops = append(ops, ot.R(pre))
+	}
+	for i := 0; i < n; i++ {
+		ops = append(ops, ot.Ic(rune('a'+i%26)))
+	}
+	return ops
+}
+
+func TestComposeLargePaste(t *testing.T) {
+	n := 100000
+	cs, err := ot.Compose(paste(0, n), ot.C(ot.Rs(n), ot.Is("!")))
+	if err != nil {
+		t.Fatalf("compose failed, err: %q", err)
+	}
+	if len(cs) != 1 || cs[0].Len() != n+1 {
+		t.Fatalf("unexpected compose result, len: %d", len(cs))
+	}
+
+	ta, tb, err := ot.Transform(paste(0, n), ot.C(ot.Is("?")))
+	if err != nil {
+		t.Fatalf("transform failed, err: %q", err)
+	}
+	if ot.OutputLen(ta) != n+1 || ot.OutputLen(tb) != n+1 {
+		t.Fatalf("unexpected transform result, lens: %d, %d", ot.OutputLen(ta), ot.OutputLen(tb))
+	}
+}
+
+var pasteSizes = []int{1000, 10000, 100000}
+
+func BenchmarkComposePaste(b *testing.B) {
+	for _, n := range pasteSizes {
+		as := paste(0, n)
+		bs := ot.C(ot.Rs(n/2), ot.Is("x"), ot.Ds(1), ot.Rs(n-n/2-1))
+		b.Run(fmt.Sprintf("%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := ot.Compose(as, bs); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkTransformPaste(b *testing.B) {
+	for _, n := range pasteSizes {
+		as := paste(10, n)
+		bs := ot.C(ot.Rs(5), ot.Is("x"), ot.Ds(1), ot.Rs(4))
+		b.Run(fmt.Sprintf("%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, _, err := ot.Transform(as, bs); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkNormalizePaste(b *testing.B) {
+	for _, n := range pasteSizes {
+		ops := paste(0, n)
+		b.Run(fmt.Sprintf("%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := ot.Normalize(ops); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
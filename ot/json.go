@@ -0,0 +1,382 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/juju/errors"
+)
+
+// The compact JSON encoding of Ops (see docs/protocol.adoc) is:
+//
+//	0          a nil op
+//	3          a retain of 3
+//	-2         a delete of 2
+//	"ab"       a leaf or text insert
+//	[...]      a With op wrapping the nested ops
+//	{"I": t}   a branch insert, with t encoded as below
+//
+// Ops that carry attributes are encoded as objects instead:
+//
+//	{"R": 3, "A": {...}}      a retain that patches attributes
+//	{"W": [...], "A": {...}}  a With op that patches attributes
+//	{"I": t, "A": {...}}      an insert of t with attributes
+//
+// Moves and places encode as objects holding their ids; moves carry "W" and
+// "A" if they also modify the moved kid:
+//
+//	{"M": 1}                  a move of the kid at its position
+//	{"M": 1, "W": [...]}      a move that also modifies the moved kid
+//	{"P": 1}                  the place of move 1
+//
+// Trees encode as strings (leaves and text runs) or as arrays of kids
+// (branches), with adjacent leaf and text kids packed into single strings.
+// Kids with attributes encode as {"T": t, "A": {...}}, and typed branches
+// encode as {"T": t, "N": type}, with "A" if they also have attributes.
+//
+// For compatibility with previously stored operations, UnmarshalJSON also
+// accepts the legacy {Tag,Size,Body,Kids} struct encoding.
+
+type legacyOp struct {
+	Tag  OpTag
+	Size int
+	Body Tree
+	Kids Ops
+}
+
+type legacyTree struct {
+	Tag  TreeTag
+	Leaf rune
+	Kids Trees
+}
+
+type attrOp struct {
+	I *Tree `json:",omitempty"`
+	R int   `json:",omitempty"`
+	W *Ops  `json:",omitempty"`
+	A Attrs `json:",omitempty"`
+	M int   `json:",omitempty"`
+	P int   `json:",omitempty"`
+}
+
+type attrTree struct {
+	T Tree
+	N string `json:",omitempty"`
+	A Attrs  `json:",omitempty"`
+}
+
+func (o Op) MarshalJSON() ([]byte, error) {
+	switch {
+	case o.IsZero():
+		return []byte("0"), nil
+	case o.IsRetain() && len(o.Attrs) > 0:
+		return json.Marshal(attrOp{R: o.Size, A: o.Attrs})
+	case o.IsRetain(), o.IsDelete():
+		return json.Marshal(o.Size)
+	case o.IsInsert() && len(o.Body.Attrs) > 0:
+		body := o.Body.WithAttrs(nil)
+		return json.Marshal(attrOp{I: &body, A: o.Body.Attrs})
+	case o.IsInsertLeaf(), o.IsInsertText():
+		return json.Marshal(AsString(o.Body.Runes()))
+	case o.IsInsertBranch():
+		return json.Marshal(attrOp{I: &o.Body})
+	case o.IsWith() && len(o.Attrs) > 0:
+		kids := o.Kids
+		if kids == nil {
+			kids = Ops{}
+		}
+		return json.Marshal(attrOp{W: &kids, A: o.Attrs})
+	case o.IsWith():
+		return o.Kids.marshalJSON()
+	case o.IsMove():
+		ao := attrOp{M: o.Id, A: o.Attrs}
+		if len(o.Kids) > 0 {
+			ao.W = &o.Kids
+		}
+		return json.Marshal(ao)
+	case o.IsPlace():
+		return json.Marshal(attrOp{P: o.Id})
+	default:
+		return nil, errors.Errorf("Op.MarshalJSON failed, bad op: %s", o.String())
+	}
+}
+
+func (o *Op) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return errors.Trace(err)
+		}
+		if len(s) == 0 {
+			return errors.Errorf("Op.UnmarshalJSON failed, empty insert")
+		}
+		*o = It(text(AsRunes(s)))
+		return nil
+	}
+	os, err := unmarshalOp(data)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(os) != 1 {
+		return errors.Errorf("Op.UnmarshalJSON failed, expected a single op, got: %s", os.String())
+	}
+	*o = os[0]
+	return nil
+}
+
+func (os Ops) MarshalJSON() ([]byte, error) {
+	if os == nil {
+		return []byte("null"), nil
+	}
+	return os.marshalJSON()
+}
+
+// marshalJSON encodes os as a JSON array.
+func (os Ops) marshalJSON() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('[')
+	for i := range os {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		bs, err := os[i].MarshalJSON()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		buf.Write(bs)
+	}
+	buf.WriteByte(']')
+	return buf.Bytes(), nil
+}
+
+func (os *Ops) UnmarshalJSON(data []byte) error {
+	if string(bytes.TrimSpace(data)) == "null" {
+		return nil
+	}
+	ops, err := unmarshalOps(data)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	*os = ops
+	return nil
+}
+
+func unmarshalOps(data []byte) (Ops, error) {
+	var raws []json.RawMessage
+	if err := json.Unmarshal(data, &raws); err != nil {
+		return nil, errors.Trace(err)
+	}
+	var ret Ops
+	for _, raw := range raws {
+		os, err := unmarshalOp(raw)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		ret = append(ret, os...)
+	}
+	return ret, nil
+}
+
+// unmarshalOp decodes a single element of an encoded Ops array.
+func unmarshalOp(data []byte) (Ops, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, errors.Errorf("unmarshalOp failed, empty op")
+	}
+	switch data[0] {
+	case '"':
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return nil, errors.Trace(err)
+		}
+		return Is(s), nil
+	case '[':
+		kids, err := unmarshalOps(data)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return Ws(kids), nil
+	case '{':
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, errors.Trace(err)
+		}
+		if _, ok := fields["Tag"]; ok {
+			lo := legacyOp{}
+			if err := json.Unmarshal(data, &lo); err != nil {
+				return nil, errors.Trace(err)
+			}
+			return Ops{Op{Tag: lo.Tag, Size: lo.Size, Body: lo.Body, Kids: lo.Kids}}, nil
+		}
+		ao := attrOp{}
+		if err := json.Unmarshal(data, &ao); err != nil {
+			return nil, errors.Trace(err)
+		}
+		switch {
+		case ao.M > 0 && ao.P == 0 && ao.I == nil && ao.R == 0:
+			var kids Ops
+			if ao.W != nil {
+				kids = *ao.W
+			}
+			return Ops{Mf(ao.M, kids, ao.A)}, nil
+		case ao.P > 0 && ao.M == 0 && ao.I == nil && ao.R == 0 && ao.W == nil && len(ao.A) == 0:
+			return Ops{P(ao.P)}, nil
+		case ao.M != 0 || ao.P != 0:
+			return nil, errors.Errorf("unmarshalOp failed, bad move: %s", data)
+		case ao.I != nil && ao.I.Len() > 0 && ao.R == 0 && ao.W == nil:
+			return Ops{It(ao.I.WithAttrs(ao.I.Attrs.Apply(ao.A)))}, nil
+		case ao.R > 0 && ao.I == nil && ao.W == nil:
+			return Fs(ao.R, ao.A), nil
+		case ao.W != nil && ao.I == nil && ao.R == 0:
+			return Ops{Wf(*ao.W, ao.A)}, nil
+		default:
+			return nil, errors.Errorf("unmarshalOp failed, bad op: %s", data)
+		}
+	default:
+		var n int
+		if err := json.Unmarshal(data, &n); err != nil {
+			return nil, errors.Annotatef(err, "unmarshalOp failed, bad op: %s", data)
+		}
+		switch {
+		case n > 0:
+			return Rs(n), nil
+		case n < 0:
+			return Ds(n), nil
+		default:
+			return Zs(), nil
+		}
+	}
+}
+
+func (t Tree) MarshalJSON() ([]byte, error) {
+	switch {
+	case t.Tag == T_NIL:
+		return []byte("null"), nil
+	case len(t.Attrs) > 0 || t.Type != "":
+		return json.Marshal(attrTree{T: t.WithAttrs(nil).WithType(""), N: t.Type, A: t.Attrs})
+	case t.HasRunes():
+		return json.Marshal(AsString(t.Runes()))
+	case t.IsBranch():
+		return t.Kids.marshalJSON()
+	default:
+		return nil, errors.Errorf("Tree.MarshalJSON failed, bad tree: %#v", t)
+	}
+}
+
+// marshalJSON encodes ts as a JSON array, packing adjacent leaves and text
+// runs without attributes into strings.
+func (ts Trees) marshalJSON() ([]byte, error) {
+	plain := func(t Tree) bool {
+		return t.HasRunes() && len(t.Attrs) == 0
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteByte('[')
+	for i := 0; i < len(ts); {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if plain(ts[i]) {
+			rs := []rune{}
+			for ; i < len(ts) && plain(ts[i]); i++ {
+				rs = append(rs, ts[i].Runes()...)
+			}
+			bs, err := json.Marshal(AsString(rs))
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			buf.Write(bs)
+			continue
+		}
+		bs, err := ts[i].MarshalJSON()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		buf.Write(bs)
+		i++
+	}
+	buf.WriteByte(']')
+	return buf.Bytes(), nil
+}
+
+func (t *Tree) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return errors.Errorf("Tree.UnmarshalJSON failed, empty tree")
+	}
+	switch data[0] {
+	case 'n':
+		*t = Zt()
+	case '"':
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return errors.Trace(err)
+		}
+		if len(s) == 0 {
+			return errors.Errorf("Tree.UnmarshalJSON failed, empty text")
+		}
+		*t = text(AsRunes(s))
+	case '[':
+		kids, err := unmarshalTrees(data)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		*t = Branch(kids)
+	case '{':
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return errors.Trace(err)
+		}
+		if _, ok := fields["T"]; ok {
+			at := attrTree{}
+			if err := json.Unmarshal(data, &at); err != nil {
+				return errors.Trace(err)
+			}
+			if at.N != "" && !at.T.IsBranch() {
+				return errors.Errorf("Tree.UnmarshalJSON failed, typed non-branch: %s", data)
+			}
+			*t = at.T.WithAttrs(at.T.Attrs.Apply(at.A)).WithType(at.N)
+			return nil
+		}
+		lt := legacyTree{}
+		if err := json.Unmarshal(data, &lt); err != nil {
+			return errors.Trace(err)
+		}
+		if lt.Tag == T_BRANCH {
+			*t = Branch(lt.Kids)
+		} else {
+			*t = Tree{Tag: lt.Tag, Leaf: lt.Leaf, Kids: lt.Kids}
+		}
+	default:
+		return errors.Errorf("Tree.UnmarshalJSON failed, bad tree: %s", data)
+	}
+	return nil
+}
+
+func unmarshalTrees(data []byte) (Trees, error) {
+	var raws []json.RawMessage
+	if err := json.Unmarshal(data, &raws); err != nil {
+		return nil, errors.Trace(err)
+	}
+	var ret Trees
+	for _, raw := range raws {
+		raw = bytes.TrimSpace(raw)
+		if len(raw) > 0 && raw[0] == '"' {
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				return nil, errors.Trace(err)
+			}
+			ret = append(ret, text(AsRunes(s)))
+			continue
+		}
+		t := Tree{}
+		if err := json.Unmarshal(raw, &t); err != nil {
+			return nil, errors.Trace(err)
+		}
+		ret = append(ret, t)
+	}
+	return ret, nil
+}
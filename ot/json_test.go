@@ -0,0 +1,108 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type JSONCase struct {
+	A Ops
+	B string
+}
+
+func doJSONTable(t *testing.T, cases []JSONCase) {
+	for idx, c := range cases {
+		t.Logf("json %d, marshaling A: %s, expecting B: %s", idx, c.A, c.B)
+		b, err := json.Marshal(c.A)
+		if err != nil {
+			t.Fatalf("json %d failed; marshal err: %q", idx, err)
+		}
+		if string(b) != c.B {
+			t.Fatalf("json %d failed; %s -> %s != expected %s", idx, c.A, b, c.B)
+		}
+		var a Ops
+		err = json.Unmarshal(b, &a)
+		if err != nil {
+			t.Fatalf("json %d failed; unmarshal err: %q", idx, err)
+		}
+		if !reflect.DeepEqual(a, c.A) {
+			t.Fatalf("json %d failed; %s -> %s != expected %s", idx, b, a, c.A)
+		}
+	}
+}
+
+func TestCompactJSON(t *testing.T) {
+	table := []JSONCase{
+		{
+			A: C(Rs(3), Is("hi"), Ds(2), Rs(1)),
+			B: `[3,"hi",-2,1]`,
+		},
+		{
+			A: C(Zs(), Is("a"), Zs()),
+			B: `[0,"a",0]`,
+		},
+		{
+			A: Ws(C(Rs(1), Is("x"))),
+			B: `[[1,"x"]]`,
+		},
+		{
+			A: Ws(nil),
+			B: `[[]]`,
+		},
+		{
+			A: C(Rs(1), Ops{It(Branch(Trees{Leaf('a'), Leaf('b'), Branch(nil), Leaf('c')}))}, Is("d")),
+			B: `[1,{"I":["ab",[],"c"]},"d"]`,
+		},
+		{
+			A: C(Ws(C(Rs(2), Ws(Ds(1)))), Is("\"]")),
+			B: `[[2,[-1]],"\"]"]`,
+		},
+	}
+
+	doJSONTable(t, table)
+}
+
+func TestLegacyJSON(t *testing.T) {
+	legacy := `[` +
+		`{"Tag":3,"Size":-1,"Body":{"Tag":0,"Leaf":0,"Kids":null},"Kids":null},` +
+		`{"Tag":2,"Size":1,"Body":{"Tag":0,"Leaf":0,"Kids":null},"Kids":null},` +
+		`{"Tag":1,"Size":0,"Body":{"Tag":1,"Leaf":104,"Kids":null},"Kids":null},` +
+		`{"Tag":1,"Size":0,"Body":{"Tag":2,"Leaf":0,"Kids":[{"Tag":1,"Leaf":105,"Kids":null}]},"Kids":null},` +
+		`{"Tag":4,"Size":0,"Body":{"Tag":0,"Leaf":0,"Kids":null},"Kids":[{"Tag":2,"Size":2,"Body":{"Tag":0,"Leaf":0,"Kids":null},"Kids":null}]}` +
+		`]`
+
+	var a Ops
+	err := json.Unmarshal([]byte(legacy), &a)
+	if err != nil {
+		t.Fatalf("unable to unmarshal legacy ops, err: %q", err)
+	}
+
+	b := C(Ds(1), Rs(1), Is("h"), Ops{It(Branch(Trees{Leaf('i')}))}, Ws(Rs(2)))
+	if !reflect.DeepEqual(a, b) {
+		t.Fatalf("expected a == b; got a: %s, b: %s", a, b)
+	}
+}
+
+func TestTreeJSON(t *testing.T) {
+	t1 := Branch(Trees{Leaf('x'), Branch(Trees{Leaf('y'), Leaf('z')}), Leaf('w')})
+	j1, err := json.Marshal(t1)
+	if err != nil {
+		t.Fatalf("unable to marshal t1 to json, err %q", err)
+	}
+	if string(j1) != `["x",["yz"],"w"]` {
+		t.Fatalf("unexpected tree encoding, j1: %s", j1)
+	}
+
+	var t2 Tree
+	err = json.Unmarshal(j1, &t2)
+	if err != nil {
+		t.Fatalf("unable to unmarshal t2 from j1, err: %q", err)
+	}
+	if !reflect.DeepEqual(t1, t2) {
+		t.Fatalf("expected t1 == t2; got t1: %s, t2: %s", t1.String(), t2.String())
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package jsonot
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/juju/errors"
+	"github.com/mstone/focus/ot"
+)
+
+// Ops encode as JSON objects holding a path, "P", and one member that says
+// what the op does:
+//
+//	{"P": ["a"], "S": v}        set the key "a" to v
+//	{"P": ["a"], "R": true}     remove the key "a"
+//	{"P": ["l", 0], "I": v}     insert v at index 0 of the list "l"
+//	{"P": ["l", 0], "D": true}  delete index 0 of the list "l"
+//	{"P": ["l", 0], "M": 2}     move index 0 of the list "l" to index 2
+//	{"P": ["n"], "N": 3}        add 3 to the number "n"
+//	{"P": ["s"], "T": [...]}    edit the string "s" with ot.Ops
+
+var opKeys = map[string]OpTag{
+	"S": J_SET,
+	"R": J_REMOVE,
+	"I": J_INSERT,
+	"D": J_DELETE,
+	"M": J_MOVE,
+	"N": J_ADD,
+	"T": J_TEXT,
+}
+
+func (o Op) MarshalJSON() ([]byte, error) {
+	p := o.Path
+	if p == nil {
+		p = Path{}
+	}
+	m := map[string]interface{}{"P": p}
+	switch o.Tag {
+	case J_SET:
+		m["S"] = o.Value
+	case J_REMOVE:
+		m["R"] = true
+	case J_INSERT:
+		m["I"] = o.Value
+	case J_DELETE:
+		m["D"] = true
+	case J_MOVE:
+		m["M"] = o.To
+	case J_ADD:
+		m["N"] = o.N
+	case J_TEXT:
+		t := o.Text
+		if t == nil {
+			t = ot.Ops{}
+		}
+		m["T"] = t
+	default:
+		return nil, errors.Errorf("Op.MarshalJSON failed, bad op: %#v", o)
+	}
+	return json.Marshal(m)
+}
+
+func (o *Op) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return errors.Trace(err)
+	}
+	raw, ok := fields["P"]
+	if !ok || len(fields) != 2 {
+		return errors.Errorf("Op.UnmarshalJSON failed, expected a path and an op: %s", data)
+	}
+	ret := Op{}
+	if err := json.Unmarshal(raw, &ret.Path); err != nil {
+		return errors.Trace(err)
+	}
+	for k, v := range fields {
+		if k == "P" {
+			continue
+		}
+		tag, ok := opKeys[k]
+		if !ok {
+			return errors.Errorf("Op.UnmarshalJSON failed, bad op: %s", data)
+		}
+		ret.Tag = tag
+		var err error
+		switch tag {
+		case J_SET, J_INSERT:
+			err = json.Unmarshal(v, &ret.Value)
+		case J_MOVE:
+			err = json.Unmarshal(v, &ret.To)
+		case J_ADD:
+			err = json.Unmarshal(v, &ret.N)
+		case J_TEXT:
+			err = json.Unmarshal(v, &ret.Text)
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if err := ret.check(); err != nil {
+		return errors.Trace(err)
+	}
+	*o = ret
+	return nil
+}
+
+func (p *Path) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var es []interface{}
+	if err := dec.Decode(&es); err != nil {
+		return errors.Trace(err)
+	}
+	ret := make(Path, len(es))
+	for k, e := range es {
+		switch e := e.(type) {
+		case string:
+			ret[k] = e
+		case json.Number:
+			i, err := e.Int64()
+			if err != nil || i < 0 {
+				return errors.Errorf("Path.UnmarshalJSON failed, bad index: %s", e)
+			}
+			ret[k] = int(i)
+		default:
+			return errors.Errorf("Path.UnmarshalJSON failed, bad path element: %s", data)
+		}
+	}
+	*p = ret
+	return nil
+}
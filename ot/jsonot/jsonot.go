@@ -0,0 +1,473 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+// Package jsonot implements operational transformation for JSON documents,
+// for co-editing configuration and form data rather than prose.
+//
+// Documents are JSON values as decoded by encoding/json: objects are
+// map[string]interface{}, lists are []interface{}, and numbers are float64.
+// Ops set and remove object keys, insert, delete, and move list elements, add
+// to numbers, and edit strings with ot.Ops, each at a Path from the root.
+package jsonot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/juju/errors"
+	"github.com/mstone/focus/ot"
+)
+
+type OpTag int
+
+const (
+	J_NIL    OpTag = iota
+	J_SET          // set the object key at Path to Value
+	J_REMOVE       // remove the object key at Path
+	J_INSERT       // insert Value into a list at the index at Path
+	J_DELETE       // delete the list element at Path
+	J_MOVE         // move the list element at Path to index To
+	J_ADD          // add N to the number at Path
+	J_TEXT         // apply Text to the string at Path
+)
+
+// Path locates a value in a document: each element is either the key of an
+// object member (a string) or the index of a list element (an int).
+type Path []interface{}
+
+type Op struct {
+	Tag  OpTag
+	Path Path
+
+	// Value is the value set by J_SET or inserted by J_INSERT. Like ot.Attrs,
+	// values are shared between ops and documents and must never be modified
+	// in place.
+	Value interface{}
+
+	// To is the index that J_MOVE moves its element to, counted in the list
+	// without the moved element.
+	To int
+
+	// N is the number added by J_ADD.
+	N float64
+
+	// Text holds the ot.Ops that J_TEXT applies to the runes of a string.
+	Text ot.Ops
+}
+
+type Ops []Op
+
+// Set returns an op that sets the object key at p to v.
+func Set(p Path, v interface{}) Op {
+	return Op{Tag: J_SET, Path: p, Value: value(v)}
+}
+
+// Remove returns an op that removes the object key at p.
+func Remove(p Path) Op {
+	return Op{Tag: J_REMOVE, Path: p}
+}
+
+// Insert returns an op that inserts v into a list at the index at p.
+func Insert(p Path, v interface{}) Op {
+	return Op{Tag: J_INSERT, Path: p, Value: value(v)}
+}
+
+// Delete returns an op that deletes the list element at p.
+func Delete(p Path) Op {
+	return Op{Tag: J_DELETE, Path: p}
+}
+
+// Move returns an op that moves the list element at p to index to of the
+// list without the element.
+func Move(p Path, to int) Op {
+	return Op{Tag: J_MOVE, Path: p, To: to}
+}
+
+// Add returns an op that adds n to the number at p.
+func Add(p Path, n float64) Op {
+	return Op{Tag: J_ADD, Path: p, N: n}
+}
+
+// Text returns an op that edits the string at p with ops.
+func Text(p Path, ops ot.Ops) Op {
+	return Op{Tag: J_TEXT, Path: p, Text: ops}
+}
+
+// value returns the JSON value equivalent to v, as decoded by encoding/json.
+func value(v interface{}) interface{} {
+	switch v.(type) {
+	case nil, bool, float64, string:
+		return v
+	}
+	bs, err := json.Marshal(v)
+	if err != nil {
+		panic(errors.Annotatef(err, "bad json value: %#v", v))
+	}
+	var ret interface{}
+	if err := json.Unmarshal(bs, &ret); err != nil {
+		panic(errors.Annotatef(err, "bad json value: %#v", v))
+	}
+	return ret
+}
+
+// at returns a copy of o whose path has index i at position k.
+func (o Op) at(k int, i int) Op {
+	p := make(Path, len(o.Path))
+	copy(p, o.Path)
+	p[k] = i
+	o.Path = p
+	return o
+}
+
+func (o Op) isList() bool {
+	return o.Tag == J_INSERT || o.Tag == J_DELETE || o.Tag == J_MOVE
+}
+
+func (o Op) isKey() bool {
+	return o.Tag == J_SET || o.Tag == J_REMOVE
+}
+
+// index returns the list index that a list op applies to.
+func (o Op) index() int {
+	return o.Path[len(o.Path)-1].(int)
+}
+
+// check reports whether o is well-formed, regardless of the document that it
+// applies to.
+func (o Op) check() error {
+	for _, e := range o.Path {
+		switch e := e.(type) {
+		case string:
+		case int:
+			if e < 0 {
+				return errors.Errorf("bad op, negative index; o: %s", o.String())
+			}
+		default:
+			return errors.Errorf("bad op, bad path element: %#v; o: %s", e, o.String())
+		}
+	}
+	switch {
+	case o.isKey():
+		if len(o.Path) == 0 {
+			return errors.Errorf("bad op, empty path; o: %s", o.String())
+		}
+		if _, ok := o.Path[len(o.Path)-1].(string); !ok {
+			return errors.Errorf("bad op, expected key; o: %s", o.String())
+		}
+	case o.isList():
+		if len(o.Path) == 0 {
+			return errors.Errorf("bad op, empty path; o: %s", o.String())
+		}
+		if _, ok := o.Path[len(o.Path)-1].(int); !ok {
+			return errors.Errorf("bad op, expected index; o: %s", o.String())
+		}
+		if o.Tag == J_MOVE && o.To < 0 {
+			return errors.Errorf("bad op, negative move target; o: %s", o.String())
+		}
+	case o.Tag == J_ADD, o.Tag == J_TEXT:
+	default:
+		return errors.Errorf("bad op, unknown tag: %d", o.Tag)
+	}
+	return nil
+}
+
+func (o Op) String() string {
+	bs, err := json.Marshal(o)
+	if err != nil {
+		return fmt.Sprintf("%#v", o)
+	}
+	return string(bs)
+}
+
+func (os Ops) String() string {
+	ks := make([]string, len(os))
+	for k, o := range os {
+		ks[k] = o.String()
+	}
+	return fmt.Sprintf("[%s]", strings.Join(ks, " "))
+}
+
+// Clone returns a copy of os that shares os's values.
+func (os Ops) Clone() Ops {
+	if os == nil {
+		return nil
+	}
+	ret := make(Ops, len(os))
+	for k, o := range os {
+		o.Path = append(Path(nil), o.Path...)
+		o.Text = o.Text.Clone()
+		ret[k] = o
+	}
+	return ret
+}
+
+// Apply returns the result of applying ops to v. v itself is left unchanged:
+// the values along the paths of ops are copied and everything else is shared.
+func Apply(ops Ops, v interface{}) (interface{}, error) {
+	for _, o := range ops {
+		if err := o.check(); err != nil {
+			return nil, errors.Trace(err)
+		}
+		var err error
+		v, err = apply(v, o.Path, o)
+		if err != nil {
+			return nil, errors.Annotatef(err, "apply failed, o: %s", o.String())
+		}
+	}
+	return v, nil
+}
+
+func apply(v interface{}, p Path, o Op) (interface{}, error) {
+	switch {
+	case len(p) == 0:
+		return applyValue(v, o)
+	case len(p) == 1 && o.isKey():
+		return applyKey(v, p[0].(string), o)
+	case len(p) == 1 && o.isList():
+		return applyList(v, p[0].(int), o)
+	}
+	switch c := v.(type) {
+	case map[string]interface{}:
+		k, ok := p[0].(string)
+		if !ok {
+			return nil, errors.Errorf("expected key, got %v", p[0])
+		}
+		kid, ok := c[k]
+		if !ok {
+			return nil, errors.Errorf("missing key %q", k)
+		}
+		kid, err := apply(kid, p[1:], o)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		ret := make(map[string]interface{}, len(c))
+		for k, v := range c {
+			ret[k] = v
+		}
+		ret[k] = kid
+		return ret, nil
+	case []interface{}:
+		i, ok := p[0].(int)
+		if !ok || i >= len(c) {
+			return nil, errors.Errorf("bad index %v into list of len %d", p[0], len(c))
+		}
+		kid, err := apply(c[i], p[1:], o)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		ret := append([]interface{}(nil), c...)
+		ret[i] = kid
+		return ret, nil
+	default:
+		return nil, errors.Errorf("expected object or list, got %#v", v)
+	}
+}
+
+func applyValue(v interface{}, o Op) (interface{}, error) {
+	switch o.Tag {
+	case J_ADD:
+		n, ok := v.(float64)
+		if !ok {
+			return nil, errors.Errorf("expected number, got %#v", v)
+		}
+		return n + o.N, nil
+	case J_TEXT:
+		s, ok := v.(string)
+		if !ok {
+			return nil, errors.Errorf("expected string, got %#v", v)
+		}
+		return applyText(s, o.Text)
+	default:
+		return nil, errors.Errorf("op needs a key or index")
+	}
+}
+
+// applyText applies ops to the runes of s. ops may only insert plain runes.
+func applyText(s string, ops ot.Ops) (string, error) {
+	t := ot.AsRuneTree(s)
+	if err := ot.Validate(ops, t); err != nil {
+		return "", errors.Trace(err)
+	}
+	if err := ot.Apply(ot.W(ops), &t); err != nil {
+		return "", errors.Trace(err)
+	}
+	rs := []rune{}
+	for _, k := range t.Kids {
+		if !k.HasRunes() || len(k.Attrs) > 0 {
+			return "", errors.Errorf("text ops may only insert plain runes, got %s", k.String())
+		}
+		rs = append(rs, k.Runes()...)
+	}
+	return ot.AsString(rs), nil
+}
+
+func applyKey(v interface{}, k string, o Op) (interface{}, error) {
+	c, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("expected object, got %#v", v)
+	}
+	if _, ok := c[k]; !ok && o.Tag == J_REMOVE {
+		return nil, errors.Errorf("missing key %q", k)
+	}
+	if o.Tag == J_SET {
+		if err := check(o.Value); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	ret := make(map[string]interface{}, len(c)+1)
+	for k, v := range c {
+		ret[k] = v
+	}
+	switch o.Tag {
+	case J_SET:
+		ret[k] = o.Value
+	case J_REMOVE:
+		delete(ret, k)
+	}
+	return ret, nil
+}
+
+func applyList(v interface{}, i int, o Op) (interface{}, error) {
+	c, ok := v.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("expected list, got %#v", v)
+	}
+	switch o.Tag {
+	case J_INSERT:
+		if i > len(c) {
+			return nil, errors.Errorf("insert past end; index: %d, len: %d", i, len(c))
+		}
+		if err := check(o.Value); err != nil {
+			return nil, errors.Trace(err)
+		}
+		ret := make([]interface{}, 0, len(c)+1)
+		ret = append(ret, c[:i]...)
+		ret = append(ret, o.Value)
+		return append(ret, c[i:]...), nil
+	case J_DELETE:
+		if i >= len(c) {
+			return nil, errors.Errorf("delete past end; index: %d, len: %d", i, len(c))
+		}
+		ret := make([]interface{}, 0, len(c)-1)
+		ret = append(ret, c[:i]...)
+		return append(ret, c[i+1:]...), nil
+	default:
+		if i >= len(c) || o.To >= len(c) {
+			return nil, errors.Errorf("move past end; index: %d, to: %d, len: %d", i, o.To, len(c))
+		}
+		e := c[i]
+		ret := make([]interface{}, 0, len(c))
+		ret = append(ret, c[:i]...)
+		ret = append(ret, c[i+1:]...)
+		ret = append(ret[:o.To], append([]interface{}{e}, ret[o.To:]...)...)
+		return ret, nil
+	}
+}
+
+// check reports whether v is a JSON value as decoded by encoding/json.
+func check(v interface{}) error {
+	switch v := v.(type) {
+	case nil, bool, float64, string:
+		return nil
+	case []interface{}:
+		for _, e := range v {
+			if err := check(e); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		for _, e := range v {
+			if err := check(e); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		return nil
+	default:
+		return errors.Errorf("bad json value: %#v", v)
+	}
+}
+
+// Hash returns a digest of v: the hex-encoded SHA-256 of its JSON encoding,
+// in which object keys are sorted.
+func Hash(v interface{}) string {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		panic(errors.Annotatef(err, "Hash failed, bad value: %#v", v))
+	}
+	sum := sha256.Sum256(bs)
+	return hex.EncodeToString(sum[:])
+}
+
+// Doc is a JSON document that can be edited by applying ops.
+//
+// Since Apply copies only the values along the paths of the ops it applies,
+// Snapshot is O(1).
+type Doc struct {
+	mu   sync.Mutex
+	root interface{}
+}
+
+// NewDoc returns a doc holding an empty object.
+func NewDoc() *Doc {
+	return &Doc{
+		root: map[string]interface{}{},
+	}
+}
+
+// Snapshot returns a copy of d that shares d's current value but that can be
+// edited independently of d.
+func (d *Doc) Snapshot() *Doc {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return &Doc{
+		root: d.root,
+	}
+}
+
+// Value returns d's current value, which must not be modified in place.
+func (d *Doc) Value() interface{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.root
+}
+
+func (d *Doc) String() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	bs, _ := json.Marshal(d.root)
+	return string(bs)
+}
+
+func (d *Doc) Apply(ops Ops) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	root, err := Apply(ops, d.root)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	d.root = root
+	return nil
+}
+
+// Validate checks that ops can be applied to d's current value.
+func (d *Doc) Validate(ops Ops) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := Apply(ops, d.root)
+	return errors.Trace(err)
+}
+
+// Hash returns the digest of d's current value; see Hash.
+func (d *Doc) Hash() string {
+	return Hash(d.Value())
+}
@@ -0,0 +1,351 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package jsonot
+
+import (
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/mstone/focus/ot"
+)
+
+// parse returns the value encoded by s.
+func parse(s string) interface{} {
+	var ret interface{}
+	if err := json.Unmarshal([]byte(s), &ret); err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+type ApplyCase struct {
+	Doc    string
+	Ops    Ops
+	Result string // empty if applying Ops should fail
+}
+
+func TestApply(t *testing.T) {
+	table := []ApplyCase{
+		{`{}`, Ops{Set(Path{"a"}, 1)}, `{"a":1}`},
+		{`{"a":1}`, Ops{Set(Path{"a"}, []interface{}{"x"})}, `{"a":["x"]}`},
+		{`{"a":1,"b":2}`, Ops{Remove(Path{"a"})}, `{"b":2}`},
+		{`{"l":[1,2]}`, Ops{Insert(Path{"l", 2}, 3), Insert(Path{"l", 0}, 0)}, `{"l":[0,1,2,3]}`},
+		{`{"l":[1,2,3]}`, Ops{Delete(Path{"l", 1})}, `{"l":[1,3]}`},
+		{`{"l":[1,2,3]}`, Ops{Move(Path{"l", 0}, 2)}, `{"l":[2,3,1]}`},
+		{`{"l":[1,2,3]}`, Ops{Move(Path{"l", 2}, 0)}, `{"l":[3,1,2]}`},
+		{`{"n":1}`, Ops{Add(Path{"n"}, 2.5)}, `{"n":3.5}`},
+		{`{"s":"hi"}`, Ops{Text(Path{"s"}, ot.C(ot.Rs(2), ot.Is(" yø")))}, `{"s":"hi yø"}`},
+		{`{"o":{"l":[{"n":1}]}}`, Ops{Add(Path{"o", "l", 0, "n"}, 1)}, `{"o":{"l":[{"n":2}]}}`},
+		{`[1]`, Ops{Insert(Path{0}, "a")}, `["a",1]`},
+
+		{`{}`, Ops{Remove(Path{"a"})}, ``},
+		{`{"l":[1]}`, Ops{Insert(Path{"l", 2}, 0)}, ``},
+		{`{"l":[1]}`, Ops{Move(Path{"l", 0}, 1)}, ``},
+		{`{"l":[1]}`, Ops{Set(Path{"l", 0}, 1)}, ``},
+		{`{"n":"1"}`, Ops{Add(Path{"n"}, 1)}, ``},
+		{`{"s":"hi"}`, Ops{Text(Path{"s"}, ot.C(ot.Rs(3)))}, ``},
+		{`{"s":"hi"}`, Ops{Text(Path{"s"}, ot.Ops{ot.It(ot.Branch(nil)), ot.R(2)})}, ``},
+		{`{"a":{}}`, Ops{Add(Path{"a", "b"}, 1)}, ``},
+	}
+
+	for idx, c := range table {
+		v := parse(c.Doc)
+		got, err := Apply(c.Ops, v)
+		if c.Result == "" {
+			if err == nil {
+				t.Fatalf("apply %d: expected error applying %s to %s", idx, c.Ops, c.Doc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("apply %d: unable to apply %s to %s, err: %q", idx, c.Ops, c.Doc, err)
+		}
+		if !reflect.DeepEqual(got, parse(c.Result)) {
+			bs, _ := json.Marshal(got)
+			t.Fatalf("apply %d: got %s, expected %s", idx, bs, c.Result)
+		}
+		if !reflect.DeepEqual(v, parse(c.Doc)) {
+			t.Fatalf("apply %d: apply modified its argument", idx)
+		}
+	}
+}
+
+type TransformCase struct {
+	Doc    string
+	A, B   Ops
+	Result string
+}
+
+func TestTransform(t *testing.T) {
+	table := []TransformCase{
+		// independent keys
+		{`{}`, Ops{Set(Path{"a"}, 1)}, Ops{Set(Path{"b"}, 2)}, `{"a":1,"b":2}`},
+		// as wins concurrent sets of the same key
+		{`{}`, Ops{Set(Path{"a"}, 1)}, Ops{Set(Path{"a"}, 2)}, `{"a":1}`},
+		{`{"a":0}`, Ops{Remove(Path{"a"})}, Ops{Set(Path{"a"}, 2)}, `{}`},
+		{`{"a":0}`, Ops{Set(Path{"a"}, 2)}, Ops{Remove(Path{"a"})}, `{"a":2}`},
+		{`{"a":0}`, Ops{Remove(Path{"a"})}, Ops{Remove(Path{"a"})}, `{}`},
+		// edits inside a replaced value are dropped
+		{`{"a":{"n":1}}`, Ops{Add(Path{"a", "n"}, 1)}, Ops{Set(Path{"a"}, 5)}, `{"a":5}`},
+		{`{"l":[{"n":1}]}`, Ops{Delete(Path{"l", 0})}, Ops{Add(Path{"l", 0, "n"}, 1)}, `{"l":[]}`},
+		// indexes follow concurrent list ops
+		{`{"l":[0,{"n":1}]}`, Ops{Insert(Path{"l", 0}, "x")}, Ops{Add(Path{"l", 1, "n"}, 1)}, `{"l":["x",0,{"n":2}]}`},
+		{`{"l":[{"n":1},0]}`, Ops{Move(Path{"l", 0}, 1)}, Ops{Add(Path{"l", 0, "n"}, 1)}, `{"l":[0,{"n":2}]}`},
+		// inserts at the same index land in the order as, bs
+		{`{"l":[0]}`, Ops{Insert(Path{"l", 0}, "a")}, Ops{Insert(Path{"l", 0}, "b")}, `{"l":["a","b",0]}`},
+		{`{"l":[0,1,2]}`, Ops{Delete(Path{"l", 1})}, Ops{Delete(Path{"l", 1})}, `{"l":[0,2]}`},
+		{`{"l":[0,1,2]}`, Ops{Delete(Path{"l", 0})}, Ops{Insert(Path{"l", 1}, "x")}, `{"l":["x",1,2]}`},
+		// as wins concurrent moves of the same element
+		{`{"l":[0,1,2]}`, Ops{Move(Path{"l", 0}, 2)}, Ops{Move(Path{"l", 0}, 1)}, `{"l":[1,2,0]}`},
+		{`{"l":[0,1,2]}`, Ops{Move(Path{"l", 0}, 1)}, Ops{Move(Path{"l", 0}, 2)}, `{"l":[1,0,2]}`},
+		{`{"l":[0,1,2]}`, Ops{Move(Path{"l", 0}, 2)}, Ops{Delete(Path{"l", 0})}, `{"l":[1,2]}`},
+		{`{"l":[0,1,2]}`, Ops{Move(Path{"l", 2}, 0)}, Ops{Move(Path{"l", 0}, 2)}, `{"l":[2,1,0]}`},
+		// concurrent adds both take effect
+		{`{"n":1}`, Ops{Add(Path{"n"}, 2)}, Ops{Add(Path{"n"}, 3)}, `{"n":6}`},
+		// concurrent string edits are transformed with ot.Transform
+		{`{"s":"ab"}`, Ops{Text(Path{"s"}, ot.C(ot.Rs(1), ot.Is("x"), ot.Rs(1)))}, Ops{Text(Path{"s"}, ot.C(ot.Rs(1), ot.Is("y"), ot.Rs(1)))}, `{"s":"axyb"}`},
+	}
+
+	for idx, c := range table {
+		v := parse(c.Doc)
+		got, err := converge(c.A, c.B, v)
+		if err != nil {
+			t.Fatalf("transform %d failed, doc: %s, as: %s, bs: %s, err: %q", idx, c.Doc, c.A, c.B, err)
+		}
+		if !reflect.DeepEqual(got, parse(c.Result)) {
+			bs, _ := json.Marshal(got)
+			t.Fatalf("transform %d: doc: %s, as: %s, bs: %s; got %s, expected %s", idx, c.Doc, c.A, c.B, bs, c.Result)
+		}
+	}
+}
+
+// converge checks that as, bs' and bs, as' produce the same value from v and
+// returns that value.
+func converge(as, bs Ops, v interface{}) (interface{}, error) {
+	a1, b1, err := Transform(as, bs)
+	if err != nil {
+		return nil, err
+	}
+	va, err := Apply(as, v)
+	if err != nil {
+		return nil, err
+	}
+	if va, err = Apply(b1, va); err != nil {
+		return nil, err
+	}
+	vb, err := Apply(bs, v)
+	if err != nil {
+		return nil, err
+	}
+	if vb, err = Apply(a1, vb); err != nil {
+		return nil, err
+	}
+	if Hash(va) != Hash(vb) {
+		ja, _ := json.Marshal(va)
+		jb, _ := json.Marshal(vb)
+		return nil, errors.Errorf("diverged; as, bs': %s, bs, as': %s; as': %s, bs': %s", ja, jb, a1, b1)
+	}
+	return va, nil
+}
+
+func TestJSON(t *testing.T) {
+	table := []struct {
+		A Ops
+		B string
+	}{
+		{Ops{Set(Path{"a"}, nil)}, `[{"P":["a"],"S":null}]`},
+		{Ops{Remove(Path{"a"})}, `[{"P":["a"],"R":true}]`},
+		{Ops{Insert(Path{"l", 0}, map[string]interface{}{"x": 1})}, `[{"I":{"x":1},"P":["l",0]}]`},
+		{Ops{Delete(Path{0})}, `[{"D":true,"P":[0]}]`},
+		{Ops{Move(Path{"l", 1}, 0)}, `[{"M":0,"P":["l",1]}]`},
+		{Ops{Add(Path{}, -1)}, `[{"N":-1,"P":[]}]`},
+		{Ops{Text(Path{"s"}, ot.C(ot.Rs(1), ot.Is("a")))}, `[{"P":["s"],"T":[1,"a"]}]`},
+	}
+
+	for idx, c := range table {
+		bs, err := json.Marshal(c.A)
+		if err != nil {
+			t.Fatalf("json %d failed; marshal err: %q", idx, err)
+		}
+		if string(bs) != c.B {
+			t.Fatalf("json %d failed; %s -> %s != expected %s", idx, c.A, bs, c.B)
+		}
+		var a Ops
+		if err := json.Unmarshal(bs, &a); err != nil {
+			t.Fatalf("json %d failed; unmarshal err: %q", idx, err)
+		}
+		if a.String() != c.A.String() {
+			t.Fatalf("json %d failed; %s -> %s != expected %s", idx, bs, a, c.A)
+		}
+	}
+
+	for _, s := range []string{`[{"P":["a"]}]`, `[{"S":1}]`, `[{"P":["a"],"S":1,"R":true}]`, `[{"P":[-1],"D":true}]`, `[{"P":[0],"R":true}]`, `[{"P":[1.5],"D":true}]`} {
+		var a Ops
+		if err := json.Unmarshal([]byte(s), &a); err == nil {
+			t.Fatalf("expected error unmarshaling %s, got %s", s, a)
+		}
+	}
+}
+
+// randValue returns a random value of nesting depth at most depth.
+func randValue(r *rand.Rand, depth int) interface{} {
+	n := 4
+	if depth > 0 {
+		n = 6
+	}
+	switch r.Intn(n) {
+	case 0:
+		return float64(r.Intn(10))
+	case 1:
+		return string([]rune("abc")[:r.Intn(4)])
+	case 2:
+		return r.Intn(2) == 0
+	case 3:
+		return nil
+	case 4:
+		l := []interface{}{}
+		for i := r.Intn(5); i > 0; i-- {
+			l = append(l, randValue(r, depth-1))
+		}
+		return l
+	default:
+		o := map[string]interface{}{}
+		for _, k := range []string{"a", "b", "c"} {
+			if r.Intn(2) == 0 {
+				o[k] = randValue(r, depth-1)
+			}
+		}
+		return o
+	}
+}
+
+// randPath returns the path of a random value within v.
+func randPath(r *rand.Rand, v interface{}) Path {
+	p := Path{}
+	for r.Intn(3) != 0 {
+		switch c := v.(type) {
+		case []interface{}:
+			if len(c) == 0 {
+				return p
+			}
+			i := r.Intn(len(c))
+			p, v = append(p, i), c[i]
+		case map[string]interface{}:
+			ks := []string{}
+			for _, k := range []string{"a", "b", "c"} {
+				if _, ok := c[k]; ok {
+					ks = append(ks, k)
+				}
+			}
+			if len(ks) == 0 {
+				return p
+			}
+			k := ks[r.Intn(len(ks))]
+			p, v = append(p, k), c[k]
+		default:
+			return p
+		}
+	}
+	return p
+}
+
+// get returns the value at p in v.
+func get(v interface{}, p Path) interface{} {
+	for _, e := range p {
+		switch e := e.(type) {
+		case int:
+			v = v.([]interface{})[e]
+		case string:
+			v = v.(map[string]interface{})[e]
+		}
+	}
+	return v
+}
+
+// randOp returns a random op that applies to v.
+func randOp(r *rand.Rand, v interface{}) Op {
+	for {
+		p := randPath(r, v)
+		with := func(e interface{}) Path {
+			return append(append(Path(nil), p...), e)
+		}
+		switch c := get(v, p).(type) {
+		case []interface{}:
+			switch n := r.Intn(3); {
+			case n == 0 || len(c) == 0:
+				return Insert(with(r.Intn(len(c)+1)), randValue(r, 1))
+			case n == 1:
+				return Delete(with(r.Intn(len(c))))
+			default:
+				return Move(with(r.Intn(len(c))), r.Intn(len(c)))
+			}
+		case map[string]interface{}:
+			k := []string{"a", "b", "c"}[r.Intn(3)]
+			if _, ok := c[k]; ok && r.Intn(2) == 0 {
+				return Remove(with(k))
+			}
+			return Set(with(k), randValue(r, 1))
+		case float64:
+			return Add(p, float64(r.Intn(5)))
+		case string:
+			n := len([]rune(c))
+			i := r.Intn(n + 1)
+			d := r.Intn(n - i + 1)
+			return Text(p, ot.C(ot.Rs(i), ot.Ds(d), ot.Is([]string{"x", "yz"}[r.Intn(2)]), ot.Rs(n-i-d)))
+		}
+	}
+}
+
+// randOps returns up to n random ops that apply to v, in sequence.
+func randOps(r *rand.Rand, v interface{}, n int) Ops {
+	ret := Ops{}
+	for i := r.Intn(n + 1); i > 0; i-- {
+		o := randOp(r, v)
+		var err error
+		if v, err = Apply(Ops{o}, v); err != nil {
+			panic(err)
+		}
+		ret = append(ret, o)
+	}
+	return ret
+}
+
+func TestRandomTransform(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		v := map[string]interface{}{
+			"l": []interface{}{0.0, 1.0, 2.0, []interface{}{"s"}},
+			"o": randValue(r, 2),
+		}
+		as := randOps(r, v, 3)
+		bs := randOps(r, v, 3)
+		if _, err := converge(as, bs, v); err != nil {
+			js, _ := json.Marshal(v)
+			t.Fatalf("iteration %d failed, doc: %s, as: %s, bs: %s, err: %q", i, js, as, bs, err)
+		}
+	}
+}
+
+func TestDoc(t *testing.T) {
+	d := NewDoc()
+	if err := d.Apply(Ops{Set(Path{"l"}, []interface{}{}), Insert(Path{"l", 0}, "a")}); err != nil {
+		t.Fatalf("apply failed, err: %q", err)
+	}
+	s := d.Snapshot()
+	if err := d.Apply(Ops{Delete(Path{"l", 0})}); err != nil {
+		t.Fatalf("apply failed, err: %q", err)
+	}
+	if d.String() != `{"l":[]}` || s.String() != `{"l":["a"]}` {
+		t.Fatalf("snapshot not independent; d: %s, s: %s", d.String(), s.String())
+	}
+	if err := d.Validate(Ops{Delete(Path{"l", 0})}); err == nil {
+		t.Fatalf("expected validate error")
+	}
+	if d.Hash() == s.Hash() {
+		t.Fatalf("expected hashes to differ")
+	}
+}
@@ -0,0 +1,423 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package jsonot
+
+import (
+	"github.com/juju/errors"
+	"github.com/mstone/focus/ot"
+)
+
+// Compose returns ops equivalent to applying as and then bs. Adjacent adds to
+// the same number and edits of the same string are merged.
+func Compose(as, bs Ops) (Ops, error) {
+	ret := as.Clone()
+	for _, b := range bs.Clone() {
+		if err := b.check(); err != nil {
+			return nil, errors.Trace(err)
+		}
+		if len(ret) > 0 {
+			l := &ret[len(ret)-1]
+			switch {
+			case l.Tag == J_ADD && b.Tag == J_ADD && samePath(l.Path, b.Path):
+				l.N += b.N
+				continue
+			case l.Tag == J_TEXT && b.Tag == J_TEXT && samePath(l.Path, b.Path):
+				t, err := ot.Compose(l.Text, b.Text)
+				if err != nil {
+					return nil, errors.Trace(err)
+				}
+				l.Text = t
+				continue
+			}
+		}
+		ret = append(ret, b)
+	}
+	return ret, nil
+}
+
+// ComposeAll composes a sequence of op lists.
+func ComposeAll(opss []Ops) (Ops, error) {
+	ret := Ops{}
+	for _, ops := range opss {
+		var err error
+		ret, err = Compose(ret, ops)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return ret, nil
+}
+
+// Transform returns as' and bs' such that applying as and then bs' gives the
+// same document as applying bs and then as'. As in ot.Transform, as wins
+// conflicts:
+//
+//   - Ops on values that a concurrent op sets, removes, or deletes are
+//     dropped, except that of two sets or removes of the same key, the op in
+//     as is kept.
+//   - Indexes into lists are adjusted for concurrent inserts, deletes, and
+//     moves. Inserts at the same index land in the order as, bs.
+//   - Concurrent moves of the same list element move it to where the op in
+//     as put it.
+//   - Concurrent adds to the same number both take effect.
+//   - Concurrent edits of the same string are transformed with ot.Transform.
+func Transform(as, bs Ops) (Ops, Ops, error) {
+	for _, ops := range []Ops{as, bs} {
+		for _, o := range ops {
+			if err := o.check(); err != nil {
+				return nil, nil, errors.Trace(err)
+			}
+		}
+	}
+	a1, b1, err := transform(as, bs)
+	if err != nil {
+		return nil, nil, errors.Annotatef(err, "transform failed, as: %s, bs: %s", as.String(), bs.String())
+	}
+	return a1, b1, nil
+}
+
+func transform(as, bs Ops) (Ops, Ops, error) {
+	switch {
+	case len(as) == 0 || len(bs) == 0:
+		return as.Clone(), bs.Clone(), nil
+	case len(as) == 1 && len(bs) == 1:
+		a1, err := xf(as[0], bs[0], true)
+		if err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		b1, err := xf(bs[0], as[0], false)
+		if err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		return a1, b1, nil
+	case len(as) > 1:
+		a1, bs1, err := transform(as[:1], bs)
+		if err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		a2, bs2, err := transform(as[1:], bs1)
+		if err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		return append(a1, a2...), bs2, nil
+	default:
+		as1, b1, err := transform(as, bs[:1])
+		if err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		as2, b2, err := transform(as1, bs[1:])
+		if err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		return as2, append(b1, b2...), nil
+	}
+}
+
+// xf returns a transformed to apply after b. left says whether a wins
+// conflicts with b.
+func xf(a, b Op, left bool) (Ops, error) {
+	switch b.Tag {
+	case J_INSERT, J_DELETE, J_MOVE:
+		l := b.Path[:len(b.Path)-1]
+		if len(a.Path) <= len(l) || !hasPrefix(a.Path, l) {
+			return Ops{a}, nil
+		}
+		if a.isList() && len(a.Path) == len(b.Path) {
+			return reorder(a, b, left), nil
+		}
+		i, ok := a.Path[len(l)].(int)
+		if !ok {
+			return Ops{a}, nil
+		}
+		j, ok := mapIndex(i, b)
+		if !ok {
+			return nil, nil
+		}
+		return Ops{a.at(len(l), j)}, nil
+	case J_SET, J_REMOVE:
+		switch {
+		case !hasPrefix(a.Path, b.Path):
+			return Ops{a}, nil
+		case len(a.Path) > len(b.Path) || !a.isKey():
+			return nil, nil
+		case a.Tag == J_REMOVE && b.Tag == J_REMOVE:
+			return nil, nil
+		case left:
+			return Ops{a}, nil
+		default:
+			return nil, nil
+		}
+	case J_TEXT:
+		if a.Tag != J_TEXT || !samePath(a.Path, b.Path) {
+			return Ops{a}, nil
+		}
+		var t ot.Ops
+		var err error
+		if left {
+			t, _, err = ot.Transform(a.Text, b.Text)
+		} else {
+			_, t, err = ot.Transform(b.Text, a.Text)
+		}
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		a.Text = t
+		return Ops{a}, nil
+	default:
+		return Ops{a}, nil
+	}
+}
+
+func hasPrefix(p, q Path) bool {
+	if len(p) < len(q) {
+		return false
+	}
+	for k := range q {
+		if p[k] != q[k] {
+			return false
+		}
+	}
+	return true
+}
+
+func samePath(p, q Path) bool {
+	return len(p) == len(q) && hasPrefix(p, q)
+}
+
+// Concurrent list ops are transformed by simulating their effects on a
+// synthetic list of distinct elements that is long enough to hold every index
+// that they mention. Elements past the end of the real list are never touched,
+// so ops derived from the simulation apply to the real list too.
+
+type elem int
+
+const (
+	e_WINNER elem = -1 // the element inserted by the winning op
+	e_LOSER  elem = -2 // the element inserted by the losing op
+)
+
+// synth returns a synthetic list long enough for the indexes of os.
+func synth(os ...Op) []elem {
+	n := 0
+	for _, o := range os {
+		if i := o.index(); i > n {
+			n = i
+		}
+		if o.Tag == J_MOVE && o.To > n {
+			n = o.To
+		}
+	}
+	ret := make([]elem, n+2)
+	for k := range ret {
+		ret[k] = elem(k)
+	}
+	return ret
+}
+
+func find(s []elem, e elem) int {
+	for k, f := range s {
+		if f == e {
+			return k
+		}
+	}
+	return -1
+}
+
+func insertElem(s []elem, i int, e elem) []elem {
+	ret := make([]elem, 0, len(s)+1)
+	ret = append(ret, s[:i]...)
+	ret = append(ret, e)
+	return append(ret, s[i:]...)
+}
+
+func removeElem(s []elem, i int) []elem {
+	ret := make([]elem, 0, len(s))
+	ret = append(ret, s[:i]...)
+	return append(ret, s[i+1:]...)
+}
+
+// simulate returns the result of applying the list op o to s; e is the
+// element that o inserts, if any.
+func simulate(s []elem, o Op, e elem) []elem {
+	i := o.index()
+	switch o.Tag {
+	case J_INSERT:
+		return insertElem(s, i, e)
+	case J_DELETE:
+		return removeElem(s, i)
+	default:
+		m := s[i]
+		return insertElem(removeElem(s, i), o.To, m)
+	}
+}
+
+// mapIndex returns the index of the element at index i after the list op o
+// has been applied, or false if o deletes it.
+func mapIndex(i int, o Op) (int, bool) {
+	s := synth(o, o.at(len(o.Path)-1, i))
+	t := simulate(s, o, e_LOSER)
+	j := find(t, elem(i))
+	return j, j >= 0
+}
+
+// mapGap returns the gap (i.e., insertion index) corresponding to g after the
+// list op o, if any, has been applied. Gaps at an insert or at the target of
+// a move stay before the inserted or moved element.
+func mapGap(g int, o *Op) int {
+	if o == nil {
+		return g
+	}
+	i := o.index()
+	switch o.Tag {
+	case J_INSERT:
+		if g > i {
+			g++
+		}
+	case J_DELETE:
+		if g > i {
+			g--
+		}
+	case J_MOVE:
+		if g > i {
+			g--
+		}
+		if g > o.To {
+			g++
+		}
+	}
+	return g
+}
+
+// without returns the list op o restated for the list without the element at
+// index f, which o must not delete; it returns nil if o only moves that
+// element.
+func without(o Op, f int) *Op {
+	i := o.index()
+	shift := func(k int) int {
+		if k > f {
+			return k - 1
+		}
+		return k
+	}
+	switch o.Tag {
+	case J_MOVE:
+		if i == f {
+			return nil
+		}
+		// the position of the element at f in the list without o's element
+		p := f
+		if f > i {
+			p--
+		}
+		to := o.To
+		if to > p {
+			to--
+		}
+		ret := o.at(len(o.Path)-1, shift(i))
+		ret.To = to
+		return &ret
+	default:
+		ret := o.at(len(o.Path)-1, shift(i))
+		return &ret
+	}
+}
+
+// reorder returns the list op a transformed to apply after the list op b,
+// which modifies the same list.
+//
+// The list that both sides converge on is the result of applying the losing
+// op and then the winning op, with the winning op's indexes adjusted for the
+// losing op. The transformed op is whatever turns the list that results from
+// applying b into that list.
+func reorder(a, b Op, left bool) Ops {
+	w, l := a, b
+	if !left {
+		w, l = b, a
+	}
+	s := synth(w, l)
+	sl := simulate(s, l, e_LOSER)
+
+	var f []elem
+	switch w.Tag {
+	case J_INSERT:
+		f = insertElem(sl, mapGap(w.index(), &l), e_WINNER)
+	case J_DELETE:
+		f = sl
+		if k := find(sl, s[w.index()]); k >= 0 {
+			f = removeElem(sl, k)
+		}
+	case J_MOVE:
+		f = sl
+		m := s[w.index()]
+		if k := find(sl, m); k >= 0 {
+			f = insertElem(removeElem(sl, k), mapGap(w.To, without(l, w.index())), m)
+		}
+	}
+
+	// elements that neither op moves keep their relative order in every list
+	moved := map[elem]bool{}
+	for _, o := range []Op{w, l} {
+		if o.Tag == J_MOVE {
+			moved[s[o.index()]] = true
+		}
+	}
+	values := map[elem]interface{}{
+		e_WINNER: w.Value,
+		e_LOSER:  l.Value,
+	}
+	from := sl
+	if !left {
+		from = simulate(s, w, e_WINNER)
+	}
+	return derive(a.Path[:len(a.Path)-1], from, f, moved, values)
+}
+
+// derive returns list ops at the list at p that turn cur into f. Elements
+// of cur that are not in f are deleted, elements of f that are not in cur are
+// inserted with the given values, and moved elements are moved to follow
+// their predecessors in f.
+func derive(p Path, cur, f []elem, moved map[elem]bool, values map[elem]interface{}) Ops {
+	at := func(i int) Path {
+		ret := append(Path(nil), p...)
+		return append(ret, i)
+	}
+
+	ret := Ops{}
+	inF := map[elem]bool{}
+	for _, e := range f {
+		inF[e] = true
+	}
+	for k := len(cur) - 1; k >= 0; k-- {
+		if !inF[cur[k]] {
+			ret = append(ret, Delete(at(k)))
+			cur = removeElem(cur, k)
+		}
+	}
+
+	for k, e := range f {
+		p := find(cur, e)
+		if p >= 0 && !moved[e] {
+			continue
+		}
+		q := -1
+		if k > 0 {
+			q = find(cur, f[k-1])
+		}
+		switch {
+		case p < 0:
+			ret = append(ret, Op{Tag: J_INSERT, Path: at(q + 1), Value: values[e]})
+			cur = insertElem(cur, q+1, e)
+		case p == q+1:
+			continue
+		default:
+			to := q + 1
+			if p < q {
+				to = q
+			}
+			ret = append(ret, Move(at(p), to))
+			cur = insertElem(removeElem(cur, p), to, e)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,218 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot
+
+import (
+	"github.com/juju/errors"
+)
+
+// A move relocates a single kid within its parent. It is written as a pair of
+// ops in the parent's op list: a move, M(id), at the kid's old position, which
+// consumes the kid like a delete, and a place, P(id), at its new position,
+// which produces it again like an insert. Since each half of the pair only
+// touches a single position, Compose and Transform walk moves and places like
+// the ops that they resemble and then fix up the other half of each pair,
+// which may lie on either side of it, once the walk is done.
+
+// moveKey names the move or place of a pair in fixes.
+type moveKey struct {
+	Tag OpTag
+	Id  int
+}
+
+// fixes maps the moves and places of an op list to the ops that should
+// replace them.
+type fixes map[moveKey]Ops
+
+// resolve returns os with the moves and places named by fs replaced.
+func (fs fixes) resolve(os Ops) Ops {
+	if len(fs) == 0 {
+		return os
+	}
+	ret := make(Ops, 0, len(os))
+	for _, o := range os {
+		if o.Tag == O_MOVE || o.Tag == O_PLACE {
+			if r, ok := fs[moveKey{o.Tag, o.Id}]; ok {
+				ret = append(ret, r...)
+				continue
+			}
+		}
+		ret = append(ret, o)
+	}
+	return ret
+}
+
+// placeholder returns a place that stands for the kid moved by the move
+// identified by id in the other op list of a transform. Placeholders are
+// always resolved before transform1 returns.
+func placeholder(id int) Op {
+	return Op{Tag: O_PLACE, Id: -id}
+}
+
+// maxId returns the largest id of the moves in os.
+func maxId(os Ops) int {
+	n := 0
+	for _, o := range os {
+		if o.IsMove() && o.Id > n {
+			n = o.Id
+		}
+	}
+	return n
+}
+
+// movesOf returns the moves of os by id.
+func movesOf(os Ops) map[int]Op {
+	ret := map[int]Op{}
+	for _, o := range os {
+		if o.IsMove() {
+			ret[o.Id] = o
+		}
+	}
+	return ret
+}
+
+// renumber numbers the pairs of os in order of first appearance.
+func renumber(os Ops) {
+	ids := map[int]int{}
+	for i := range os {
+		o := &os[i]
+		if !o.IsMove() && !o.IsPlace() {
+			continue
+		}
+		id, ok := ids[o.Id]
+		if !ok {
+			id = len(ids) + 1
+			ids[o.Id] = id
+		}
+		o.Id = id
+	}
+}
+
+// collapse replaces the pairs of os whose moves and places are adjacent by
+// ops that modify the moved kid in place. It reports whether it replaced any.
+func collapse(os Ops) bool {
+	ret := false
+	for i := 0; i+1 < len(os); i++ {
+		a, b := &os[i], &os[i+1]
+		switch {
+		case a.IsMove() && b.IsPlace() && a.Id == b.Id:
+			*a, *b = editKid(a.Kids, a.Attrs), Z()
+		case a.IsPlace() && b.IsMove() && a.Id == b.Id:
+			*a, *b = editKid(b.Kids, b.Attrs), Z()
+		default:
+			continue
+		}
+		ret = true
+	}
+	return ret
+}
+
+// editKid returns an op that modifies a single kid with kids and attrs.
+func editKid(kids Ops, attrs Attrs) Op {
+	if len(kids) > 0 {
+		return Wf(kids, attrs)
+	}
+	return F(1, attrs)
+}
+
+// moveKid returns k as modified by the move o.
+func moveKid(o Op, k Tree) (Tree, error) {
+	k = k.Clone()
+	if k.IsBranch() {
+		err := Apply(Wf(o.Kids, o.Attrs), &k)
+		return k, errors.Trace(err)
+	}
+	if len(o.Kids) > 0 {
+		return Tree{}, errors.Errorf("moveKid failed, move modifies non-branch; o: %s, k: %s", o.String(), k.String())
+	}
+	return k.WithAttrs(k.Attrs.Apply(o.Attrs)), nil
+}
+
+// moved returns the kids that the moves in os take out of kids, by id. If
+// modify is set, the kids are modified as the moves direct.
+func moved(os Ops, kids kidSeq, modify bool) (map[int]Tree, error) {
+	ret := map[int]Tree{}
+	size, pos := kids.Len(), 0
+	for _, o := range os {
+		switch {
+		case o.IsMove():
+			if pos >= size {
+				return nil, errors.Errorf("moved failed, move past end; pos: %d, o: %s, kids: %s", pos, o.String(), kids.String())
+			}
+			if _, ok := ret[o.Id]; ok {
+				return nil, errors.Errorf("moved failed, duplicate move: %s", o.String())
+			}
+			k := kids.slice(pos, pos+1)[0]
+			if modify {
+				var err error
+				if k, err = moveKid(o, k); err != nil {
+					return nil, errors.Trace(err)
+				}
+			}
+			ret[o.Id] = k
+			pos++
+		case o.IsRetain(), o.IsDelete(), o.IsWith():
+			pos += o.Len()
+		}
+	}
+	return ret, nil
+}
+
+// composeKids composes the modifications that two ops make to the same kid,
+// either of which may be empty.
+func composeKids(as, bs Ops) (Ops, error) {
+	switch {
+	case len(as) == 0:
+		return bs.Clone(), nil
+	case len(bs) == 0:
+		return as.Clone(), nil
+	default:
+		return Compose(as, bs)
+	}
+}
+
+// transformKids transforms the modifications that two ops make to the same
+// kid, either of which may be empty.
+func transformKids(as, bs Ops, aFirst bool) (Ops, Ops, error) {
+	switch {
+	case len(as) == 0:
+		return nil, bs.Clone(), nil
+	case len(bs) == 0:
+		return as.Clone(), nil, nil
+	default:
+		return transform(as, bs, aFirst)
+	}
+}
+
+// MoveAt returns ops that move the kid of the innermost branch along p at the
+// last element of p so that it ends up at position to of that branch.
+// Concurrent edits of the moved kid follow it to its new position.
+func MoveAt(p Path, to int, t Tree) (Ops, error) {
+	if !t.IsBranch() {
+		return nil, errors.Errorf("MoveAt failed, expected branch; p: %v, t: %s", p, t.String())
+	}
+	return moveAt(p, to, t.Kids)
+}
+
+func moveAt(p Path, to int, kids kidSeq) (Ops, error) {
+	if len(p) == 0 {
+		return nil, errors.Errorf("moveAt failed, empty path")
+	}
+	return descend(p[:len(p)-1], kids, func(kids kidSeq) (Ops, error) {
+		pos, size := p[len(p)-1], kids.Len()
+		if pos < 0 || pos >= size || to < 0 || to >= size {
+			return nil, errors.Errorf("moveAt failed, move out of range; pos: %d, to: %d, size: %d", pos, to, size)
+		}
+		return Normalize(NewMove(size, pos, to))
+	})
+}
+
+// MoveAt returns ops that move the kid at p in d's current body to position
+// to; see MoveAt.
+func (d *Doc) MoveAt(p Path, to int) (Ops, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return moveAt(p, to, d.body)
+}
@@ -0,0 +1,202 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// applyTo returns a snapshot of d to which each of oss has been applied.
+func applyTo(t *testing.T, d *Doc, oss ...Ops) *Doc {
+	ret := d.Snapshot()
+	for _, os := range oss {
+		if err := ret.Apply(os); err != nil {
+			t.Fatalf("apply failed, os: %s, doc: %s, err: %q", os, ret.String(), err)
+		}
+	}
+	return ret
+}
+
+func TestMoveApply(t *testing.T) {
+	cases := []struct {
+		From, To int
+		Expected []string
+	}{
+		{0, 2, []string{"cd", "ef", "ab"}},
+		{2, 0, []string{"ef", "ab", "cd"}},
+		{1, 2, []string{"ab", "ef", "cd"}},
+		{1, 1, []string{"ab", "cd", "ef"}},
+	}
+	base := paras("ab", "cd", "ef")
+	for _, c := range cases {
+		ops, err := base.MoveAt(Path{0, c.From}, c.To)
+		if err != nil {
+			t.Fatalf("MoveAt %d, %d failed, err: %q", c.From, c.To, err)
+		}
+		samePars(t, "move", applyTo(t, base, ops), c.Expected...)
+
+		// the tree and the rope apply moves alike
+		body := base.Body()
+		if err := Apply(W(ops), &body); err != nil {
+			t.Fatalf("Apply failed, ops: %s, err: %q", ops, err)
+		}
+		if Hash(body) != Hash(applyTo(t, base, ops).Body()) {
+			t.Fatalf("Apply and Doc.Apply disagree, ops: %s, body: %s", ops, body.String())
+		}
+	}
+
+	// moves may modify the kid that they move
+	ops := Ops{W(Ops{Mf(1, C(Ds(1), Rs(1)), nil), R(2), P(1)})}
+	samePars(t, "move with edit", applyTo(t, base, ops), "cd", "ef", "b")
+
+	bad := []Ops{
+		{W(Ops{M(1), R(2)})},
+		{W(Ops{R(2), P(1), R(1)})},
+		{W(Ops{M(1), M(1), P(1), R(1)})},
+	}
+	for _, ops := range bad {
+		if err := base.Snapshot().Apply(ops); err == nil {
+			t.Fatalf("expected apply of unpaired move to fail, ops: %s", ops)
+		}
+		if err := Validate(ops, base.Body()); err == nil {
+			t.Fatalf("expected validate of unpaired move to fail, ops: %s", ops)
+		}
+	}
+}
+
+func TestMoveCompose(t *testing.T) {
+	base := paras("ab", "cd", "ef")
+
+	first, _ := base.MoveAt(Path{0, 0}, 2)
+	d := applyTo(t, base, first)
+	second, _ := d.MoveAt(Path{0, 2}, 1)
+	edit, _ := d.InsertAt(Path{0, 2, 1}, Leaf('x'))
+	del, _ := d.DeleteAt(Path{0, 2}, 1)
+
+	for _, c := range []struct {
+		Name     string
+		B        Ops
+		Expected []string
+	}{
+		{"move back", second, []string{"cd", "ab", "ef"}},
+		{"edit moved kid", edit, []string{"cd", "ef", "axb"}},
+		{"delete moved kid", del, []string{"cd", "ef"}},
+	} {
+		ab, err := Compose(first, c.B)
+		if err != nil {
+			t.Fatalf("compose %s failed, err: %q", c.Name, err)
+		}
+		samePars(t, c.Name, applyTo(t, base, ab), c.Expected...)
+		samePars(t, c.Name, applyTo(t, base, first, c.B), c.Expected...)
+	}
+
+	// moving a kid back to where it started leaves no move behind
+	back, _ := d.MoveAt(Path{0, 2}, 0)
+	ab, err := Compose(first, back)
+	if err != nil {
+		t.Fatalf("compose failed, err: %q", err)
+	}
+	if expected := C(Rs(3)); !reflect.DeepEqual(ab, C(Ws(expected))) {
+		t.Fatalf("expected moves to cancel, got: %s", ab)
+	}
+}
+
+func TestMoveTransform(t *testing.T) {
+	base := paras("ab", "cd", "ef")
+
+	move, _ := base.MoveAt(Path{0, 0}, 2)
+	moveOther, _ := base.MoveAt(Path{0, 0}, 1)
+	moveSecond, _ := base.MoveAt(Path{0, 1}, 0)
+	edit, _ := base.InsertAt(Path{0, 0, 1}, Leaf('x'))
+	format := C(Ws(C(Fs(1, ital), Rs(2))))
+	del, _ := base.DeleteAt(Path{0, 0}, 1)
+	ins, _ := base.InsertAt(Path{0, 1}, para("new"))
+
+	cases := []struct {
+		Name     string
+		A, B     Ops
+		Expected []string
+	}{
+		// concurrent edits follow the moved kid
+		{"move, edit", move, edit, []string{"cd", "ef", "axb"}},
+		{"edit, move", edit, move, []string{"cd", "ef", "axb"}},
+		// of two moves of the same kid, a's wins
+		{"move, move", move, moveOther, []string{"cd", "ef", "ab"}},
+		{"move, move'", moveOther, move, []string{"cd", "ab", "ef"}},
+		// moves of different kids both take effect
+		{"move, move second", move, moveSecond, []string{"cd", "ef", "ab"}},
+		// deletes win over moves
+		{"move, delete", move, del, []string{"cd", "ef"}},
+		{"delete, move", del, move, []string{"cd", "ef"}},
+		{"move, insert", move, ins, []string{"new", "cd", "ef", "ab"}},
+		{"move, format", move, format, nil},
+	}
+	for _, c := range cases {
+		a1, b1, err := Transform(c.A, c.B)
+		if err != nil {
+			t.Fatalf("transform %s failed, err: %q", c.Name, err)
+		}
+		d1 := applyTo(t, base, c.A, b1)
+		d2 := applyTo(t, base, c.B, a1)
+		if Hash(d1.Body()) != Hash(d2.Body()) {
+			t.Fatalf("docs diverged, %s;\n\td1: %s\n\td2: %s", c.Name, d1.String(), d2.String())
+		}
+		if c.Expected != nil {
+			samePars(t, c.Name, d1, c.Expected...)
+		}
+	}
+}
+
+func TestMoveInvert(t *testing.T) {
+	base := paras("ab", "cd", "ef")
+	ops := Ops{W(Ops{R(1), Mf(1, C(Ds(1), Rs(1)), nil), R(1), P(1)})}
+	inv, err := Invert(ops, base.Body())
+	if err != nil {
+		t.Fatalf("invert failed, err: %q", err)
+	}
+	samePars(t, "invert", applyTo(t, base, ops, inv), "ab", "cd", "ef")
+}
+
+func TestMoveJSON(t *testing.T) {
+	ops := Ops{R(1), Mf(1, C(Rs(1), Ds(1)), ital), M(2), R(1), P(2), P(1)}
+	bs, err := json.Marshal(ops)
+	if err != nil {
+		t.Fatalf("marshal failed, err: %q", err)
+	}
+	if expected := `[1,{"W":[1,-1],"A":{"italic":"true"},"M":1},{"M":2},1,{"P":2},{"P":1}]`; string(bs) != expected {
+		t.Fatalf("bad encoding;\n\tgot: %s\n\texpected: %s", bs, expected)
+	}
+	var x Ops
+	if err := json.Unmarshal(bs, &x); err != nil {
+		t.Fatalf("unmarshal failed, err: %q", err)
+	}
+	if !reflect.DeepEqual(x, ops) {
+		t.Fatalf("bad roundtrip;\n\tgot: %s\n\texpected: %s", x, ops)
+	}
+	for _, s := range []string{`[{"M":1,"P":1}]`, `[{"P":1,"A":{"b":"1"}}]`, `[{"M":-1}]`} {
+		if err := json.Unmarshal([]byte(s), &x); err == nil {
+			t.Fatalf("expected unmarshal of %s to fail", s)
+		}
+	}
+}
+
+func TestMovePath(t *testing.T) {
+	base := paras("ab", "cd", "ef")
+	ops, _ := base.MoveAt(Path{0, 0}, 2)
+	ops, err := Compose(ops, C(Ws(C(Rs(2), Ws(C(Rs(1), Is("y"), Rs(1)))))))
+	if err != nil {
+		t.Fatalf("compose failed, err: %q", err)
+	}
+
+	p, ok := TransformPath(Path{0, 0, 1}, ops, B_BEFORE)
+	if !ok || !reflect.DeepEqual(p, Path{0, 2, 2}) {
+		t.Fatalf("bad path, got: %v, %v", p, ok)
+	}
+	p, ok = TransformPath(Path{0, 1, 1}, ops, B_BEFORE)
+	if !ok || !reflect.DeepEqual(p, Path{0, 0, 1}) {
+		t.Fatalf("bad path, got: %v, %v", p, ok)
+	}
+}
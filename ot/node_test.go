@@ -0,0 +1,175 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// table returns a table node holding one row of cells per row of ss.
+func table(ss ...[]string) Tree {
+	rows := Trees{}
+	for _, r := range ss {
+		cells := Trees{}
+		for _, s := range r {
+			cells = append(cells, Node(N_CELL, nil, Trees{Text(AsRunes(s))}))
+		}
+		rows = append(rows, Node(N_ROW, nil, cells))
+	}
+	return Node(N_TABLE, Attrs{"border": "1"}, rows)
+}
+
+func TestNodeJSON(t *testing.T) {
+	table := []JSONCase{
+		{
+			A: Ops{It(Node(N_PARAGRAPH, nil, Trees{Text(AsRunes("hi"))}))},
+			B: `[{"I":{"T":["hi"],"N":"paragraph"}}]`,
+		},
+		{
+			A: Ops{It(Node(N_LIST, Attrs{"ordered": "true"}, Trees{Node(N_LIST_ITEM, nil, nil), Node(N_LIST_ITEM, bold, Trees{Leaf('a')})}))},
+			B: `[{"I":{"T":[{"T":[],"N":"list-item"},{"T":["a"],"N":"list-item","A":{"bold":"true"}}],"N":"list"},"A":{"ordered":"true"}}]`,
+		},
+	}
+
+	doJSONTable(t, table)
+
+	var os Ops
+	if err := json.Unmarshal([]byte(`[{"I":{"T":"a","N":"paragraph"}}]`), &os); err == nil {
+		t.Fatalf("expected error unmarshaling typed text, got %s", os)
+	}
+	if err := Validate(Ops{It(Leaf('a').WithType(N_CELL))}, Branch(nil)); err == nil {
+		t.Fatalf("expected error validating typed leaf")
+	}
+}
+
+func TestNodeOps(t *testing.T) {
+	d := NewDoc()
+	d.Apply(Ops{It(table([]string{"a", "b"}, []string{"c", "d"}))})
+	before := d.Body()
+
+	steps := []func(d *Doc) (Ops, error){
+		// edit the text of a cell
+		func(d *Doc) (Ops, error) { return d.EditAt(Path{0, 1, 0}, C(Rs(1), Is("x"))) },
+		// patch the attributes of a row and of the table
+		func(d *Doc) (Ops, error) { return d.FormatAt(Path{0, 0}, 1, Attrs{"header": "true"}) },
+		func(d *Doc) (Ops, error) { return d.FormatAt(Path{0}, 1, Attrs{"border": "2"}) },
+		// insert a new row
+		func(d *Doc) (Ops, error) { return d.InsertAt(Path{0, 2}, Node(N_ROW, nil, Trees{Node(N_CELL, nil, nil)})) },
+	}
+
+	comp := Ops{}
+	for i, step := range steps {
+		ops, err := step(d)
+		if err != nil {
+			t.Fatalf("step %d failed, err: %q", i, err)
+		}
+		if err := d.Apply(ops); err != nil {
+			t.Fatalf("step %d failed to apply ops: %s, err: %q", i, ops, err)
+		}
+		if comp, err = Compose(comp, ops); err != nil {
+			t.Fatalf("step %d failed to compose, err: %q", i, err)
+		}
+	}
+
+	after := d.Body()
+	tbl := after.Kids[0]
+	if tbl.Type != N_TABLE || !tbl.Attrs.Equal(Attrs{"border": "2"}) || len(tbl.Kids) != 3 {
+		t.Fatalf("bad table: %s", tbl.String())
+	}
+	for _, row := range tbl.Kids {
+		if row.Type != N_ROW {
+			t.Fatalf("expected row, got %s", row.String())
+		}
+		for _, cell := range row.Kids {
+			if cell.Type != N_CELL {
+				t.Fatalf("expected cell, got %s", cell.String())
+			}
+		}
+	}
+	if !tbl.Kids[0].Attrs.Equal(Attrs{"header": "true"}) || tbl.Kids[1].Kids[0].String() != "cell[c x]" {
+		t.Fatalf("bad table contents: %s", tbl.String())
+	}
+
+	// composed ops and inverses preserve node types too
+	d2 := NewDoc()
+	d2.Apply(Ops{It(before.Kids[0])})
+	inv, err := d2.Invert(comp)
+	if err != nil {
+		t.Fatalf("invert failed, err: %q", err)
+	}
+	if err := d2.Apply(comp); err != nil {
+		t.Fatalf("unable to apply composed ops: %s, err: %q", comp, err)
+	}
+	if !reflect.DeepEqual(d2.Body(), after) {
+		t.Fatalf("composed ops diverged;\n\tgot: %s\n\texpected: %s", d2.String(), after.String())
+	}
+	d3 := NewDoc()
+	d3.Apply(Ops{It(after.Kids[0])})
+	d3.Apply(inv)
+	if Hash(d3.Body()) != Hash(before) {
+		t.Fatalf("invert failed;\n\tgot: %s\n\texpected: %s", d3.String(), before.String())
+	}
+	if Hash(before) == Hash(Branch(Trees{Branch(before.Kids[0].Kids).WithAttrs(before.Kids[0].Attrs)})) {
+		t.Fatalf("expected node types to affect hashes")
+	}
+}
+
+func TestNodeTransform(t *testing.T) {
+	d := NewDoc()
+	d.Apply(Ops{It(table([]string{"a", "b"}))})
+
+	// concurrent patches of the same node's attributes: as wins
+	a, _ := d.FormatAt(Path{0, 0, 1}, 1, Attrs{"align": "left", "color": "red"})
+	b, _ := d.FormatAt(Path{0, 0, 1}, 1, Attrs{"align": "right"})
+	a1, b1, err := Transform(a, b)
+	if err != nil {
+		t.Fatalf("transform failed, err: %q", err)
+	}
+	d1, d2 := d.Snapshot(), d.Snapshot()
+	d1.Apply(a)
+	d1.Apply(b1)
+	d2.Apply(b)
+	d2.Apply(a1)
+	if !reflect.DeepEqual(d1.Body(), d2.Body()) {
+		t.Fatalf("docs diverged;\n\td1: %s\n\td2: %s", d1.String(), d2.String())
+	}
+	cell := d1.Body().Kids[0].Kids[0].Kids[1]
+	if cell.Type != N_CELL || !cell.Attrs.Equal(Attrs{"align": "left", "color": "red"}) {
+		t.Fatalf("expected as to win; got %s", cell.String())
+	}
+
+	// deleting a row wins over concurrent edits inside it
+	a, _ = d.DeleteAt(Path{0, 0}, 1)
+	b, _ = d.EditAt(Path{0, 0, 0}, C(Is("z"), Rs(1)))
+	_, b1, err = Transform(a, b)
+	if err != nil {
+		t.Fatalf("transform failed, err: %q", err)
+	}
+	d1 = d.Snapshot()
+	d1.Apply(a)
+	d1.Apply(b1)
+	if tbl := d1.Body().Kids[0]; tbl.Type != N_TABLE || len(tbl.Kids) != 0 {
+		t.Fatalf("expected empty table; got %s", tbl.String())
+	}
+}
+
+func TestNodeDiff(t *testing.T) {
+	a := Branch(Trees{Node(N_PARAGRAPH, nil, Trees{Text(AsRunes("ab"))}), Node(N_PARAGRAPH, nil, Trees{Text(AsRunes("cd"))})})
+	b := Branch(Trees{Node(N_PARAGRAPH, nil, Trees{Text(AsRunes("ax"))}), Node(N_HEADING, nil, Trees{Text(AsRunes("cd"))})})
+
+	ops := DiffTree(a, b)
+	root := a.Clone()
+	if err := Apply(ops[0], &root); err != nil {
+		t.Fatalf("apply failed, ops: %s, err: %q", ops, err)
+	}
+	if Hash(root) != Hash(b) {
+		t.Fatalf("diff failed, ops: %s;\n\tgot: %s\n\texpected: %s", ops, root.String(), b.String())
+	}
+	// the paragraph is edited in place; the retyped node is replaced
+	if len(ops[0].Kids) == 0 || !ops[0].Kids[0].IsWith() {
+		t.Fatalf("expected paragraph to be diffed recursively; got %s", ops)
+	}
+}
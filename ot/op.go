@@ -18,6 +18,8 @@ const (
 	O_RETAIN
 	O_DELETE
 	O_WITH
+	O_MOVE
+	O_PLACE
 )
 
 type Op struct {
@@ -32,14 +34,26 @@ type Op struct {
 
 	// Kids are the child-ops for parent With operations
 	Kids Ops
+
+	// Attrs are the attributes to patch onto the trees affected by retain and
+	// With operations. (Inserts carry their attributes in Body.)
+	Attrs Attrs
+
+	// Id pairs a move, which takes the kid at its position out of the
+	// branch, with the place that puts that kid back at its own position.
+	// Like With ops, moves may also modify the kid they move with Kids and
+	// Attrs. Ids are local to the op list that holds them.
+	Id int
 }
 
 func (o Op) Clone() Op {
 	return Op{
-		Tag:  o.Tag,
-		Size: o.Size,
-		Body: o.Body.Clone(),
-		Kids: o.Kids.Clone(),
+		Tag:   o.Tag,
+		Size:  o.Size,
+		Body:  o.Body.Clone(),
+		Kids:  o.Kids.Clone(),
+		Attrs: o.Attrs,
+		Id:    o.Id,
 	}
 }
 
@@ -71,11 +85,25 @@ func (o *Op) IsWith() bool {
 	return o.Tag == O_WITH
 }
 
+func (o *Op) IsMove() bool {
+	if o == nil {
+		return false
+	}
+	return o.Tag == O_MOVE && o.Id > 0
+}
+
+func (o *Op) IsPlace() bool {
+	if o == nil {
+		return false
+	}
+	return o.Tag == O_PLACE && o.Id > 0
+}
+
 func (o *Op) IsZero() bool {
 	if o == nil {
 		return true
 	}
-	return o.Tag == O_NIL && o.Size == 0 && o.Body.Len() == 0 && len(o.Kids) == 0
+	return o.Tag == O_NIL && o.Size == 0 && o.Body.Len() == 0 && len(o.Kids) == 0 && len(o.Attrs) == 0
 }
 
 func (o *Op) IsInsertLeaf() bool {
@@ -85,6 +113,13 @@ func (o *Op) IsInsertLeaf() bool {
 	return o.Tag == O_INSERT && o.Size == 0 && o.Body.IsLeaf()
 }
 
+func (o *Op) IsInsertText() bool {
+	if o == nil {
+		return false
+	}
+	return o.Tag == O_INSERT && o.Size == 0 && o.Body.IsText()
+}
+
 func (o *Op) IsInsertBranch() bool {
 	if o == nil {
 		return false
@@ -106,6 +141,8 @@ func (o *Op) Len() int {
 	case o.IsWith():
 		// SUBTLE(mistone): W ops have tree-length 1, due to their interaction with D + R ops in compose1().
 		return 1
+	case o.IsMove(), o.IsPlace():
+		return 1
 	default:
 		panic(fmt.Sprintf("len got bad op, %s", o.String()))
 	}
@@ -117,14 +154,30 @@ func (o *Op) String() string {
 		return "N"
 	case o.IsDelete():
 		return fmt.Sprintf("D%d", -o.Size)
+	case o.IsRetain() && len(o.Attrs) > 0:
+		return fmt.Sprintf("R%d%s", o.Size, o.Attrs)
 	case o.IsRetain():
 		return fmt.Sprintf("R%d", o.Size)
+	case o.IsInsertText() && len(o.Body.Attrs) > 0:
+		return fmt.Sprintf("I%q%s", AsString(o.Body.Text), o.Body.Attrs)
+	case o.IsInsertText():
+		return fmt.Sprintf("I%q", AsString(o.Body.Text))
 	case o.IsInsert():
 		return fmt.Sprintf("I%s", o.Body.String())
 	case o.IsZero():
 		return "Z"
+	case o.IsWith() && len(o.Attrs) > 0:
+		return fmt.Sprintf("W%s%s", o.Kids, o.Attrs)
 	case o.IsWith():
 		return fmt.Sprintf("W%s", o.Kids)
+	case o.IsMove() && len(o.Attrs) > 0:
+		return fmt.Sprintf("M%d%s%s", o.Id, o.Kids, o.Attrs)
+	case o.IsMove() && len(o.Kids) > 0:
+		return fmt.Sprintf("M%d%s", o.Id, o.Kids)
+	case o.IsMove():
+		return fmt.Sprintf("M%d", o.Id)
+	case o.IsPlace():
+		return fmt.Sprintf("P%d", o.Id)
 	default:
 		return fmt.Sprintf("E%#v", o)
 	}
@@ -179,7 +232,7 @@ func (o Op) SplitAt(n int) (Op, Op, error) {
 		return o.splitDelete(n)
 	case o.IsRetain():
 		return o.splitRetain(n)
-	case o.IsWith():
+	case o.IsWith(), o.IsMove(), o.IsPlace():
 		return o.splitWith(n)
 	case o.IsZero():
 		return Z(), Z(), nil
@@ -189,8 +242,17 @@ func (o Op) SplitAt(n int) (Op, Op, error) {
 }
 
 func (o Op) splitInsert(n int) (Op, Op, error) {
-	l, r, err := o.Body.SplitAt(n)
-	return It(l), It(r), errors.Trace(err)
+	switch {
+	case o.IsInsertText():
+		l, r, err := o.Body.SplitAt(n)
+		return It(l), It(r), errors.Trace(err)
+	case n == 0:
+		return Z(), o, nil
+	case n == 1:
+		return o, Z(), nil
+	default:
+		return Z(), Z(), errors.Errorf("Op.splitInsert failed, o: %s, n: %d", o.String(), n)
+	}
 }
 
 func (o Op) splitRetain(n int) (Op, Op, error) {
@@ -198,7 +260,7 @@ func (o Op) splitRetain(n int) (Op, Op, error) {
 	if n > sz {
 		return Z(), Z(), errors.Errorf("Op.splitRetain failed, o: %s, n: %d", o.String(), n)
 	}
-	return R(n), R(sz - n), nil
+	return F(n, o.Attrs), F(sz-n, o.Attrs), nil
 }
 
 func (o Op) splitDelete(n int) (Op, Op, error) {
@@ -228,22 +290,22 @@ func (os Ops) SplitAt(n int) (Ops, Ops, error) {
 	return os[:n], os[n:], nil
 }
 
-// func (op *Op) extend(rhs Tree) {
-// 	switch {
-// 	// case op.Body.IsLeaf() && rhs.IsLeaf():
-// 	// 	lhs := op.Body.Body
-// 	// 	op.Body.Body = make([]rune, len(lhs)+rhs.Len())
-// 	// 	copy(op.Body.Body, lhs)
-// 	// 	copy(op.Body.Body[len(lhs):], rhs.Body)
-// 	case op.Body.IsBranch(): // && rhs.IsBranch():
-// 		lhs := op.Body.Kids
-// 		op.Body.Kids = make(Trees, len(lhs)+rhs.Len())
-// 		copy(op.Body.Kids, lhs)
-// 		copy(op.Body.Kids[len(lhs):], rhs.Kids)
-// 	default:
-// 		panic(errors.Errorf("extend error: op.Body.Tag != rhs.Tag, op: %s, t: %s", op.String(), rhs.String()))
-// 	}
-// }
+// extend appends the runes of rhs to op, which must insert a leaf or text run
+// with the same attributes as rhs.
+func (op *Op) extend(rhs Tree) {
+	lhs := op.Body.Runes()
+	rs := make([]rune, len(lhs)+rhs.Len())
+	copy(rs, lhs)
+	copy(rs[len(lhs):], rhs.Runes())
+	attrs := op.Body.Attrs
+	op.Body = text(rs)
+	op.Body.Attrs = attrs
+}
+
+// canExtend reports whether op inserts runes that t can be merged into.
+func (op *Op) canExtend(t Tree) bool {
+	return op.IsInsert() && op.Body.HasRunes() && t.HasRunes() && op.Body.Attrs.Equal(t.Attrs)
+}
 
 func (os *Ops) insertPenultimate(op Op) {
 	rhs := *os
@@ -272,27 +334,32 @@ func (os *Ops) Insert(t Tree) {
 	}
 
 	switch {
-	// case olen > 0 && os.Last().IsInsert() && os.Last().Body.Tag == t.Tag:
-	// 	ops.Last().extend(t)
+	case olen > 0 && os.Last().canExtend(t):
+		os.Last().extend(t)
 	case olen > 0 && os.Last().IsDelete():
-		// if olen > 1 && ops[olen-2].IsInsert() && ops[olen-2].Body.Tag == t.Tag {
-		// (&ops[olen-2]).extend(t)
-		// } else {
-		os.insertPenultimate(It(t))
-		// }
+		if olen > 1 && ops[olen-2].canExtend(t) {
+			(&ops[olen-2]).extend(t)
+		} else {
+			os.insertPenultimate(It(t))
+		}
 	default:
 		os.insertUltimate(It(t))
 	}
 }
 
 func (os *Ops) Retain(size int) {
+	os.Format(size, nil)
+}
+
+// Format appends a retain of size that patches the retained trees with attrs.
+func (os *Ops) Format(size int, attrs Attrs) {
 	switch {
 	case size == 0:
 		return
-	case len(*os) > 0 && os.Last().IsRetain():
+	case len(*os) > 0 && os.Last().IsRetain() && os.Last().Attrs.Equal(attrs):
 		os.Last().Size += size
 	default:
-		os.insertUltimate(R(size))
+		os.insertUltimate(F(size, attrs))
 	}
 }
 
@@ -315,3 +382,20 @@ func (os *Ops) Delete(size int) {
 func (os *Ops) With(kids Ops) {
 	os.insertUltimate(W(kids)) // BUG(mistone): should With() fold into previous With ops?
 }
+
+// FormatWith appends a With op that modifies kids and that patches the
+// modified tree with attrs.
+func (os *Ops) FormatWith(kids Ops, attrs Attrs) {
+	os.insertUltimate(Wf(kids, attrs))
+}
+
+// Move appends a move, identified by id, of the kid at the current position.
+func (os *Ops) Move(id int) {
+	os.insertUltimate(M(id))
+}
+
+// Place appends the place at which the kid moved by the move identified by
+// id lands.
+func (os *Ops) Place(id int) {
+	os.insertUltimate(P(id))
+}
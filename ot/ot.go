@@ -35,9 +35,12 @@ import (
 	"fmt"
 	"math/big"
 	"sync"
+	"time"
 	"unicode/utf8"
 
 	"github.com/juju/errors"
+
+	"github.com/mstone/focus/ot/textpos"
 )
 
 func CloneRunes(body []rune) []rune {
@@ -50,9 +53,9 @@ func CloneRunes(body []rune) []rune {
 }
 
 func AsRunes(s string) []rune {
-	rs := make([]rune, utf8.RuneCountInString(s))
-	for i, r := range s {
-		rs[i] = r
+	rs := make([]rune, 0, utf8.RuneCountInString(s))
+	for _, r := range s {
+		rs = append(rs, r)
 	}
 	return rs
 }
@@ -66,11 +69,7 @@ func AsString(rs []rune) string {
 }
 
 func AsRuneTree(s string) Tree {
-	kids := make(Trees, utf8.RuneCountInString(s))
-	for i, r := range s {
-		kids[i] = Leaf(r)
-	}
-	return Branch(kids)
+	return Branch(Trees{text(AsRunes(s))})
 }
 
 func min(a, b int) int {
@@ -85,6 +84,14 @@ func Apply(o Op, t *Tree) error {
 		return errors.Errorf("Apply failed; o: %s, t: %s", o.String(), t.String())
 	}
 
+	t.Attrs = t.Attrs.Apply(o.Attrs)
+
+	// take the kids that are moved before they are modified
+	mv, err := moved(o.Kids, t.Kids, true)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
 	tz := NewZipper(t, 0, 10)
 
 	for _, o := range o.Kids {
@@ -93,28 +100,30 @@ func Apply(o Op, t *Tree) error {
 			continue
 		case o.IsInsert():
 			tz.Insert(o.Body.Clone())
-			tz.Skip(1)
+			tz.Skip(o.Len())
+		case o.IsPlace():
+			k, ok := mv[o.Id]
+			if !ok {
+				return errors.Errorf("Apply failed, place without move; o: %s, t: %s", o.String(), t.String())
+			}
+			delete(mv, o.Id)
+			tz.Insert(k)
+			tz.Skip(k.Len())
+		case o.IsMove():
+			tz.Delete(1)
+		case o.IsRetain() && len(o.Attrs) > 0:
+			tz.Format(o.Len(), o.Attrs)
 		case o.IsRetain():
 			tz.Retain(o.Len())
 		case o.IsDelete():
 			tz.Delete(o.Len())
 		case o.IsWith():
-			// BUG(mistone): on a newly created zipper to a Branch(nil), tz.Current is nil
-			// because our zipper is an insertion-point, at index 0, to the left of cell
-			// #0 in t.Kids (bug?).
-			//
-			// However, as we advance down into t, we will create new zippers each time
-			// we descend (bug?). Therefore, for the purposes of applying a top-level With-op, we
-			// want to recurse on tz.Parent()
-			//
-			// However, assuming we're already in the middle of processing, current will correctly
-			// point to the branch to be modified.
-			//
-			// (Argh!)
+			// Current returns the parent when the caret is past the last
+			// kid, so check the caret before descending.
+			if !tz.CanSkip(0) {
+				return errors.Errorf("Apply failed, with past end; o: %s, t: %s", o.String(), t.String())
+			}
 			c := tz.Current()
-			// if c == nil {
-			// 	c = tz.Parent()
-			// }
 			err := Apply(o, c)
 			if err != nil {
 				return errors.Trace(err)
@@ -122,6 +131,9 @@ func Apply(o Op, t *Tree) error {
 			tz.Skip(1)
 		}
 	}
+	if len(mv) > 0 {
+		return errors.Errorf("Apply failed, move without place; o: %s, t: %s", o.String(), t.String())
+	}
 	return nil
 }
 
@@ -145,6 +157,10 @@ func shortenOp(o Op, nl int) (Op, error) {
 			return Z(), errors.Errorf("shorten fail; tried to split atomic leaf")
 		}
 		return o, nil
+	case o.IsInsertText():
+		attrs := o.Body.Attrs
+		o.Body = text(o.Body.Text[nl:])
+		o.Body.Attrs = attrs
 	case o.IsInsertBranch():
 		o.Body.Kids = o.Body.Kids[nl:]
 	case o.IsWith():
@@ -176,20 +192,6 @@ func shortenOps(a Op, b Op) (Op, Op, error) {
 	return Z(), Z(), errors.Errorf("ot.shortenOps() -- unreachable case, a: %s, b: %s", a, b)
 }
 
-func addDeleteOp(d Op, os Ops) Ops {
-	if len(os) > 0 && os.First().IsInsert() {
-		ret := Ops{}
-		ret = append(ret, os.First().Clone())
-		ret = append(ret, addDeleteOp(d, os.Rest())...)
-		return ret
-	} else {
-		ret := Ops{}
-		ret = append(ret, d)
-		ret = append(ret, os...)
-		return ret
-	}
-}
-
 func Compose(as, bs Ops) (Ops, error) {
 	cs, err := compose1(as, bs)
 	if err != nil {
@@ -198,115 +200,152 @@ func Compose(as, bs Ops) (Ops, error) {
 	return Normalize(cs)
 }
 
+// compose1 composes as and bs without normalizing the result.
+//
+// It walks both op lists with a cursor apiece. Ops that are only partly
+// consumed are replaced in place by their unconsumed suffixes, via
+// shortenOps. Deletes from as are held back until the next op that is not an
+// insert so that insertions run before them.
 func compose1(as, bs Ops) (Ops, error) {
-	ret := Ops{}
-	rest := Ops{}
-	hcs := Ops{}
-	oc := Op{}
-	var err error
-	var sa, sb Op
-
-	a := 0
-	b := 0
-	la := len(as)
-	lb := len(bs)
-
-	switch {
-	case a == la && b == lb:
-		break
-	case la > 0 && as[a].IsZero():
-		ret, err = compose1(as[a+1:], bs)
-	case lb > 0 && bs[b].IsZero():
-		ret, err = compose1(as, bs[b+1:])
-	case a == la:
-		ret = bs.Clone()
-	case b == lb:
-		ret = as.Clone()
-	case la > 0 && as[a].IsDelete():
-		// run insertions, then delete, then apply remaining effects
-		rest, err = compose1(as[a+1:], bs)
-		if err != nil {
-			break
-		}
-		ret = addDeleteOp(as[a].Clone(), rest)
-	case lb > 0 && bs[b].IsInsert():
-		// as[a] is insert, retain, or empty so insert then apply remaining effects
-		rest, err = compose1(as, bs[b+1:])
-		if err != nil {
-			break
-		}
-		ret = append(ret, bs[b].Clone())
-		ret = append(ret, rest...)
-	case la > 0 && lb > 0:
-		// do as much as we can, then recurse in a new hypothetical world
-		oa := as[a]
-		ob := bs[b]
-
-		sa, sb, err = shortenOps(oa, ob)
-		if err != nil {
-			break
+	// copy the lists so that partly-consumed ops can be replaced in place
+	as = append(Ops(nil), as...)
+	bs = append(Ops(nil), bs...)
+
+	// renumber the moves of bs so that their ids do not clash with those of
+	// as; the moves of as may be modified by bs once their places are reached
+	off := maxId(as)
+	for i := range bs {
+		if bs[i].IsMove() || bs[i].IsPlace() {
+			bs[i].Id += off
 		}
+	}
+	amoves := movesOf(as)
+	fs := fixes{}
 
-		has := Ops{}
-		has = append(has, sa)
-		has = append(has, as[a+1:]...)
+	ret := Ops{}
+	dels := Ops{}
+	emit := func(o Op) {
+		if !o.IsInsert() && len(dels) > 0 {
+			ret = append(ret, dels...)
+			dels = dels[:0]
+		}
+		ret = append(ret, o)
+	}
 
-		hbs := Ops{}
-		hbs = append(hbs, sb)
-		hbs = append(hbs, bs[b+1:]...)
+	a, b := 0, 0
+	la, lb := len(as), len(bs)
 
-		minlen := min(oa.Len(), ob.Len())
+	for a < la || b < lb {
 		switch {
-		case oa.IsRetain() && ob.IsRetain():
-			ret = append(ret, R(minlen))
-		case oa.IsRetain() && ob.IsDelete():
-			ret = append(ret, D(minlen))
-		case oa.IsRetain() && ob.IsWith():
-			ret = append(ret, ob.Clone())
-		case oa.IsInsert() && ob.IsRetain():
-			oc, err = shortenOp(oa, minlen)
-			if err != nil {
-				err = errors.Trace(err)
-				break
+		case a < la && as[a].IsZero():
+			a++
+		case b < lb && bs[b].IsZero():
+			b++
+		case a == la:
+			for ; b < lb; b++ {
+				emit(bs[b].Clone())
 			}
-			ret = append(ret, oc)
-		case oa.IsInsert() && ob.IsDelete():
-			// insertion then deletion cancels
-		case oa.IsInsert() && ob.IsWith():
-			ta := oa.Body.Clone()
-			err = Apply(ob, &ta)
-			if err != nil {
-				err = errors.Trace(err)
-				break
+		case b == lb:
+			for ; a < la; a++ {
+				emit(as[a].Clone())
 			}
-			ret = append(ret, It(ta))
-		case oa.IsWith() && ob.IsWith():
-			kc := Ops{}
-			kc, err = Compose(oa.Kids, ob.Kids)
+		case as[a].IsDelete(), as[a].IsMove():
+			// run insertions, then delete, then apply remaining effects
+			dels = append(dels, as[a].Clone())
+			a++
+		case bs[b].IsInsert(), bs[b].IsPlace():
+			// as[a] is insert, retain, or empty so insert then apply remaining effects
+			emit(bs[b].Clone())
+			b++
+		default:
+			// do as much as we can, then continue in a new hypothetical world
+			oa := as[a]
+			ob := bs[b]
+
+			sa, sb, err := shortenOps(oa, ob)
 			if err != nil {
-				err = errors.Trace(err)
-				break
+				return nil, errors.Trace(err)
 			}
-			ret = append(ret, W(kc))
-		case oa.IsWith() && ob.IsRetain():
-			ret = append(ret, oa.Clone())
-		case oa.IsWith() && ob.IsDelete():
-			ret = append(ret, D(minlen))
-		default:
-			err = errors.Errorf("compose1 error: impossible case\n\tas: %s\n\tbs: %s", as.String(), bs.String())
-		}
-		if err != nil {
-			break
-		}
 
-		hcs, err = compose1(has, hbs)
-		if err != nil {
-			break
-		}
+			minlen := min(oa.Len(), ob.Len())
+			switch {
+			case oa.IsRetain() && ob.IsRetain():
+				emit(F(minlen, oa.Attrs.Compose(ob.Attrs)))
+			case oa.IsRetain() && ob.IsDelete():
+				emit(D(minlen))
+			case oa.IsRetain() && ob.IsWith():
+				emit(Wf(ob.Kids.Clone(), oa.Attrs.Compose(ob.Attrs)))
+			case oa.IsInsert() && ob.IsRetain():
+				oc, _, err := oa.SplitAt(minlen)
+				if err != nil {
+					return nil, errors.Trace(err)
+				}
+				oc.Body = oc.Body.WithAttrs(oc.Body.Attrs.Apply(ob.Attrs))
+				emit(oc)
+			case oa.IsInsert() && ob.IsDelete():
+				// insertion then deletion cancels
+			case oa.IsInsert() && ob.IsWith():
+				oc, _, err := oa.SplitAt(minlen)
+				if err != nil {
+					return nil, errors.Trace(err)
+				}
+				ta := oc.Body.Clone()
+				if err := Apply(ob, &ta); err != nil {
+					return nil, errors.Trace(err)
+				}
+				emit(It(ta))
+			case oa.IsWith() && ob.IsWith():
+				kc, err := Compose(oa.Kids, ob.Kids)
+				if err != nil {
+					return nil, errors.Trace(err)
+				}
+				emit(Wf(kc, oa.Attrs.Compose(ob.Attrs)))
+			case oa.IsWith() && ob.IsRetain():
+				emit(Wf(oa.Kids.Clone(), oa.Attrs.Compose(ob.Attrs)))
+			case oa.IsWith() && ob.IsDelete():
+				emit(D(minlen))
+			case oa.IsPlace() && ob.IsDelete():
+				fs[moveKey{O_MOVE, oa.Id}] = Ops{D(1)}
+			case oa.IsPlace():
+				// ob modifies, and may move again, the kid moved by oa
+				am := amoves[oa.Id]
+				kc, err := composeKids(am.Kids, ob.Kids)
+				if err != nil {
+					return nil, errors.Trace(err)
+				}
+				fs[moveKey{O_MOVE, oa.Id}] = Ops{Mf(oa.Id, kc, am.Attrs.Compose(ob.Attrs))}
+				if ob.IsMove() {
+					fs[moveKey{O_PLACE, ob.Id}] = Ops{P(oa.Id)}
+				} else {
+					emit(oa.Clone())
+				}
+			case oa.IsInsert() && ob.IsMove():
+				oc, _, err := oa.SplitAt(minlen)
+				if err != nil {
+					return nil, errors.Trace(err)
+				}
+				k, err := moveKid(ob, oc.Body)
+				if err != nil {
+					return nil, errors.Trace(err)
+				}
+				fs[moveKey{O_PLACE, ob.Id}] = Ops{It(k)}
+			case ob.IsMove():
+				kc, err := composeKids(oa.Kids, ob.Kids)
+				if err != nil {
+					return nil, errors.Trace(err)
+				}
+				emit(Mf(ob.Id, kc, oa.Attrs.Compose(ob.Attrs)))
+			default:
+				return nil, errors.Errorf("compose1 error: impossible case\n\tas: %s\n\tbs: %s", as[a:].String(), bs[b:].String())
+			}
 
-		ret = append(ret, hcs...)
+			as[a] = sa
+			bs[b] = sb
+		}
 	}
-	return ret, errors.Trace(err)
+
+	ret = append(ret, dels...)
+	return fs.resolve(ret), nil
 }
 
 func ComposeAll(all []Ops) (Ops, error) {
@@ -321,7 +360,30 @@ func ComposeAll(all []Ops) (Ops, error) {
 	return ret, errors.Trace(err)
 }
 
+// Transform returns (as', bs') such that Compose(bs, as') == Compose(as, bs').
+//
+// When as and bs insert at the same position, as's inserts come first; see
+// TransformSites. When as and bs patch the same attribute of the same tree,
+// as wins; i.e., as is taken to be the later of the two writers.
 func Transform(as, bs Ops) (Ops, Ops, error) {
+	return transform(as, bs, true)
+}
+
+// TransformSites is like Transform but orders inserts at the same position
+// by the sites that wrote as and bs, rather than by the order of its
+// arguments: the inserts of the site that sorts first come first, so that
+// TransformSites(as, bs, sa, sb) and TransformSites(bs, as, sb, sa) agree on
+// the order of the inserted trees. When sa == sb, as's inserts come first.
+//
+// Sites are stable client ids, like the Site of a msg.Msg; the server and
+// every client must transform each pair of writes with the same sites.
+func TransformSites(as, bs Ops, sa, sb string) (Ops, Ops, error) {
+	return transform(as, bs, sa <= sb)
+}
+
+// transform implements Transform; the inserts of as come before those of bs
+// when aFirst is set.
+func transform(as, bs Ops, aFirst bool) (Ops, Ops, error) {
 	var r1, r2 Ops
 	var err error
 
@@ -329,7 +391,7 @@ func Transform(as, bs Ops) (Ops, Ops, error) {
 		return as.Clone(), bs.Clone(), nil
 	}
 
-	r1, r2, err = transform1(as, bs)
+	r1, r2, err = transform1(as, bs, aFirst)
 	if err != nil {
 		return nil, nil, errors.Trace(err)
 	}
@@ -346,111 +408,155 @@ func Transform(as, bs Ops) (Ops, Ops, error) {
 	return r1, r2, nil
 }
 
-func transform1(as, bs Ops) (Ops, Ops, error) {
-	a := 0
-	b := 0
-
-	var ra, rb, sa, sb Ops
-	var ta, tb Op
+// transform1 transforms as and bs without normalizing the results.
+//
+// Like compose1, it walks both op lists with a cursor apiece, replacing
+// partly-consumed ops in place by their unconsumed suffixes. Inserts and
+// places at the same position are emitted a's first when aFirst is set and
+// b's first otherwise.
+func transform1(as, bs Ops, aFirst bool) (Ops, Ops, error) {
+	// copy the lists so that partly-consumed ops can be replaced in place
+	as = append(Ops(nil), as...)
+	bs = append(Ops(nil), bs...)
 
-	la := len(as)
-	lb := len(bs)
+	var ret1, ret2 Ops
 
-	var err error
+	// the places of the moves of each side are emitted into the other side's
+	// result as placeholders, which are resolved into ops on the moved kid
+	// once the ops at the other end of the move have been reached.
+	fs1, fs2 := fixes{}, fixes{}
 
-	switch {
-	case a == la && b == lb:
-		break
-	case la > 0 && as.First().IsZero():
-		sa, sb, err = transform1(as[a+1:], bs)
-	case lb > 0 && bs.First().IsZero():
-		sa, sb, err = transform1(as, bs[b+1:])
-	case la > 0 && as.First().IsInsert():
-		oa := as.First()
-		ra.Insert(oa.Body)
-		rb.Retain(oa.Len())
-		sa, sb, err = transform1(as[a+1:], bs)
-		if err != nil {
-			err = errors.Trace(err)
-			break
-		}
-	case lb > 0 && bs.First().IsInsert():
-		ob := bs.First()
-		ra.Retain(ob.Len())
-		rb.Insert(ob.Body)
-		sa, sb, err = transform1(as, bs[b+1:])
-		if err != nil {
-			err = errors.Trace(err)
-			break
-		}
-	case la > 0 && lb > 0:
-		oa := as.First()
-		ob := bs.First()
-		minlen := min(oa.Len(), ob.Len())
-		ta, tb, err = shortenOps(*oa, *ob)
-		if err != nil {
-			err = errors.Trace(err)
-			break
-		}
+	a, b := 0, 0
+	la, lb := len(as), len(bs)
 
-		has := Ops{}
-		if !ta.IsZero() {
-			has = append(has, ta)
-		}
-		has = append(has, as[a+1:]...)
+	for a < la || b < lb {
+		var ra, rb Ops
 
-		hbs := Ops{}
-		if !tb.IsZero() {
-			hbs = append(hbs, tb)
-		}
-		hbs = append(hbs, bs[b+1:]...)
+		// of two sides that both insert here, only one may go next
+		aIns := a < la && (as[a].IsInsert() || as[a].IsPlace())
+		bIns := b < lb && (bs[b].IsInsert() || bs[b].IsPlace())
+		aNext := aIns && (aFirst || !bIns)
 
 		switch {
-		case oa.IsRetain() && ob.IsRetain():
-			ra.Retain(minlen)
-			rb.Retain(minlen)
-		case oa.IsWith() && ob.IsWith():
-			var ka, kb Ops
-			ka, kb, err = Transform(oa.Kids, ob.Kids)
+		case a < la && as[a].IsZero():
+			a++
+		case b < lb && bs[b].IsZero():
+			b++
+		case aNext && as[a].IsInsert():
+			oa := &as[a]
+			ra.Insert(oa.Body)
+			rb.Retain(oa.Len())
+			a++
+		case aNext && as[a].IsPlace():
+			ra = Ops{as[a]}
+			rb = Ops{placeholder(as[a].Id)}
+			a++
+		case bIns && bs[b].IsInsert():
+			ob := &bs[b]
+			ra.Retain(ob.Len())
+			rb.Insert(ob.Body)
+			b++
+		case bIns && bs[b].IsPlace():
+			ra = Ops{placeholder(bs[b].Id)}
+			rb = Ops{bs[b]}
+			b++
+		case a < la && b < lb:
+			oa := &as[a]
+			ob := &bs[b]
+			minlen := min(oa.Len(), ob.Len())
+			ta, tb, err := shortenOps(*oa, *ob)
 			if err != nil {
-				err = errors.Trace(err)
-				break
+				return nil, nil, errors.Annotatef(err, "transform failed, as: %s, bs: %s", as[a:].String(), bs[b:].String())
 			}
-			ra.With(ka)
-			rb.With(kb)
-		case oa.IsRetain() && ob.IsWith():
-			ra.With(ob.Kids)
-		case oa.IsWith() && ob.IsRetain():
-			rb.With(oa.Kids)
-		case oa.IsDelete() && ob.IsDelete():
-			// No action required; both sides have already D(minlen)
-		case oa.IsDelete() && ob.IsRetain():
-			fallthrough
-		case oa.IsDelete() && ob.IsWith():
-			ra.Delete(minlen)
-		case oa.IsRetain() && ob.IsDelete():
-			fallthrough
-		case oa.IsWith() && ob.IsDelete():
-			rb.Delete(minlen)
-		}
-		sa, sb, err = transform1(has, hbs)
-		if err != nil {
-			err = errors.Trace(err)
-			break
-		}
-	default:
-		err = errors.Errorf("transform failed, as: %s, bs: %s", as.String(), bs.String())
-		if err != nil {
-			break
+
+			switch {
+			case oa.IsRetain() && ob.IsRetain():
+				ra.Format(minlen, oa.Attrs)
+				rb.Format(minlen, oa.Attrs.Transform(ob.Attrs))
+			case oa.IsWith() && ob.IsWith():
+				ka, kb, err := transform(oa.Kids, ob.Kids, aFirst)
+				if err != nil {
+					return nil, nil, errors.Annotatef(err, "transform failed, as: %s, bs: %s", as[a:].String(), bs[b:].String())
+				}
+				ra.FormatWith(ka, oa.Attrs)
+				rb.FormatWith(kb, oa.Attrs.Transform(ob.Attrs))
+			case oa.IsRetain() && ob.IsWith():
+				ra.Format(minlen, oa.Attrs)
+				rb.FormatWith(ob.Kids, oa.Attrs.Transform(ob.Attrs))
+			case oa.IsWith() && ob.IsRetain():
+				ra.FormatWith(oa.Kids, oa.Attrs)
+				rb.Format(minlen, oa.Attrs.Transform(ob.Attrs))
+			case oa.IsDelete() && ob.IsDelete():
+				// No action required; both sides have already D(minlen)
+			case oa.IsDelete() && ob.IsRetain():
+				fallthrough
+			case oa.IsDelete() && ob.IsWith():
+				ra.Delete(minlen)
+			case oa.IsRetain() && ob.IsDelete():
+				fallthrough
+			case oa.IsWith() && ob.IsDelete():
+				rb.Delete(minlen)
+			case oa.IsMove() || ob.IsMove():
+				if err := transformMove(*oa, *ob, aFirst, &ra, &rb, fs1, fs2); err != nil {
+					return nil, nil, errors.Annotatef(err, "transform failed, as: %s, bs: %s", as[a:].String(), bs[b:].String())
+				}
+			}
+
+			// continue with the unconsumed suffixes, if any
+			if ta.IsZero() {
+				a++
+			} else {
+				as[a] = ta
+			}
+			if tb.IsZero() {
+				b++
+			} else {
+				bs[b] = tb
+			}
+		default:
+			return nil, nil, errors.Errorf("transform failed, as: %s, bs: %s", as[a:].String(), bs[b:].String())
 		}
+
+		ret1 = append(ret1, ra...)
+		ret2 = append(ret2, rb...)
 	}
 
-	ret1 := append(ra, sa...)
-	ret2 := append(rb, sb...)
+	return fs1.resolve(ret1), fs2.resolve(ret2), nil
+}
+
+// transformMove transforms oa and ob, at least one of which is a move, and
+// which apply to the same kid. As for attributes, oa wins conflicts: of two
+// moves of the same kid, only oa's takes effect.
+//
+// ra and rb receive the ops to emit at the kid's current position; fs1 and fs2
+// receive the ops that the placeholders for the kid and the places of moves
+// that no longer apply resolve to.
+func transformMove(oa, ob Op, aFirst bool, ra, rb *Ops, fs1, fs2 fixes) error {
+	ka, kb, err := transformKids(oa.Kids, ob.Kids, aFirst)
 	if err != nil {
-		err = errors.Annotatef(err, "transform failed, as: %s, bs: %s", as.String(), bs.String())
+		return errors.Trace(err)
+	}
+	attrs := oa.Attrs.Transform(ob.Attrs)
+
+	switch {
+	case oa.IsMove() && ob.IsDelete():
+		fs1[moveKey{O_PLACE, oa.Id}] = nil
+		fs2[moveKey{O_PLACE, -oa.Id}] = Ops{D(1)}
+	case oa.IsMove() && ob.IsMove():
+		fs1[moveKey{O_PLACE, -ob.Id}] = Ops{Mf(oa.Id, ka, oa.Attrs)}
+		fs2[moveKey{O_PLACE, -oa.Id}] = Ops{editKid(kb, attrs)}
+		fs2[moveKey{O_PLACE, ob.Id}] = nil
+	case oa.IsMove():
+		*ra = append(*ra, Mf(oa.Id, ka, oa.Attrs))
+		fs2[moveKey{O_PLACE, -oa.Id}] = Ops{editKid(kb, attrs)}
+	case oa.IsDelete():
+		fs1[moveKey{O_PLACE, -ob.Id}] = Ops{D(1)}
+		fs2[moveKey{O_PLACE, ob.Id}] = nil
+	default:
+		fs1[moveKey{O_PLACE, -ob.Id}] = Ops{editKid(ka, oa.Attrs)}
+		*rb = append(*rb, Mf(ob.Id, kb, attrs))
 	}
-	return ret1, ret2, errors.Trace(err)
+	return nil
 }
 
 func Normalize(os Ops) (Ops, error) {
@@ -466,36 +572,130 @@ func Normalize(os Ops) (Ops, error) {
 		}
 	}
 
-	ret2 := Ops{}
+	nb := normalizer{ops: make(Ops, 0, len(ret))}
 	for _, o := range ret {
 		switch {
 		case o.IsZero():
 			continue
 		case o.IsInsert():
-			ret2.Insert(o.Body)
+			nb.insert(o.Body)
 		case o.IsDelete():
-			ret2.Delete(o.Size)
+			nb.delete(o.Size)
 		case o.IsRetain():
-			ret2.Retain(o.Size)
+			nb.format(o.Size, o.Attrs)
 		case o.IsWith():
-			ret2.With(o.Kids)
+			nb.ops = append(nb.ops, Wf(o.Kids, o.Attrs))
+		case o.IsMove(), o.IsPlace():
+			nb.ops = append(nb.ops, o)
 		default:
 			return nil, errors.Errorf("normalize got bad op: %s", o.String())
 		}
 	}
 
-	return ret2, nil
+	ret = nb.finish()
+	if collapse(ret) {
+		return Normalize(ret)
+	}
+	renumber(ret)
+	return ret, nil
 }
 
+// normalizer builds ops exactly like the Ops builders do, but in amortized
+// constant time per op: it appends to its list in place and grows the text
+// of the insert that it is extending in a buffer that it owns.
+type normalizer struct {
+	ops Ops
+	buf *rune // first rune of the owned buffer, if any
+}
+
+func (nb *normalizer) insert(t Tree) {
+	ops := nb.ops
+	n := len(ops)
+
+	if t.Len() == 0 {
+		return
+	}
+
+	switch {
+	case n > 0 && ops[n-1].canExtend(t):
+		nb.extend(&ops[n-1], t)
+	case n > 0 && ops[n-1].IsDelete():
+		if n > 1 && ops[n-2].canExtend(t) {
+			nb.extend(&ops[n-2], t)
+		} else {
+			nb.ops = append(ops, ops[n-1])
+			nb.ops[n-1] = It(t)
+		}
+	default:
+		nb.ops = append(ops, It(t))
+	}
+}
+
+func (nb *normalizer) extend(op *Op, t Tree) {
+	rs := op.Body.Runes()
+	if &rs[0] != nb.buf {
+		buf := make([]rune, len(rs), 2*(len(rs)+t.Len()))
+		copy(buf, rs)
+		rs = buf
+	}
+	rs = append(rs, t.Runes()...)
+	nb.buf = &rs[0]
+	attrs := op.Body.Attrs
+	op.Body = Tree{Tag: T_TEXT, Text: rs, Attrs: attrs}
+}
+
+func (nb *normalizer) format(size int, attrs Attrs) {
+	n := len(nb.ops)
+	switch {
+	case size == 0:
+		return
+	case n > 0 && nb.ops[n-1].IsRetain() && nb.ops[n-1].Attrs.Equal(attrs):
+		nb.ops[n-1].Size += size
+	default:
+		nb.ops = append(nb.ops, F(size, attrs))
+	}
+}
+
+func (nb *normalizer) delete(size int) {
+	n := len(nb.ops)
+	if size == 0 {
+		return
+	}
+	if size > 0 {
+		size = -size
+	}
+	if n > 0 && nb.ops[n-1].IsDelete() {
+		nb.ops[n-1].Size += size
+	} else {
+		nb.ops = append(nb.ops, D(size))
+	}
+}
+
+// finish returns the built ops, trimming the capacity of text runs so that
+// they can be shared like any other.
+func (nb *normalizer) finish() Ops {
+	for i := range nb.ops {
+		if b := &nb.ops[i].Body; b.IsText() {
+			b.Text = b.Text[:len(b.Text):len(b.Text)]
+		}
+	}
+	return nb.ops
+}
+
+// Doc is a document body that can be edited by applying ops.
+//
+// The body is kept in a persistent rope so that Apply costs time proportional
+// to the size of the edit (times log of the size of the document) and so that
+// Snapshot is O(1).
 type Doc struct {
 	mu sync.Mutex
-	// Current text
-	body Tree
+	// Current kids of the document's root branch
+	body *rope
 }
 
 func NewDoc() *Doc {
 	d := new(Doc)
-	d.body = Branch(nil)
+	d.body = nil
 	return d
 	// return &Doc{
 	// 	mu:   sync.Mutex{},
@@ -503,11 +703,48 @@ func NewDoc() *Doc {
 	// }
 }
 
+// Snapshot returns a copy of d that shares d's current body but that can be
+// edited independently of d.
+func (d *Doc) Snapshot() *Doc {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return &Doc{
+		body: d.body,
+	}
+}
+
 func (d *Doc) Len() int {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	return len(d.body.Kids)
+	return d.body.Len()
+}
+
+// Prefix returns the counts of the longest prefix of d's current body whose
+// measure in unit u is at most n, so that d is a textpos.Text. Leaves count as
+// their runes and branches as single UTF-16 code units.
+func (d *Doc) Prefix(u textpos.Unit, n int) textpos.Counts {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.body.prefix(u, n)
+}
+
+// Counts returns the counts of d's current body.
+func (d *Doc) Counts() textpos.Counts {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.body.Counts()
+}
+
+// Body returns a copy of d's current body as a branch.
+func (d *Doc) Body() Tree {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return Branch(d.body.Trees())
 }
 
 func (d *Doc) String() string {
@@ -526,10 +763,9 @@ func (d *Doc) Apply(os Ops) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	body := d.body.Clone()
 	// alert.String(fmt.Sprintf("Apply: ops: %s, body: %+v", os.String(), body))
 
-	err := Apply(W(os), &body)
+	body, err := d.body.apply(os)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -537,6 +773,22 @@ func (d *Doc) Apply(os Ops) error {
 	return nil
 }
 
+// Invert returns the inverse of os with respect to d's current body.
+func (d *Doc) Invert(os Ops) (Ops, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return invert(os, d.body)
+}
+
+// Validate checks that os can be applied to d's current body; see Validate.
+func (d *Doc) Validate(os Ops) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return validate(os, d.body)
+}
+
 func RandIntn(n int) int {
 	b, _ := rand.Int(rand.Reader, big.NewInt(int64(n)))
 	return int(b.Int64())
@@ -582,16 +834,12 @@ func C(os ...Ops) Ops {
 	return ret
 }
 
-// Is converts s into a slice of rune leaf insertion ops
+// Is converts s into an op slice containing a single text insertion op
 func Is(s string) Ops {
 	if len(s) == 0 {
 		return nil
 	}
-	os := make(Ops, utf8.RuneCountInString(s))
-	for i, r := range s {
-		os[i] = Op{Tag: O_INSERT, Body: Leaf(r)}
-	}
-	return os
+	return Ops{It(text(AsRunes(s)))}
 }
 
 // Ic converts r into a single leaf insertion op
@@ -599,16 +847,12 @@ func Ic(r rune) Op {
 	return Op{Tag: O_INSERT, Body: Leaf(r)}
 }
 
-// Ir converts rs into a slice of rune leaf insertion ops
+// Ir converts rs into an op slice containing a single text insertion op
 func Ir(rs []rune) Ops {
 	if len(rs) == 0 {
 		return nil
 	}
-	os := make(Ops, len(rs))
-	for i, r := range rs {
-		os[i] = Op{Tag: O_INSERT, Body: Leaf(r)}
-	}
-	return os
+	return Ops{It(Text(rs))}
 }
 
 // It creates an insertion op based on t
@@ -632,6 +876,29 @@ func Rs(n int) Ops {
 	return Ops{R(n)}
 }
 
+// F creates a retain op that patches the retained trees with attrs
+func F(n int, attrs Attrs) Op {
+	o := R(n)
+	if o.IsRetain() && len(attrs) > 0 {
+		o.Attrs = attrs
+	}
+	return o
+}
+
+// Fs creates an op slice containing a single formatting retain op
+func Fs(n int, attrs Attrs) Ops {
+	return Ops{F(n, attrs)}
+}
+
+// Ia converts s into an op slice containing a single insertion op for a text
+// run with attributes attrs
+func Ia(s string, attrs Attrs) Ops {
+	if len(s) == 0 {
+		return nil
+	}
+	return Ops{It(text(AsRunes(s)).WithAttrs(attrs))}
+}
+
 // D creates a delete op
 func D(n int) Op {
 	if n == 0 {
@@ -658,6 +925,16 @@ func Ws(kids Ops) Ops {
 	return Ops{W(kids)}
 }
 
+// Wf returns a With op wrapping kids that also patches the modified tree with
+// attrs
+func Wf(kids Ops, attrs Attrs) Op {
+	o := W(kids)
+	if len(attrs) > 0 {
+		o.Attrs = attrs
+	}
+	return o
+}
+
 // Z returns a nil op
 func Z() Op {
 	return Op{Tag: O_NIL}
@@ -668,6 +945,30 @@ func Zs() Ops {
 	return Ops{Z()}
 }
 
+// M returns a move, identified by id, of the kid at its position
+func M(id int) Op {
+	return Op{Tag: O_MOVE, Id: id}
+}
+
+// Mf returns a move, identified by id, that also modifies the moved kid like
+// Wf(kids, attrs)
+func Mf(id int, kids Ops, attrs Attrs) Op {
+	o := M(id)
+	if len(kids) > 0 {
+		o.Kids = kids
+	}
+	if len(attrs) > 0 {
+		o.Attrs = attrs
+	}
+	return o
+}
+
+// P returns the place at which the kid moved by the move identified by id
+// lands
+func P(id int) Op {
+	return Op{Tag: O_PLACE, Id: id}
+}
+
 func NewInsert(docLen int, pos int, s string) Ops {
 	if pos < 0 || pos > docLen+1 {
 		panic(errors.Errorf("bad position; insert is out of range; pos: %d, s: %q", pos, s))
@@ -689,16 +990,41 @@ func NewDelete(docLen int, pos int, length int) Ops {
 	return Ops{R(pos), D(length), R(docLen - length - pos)}
 }
 
+// NewMove returns ops that move the kid at position from of a branch with
+// docLen positions so that it ends up at position to.
+func NewMove(docLen int, from int, to int) Ops {
+	if from < 0 || from >= docLen || to < 0 || to >= docLen {
+		panic(errors.Errorf("bad position; move is out of range: from: %d, to: %d, len: %d", from, to, docLen))
+	}
+
+	switch {
+	case from < to:
+		return Ops{R(from), M(1), R(to - from), P(1), R(docLen - to - 1)}
+	case from > to:
+		return Ops{R(to), P(1), R(from - to), M(1), R(docLen - from - 1)}
+	default:
+		return Rs(docLen)
+	}
+}
+
 type State int
 
 const (
 	CS_SYNCED State = iota
 	CS_WAIT_ONE
 	CS_WAIT_MANY
+	CS_BROKEN     // a write failed to apply; see Err and Recover
+	CS_RECOVERING // Recover was called; waiting for the reopened doc
+	CS_HOLDING    // holding client writes for the send window; see AttachWindow
 )
 
 type Sender interface {
-	Send(rev int, hash string, ops Ops)
+	// Send sends ops, which apply to the server doc at rev, to the server.
+	// hash is the Hash of the server doc at rev. seq numbers the client's
+	// writes from 1; resubmitted writes keep their seq so that, together
+	// with the client's site, the server can recognize writes that it has
+	// already applied.
+	Send(rev int, hash string, seq int, ops Ops)
 }
 
 type Receiver interface {
@@ -710,9 +1036,22 @@ type Controller struct {
 	conn      Sender
 	client    Receiver
 	first     Ops
-	rest      []Ops
+	rest      Ops // the composition of the client writes queued behind first
 	serverRev int
 	serverDoc *Doc
+	// serverHash is the Hash of serverDoc at hashRev, if known; see hash.
+	serverHash string
+	hashRev    int
+	clientDoc  *Doc
+	undo       []Ops
+	redo       []Ops
+	err        error // the error that broke the controller, if any
+	base       *Doc  // the last good server doc, while recovering
+	store      StateStore
+	site       string // the client's site; see TransformSites
+	seq        int    // the seq of the last write sent; see Sender
+	window     time.Duration
+	wake       func(d time.Duration)
 }
 
 func (c *Controller) String() string {
@@ -728,94 +1067,394 @@ func NewController(sender Sender, receiver Receiver) *Controller {
 		rest:      nil,
 		serverRev: 0,
 		serverDoc: NewDoc(),
+		clientDoc: NewDoc(),
+		undo:      nil,
+		redo:      nil,
 	}
 }
 
-func (c *Controller) OnClientWrite(ops Ops) {
-	ops, err := Normalize(ops.Clone())
+// AttachSite sets the stable id of c's client, which orders the client's
+// inserts against concurrent inserts by other sites; see TransformSites. The
+// client must send the same site to the server.
+func (c *Controller) AttachSite(site string) {
+	c.site = site
+}
+
+func (c *Controller) Site() string {
+	return c.site
+}
+
+// AttachWindow makes c hold client writes made while no write is pending for
+// d before sending them, composed, as a single write, so that a burst of
+// keystrokes costs one round trip instead of two. When c starts holding, it
+// calls wake(d), which must arrange for Flush to be called once d has passed
+// from the goroutine that drives c. A window of 0, the default, sends writes
+// at once.
+func (c *Controller) AttachWindow(d time.Duration, wake func(d time.Duration)) {
+	c.window = d
+	c.wake = wake
+}
+
+// Flush sends the client writes held for the send window, if any.
+func (c *Controller) Flush() (err error) {
+	if c.state != CS_HOLDING {
+		return nil
+	}
+	defer c.saved(&err)
+
+	c.sendFirst()
+	c.state = CS_WAIT_ONE
+	return nil
+}
+
+// sendFirst sends c.first to the server as a new write.
+func (c *Controller) sendFirst() {
+	c.seq++
+	c.conn.Send(c.serverRev, c.hash(), c.seq, c.first)
+}
+
+// Seq returns the seq of c's pending write, or 0 if no write is pending.
+// Clients send it when they reopen the doc so that the server can tell them
+// whether it applied the write; see Resubmit.
+func (c *Controller) Seq() int {
+	switch c.state {
+	case CS_WAIT_ONE, CS_WAIT_MANY:
+		return c.seq
+	}
+	return 0
+}
+
+// fail moves c to CS_BROKEN and returns err. Until Recover is called, c
+// tracks client writes but neither sends them nor accepts server messages.
+func (c *Controller) fail(err error) error {
+	c.state = CS_BROKEN
+	c.err = err
+	return err
+}
+
+// saved saves c's state once a Controller method that returns *err has
+// changed it. Errors from the store are reported in *err unless the method
+// itself failed.
+func (c *Controller) saved(err *error) {
+	if serr := c.save(); *err == nil {
+		*err = serr
+	}
+}
+
+// broken reports whether c is waiting to be recovered.
+func (c *Controller) broken() bool {
+	return c.state == CS_BROKEN || c.state == CS_RECOVERING
+}
+
+// OnClientWrite records ops, which the client has already applied locally,
+// on the undo stack and sends them to the server.
+func (c *Controller) OnClientWrite(ops Ops) (err error) {
+	defer c.saved(&err)
+
+	ops, err = Normalize(ops.Clone())
+	if err != nil {
+		return c.fail(errors.Trace(err))
+	}
+	if c.broken() {
+		// keep tracking the client so that Recover can replay its edits
+		if err := c.clientDoc.Apply(ops); err != nil {
+			return c.fail(errors.Trace(err))
+		}
+		return nil
+	}
+	inv, err := c.clientDoc.Invert(ops)
 	if err != nil {
-		panic(err)
+		return c.fail(errors.Trace(err))
 	}
+	c.undo = append(c.undo, inv)
+	c.redo = nil
+	return errors.Trace(c.write(ops))
+}
+
+// Undo reverts the most recent client write that has not yet been undone, as
+// rebased over any intervening server writes. The reverting ops are delivered
+// to the client via Recv() and are sent to the server like any other write.
+func (c *Controller) Undo() (err error) {
+	if len(c.undo) == 0 || c.broken() {
+		return nil
+	}
+	defer c.saved(&err)
+
+	ops := c.undo[len(c.undo)-1]
+	c.undo = c.undo[:len(c.undo)-1]
+	inv, err := c.clientDoc.Invert(ops)
+	if err != nil {
+		return c.fail(errors.Trace(err))
+	}
+	c.redo = append(c.redo, inv)
+	c.client.Recv(ops.Clone())
+	return errors.Trace(c.write(ops))
+}
+
+// Redo reapplies the most recently undone client write.
+func (c *Controller) Redo() (err error) {
+	if len(c.redo) == 0 || c.broken() {
+		return nil
+	}
+	defer c.saved(&err)
+
+	ops := c.redo[len(c.redo)-1]
+	c.redo = c.redo[:len(c.redo)-1]
+	inv, err := c.clientDoc.Invert(ops)
+	if err != nil {
+		return c.fail(errors.Trace(err))
+	}
+	c.undo = append(c.undo, inv)
+	c.client.Recv(ops.Clone())
+	return errors.Trace(c.write(ops))
+}
+
+func (c *Controller) CanUndo() bool {
+	return len(c.undo) > 0
+}
+
+func (c *Controller) CanRedo() bool {
+	return len(c.redo) > 0
+}
+
+// write applies ops to the client doc and sends or queues them for the server.
+func (c *Controller) write(ops Ops) error {
+	err := c.clientDoc.Apply(ops)
+	if err != nil {
+		return c.fail(errors.Trace(err))
+	}
+	return errors.Trace(c.send(ops))
+}
+
+// send sends, holds, or queues ops, which have been applied to the client
+// doc, for the server. Held and queued writes are composed as they arrive so
+// that rebasing them over server writes costs the same however many there
+// are.
+func (c *Controller) send(ops Ops) error {
+	var err error
 	switch c.state {
 	case CS_SYNCED:
 		c.first = ops
-		c.conn.Send(c.serverRev, c.serverDoc.String(), ops)
+		if c.window > 0 {
+			c.state = CS_HOLDING
+			if c.wake != nil {
+				c.wake(c.window)
+			}
+			return nil
+		}
+		c.sendFirst()
 		c.state = CS_WAIT_ONE
+	case CS_HOLDING:
+		if c.first, err = Compose(c.first, ops); err != nil {
+			return c.fail(errors.Annotatef(err, "hold failed, compose failed"))
+		}
 	case CS_WAIT_ONE:
-		c.rest = []Ops{ops}
+		c.rest = ops
 		c.state = CS_WAIT_MANY
 	case CS_WAIT_MANY:
-		c.rest = append(c.rest, ops)
+		if c.rest, err = Compose(c.rest, ops); err != nil {
+			return c.fail(errors.Annotatef(err, "queue failed, compose failed"))
+		}
 	}
+	return nil
+}
+
+// recv delivers ops to the client, rebasing the undo and redo stacks over them.
+func (c *Controller) recv(ops Ops) error {
+	err := c.clientDoc.Apply(ops)
+	if err != nil {
+		return c.fail(errors.Trace(err))
+	}
+	c.undo, err = rebaseStack(c.undo, ops)
+	if err != nil {
+		return c.fail(errors.Trace(err))
+	}
+	c.redo, err = rebaseStack(c.redo, ops)
+	if err != nil {
+		return c.fail(errors.Trace(err))
+	}
+	c.client.Recv(ops)
+	return nil
 }
 
-func (c *Controller) OnServerAck(rev int, ops Ops) {
+// rebaseStack transforms each entry of an undo or redo stack, top first,
+// so that the stack applies to the document after ops.
+func rebaseStack(stack []Ops, ops Ops) ([]Ops, error) {
+	var err error
+	for i := len(stack) - 1; i >= 0; i-- {
+		stack[i], ops, err = Transform(stack[i], ops)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return stack, nil
+}
+
+// OnServerAck records the server's acceptance, at rev, of the pending client
+// write, as rebased into ops. Acks received while c is broken are ignored.
+func (c *Controller) OnServerAck(rev int, ops Ops) (err error) {
+	if c.broken() {
+		return nil
+	}
+	defer c.saved(&err)
+
+	if c.state == CS_SYNCED || c.state == CS_HOLDING {
+		return c.fail(errors.Errorf("bad ack, no write pending; rev: %d, ops: %s", rev, ops.String()))
+	}
+	if err := c.serverDoc.Apply(ops); err != nil {
+		return c.fail(errors.Annotatef(err, "bad ack, apply failed; rev: %d", rev))
+	}
+	c.serverRev = rev
 	switch c.state {
-	case CS_SYNCED:
-		panic("bad ack")
 	case CS_WAIT_ONE:
-		c.serverRev = rev
-		c.serverDoc.Apply(ops)
 		c.first = nil
 		c.state = CS_SYNCED
 	case CS_WAIT_MANY:
-		c.serverRev = rev
-		c.serverDoc.Apply(ops)
-		cs, err := ComposeAll(c.rest)
-		if err != nil {
-			panic("bad ack, compose failed")
-		}
-		c.first, err = Normalize(cs)
+		c.first, err = Normalize(c.rest)
 		if err != nil {
-			panic("bad ack, normalize failed")
+			return c.fail(errors.Annotatef(err, "bad ack, normalize failed"))
 		}
 		c.rest = nil
-		c.conn.Send(c.serverRev, c.serverDoc.String(), c.first)
+		c.sendFirst()
 		c.state = CS_WAIT_ONE
 	}
+	return nil
 }
 
-func (c *Controller) OnServerWrite(rev int, ops Ops) {
-	err := c.serverDoc.Apply(ops)
+// OnServerWrite applies ops, which the client with the given site wrote at
+// rev, rebasing any pending client writes over them. Writes received while c
+// is broken are ignored; the first write received while c is recovering
+// completes recovery.
+func (c *Controller) OnServerWrite(rev int, site string, ops Ops) (err error) {
+	if c.state == CS_BROKEN {
+		return nil
+	}
+	defer c.saved(&err)
+
+	if c.state == CS_RECOVERING {
+		return errors.Trace(c.reopen(rev, ops))
+	}
+	err = c.serverDoc.Apply(ops)
 	if err != nil {
-		panic(err)
+		return c.fail(errors.Annotatef(err, "bad write, apply failed; rev: %d", rev))
 	}
+	c.serverRev = rev
 	switch c.state {
 	case CS_SYNCED:
-		c.serverRev = rev
-		c.client.Recv(ops)
-	case CS_WAIT_ONE:
-		c.serverRev = rev
-		first2, ops2, err := Transform(c.first, ops)
+		return errors.Trace(c.recv(ops))
+	case CS_WAIT_ONE, CS_HOLDING:
+		first2, ops2, err := TransformSites(c.first, ops, c.site, site)
 		if err != nil {
-			panic("bad write, transform failed in CS_WAIT_ONE")
+			return c.fail(errors.Annotatef(err, "bad write, transform failed in state %d", c.state))
 		}
 		c.first = first2
-		c.client.Recv(ops2)
+		return errors.Trace(c.recv(ops2))
 	case CS_WAIT_MANY:
-		c.serverRev = rev
-		first2, ops2, err := Transform(c.first, ops)
-		if err != nil {
-			panic("bad write, transform failed in CS_WAIT_MANY, pt 1")
-		}
-		cs, err := ComposeAll(c.rest)
+		first2, ops2, err := TransformSites(c.first, ops, c.site, site)
 		if err != nil {
-			panic("bad write, compose failed")
+			return c.fail(errors.Annotatef(err, "bad write, transform failed in CS_WAIT_MANY, pt 1"))
 		}
-		rest2, ops3, err := Transform(cs, ops2)
+		rest2, ops3, err := TransformSites(c.rest, ops2, c.site, site)
 		if err != nil {
-			panic("bad write, transform failed in CS_WAIT_MANY, pt 2")
+			return c.fail(errors.Annotatef(err, "bad write, transform failed in CS_WAIT_MANY, pt 2"))
 		}
 		c.first = first2
-		c.rest = []Ops{rest2}
-		c.client.Recv(ops3)
+		c.rest = rest2
+		return errors.Trace(c.recv(ops3))
+	}
+	return nil
+}
+
+// hash returns the Hash of the server doc, which it computes at most once
+// per server rev rather than once per send.
+func (c *Controller) hash() string {
+	if c.serverHash == "" || c.hashRev != c.serverRev {
+		c.serverHash = c.serverDoc.Hash()
+		c.hashRev = c.serverRev
+	}
+	return c.serverHash
+}
+
+// Recover starts recovering c, whether or not it is broken, by forgetting
+// the server doc and its revision. The caller must then reopen the doc at
+// ServerRev() so that the server sends the whole doc as a single write.
+//
+// On receipt of that write, c replays the client's edits since the last
+// server doc that c applied, taken as a Diff of the client doc, on top of
+// the reopened doc: the client receives the other clients' edits via Recv()
+// and the server receives the client's. Since edits that were sent but not
+// acked before c broke may be replayed, recovery is best-effort. The undo
+// and redo stacks are cleared. Recovering a controller that is already
+// recovering, or that broke while reopening, keeps the base that it is
+// recovering from rather than the empty server doc.
+func (c *Controller) Recover() {
+	if c.base == nil {
+		c.base = c.serverDoc
 	}
+	c.serverDoc = NewDoc()
+	c.serverRev = 0
+	c.serverHash = ""
+	c.first = nil
+	c.rest = nil
+	c.undo = nil
+	c.redo = nil
+	c.err = nil
+	c.state = CS_RECOVERING
 }
 
+// reopen completes recovery with ops, which build the server doc at rev.
+func (c *Controller) reopen(rev int, ops Ops) error {
+	if err := c.serverDoc.Apply(ops); err != nil {
+		return c.fail(errors.Annotatef(err, "bad reopen, apply failed; rev: %d", rev))
+	}
+	c.serverRev = rev
+
+	base := c.base.Body()
+	local := DiffTree(base, c.clientDoc.Body())[0].Kids
+	remote := DiffTree(base, c.serverDoc.Body())[0].Kids
+	// remote may combine the writes of many sites, but since local2 is sent
+	// against rev, nobody else transforms local against remote, so local may
+	// simply come first.
+	local2, remote2, err := Transform(local, remote)
+	if err != nil {
+		return c.fail(errors.Annotatef(err, "bad reopen, transform failed"))
+	}
+	c.base = nil
+	c.state = CS_SYNCED
+	if err := c.recv(remote2); err != nil {
+		return errors.Trace(err)
+	}
+	if !isIdentity(local2) {
+		return errors.Trace(c.send(local2))
+	}
+	return nil
+}
+
+// isIdentity reports whether ops leave the branch they apply to unchanged.
+func isIdentity(ops Ops) bool {
+	for _, o := range ops {
+		if !o.IsZero() && !(o.IsRetain() && len(o.Attrs) == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsSynchronized reports whether c has no client writes pending or held.
 func (c *Controller) IsSynchronized() bool {
 	return c.state == CS_SYNCED
 }
 
+// IsBroken reports whether c has failed and has not yet recovered.
+func (c *Controller) IsBroken() bool {
+	return c.broken()
+}
+
+// Err returns the error that broke c, if c is broken.
+func (c *Controller) Err() error {
+	return c.err
+}
+
 func (c *Controller) ServerRev() int {
 	return c.serverRev
 }
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/juju/errors"
 )
@@ -645,3 +646,248 @@ func TestDocApply(t *testing.T) {
 
 	doDocApplyTable(t, cases)
 }
+
+type sent struct {
+	Rev  int
+	Ops  Ops
+	Hash string
+	Seq  int
+}
+
+type testClient struct {
+	doc  *Doc
+	sent []sent
+}
+
+func (c *testClient) Send(rev int, hash string, seq int, ops Ops) {
+	c.sent = append(c.sent, sent{rev, ops.Clone(), hash, seq})
+}
+
+func (c *testClient) Recv(ops Ops) {
+	c.doc.Apply(ops)
+}
+
+func (c *testClient) write(st *Controller, ops Ops) {
+	c.doc.Apply(ops)
+	st.OnClientWrite(ops)
+}
+
+func (c *testClient) lastSent(t *testing.T) Ops {
+	if len(c.sent) == 0 {
+		t.Fatalf("expected a send")
+	}
+	return c.sent[len(c.sent)-1].Ops
+}
+
+func TestControllerUndo(t *testing.T) {
+	c := &testClient{doc: NewDoc()}
+	st := NewController(c, c)
+
+	// local "ab", acked at rev 1
+	c.write(st, NewInsert(0, 0, "ab"))
+	st.OnServerAck(1, NewInsert(0, 0, "ab"))
+
+	// concurrent remote insert of "x" at 0
+	st.OnServerWrite(2, "", NewInsert(2, 0, "x"))
+	if c.doc.String() != "[x a b]" {
+		t.Fatalf("expected [x a b], got %s", c.doc.String())
+	}
+
+	// undo removes only the local "ab"
+	st.Undo()
+	if c.doc.String() != "[x]" {
+		t.Fatalf("undo: expected [x], got %s", c.doc.String())
+	}
+	if !reflect.DeepEqual(c.lastSent(t), C(Rs(1), Ds(2))) {
+		t.Fatalf("undo: unexpected send %s", c.lastSent(t))
+	}
+	st.OnServerAck(3, c.lastSent(t))
+
+	// concurrent remote insert of "y" at the end
+	st.OnServerWrite(4, "", NewInsert(1, 1, "y"))
+
+	// redo reinserts "ab" after "x"
+	st.Redo()
+	if c.doc.String() != "[x a b y]" {
+		t.Fatalf("redo: expected [x a b y], got %s", c.doc.String())
+	}
+	if st.CanRedo() || !st.CanUndo() {
+		t.Fatalf("redo: unexpected stacks, undo: %t, redo: %t", st.CanUndo(), st.CanRedo())
+	}
+
+	// a fresh write clears the redo stack
+	st.Undo()
+	c.write(st, NewInsert(2, 2, "z"))
+	if st.CanRedo() {
+		t.Fatalf("write: expected empty redo stack")
+	}
+	if c.doc.String() != "[x y z]" {
+		t.Fatalf("write: expected [x y z], got %s", c.doc.String())
+	}
+}
+
+func TestControllerUndoUnacked(t *testing.T) {
+	c := &testClient{doc: NewDoc()}
+	st := NewController(c, c)
+
+	c.write(st, NewInsert(0, 0, "a"))
+	c.write(st, NewInsert(1, 1, "b"))
+
+	// remote write concurrent with both pending local writes
+	st.OnServerWrite(1, "", NewInsert(0, 0, "c"))
+	if c.doc.String() != "[a b c]" {
+		t.Fatalf("expected [a b c], got %s", c.doc.String())
+	}
+
+	st.Undo()
+	if c.doc.String() != "[a c]" {
+		t.Fatalf("undo 1: expected [a c], got %s", c.doc.String())
+	}
+	st.Undo()
+	if c.doc.String() != "[c]" {
+		t.Fatalf("undo 2: expected [c], got %s", c.doc.String())
+	}
+	st.Undo()
+	if c.doc.String() != "[c]" {
+		t.Fatalf("undo 3: expected [c], got %s", c.doc.String())
+	}
+}
+
+func TestControllerRecover(t *testing.T) {
+	c := &testClient{doc: NewDoc()}
+	st := NewController(c, c)
+
+	// local "ab", acked at rev 1
+	c.write(st, NewInsert(0, 0, "ab"))
+	if err := st.OnServerAck(1, NewInsert(0, 0, "ab")); err != nil {
+		t.Fatalf("ack failed, err: %q", err)
+	}
+
+	// an unexpected ack breaks the controller
+	if err := st.OnServerAck(2, Rs(2)); err == nil {
+		t.Fatalf("expected unexpected ack to fail")
+	}
+	if !st.IsBroken() || st.Err() == nil || st.IsSynchronized() {
+		t.Fatalf("expected broken controller, got: %s", st)
+	}
+
+	// broken controllers track local writes but neither send them nor
+	// accept server messages
+	sent := len(c.sent)
+	c.write(st, NewInsert(2, 2, "c"))
+	if len(c.sent) != sent {
+		t.Fatalf("broken controller sent %s", c.lastSent(t))
+	}
+	if err := st.OnServerWrite(3, "", NewInsert(2, 0, "z")); err != nil {
+		t.Fatalf("broken controller failed on write, err: %q", err)
+	}
+	if c.doc.String() != "[a b c]" {
+		t.Fatalf("expected [a b c], got %s", c.doc.String())
+	}
+
+	// reopening at rev 0 yields the whole doc, including a remote "x" that
+	// the client missed; the local "c" is replayed on top of it
+	st.Recover()
+	if st.ServerRev() != 0 || !st.IsBroken() || st.Err() != nil {
+		t.Fatalf("unexpected recovering controller: %s", st)
+	}
+	c.write(st, NewInsert(3, 0, "d"))
+	if err := st.OnServerWrite(3, "", NewInsert(0, 0, "abx")); err != nil {
+		t.Fatalf("reopen failed, err: %q", err)
+	}
+	if st.IsBroken() || st.ServerRev() != 3 {
+		t.Fatalf("expected recovered controller, got: %s", st)
+	}
+	if c.doc.String() != "[d a b c x]" {
+		t.Fatalf("recover: expected [d a b c x], got %s", c.doc.String())
+	}
+	if !reflect.DeepEqual(c.lastSent(t), C(Is("d"), Rs(2), Is("c"), Rs(1))) {
+		t.Fatalf("recover: unexpected send %s", c.lastSent(t))
+	}
+	if err := st.OnServerAck(4, c.lastSent(t)); err != nil || !st.IsSynchronized() {
+		t.Fatalf("recover: ack failed, err: %q", err)
+	}
+}
+
+func TestControllerRecoverTwice(t *testing.T) {
+	c := &testClient{doc: NewDoc()}
+	st := NewController(c, c)
+	c.write(st, NewInsert(0, 0, "ab"))
+	if err := st.OnServerAck(1, NewInsert(0, 0, "ab")); err != nil {
+		t.Fatalf("ack failed, err: %q", err)
+	}
+
+	// a second Recover, e.g. on a resync while reopening, keeps the base
+	st.Recover()
+	st.Recover()
+	if err := st.OnServerWrite(1, "", NewInsert(0, 0, "ab")); err != nil {
+		t.Fatalf("reopen failed, err: %q", err)
+	}
+	if c.doc.String() != "[a b]" {
+		t.Fatalf("recover twice: expected [a b], got %s", c.doc.String())
+	}
+	if len(c.sent) != 1 || !st.IsSynchronized() {
+		t.Fatalf("recover twice: expected nothing to send, got %v, state: %s", c.sent, st)
+	}
+}
+
+func TestControllerWindow(t *testing.T) {
+	c := &testClient{doc: NewDoc()}
+	st := NewController(c, c)
+	woken := 0
+	st.AttachWindow(time.Second, func(d time.Duration) {
+		if d != time.Second {
+			t.Fatalf("expected wake after %s, got %s", time.Second, d)
+		}
+		woken++
+	})
+
+	// writes are held, composed, until flushed
+	c.write(st, NewInsert(0, 0, "a"))
+	c.write(st, NewInsert(1, 1, "b"))
+	if len(c.sent) != 0 || woken != 1 || st.IsSynchronized() {
+		t.Fatalf("expected held writes, sent: %d, woken: %d, state: %s", len(c.sent), woken, st)
+	}
+
+	// held writes are rebased over server writes like pending ones
+	if err := st.OnServerWrite(1, "", NewInsert(0, 0, "x")); err != nil {
+		t.Fatalf("write failed, err: %q", err)
+	}
+	if c.doc.String() != "[a b x]" {
+		t.Fatalf("expected [a b x], got %s", c.doc.String())
+	}
+	if err := st.OnServerAck(2, Rs(3)); err == nil {
+		t.Fatalf("expected ack of held writes to fail")
+	}
+}
+
+func TestControllerWindowFlush(t *testing.T) {
+	c := &testClient{doc: NewDoc()}
+	st := NewController(c, c)
+	st.AttachWindow(time.Second, func(time.Duration) {})
+
+	c.write(st, NewInsert(0, 0, "a"))
+	c.write(st, NewInsert(1, 1, "b"))
+	if err := st.Flush(); err != nil {
+		t.Fatalf("flush failed, err: %q", err)
+	}
+	if len(c.sent) != 1 || !reflect.DeepEqual(c.lastSent(t), C(Is("ab"))) {
+		t.Fatalf("expected one composed send, got %v", c.sent)
+	}
+
+	// writes made while a write is pending are queued, not held
+	c.write(st, NewInsert(2, 2, "c"))
+	c.write(st, NewInsert(3, 3, "d"))
+	if err := st.OnServerAck(1, C(Is("ab"))); err != nil {
+		t.Fatalf("ack failed, err: %q", err)
+	}
+	if len(c.sent) != 2 || !reflect.DeepEqual(c.lastSent(t), C(Rs(2), Is("cd"))) {
+		t.Fatalf("expected queued writes to be sent composed, got %v", c.sent)
+	}
+	if err := st.OnServerAck(2, c.lastSent(t)); err != nil || !st.IsSynchronized() {
+		t.Fatalf("ack failed, err: %q, state: %s", err, st)
+	}
+	if err := st.Flush(); err != nil || len(c.sent) != 2 {
+		t.Fatalf("expected idle flush to send nothing, err: %q, sent: %v", err, c.sent)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ottest
+
+import (
+	"testing"
+
+	"github.com/mstone/focus/ot"
+)
+
+// fuzzSeeds are starting points for the fuzz targets below, which decode
+// their inputs into cases with FromBytes.
+var fuzzSeeds = [][]byte{
+	nil,
+	[]byte("\x00\x00\x00\x00\x00\x00\x00\x00"),
+	[]byte("focus"),
+	[]byte("\xff\x01\x80\x7f\x10\x20\x30\x40\x50\x60\x70\x80\x90\xa0\xb0\xc0\xd0\xe0\xf0"),
+}
+
+func fuzzLaw(f *testing.F, laws ...Law) {
+	for _, s := range fuzzSeeds {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, law := range laws {
+			cs := DefaultConfig.Generate(FromBytes(data), law)
+			if err := law.Check(cs); err != nil {
+				small := Shrink(law, cs)
+				t.Fatalf("%s failed\n\tcase: %s\n\tminimized: %s\n\terr: %s", law.Name, cs, small, law.Check(small))
+			}
+		}
+	})
+}
+
+func FuzzCompose(f *testing.F) {
+	fuzzLaw(f, ApplyCompose, ComposeAssoc)
+}
+
+func FuzzTransform(f *testing.F) {
+	fuzzLaw(f, TP1)
+}
+
+func FuzzApply(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		cs := DefaultConfig.Concurrent(FromBytes(data), 1)
+		ops := cs.Ops[0]
+		if err := ot.Validate(ops, cs.Doc); err != nil {
+			t.Fatalf("generated invalid ops; case: %s, err: %s", cs, err)
+		}
+		after, err := ApplyOps(cs.Doc, ops)
+		if err != nil {
+			t.Fatalf("apply failed; case: %s, err: %s", cs, err)
+		}
+		if n := after.Kids.Len(); n != ot.OutputLen(ops) {
+			t.Fatalf("apply produced %d positions, expected %d; case: %s, after: %s", n, ot.OutputLen(ops), cs, after.String())
+		}
+	})
+}
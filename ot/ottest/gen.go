@@ -0,0 +1,258 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+// Package ottest provides property-based testing tools for package ot:
+// generators for random trees and well-formed ops at arbitrary nesting depth,
+// checks for the laws that Compose, Transform, Apply, and Normalize must obey,
+// and a shrinker that minimizes the counterexamples that those checks find.
+package ottest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"reflect"
+
+	"github.com/juju/errors"
+	"github.com/mstone/focus/ot"
+)
+
+// Config controls the shape of generated trees and ops.
+type Config struct {
+	MaxDepth int  // maximum nesting depth of generated branches
+	MaxKids  int  // maximum number of kids of generated branches
+	Attrs    bool // whether to generate rich-text attributes
+	Moves    bool // whether to generate moves
+}
+
+var DefaultConfig = Config{
+	MaxDepth: 3,
+	MaxKids:  6,
+	Attrs:    true,
+	Moves:    true,
+}
+
+var (
+	alphabet = []rune("abxyé")
+	attrKeys = []string{"b", "i"}
+	attrVals = []string{"1", "2"}
+	types    = []string{"", ot.N_PARAGRAPH, ot.N_CELL}
+	noAttrs  ot.Attrs
+)
+
+// attrs returns random attributes or, when patch is set, a random attribute
+// patch that may remove keys.
+func (c Config) attrs(r *rand.Rand, patch bool) ot.Attrs {
+	if !c.Attrs || r.Intn(3) != 0 {
+		return noAttrs
+	}
+	ret := ot.Attrs{}
+	for _, k := range attrKeys {
+		switch n := r.Intn(len(attrVals) + 2); {
+		case n < len(attrVals):
+			ret[k] = attrVals[n]
+		case n == len(attrVals) && patch:
+			ret[k] = ""
+		}
+	}
+	if len(ret) == 0 {
+		return noAttrs
+	}
+	return ret
+}
+
+func (c Config) text(r *rand.Rand) ot.Tree {
+	rs := make([]rune, 1+r.Intn(3))
+	for i := range rs {
+		rs[i] = alphabet[r.Intn(len(alphabet))]
+	}
+	return ot.Text(rs).WithAttrs(c.attrs(r, false))
+}
+
+// RandTree returns a random branch nested at most depth levels deep.
+func (c Config) RandTree(r *rand.Rand, depth int) ot.Tree {
+	kids := make(ot.Trees, r.Intn(c.MaxKids+1))
+	for i := range kids {
+		switch n := r.Intn(3); {
+		case n == 2 && depth > 0:
+			kids[i] = c.RandTree(r, depth-1).WithType(types[r.Intn(len(types))])
+		case n == 1:
+			kids[i] = ot.Leaf(alphabet[r.Intn(len(alphabet))]).WithAttrs(c.attrs(r, false))
+		default:
+			kids[i] = c.text(r)
+		}
+	}
+	return ot.Branch(kids).WithAttrs(c.attrs(r, false))
+}
+
+// positions returns the kids of the branch t split into one tree per position.
+func positions(t ot.Tree) ot.Trees {
+	ret := ot.Trees{}
+	for _, k := range t.Kids {
+		if k.HasRunes() {
+			for _, r := range k.Runes() {
+				ret = append(ret, ot.Leaf(r).WithAttrs(k.Attrs))
+			}
+			continue
+		}
+		ret = append(ret, k)
+	}
+	return ret
+}
+
+// RandOps returns random ops that are well-formed with respect to the branch
+// t; i.e., ops such that ot.Validate(ops, t) succeeds.
+//
+// Ops are usually built with the ot.Ops builders but are sometimes appended
+// directly, so that generated ops are not always normalized.
+func (c Config) RandOps(r *rand.Rand, t ot.Tree) ot.Ops {
+	return c.randOps(r, t, c.MaxDepth)
+}
+
+func (c Config) randOps(r *rand.Rand, t ot.Tree, depth int) ot.Ops {
+	ops := ot.Ops{}
+	ps := positions(t)
+	moves := 0
+	for i := 0; i <= len(ps); i++ {
+		if r.Intn(4) == 0 {
+			body := c.text(r)
+			if depth > 0 && r.Intn(4) == 0 {
+				body = c.RandTree(r, depth-1)
+			}
+			c.push(r, &ops, ot.It(body))
+		}
+		if i == len(ps) {
+			break
+		}
+		switch n := r.Intn(8); {
+		case c.Moves && r.Intn(6) == 0:
+			var kids ot.Ops
+			if ps[i].IsBranch() && depth > 0 && r.Intn(2) == 0 {
+				kids = c.randOps(r, ps[i], depth-1)
+			}
+			moves++
+			ops = append(ops, ot.Mf(moves, kids, c.attrs(r, true)))
+		case n < 3:
+			c.push(r, &ops, ot.R(1))
+		case n < 5:
+			c.push(r, &ops, ot.D(1))
+		case n < 6:
+			c.push(r, &ops, ot.F(1, c.attrs(r, true)))
+		case ps[i].IsBranch() && depth > 0:
+			c.push(r, &ops, ot.Wf(c.randOps(r, ps[i], depth-1), c.attrs(r, true)))
+		default:
+			c.push(r, &ops, ot.R(1))
+		}
+	}
+	// place the moved kids at random, between any two ops
+	for id := 1; id <= moves; id++ {
+		k := r.Intn(len(ops) + 1)
+		ops = append(ops, ot.Op{})
+		copy(ops[k+1:], ops[k:])
+		ops[k] = ot.P(id)
+	}
+	return ops
+}
+
+func (c Config) push(r *rand.Rand, ops *ot.Ops, o ot.Op) {
+	if r.Intn(4) == 0 {
+		*ops = append(*ops, o)
+		return
+	}
+	switch {
+	case o.IsInsert():
+		ops.Insert(o.Body)
+	case o.IsRetain():
+		ops.Format(o.Size, o.Attrs)
+	case o.IsDelete():
+		ops.Delete(o.Size)
+	case o.IsWith():
+		ops.FormatWith(o.Kids, o.Attrs)
+	}
+}
+
+// Case is a document together with the op lists that a law is checked
+// against. In sequential cases, each list applies to the result of applying
+// the previous lists to Doc; otherwise, each list applies to Doc.
+type Case struct {
+	Doc ot.Tree
+	Ops []ot.Ops
+	Seq bool
+}
+
+func (cs Case) String() string {
+	s := "doc: " + cs.Doc.String()
+	for k, ops := range cs.Ops {
+		s += fmt.Sprintf(", ops[%d]: %s", k, ops.String())
+	}
+	if cs.Seq {
+		s += " (sequential)"
+	}
+	return s
+}
+
+// Concurrent returns a case with n op lists, all of which apply to Doc.
+func (c Config) Concurrent(r *rand.Rand, n int) Case {
+	cs := Case{Doc: c.RandTree(r, c.MaxDepth)}
+	for i := 0; i < n; i++ {
+		cs.Ops = append(cs.Ops, c.RandOps(r, cs.Doc))
+	}
+	return cs
+}
+
+// Sequential returns a case with n op lists, each of which applies to the
+// result of applying the previous lists to Doc.
+func (c Config) Sequential(r *rand.Rand, n int) Case {
+	cs := Case{Doc: c.RandTree(r, c.MaxDepth), Seq: true}
+	t := cs.Doc
+	for i := 0; i < n; i++ {
+		ops := c.RandOps(r, t)
+		cs.Ops = append(cs.Ops, ops)
+		next, err := ApplyOps(t, ops)
+		if err != nil {
+			panic(errors.Annotatef(err, "Sequential failed, unable to apply generated ops; t: %s, ops: %s", t.String(), ops.String()))
+		}
+		t = next
+	}
+	return cs
+}
+
+// Concurrent2 and Sequential3 implement quick.Generator so that cases can be
+// passed to testing/quick.
+type Concurrent2 struct{ Case }
+type Sequential3 struct{ Case }
+
+func (Concurrent2) Generate(r *rand.Rand, size int) reflect.Value {
+	return reflect.ValueOf(Concurrent2{sized(size).Concurrent(r, 2)})
+}
+
+func (Sequential3) Generate(r *rand.Rand, size int) reflect.Value {
+	return reflect.ValueOf(Sequential3{sized(size).Sequential(r, 3)})
+}
+
+func sized(size int) Config {
+	c := DefaultConfig
+	if size < c.MaxKids {
+		c.MaxKids = size
+	}
+	return c
+}
+
+// FromBytes returns a random source that draws its values from data and then
+// from zeros, for use by fuzz targets.
+func FromBytes(data []byte) *rand.Rand {
+	return rand.New(&byteSource{data})
+}
+
+type byteSource struct {
+	data []byte
+}
+
+func (s *byteSource) Int63() int64 {
+	var b [8]byte
+	n := copy(b[:], s.data)
+	s.data = s.data[n:]
+	return int64(binary.BigEndian.Uint64(b[:]) >> 1)
+}
+
+func (s *byteSource) Seed(int64) {}
@@ -0,0 +1,195 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ottest
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/mstone/focus/ot"
+)
+
+// Law is a property that should hold for every case with N op lists.
+type Law struct {
+	Name  string
+	N     int
+	Seq   bool
+	Check func(cs Case) error
+}
+
+var (
+	// TP1 checks that transformed concurrent ops converge:
+	// apply(apply(d, a), b') == apply(apply(d, b), a').
+	TP1 = Law{"TP1", 2, false, checkTP1}
+
+	// TP1Sites checks that concurrent ops converge when transformed with
+	// ot.TransformSites so that b's inserts come before a's.
+	TP1Sites = Law{"TP1Sites", 2, false, checkTP1Sites}
+
+	// ComposeAssoc checks that compose(compose(a, b), c) and
+	// compose(a, compose(b, c)) have the same effect.
+	ComposeAssoc = Law{"ComposeAssoc", 3, true, checkComposeAssoc}
+
+	// ApplyCompose checks that applying compose(a, b) has the same effect as
+	// applying a and then b.
+	ApplyCompose = Law{"ApplyCompose", 2, true, checkApplyCompose}
+
+	// NormalizeIdem checks that normalizing ops is idempotent and that it
+	// preserves their effect.
+	NormalizeIdem = Law{"NormalizeIdem", 1, false, checkNormalizeIdem}
+
+	Laws = []Law{TP1, TP1Sites, ComposeAssoc, ApplyCompose, NormalizeIdem}
+)
+
+// ApplyOps returns a copy of the branch t to which ops have been applied.
+func ApplyOps(t ot.Tree, ops ot.Ops) (ot.Tree, error) {
+	t = t.Clone()
+	if err := ot.Apply(ot.W(ops), &t); err != nil {
+		return ot.Tree{}, errors.Trace(err)
+	}
+	return t, nil
+}
+
+// sameTree reports an error unless a and b have the same contents.
+func sameTree(a, b ot.Tree) error {
+	ca, cb := ot.Canonical(a), ot.Canonical(b)
+	if !reflect.DeepEqual(ca, cb) {
+		return errors.Errorf("trees differ; a: %s, b: %s", ca.String(), cb.String())
+	}
+	return nil
+}
+
+// applyAll applies each op list in turn to t.
+func applyAll(t ot.Tree, all ...ot.Ops) (ot.Tree, error) {
+	for k, ops := range all {
+		var err error
+		t, err = ApplyOps(t, ops)
+		if err != nil {
+			return ot.Tree{}, errors.Annotatef(err, "apply %d failed, ops: %s", k, ops.String())
+		}
+	}
+	return t, nil
+}
+
+func checkTP1(cs Case) error {
+	return checkConverge(cs, ot.Transform)
+}
+
+func checkTP1Sites(cs Case) error {
+	return checkConverge(cs, func(a, b ot.Ops) (ot.Ops, ot.Ops, error) {
+		return ot.TransformSites(a, b, "b", "a")
+	})
+}
+
+// checkConverge checks that the ops of cs, transformed by transform, converge.
+func checkConverge(cs Case, transform func(a, b ot.Ops) (ot.Ops, ot.Ops, error)) error {
+	a, b := cs.Ops[0], cs.Ops[1]
+	a1, b1, err := transform(a, b)
+	if err != nil {
+		return errors.Annotatef(err, "transform failed")
+	}
+	x, err := applyAll(cs.Doc, a, b1)
+	if err != nil {
+		return errors.Annotatef(err, "a, b' failed; b': %s", b1.String())
+	}
+	y, err := applyAll(cs.Doc, b, a1)
+	if err != nil {
+		return errors.Annotatef(err, "b, a' failed; a': %s", a1.String())
+	}
+	return errors.Annotatef(sameTree(x, y), "no convergence; a': %s, b': %s", a1.String(), b1.String())
+}
+
+func checkComposeAssoc(cs Case) error {
+	a, b, c := cs.Ops[0], cs.Ops[1], cs.Ops[2]
+	ab, err := ot.Compose(a, b)
+	if err != nil {
+		return errors.Annotatef(err, "compose(a, b) failed")
+	}
+	bc, err := ot.Compose(b, c)
+	if err != nil {
+		return errors.Annotatef(err, "compose(b, c) failed")
+	}
+	l, err := ot.Compose(ab, c)
+	if err != nil {
+		return errors.Annotatef(err, "compose(ab, c) failed; ab: %s", ab.String())
+	}
+	r, err := ot.Compose(a, bc)
+	if err != nil {
+		return errors.Annotatef(err, "compose(a, bc) failed; bc: %s", bc.String())
+	}
+	x, err := ApplyOps(cs.Doc, l)
+	if err != nil {
+		return errors.Annotatef(err, "apply (ab)c failed; (ab)c: %s", l.String())
+	}
+	y, err := ApplyOps(cs.Doc, r)
+	if err != nil {
+		return errors.Annotatef(err, "apply a(bc) failed; a(bc): %s", r.String())
+	}
+	return errors.Annotatef(sameTree(x, y), "not associative; (ab)c: %s, a(bc): %s", l.String(), r.String())
+}
+
+func checkApplyCompose(cs Case) error {
+	a, b := cs.Ops[0], cs.Ops[1]
+	ab, err := ot.Compose(a, b)
+	if err != nil {
+		return errors.Annotatef(err, "compose failed")
+	}
+	x, err := applyAll(cs.Doc, a, b)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	y, err := ApplyOps(cs.Doc, ab)
+	if err != nil {
+		return errors.Annotatef(err, "apply ab failed; ab: %s", ab.String())
+	}
+	return errors.Annotatef(sameTree(x, y), "apply and compose disagree; ab: %s", ab.String())
+}
+
+func checkNormalizeIdem(cs Case) error {
+	a := cs.Ops[0]
+	n1, err := ot.Normalize(a)
+	if err != nil {
+		return errors.Annotatef(err, "normalize failed")
+	}
+	n2, err := ot.Normalize(n1)
+	if err != nil {
+		return errors.Annotatef(err, "renormalize failed; n: %s", n1.String())
+	}
+	if !reflect.DeepEqual(n1, n2) {
+		return errors.Errorf("normalize not idempotent; n: %s, n(n): %s", n1.String(), n2.String())
+	}
+	x, err := ApplyOps(cs.Doc, a)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	y, err := ApplyOps(cs.Doc, n1)
+	if err != nil {
+		return errors.Annotatef(err, "apply n failed; n: %s", n1.String())
+	}
+	return errors.Annotatef(sameTree(x, y), "normalize changed effect; n: %s", n1.String())
+}
+
+// Generate returns a random case for law.
+func (c Config) Generate(r *rand.Rand, law Law) Case {
+	if law.Seq {
+		return c.Sequential(r, law.N)
+	}
+	return c.Concurrent(r, law.N)
+}
+
+// Check checks law against n random cases drawn from seed. On failure, it
+// reports both the failing case and a minimized counterexample.
+func (c Config) Check(t testing.TB, law Law, seed int64, n int) {
+	r := rand.New(rand.NewSource(seed))
+	for i := 0; i < n; i++ {
+		cs := c.Generate(r, law)
+		if err := law.Check(cs); err != nil {
+			small := Shrink(law, cs)
+			t.Fatalf("%s failed; seed: %d, iter: %d\n\tcase: %s\n\tminimized: %s\n\terr: %s",
+				law.Name, seed, i, cs, small, law.Check(small))
+		}
+	}
+}
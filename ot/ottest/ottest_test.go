@@ -0,0 +1,103 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ottest
+
+import (
+	"math/rand"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/mstone/focus/ot"
+)
+
+func TestGenerate(t *testing.T) {
+	seed := time.Now().UnixNano()
+	r := rand.New(rand.NewSource(seed))
+	for i := 0; i < 500; i++ {
+		cs := DefaultConfig.Sequential(r, 3)
+		if !Valid(cs) {
+			t.Fatalf("generated invalid sequential case, seed: %d, case: %s", seed, cs)
+		}
+		cs = DefaultConfig.Concurrent(r, 3)
+		if !Valid(cs) {
+			t.Fatalf("generated invalid concurrent case, seed: %d, case: %s", seed, cs)
+		}
+	}
+}
+
+func TestLaws(t *testing.T) {
+	seed := time.Now().UnixNano()
+	for _, law := range Laws {
+		t.Run(law.Name, func(t *testing.T) {
+			DefaultConfig.Check(t, law, seed, 1000)
+		})
+	}
+}
+
+func TestQuick(t *testing.T) {
+	tp1 := func(c Concurrent2) bool {
+		return TP1.Check(c.Case) == nil
+	}
+	if err := quick.Check(tp1, nil); err != nil {
+		t.Error(err)
+	}
+	assoc := func(c Sequential3) bool {
+		return ComposeAssoc.Check(c.Case) == nil
+	}
+	if err := quick.Check(assoc, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestShrink(t *testing.T) {
+	// a deliberately false law: ops never delete anything
+	noDeletes := Law{
+		Name: "NoDeletes",
+		N:    2,
+		Check: func(cs Case) error {
+			for _, o := range cs.Ops[0] {
+				if o.IsDelete() {
+					return errors.Errorf("found delete: %s", o.String())
+				}
+			}
+			return nil
+		},
+	}
+
+	seed := time.Now().UnixNano()
+	r := rand.New(rand.NewSource(seed))
+	for i := 0; i < 100; i++ {
+		cs := DefaultConfig.Generate(r, noDeletes)
+		if noDeletes.Check(cs) == nil {
+			continue
+		}
+		small := Shrink(noDeletes, cs)
+		if !Valid(small) || noDeletes.Check(small) == nil {
+			t.Fatalf("shrink produced a non-counterexample; seed: %d, case: %s, small: %s", seed, cs, small)
+		}
+		if n := len(positions(small.Doc)); n != 1 || small.Ops[0].String() != ot.Ds(1).String() {
+			t.Fatalf("shrink not minimal; seed: %d, case: %s, small: %s", seed, cs, small)
+		}
+	}
+}
+
+func TestDropPos(t *testing.T) {
+	// [a b [c] d]
+	doc := ot.Branch(ot.Trees{ot.Text(ot.AsRunes("ab")), ot.Branch(ot.Trees{ot.Leaf('c')}), ot.Leaf('d')})
+	ops := ot.C(ot.Rs(1), ot.Is("x"), ot.Ds(1), ot.Ws(ot.C(ot.Ds(1))), ot.Rs(1))
+	for i := 0; i < 4; i++ {
+		cs := Case{Doc: doc, Ops: []ot.Ops{ops}}
+		cands := candidates(cs)
+		if !Valid(cands[0]) {
+			t.Fatalf("dropping position %d produced invalid case: %s", i, cands[0])
+		}
+		doc = cands[0].Doc
+		ops = cands[0].Ops[0]
+	}
+	if doc.String() != "[]" || ops.String() != ot.Is("x").String() {
+		t.Fatalf("unexpected result of dropping all positions; doc: %s, ops: %s", doc.String(), ops.String())
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ottest
+
+import (
+	"github.com/mstone/focus/ot"
+)
+
+// maxShrinks bounds the number of simplifications that Shrink will make.
+const maxShrinks = 1000
+
+// Shrink greedily simplifies cs, which must violate law, while keeping it
+// well-formed and in violation of law. Simplifications remove positions from
+// the document, drop or shorten inserts, turn With ops into retains, undo
+// moves, and strip attributes.
+func Shrink(law Law, cs Case) Case {
+	for i := 0; i < maxShrinks; i++ {
+		progress := false
+		for _, c := range candidates(cs) {
+			if Valid(c) && law.Check(c) != nil {
+				cs = c
+				progress = true
+				break
+			}
+		}
+		if !progress {
+			break
+		}
+	}
+	return cs
+}
+
+// Valid reports whether each op list of cs is well-formed with respect to the
+// tree that it applies to.
+func Valid(cs Case) bool {
+	if !cs.Doc.IsBranch() {
+		return false
+	}
+	t := cs.Doc
+	for _, ops := range cs.Ops {
+		if ot.Validate(ops, t) != nil {
+			return false
+		}
+		if cs.Seq {
+			var err error
+			if t, err = ApplyOps(t, ops); err != nil {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (cs Case) clone() Case {
+	ret := Case{Doc: cs.Doc.Clone(), Seq: cs.Seq}
+	for _, ops := range cs.Ops {
+		ret.Ops = append(ret.Ops, ops.Clone())
+	}
+	return ret
+}
+
+// dropPos returns ops adjusted to apply to a branch from which input position
+// i has been removed, together with the output position that i was mapped to,
+// or -1 if ops deleted it.
+func dropPos(ops ot.Ops, i int) (ot.Ops, int) {
+	ret := ot.Ops{}
+	in, out, at := 0, 0, -1
+	places := map[int]int{}
+	id := 0
+	for _, o := range ops {
+		switch {
+		case o.IsZero():
+		case o.IsInsert():
+			out += o.Len()
+		case o.IsPlace():
+			places[o.Id] = out
+			out++
+		case o.IsMove():
+			in++
+			if in-1 == i {
+				id = o.Id
+				continue
+			}
+		case o.IsWith():
+			if in == i {
+				at = out
+				in++
+				out++
+				continue
+			}
+			in++
+			out++
+		case o.IsRetain(), o.IsDelete():
+			n := o.Len()
+			if in <= i && i < in+n {
+				if o.IsRetain() {
+					at = out + i - in
+					o.Size--
+				} else {
+					o.Size++
+				}
+			}
+			in += n
+			if o.IsRetain() {
+				out += n
+			}
+			if o.Size == 0 {
+				continue
+			}
+		}
+		ret = append(ret, o)
+	}
+	if id == 0 {
+		return ret, at
+	}
+	// the dropped position was moved; drop its place, too
+	at = places[id]
+	return unplace(ret, id), at
+}
+
+// unplace returns ops without the place of the move identified by id.
+func unplace(ops ot.Ops, id int) ot.Ops {
+	ret := ot.Ops{}
+	for _, o := range ops {
+		if !(o.IsPlace() && o.Id == id) {
+			ret = append(ret, o)
+		}
+	}
+	return ret
+}
+
+// dropFrom removes input position p from the op list k of the sequential
+// case cs and the positions that p maps to from the lists after k.
+func (cs *Case) dropFrom(k, p int) {
+	for ; k < len(cs.Ops) && p >= 0; k++ {
+		cs.Ops[k], p = dropPos(cs.Ops[k], p)
+	}
+}
+
+// candidates returns simplifications of cs, some of which may be invalid.
+func candidates(cs Case) []Case {
+	ret := []Case{}
+
+	// remove or simplify document positions
+	ps := positions(cs.Doc)
+	for i := range ps {
+		c := cs.clone()
+		kids := append(ps[:i:i], ps[i+1:]...)
+		c.Doc = ot.Branch(kids).WithAttrs(cs.Doc.Attrs).WithType(cs.Doc.Type)
+		if cs.Seq {
+			c.dropFrom(0, i)
+		} else {
+			for k := range c.Ops {
+				c.Ops[k], _ = dropPos(c.Ops[k], i)
+			}
+		}
+		ret = append(ret, c)
+
+		if len(ps[i].Kids) > 0 || len(ps[i].Attrs) > 0 {
+			c := cs.clone()
+			simple := append(ot.Trees{}, ps...)
+			if ps[i].IsBranch() {
+				simple[i] = ot.Branch(nil)
+			} else {
+				simple[i] = ps[i].WithAttrs(nil)
+			}
+			c.Doc = ot.Branch(simple).WithAttrs(cs.Doc.Attrs).WithType(cs.Doc.Type)
+			ret = append(ret, c)
+		}
+	}
+	if len(cs.Doc.Attrs) > 0 {
+		c := cs.clone()
+		c.Doc.Attrs = nil
+		ret = append(ret, c)
+	}
+
+	// remove or simplify ops
+	for k, ops := range cs.Ops {
+		out := 0
+		for j, o := range ops {
+			switch {
+			case o.IsInsert():
+				c := cs.clone()
+				c.Ops[k] = append(c.Ops[k][:j:j], c.Ops[k][j+1:]...)
+				for n := 0; cs.Seq && n < o.Len(); n++ {
+					c.dropFrom(k+1, out)
+				}
+				ret = append(ret, c)
+
+				if o.Len() > 1 {
+					c := cs.clone()
+					c.Ops[k][j] = ot.It(ot.Leaf(o.Body.Runes()[0]).WithAttrs(o.Body.Attrs))
+					for n := 1; cs.Seq && n < o.Len(); n++ {
+						c.dropFrom(k+1, out+1)
+					}
+					ret = append(ret, c)
+				}
+				if o.Body.IsBranch() && len(o.Body.Kids) > 0 {
+					c := cs.clone()
+					c.Ops[k][j] = ot.It(ot.Branch(nil).WithAttrs(o.Body.Attrs).WithType(o.Body.Type))
+					ret = append(ret, c)
+				}
+				if len(o.Body.Attrs) > 0 {
+					c := cs.clone()
+					c.Ops[k][j].Body = o.Body.WithAttrs(nil)
+					ret = append(ret, c)
+				}
+			case o.IsWith():
+				c := cs.clone()
+				c.Ops[k][j] = ot.R(1)
+				ret = append(ret, c)
+
+				if len(o.Attrs) > 0 {
+					c := cs.clone()
+					c.Ops[k][j].Attrs = nil
+					ret = append(ret, c)
+				}
+			case o.IsMove():
+				c := cs.clone()
+				if len(o.Kids) > 0 {
+					c.Ops[k][j] = ot.Wf(o.Kids, o.Attrs)
+				} else {
+					c.Ops[k][j] = ot.F(1, o.Attrs)
+				}
+				c.Ops[k] = unplace(c.Ops[k], o.Id)
+				ret = append(ret, c)
+
+				if len(o.Attrs) > 0 {
+					c := cs.clone()
+					c.Ops[k][j].Attrs = nil
+					ret = append(ret, c)
+				}
+			case o.IsRetain() && len(o.Attrs) > 0:
+				c := cs.clone()
+				c.Ops[k][j].Attrs = nil
+				ret = append(ret, c)
+			}
+			if !o.IsDelete() && !o.IsMove() {
+				out += o.Len()
+			}
+		}
+	}
+	return ret
+}
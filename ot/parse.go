@@ -0,0 +1,353 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot
+
+import (
+	"strconv"
+	"unicode"
+
+	"github.com/juju/errors"
+)
+
+// ParseOps parses ops in the textual form printed by Ops.String(); e.g.,
+// `[R3 D2 I"ab" W[R1 I[a b]]{b="1"} M1 P1]`, so that ops copied from logs and
+// test failures can be pasted back into tests. ParseOps(os.String()) prints
+// exactly like os.
+//
+// Since Tree.String() prints text runs like the leaves that they stand for,
+// runs of runes within branches parse as text runs, each of which takes the
+// attributes printed after its last rune. Leaves holding '[', ']', '"' or
+// '\\' print escaped by a '\\'. Node types are printed as is, so types holding
+// '[', '\\' or spaces do not parse.
+func ParseOps(s string) (Ops, error) {
+	p := &parser{s: []rune(s)}
+	ops, err := p.ops()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := p.end(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return ops, nil
+}
+
+// ParseTree parses a branch in the textual form printed by Tree.String(),
+// like the docs printed by ottest cases; see ParseOps.
+func ParseTree(s string) (Tree, error) {
+	p := &parser{s: []rune(s)}
+	t, err := p.branch("")
+	if err != nil {
+		return Tree{}, errors.Trace(err)
+	}
+	attrs, err := p.attrs()
+	if err != nil {
+		return Tree{}, errors.Trace(err)
+	}
+	if err := p.end(); err != nil {
+		return Tree{}, errors.Trace(err)
+	}
+	return t.WithAttrs(attrs), nil
+}
+
+// parser holds the input of ParseOps and ParseTree and the position of the
+// next rune to parse.
+type parser struct {
+	s   []rune
+	pos int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return errors.Annotatef(errors.Errorf(format, args...), "parse failed at pos: %d, s: %q", p.pos, string(p.s))
+}
+
+// peek returns the next rune, or 0 at the end of the input.
+func (p *parser) peek() rune {
+	if p.pos >= len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *parser) expect(r rune) error {
+	if p.peek() != r || p.pos >= len(p.s) {
+		return p.errorf("expected %q", r)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *parser) end() error {
+	if p.pos != len(p.s) {
+		return p.errorf("unexpected trailing input")
+	}
+	return nil
+}
+
+// ops parses `[op op ...]`.
+func (p *parser) ops() (Ops, error) {
+	if err := p.expect('['); err != nil {
+		return nil, err
+	}
+	ret := Ops{}
+	for p.peek() != ']' {
+		if len(ret) > 0 {
+			if err := p.expect(' '); err != nil {
+				return nil, err
+			}
+		}
+		o, err := p.op()
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, o)
+	}
+	p.pos++
+	return ret, nil
+}
+
+func (p *parser) op() (Op, error) {
+	if p.pos >= len(p.s) {
+		return Op{}, p.errorf("expected op")
+	}
+	tag := p.s[p.pos]
+	p.pos++
+	switch tag {
+	case 'Z':
+		return Z(), nil
+	case 'D':
+		n, err := p.int()
+		if err != nil {
+			return Op{}, err
+		}
+		return D(n), nil
+	case 'R':
+		n, err := p.int()
+		if err != nil {
+			return Op{}, err
+		}
+		attrs, err := p.attrs()
+		if err != nil {
+			return Op{}, err
+		}
+		return F(n, attrs), nil
+	case 'I':
+		t, err := p.insert()
+		if err != nil {
+			return Op{}, err
+		}
+		return It(t), nil
+	case 'W':
+		kids, err := p.ops()
+		if err != nil {
+			return Op{}, err
+		}
+		attrs, err := p.attrs()
+		if err != nil {
+			return Op{}, err
+		}
+		return Wf(kids, attrs), nil
+	case 'M':
+		id, err := p.int()
+		if err != nil {
+			return Op{}, err
+		}
+		var kids Ops
+		if p.peek() == '[' {
+			if kids, err = p.ops(); err != nil {
+				return Op{}, err
+			}
+		}
+		attrs, err := p.attrs()
+		if err != nil {
+			return Op{}, err
+		}
+		return Mf(id, kids, attrs), nil
+	case 'P':
+		id, err := p.int()
+		if err != nil {
+			return Op{}, err
+		}
+		return P(id), nil
+	default:
+		p.pos--
+		return Op{}, p.errorf("bad op tag: %q", tag)
+	}
+}
+
+// insert parses the body of an insert: a quoted text run, a single leaf, or
+// a branch, any of which may carry attributes.
+func (p *parser) insert() (Tree, error) {
+	var t Tree
+	switch {
+	case p.peek() == '"':
+		s, err := p.quoted()
+		if err != nil {
+			return Tree{}, err
+		}
+		if len(s) == 0 {
+			return Tree{}, p.errorf("empty text insert")
+		}
+		t = Text(AsRunes(s))
+	default:
+		var err error
+		if t, err = p.kid(); err != nil {
+			return Tree{}, err
+		}
+	}
+	attrs, err := p.attrs()
+	if err != nil {
+		return Tree{}, err
+	}
+	return t.WithAttrs(attrs), nil
+}
+
+// kid parses a single rune or a branch, without attributes.
+func (p *parser) kid() (Tree, error) {
+	if p.pos >= len(p.s) {
+		return Tree{}, p.errorf("expected tree")
+	}
+	switch p.s[p.pos] {
+	case '[':
+		return p.branch("")
+	case '\\':
+		// an escaped rune, as printed by leafString
+		if p.pos+1 >= len(p.s) {
+			return Tree{}, p.errorf("expected escaped rune")
+		}
+		r := p.s[p.pos+1]
+		p.pos += 2
+		return Leaf(r), nil
+	}
+	// a rune is followed by a separator, attributes, or the end of its
+	// branch; anything else names the type of a typed branch
+	if n := p.pos + 1; n >= len(p.s) || p.s[n] == ' ' || p.s[n] == ']' || p.s[n] == '{' {
+		r := p.s[p.pos]
+		p.pos++
+		return Leaf(r), nil
+	}
+	start := p.pos
+	for p.pos < len(p.s) && p.s[p.pos] != '[' {
+		if unicode.IsSpace(p.s[p.pos]) {
+			return Tree{}, p.errorf("bad node type")
+		}
+		p.pos++
+	}
+	return p.branch(string(p.s[start:p.pos]))
+}
+
+// branch parses `[kid kid ...]` into a branch of type typ.
+func (p *parser) branch(typ string) (Tree, error) {
+	if err := p.expect('['); err != nil {
+		return Tree{}, err
+	}
+	kids := Trees{}
+	var run []rune
+	flush := func(attrs Attrs) {
+		if len(run) > 0 {
+			kids = append(kids, Text(run).WithAttrs(attrs))
+		}
+		run = nil
+	}
+	for first := true; p.peek() != ']'; first = false {
+		if !first {
+			if err := p.expect(' '); err != nil {
+				return Tree{}, err
+			}
+		}
+		k, err := p.kid()
+		if err != nil {
+			return Tree{}, err
+		}
+		attrs, err := p.attrs()
+		if err != nil {
+			return Tree{}, err
+		}
+		if k.IsBranch() {
+			flush(nil)
+			kids = append(kids, k.WithAttrs(attrs))
+			continue
+		}
+		run = append(run, k.Leaf)
+		if len(attrs) > 0 {
+			flush(attrs)
+		}
+	}
+	p.pos++
+	flush(nil)
+	// SUBTLE: kids are kept as printed, rather than packed by Branch, so
+	// that unpacked branches print as they did before parsing.
+	if len(kids) == 0 {
+		kids = nil
+	}
+	return Tree{Tag: T_BRANCH, Kids: kids, Type: typ}, nil
+}
+
+// attrs parses optional attributes, `{k="v" ...}`.
+func (p *parser) attrs() (Attrs, error) {
+	if p.peek() != '{' || p.pos >= len(p.s) {
+		return nil, nil
+	}
+	p.pos++
+	ret := Attrs{}
+	for p.peek() != '}' {
+		if len(ret) > 0 {
+			if err := p.expect(' '); err != nil {
+				return nil, err
+			}
+		}
+		start := p.pos
+		for p.pos < len(p.s) && p.s[p.pos] != '=' {
+			p.pos++
+		}
+		k := string(p.s[start:p.pos])
+		if err := p.expect('='); err != nil {
+			return nil, err
+		}
+		v, err := p.quoted()
+		if err != nil {
+			return nil, err
+		}
+		ret[k] = v
+	}
+	p.pos++
+	if len(ret) == 0 {
+		return nil, p.errorf("empty attributes")
+	}
+	return ret, nil
+}
+
+// quoted parses a Go-quoted string.
+func (p *parser) quoted() (string, error) {
+	if err := p.expect('"'); err != nil {
+		return "", err
+	}
+	start := p.pos - 1
+	for p.pos < len(p.s) && p.s[p.pos] != '"' {
+		if p.s[p.pos] == '\\' {
+			p.pos++
+		}
+		p.pos++
+	}
+	if err := p.expect('"'); err != nil {
+		return "", err
+	}
+	s, err := strconv.Unquote(string(p.s[start:p.pos]))
+	if err != nil {
+		return "", p.errorf("bad quoted string: %s", err)
+	}
+	return s, nil
+}
+
+// int parses a non-negative decimal integer.
+func (p *parser) int() (int, error) {
+	start := p.pos
+	for p.pos < len(p.s) && p.s[p.pos] >= '0' && p.s[p.pos] <= '9' {
+		p.pos++
+	}
+	n, err := strconv.Atoi(string(p.s[start:p.pos]))
+	if err != nil {
+		return 0, p.errorf("expected integer")
+	}
+	return n, nil
+}
@@ -0,0 +1,175 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot_test
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mstone/focus/ot"
+	"github.com/mstone/focus/ot/ottest"
+)
+
+func TestParseOps(t *testing.T) {
+	ital := ot.Attrs{"italic": "true"}
+	cases := []struct {
+		S        string
+		Expected ot.Ops
+	}{
+		{`[]`, ot.Ops{}},
+		{`[R3 D2 Z]`, ot.Ops{ot.R(3), ot.D(2), ot.Z()}},
+		{`[I"a\"b c\n"]`, ot.Is("a\"b c\n")},
+		{`[Ié R1{italic="true"}]`, ot.Ops{ot.Ic('é'), ot.F(1, ital)}},
+		{`[I"ab"{italic="true"}]`, ot.Ops{ot.It(ot.Text([]rune("ab")).WithAttrs(ital))}},
+		{`[I[a b [x]]]`, ot.Ops{ot.It(ot.Branch(ot.Trees{ot.Text([]rune("ab")), ot.Branch(ot.Trees{ot.Leaf('x')})}))}},
+		{`[Iparagraph[a b{italic="true"} x]{align="left"}]`, ot.Ops{ot.It(ot.Node(ot.N_PARAGRAPH, ot.Attrs{"align": "left"}, ot.Trees{
+			ot.Text([]rune("ab")).WithAttrs(ital),
+			ot.Leaf('x'),
+		}))}},
+		{`[R1 W[R1 W[D1]]{italic="true"} D1]`, ot.Ops{ot.R(1), ot.Wf(ot.Ops{ot.R(1), ot.W(ot.Ops{ot.D(1)})}, ital), ot.D(1)}},
+		{`[M1[R1 D1]{italic="true"} M2 R1 P2 P1]`, ot.Ops{ot.Mf(1, ot.Ops{ot.R(1), ot.D(1)}, ital), ot.M(2), ot.R(1), ot.P(2), ot.P(1)}},
+	}
+	for _, c := range cases {
+		ops, err := ot.ParseOps(c.S)
+		if err != nil {
+			t.Fatalf("ParseOps failed, s: %s, err: %q", c.S, err)
+		}
+		if !reflect.DeepEqual(ops, c.Expected) {
+			t.Fatalf("bad parse of %s;\n\tgot: %#v\n\texpected: %#v", c.S, ops, c.Expected)
+		}
+		if s := ops.String(); s != c.S {
+			t.Fatalf("bad roundtrip;\n\tgot: %s\n\texpected: %s", s, c.S)
+		}
+	}
+
+	bad := []string{``, `[`, `R1`, `[R1 ]`, `[R1  D1]`, `[Rx]`, `[X1]`, `[I"ab]`, `[R1{b=1}]`, `[I[a b]`, `[R1] R1`}
+	for _, s := range bad {
+		if ops, err := ot.ParseOps(s); err == nil {
+			t.Fatalf("expected ParseOps of %q to fail, got: %s", s, ops)
+		}
+	}
+}
+
+func TestParseTree(t *testing.T) {
+	s := `[paragraph[a b] [x{b="1"} y]]`
+	tree, err := ot.ParseTree(s)
+	if err != nil {
+		t.Fatalf("ParseTree failed, err: %q", err)
+	}
+	expected := ot.Branch(ot.Trees{
+		ot.Node(ot.N_PARAGRAPH, nil, ot.Trees{ot.Text([]rune("ab"))}),
+		ot.Branch(ot.Trees{ot.Leaf('x').WithAttrs(ot.Attrs{"b": "1"}), ot.Leaf('y')}),
+	})
+	if !reflect.DeepEqual(tree, expected) {
+		t.Fatalf("bad parse;\n\tgot: %s\n\texpected: %s", tree.String(), expected.String())
+	}
+}
+
+// TestParseEscapes checks that leaves holding the runes that delimit
+// branches, attributes and kids round-trip.
+func TestParseEscapes(t *testing.T) {
+	cases := []struct {
+		S        string
+		Expected ot.Tree
+	}{
+		{`[\[ \] \\]`, ot.Branch(ot.Trees{ot.Text([]rune(`[]\`))})},
+		{`[\[]`, ot.Branch(ot.Trees{ot.Leaf('[')})},
+		{`[\]{b="1"}]`, ot.Branch(ot.Trees{ot.Leaf(']').WithAttrs(ot.Attrs{"b": "1"})})},
+		{`[paragraph[  a]]`, ot.Branch(ot.Trees{ot.Node(ot.N_PARAGRAPH, nil, ot.Trees{ot.Text([]rune(" a"))})})},
+		{`[{ } \" =]`, ot.Branch(ot.Trees{ot.Text([]rune(`{}"=`))})},
+	}
+	for _, c := range cases {
+		if s := c.Expected.String(); s != c.S {
+			t.Fatalf("bad print;\n\tgot: %s\n\texpected: %s", s, c.S)
+		}
+		tree, err := ot.ParseTree(c.S)
+		if err != nil {
+			t.Fatalf("ParseTree failed, s: %s, err: %q", c.S, err)
+		}
+		if !reflect.DeepEqual(tree, c.Expected) {
+			t.Fatalf("bad parse of %s;\n\tgot: %#v\n\texpected: %#v", c.S, tree, c.Expected)
+		}
+	}
+
+	seed := time.Now().UnixNano()
+	r := rand.New(rand.NewSource(seed))
+	alphabet := []rune(`ab []{}\"=`)
+	var gen func(depth int) ot.Tree
+	gen = func(depth int) ot.Tree {
+		var kids ot.Trees
+		for i := r.Intn(4); i > 0; i-- {
+			if depth < 2 && r.Intn(3) == 0 {
+				kids = append(kids, gen(depth+1))
+				continue
+			}
+			k := ot.Leaf(alphabet[r.Intn(len(alphabet))])
+			if r.Intn(4) == 0 {
+				k = k.WithAttrs(ot.Attrs{"b": "1"})
+			}
+			kids = append(kids, k)
+		}
+		if r.Intn(2) == 0 {
+			return ot.Node(ot.N_PARAGRAPH, nil, kids)
+		}
+		return ot.Tree{Tag: ot.T_BRANCH, Kids: kids}
+	}
+	for i := 0; i < 2000; i++ {
+		s := ot.Ops{ot.It(gen(0)), ot.Ic(alphabet[r.Intn(len(alphabet))])}.String()
+		ops, err := ot.ParseOps(s)
+		if err != nil {
+			t.Fatalf("ParseOps failed, seed: %d, s: %s, err: %q", seed, s, err)
+		}
+		if s2 := ops.String(); s2 != s {
+			t.Fatalf("bad roundtrip, seed: %d;\n\tgot: %s\n\texpected: %s", seed, s2, s)
+		}
+	}
+}
+
+// TestParseRandom checks that ParseOps and ParseTree round-trip the cases
+// that ottest generates, as printed in test failures.
+func TestParseRandom(t *testing.T) {
+	seed := time.Now().UnixNano()
+	r := rand.New(rand.NewSource(seed))
+	for _, c := range []ottest.Config{ottest.DefaultConfig, {MaxDepth: 3, MaxKids: 6}} {
+		for i := 0; i < 2000; i++ {
+			cs := c.Sequential(r, 2)
+			doc, err := ot.ParseTree(cs.Doc.String())
+			if err != nil {
+				t.Fatalf("ParseTree failed, seed: %d, doc: %s, err: %q", seed, cs.Doc.String(), err)
+			}
+			if s := doc.String(); s != cs.Doc.String() {
+				t.Fatalf("bad tree roundtrip, seed: %d;\n\tgot: %s\n\texpected: %s", seed, s, cs.Doc.String())
+			}
+			for _, ops := range cs.Ops {
+				ops2, err := ot.ParseOps(ops.String())
+				if err != nil {
+					t.Fatalf("ParseOps failed, seed: %d, ops: %s, err: %q", seed, ops, err)
+				}
+				if s := ops2.String(); s != ops.String() {
+					t.Fatalf("bad roundtrip, seed: %d;\n\tgot: %s\n\texpected: %s", seed, s, ops)
+				}
+			}
+			if c.Attrs {
+				// attributed text runs print like leaves, so only the
+				// printed forms are guaranteed to match
+				continue
+			}
+			t1, err1 := ottest.ApplyOps(cs.Doc, cs.Ops[0])
+			t2, err2 := ottest.ApplyOps(doc, mustParse(t, cs.Ops[0].String()))
+			if err1 != nil || err2 != nil || ot.Hash(t1) != ot.Hash(t2) {
+				t.Fatalf("parsed ops apply differently, seed: %d, case: %s", seed, cs)
+			}
+		}
+	}
+}
+
+func mustParse(t *testing.T, s string) ot.Ops {
+	ops, err := ot.ParseOps(s)
+	if err != nil {
+		t.Fatalf("ParseOps failed, s: %s, err: %q", s, err)
+	}
+	return ops
+}
@@ -0,0 +1,175 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot
+
+import (
+	"github.com/juju/errors"
+)
+
+// InsertAt returns ops that insert body at p in the branch t. The last
+// element of p is the index within the innermost branch at which body is
+// inserted; the other elements locate that branch, as for Path.
+//
+// Like NewInsert, the returned ops span all of t's kids and retain everything
+// else, descending into the branches along p with With ops.
+func InsertAt(p Path, body Tree, t Tree) (Ops, error) {
+	if !t.IsBranch() {
+		return nil, errors.Errorf("InsertAt failed, expected branch; p: %v, t: %s", p, t.String())
+	}
+	return insertAt(p, body, t.Kids)
+}
+
+// DeleteAt returns ops that delete the n kids of the innermost branch along p
+// that start at the last element of p.
+func DeleteAt(p Path, n int, t Tree) (Ops, error) {
+	if !t.IsBranch() {
+		return nil, errors.Errorf("DeleteAt failed, expected branch; p: %v, t: %s", p, t.String())
+	}
+	return deleteAt(p, n, t.Kids)
+}
+
+// EditAt returns ops that apply ops to the branch located by p, each element
+// of which is the position of a branch within its parent. An empty p locates
+// t itself.
+func EditAt(p Path, ops Ops, t Tree) (Ops, error) {
+	if !t.IsBranch() {
+		return nil, errors.Errorf("EditAt failed, expected branch; p: %v, t: %s", p, t.String())
+	}
+	return editAt(p, ops, t.Kids)
+}
+
+// FormatAt returns ops that patch the attributes of the n kids of the
+// innermost branch along p that start at the last element of p with attrs.
+// Like other attribute patches, concurrent patches of the same key are
+// resolved in favor of the first argument of Transform.
+func FormatAt(p Path, n int, attrs Attrs, t Tree) (Ops, error) {
+	if !t.IsBranch() {
+		return nil, errors.Errorf("FormatAt failed, expected branch; p: %v, t: %s", p, t.String())
+	}
+	return formatAt(p, n, attrs, t.Kids)
+}
+
+func insertAt(p Path, body Tree, kids kidSeq) (Ops, error) {
+	if len(p) == 0 {
+		return nil, errors.Errorf("insertAt failed, empty path")
+	}
+	if err := validateTree(body); err != nil {
+		return nil, errors.Annotatef(err, "insertAt failed, bad body")
+	}
+	return descend(p[:len(p)-1], kids, func(kids kidSeq) (Ops, error) {
+		pos, size := p[len(p)-1], kids.Len()
+		if pos < 0 || pos > size {
+			return nil, errors.Errorf("insertAt failed, index out of range; pos: %d, size: %d", pos, size)
+		}
+		ret := Ops{}
+		ret.Retain(pos)
+		ret.Insert(body.Clone())
+		ret.Retain(size - pos)
+		return ret, nil
+	})
+}
+
+func deleteAt(p Path, n int, kids kidSeq) (Ops, error) {
+	if len(p) == 0 {
+		return nil, errors.Errorf("deleteAt failed, empty path")
+	}
+	return descend(p[:len(p)-1], kids, func(kids kidSeq) (Ops, error) {
+		pos, size := p[len(p)-1], kids.Len()
+		if n < 0 || pos < 0 || pos+n > size {
+			return nil, errors.Errorf("deleteAt failed, delete out of range; pos: %d, n: %d, size: %d", pos, n, size)
+		}
+		ret := Ops{}
+		ret.Retain(pos)
+		ret.Delete(n)
+		ret.Retain(size - pos - n)
+		return ret, nil
+	})
+}
+
+func formatAt(p Path, n int, attrs Attrs, kids kidSeq) (Ops, error) {
+	if len(p) == 0 {
+		return nil, errors.Errorf("formatAt failed, empty path")
+	}
+	return descend(p[:len(p)-1], kids, func(kids kidSeq) (Ops, error) {
+		pos, size := p[len(p)-1], kids.Len()
+		if n < 0 || pos < 0 || pos+n > size {
+			return nil, errors.Errorf("formatAt failed, format out of range; pos: %d, n: %d, size: %d", pos, n, size)
+		}
+		ret := Ops{}
+		ret.Retain(pos)
+		ret.Format(n, attrs)
+		ret.Retain(size - pos - n)
+		return ret, nil
+	})
+}
+
+func editAt(p Path, ops Ops, kids kidSeq) (Ops, error) {
+	return descend(p, kids, func(kids kidSeq) (Ops, error) {
+		if err := validate(ops, kids); err != nil {
+			return nil, errors.Annotatef(err, "editAt failed, bad ops")
+		}
+		return ops.Clone(), nil
+	})
+}
+
+// descend returns ops that retain kids except for the branch located by p,
+// which is modified by the ops returned by f.
+func descend(p Path, kids kidSeq, f func(kids kidSeq) (Ops, error)) (Ops, error) {
+	if len(p) == 0 {
+		return f(kids)
+	}
+	pos, size := p[0], kids.Len()
+	if pos < 0 || pos >= size {
+		return nil, errors.Errorf("descend failed, path out of range; pos: %d, size: %d", pos, size)
+	}
+	k := kids.slice(pos, pos+1)[0]
+	if !k.IsBranch() {
+		return nil, errors.Errorf("descend failed, expected branch at pos: %d; got %s", pos, k.String())
+	}
+	kc, err := descend(p[1:], k.Kids, f)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	ret := Ops{}
+	ret.Retain(pos)
+	ret.With(kc)
+	ret.Retain(size - pos - 1)
+	return ret, nil
+}
+
+// InsertAt returns ops that insert body at p in d's current body; see
+// InsertAt.
+func (d *Doc) InsertAt(p Path, body Tree) (Ops, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return insertAt(p, body, d.body)
+}
+
+// DeleteAt returns ops that delete n kids at p in d's current body; see
+// DeleteAt.
+func (d *Doc) DeleteAt(p Path, n int) (Ops, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return deleteAt(p, n, d.body)
+}
+
+// EditAt returns ops that apply ops to the branch at p in d's current body;
+// see EditAt.
+func (d *Doc) EditAt(p Path, ops Ops) (Ops, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return editAt(p, ops, d.body)
+}
+
+// FormatAt returns ops that patch the attributes of n kids at p in d's
+// current body; see FormatAt.
+func (d *Doc) FormatAt(p Path, n int, attrs Attrs) (Ops, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return formatAt(p, n, attrs, d.body)
+}
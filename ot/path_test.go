@@ -0,0 +1,212 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot
+
+import (
+	"reflect"
+	"testing"
+)
+
+// para returns a paragraph: a branch holding the runes of s.
+func para(s string) Tree {
+	return AsRuneTree(s)
+}
+
+// paras returns a doc holding a list of paragraphs.
+func paras(ss ...string) *Doc {
+	ts := Trees{}
+	for _, s := range ss {
+		ts = append(ts, para(s))
+	}
+	d := NewDoc()
+	d.Apply(Ops{It(Branch(ts))})
+	return d
+}
+
+func samePars(t *testing.T, what string, d *Doc, ss ...string) {
+	expected := paras(ss...).Body()
+	if body := d.Body(); Hash(body) != Hash(expected) {
+		t.Fatalf("%s failed;\n\tgot: %s\n\texpected: %s", what, body.String(), expected.String())
+	}
+}
+
+func TestPathConstructors(t *testing.T) {
+	d := paras("ab", "cd")
+	// the doc holds a single list of paragraphs
+	list := Path{0}
+
+	cases := []struct {
+		Name string
+		Ops  func(d *Doc) (Ops, error)
+		Pars []string
+	}{
+		{"insert rune", func(d *Doc) (Ops, error) { return d.InsertAt(append(list, 1, 1), Leaf('x')) }, []string{"ab", "cxd"}},
+		{"insert text", func(d *Doc) (Ops, error) { return d.InsertAt(append(list, 0, 2), Text(AsRunes("yz"))) }, []string{"abyz", "cxd"}},
+		{"insert para", func(d *Doc) (Ops, error) { return d.InsertAt(append(list, 1), para("new")) }, []string{"abyz", "new", "cxd"}},
+		{"delete runes", func(d *Doc) (Ops, error) { return d.DeleteAt(append(list, 0, 1), 2) }, []string{"az", "new", "cxd"}},
+		{"delete paras", func(d *Doc) (Ops, error) { return d.DeleteAt(append(list, 0), 2) }, []string{"cxd"}},
+		{"edit para", func(d *Doc) (Ops, error) { return d.EditAt(append(list, 0), C(Rs(1), Ds(1), Is("!"), Rs(1))) }, []string{"c!d"}},
+	}
+
+	for _, c := range cases {
+		ops, err := c.Ops(d)
+		if err != nil {
+			t.Fatalf("%s failed, err: %q", c.Name, err)
+		}
+		if err := d.Validate(ops); err != nil {
+			t.Fatalf("%s produced invalid ops: %s, err: %q", c.Name, ops, err)
+		}
+		body := d.Body()
+		if err := d.Apply(ops); err != nil {
+			t.Fatalf("%s failed to apply ops: %s, err: %q", c.Name, ops, err)
+		}
+		samePars(t, c.Name, d, c.Pars...)
+
+		// the Tree forms agree with the Doc forms
+		var ops2 Ops
+		switch c.Name {
+		case "insert rune":
+			ops2, err = InsertAt(append(list, 1, 1), Leaf('x'), body)
+		case "delete paras":
+			ops2, err = DeleteAt(append(list, 0), 2, body)
+		case "edit para":
+			ops2, err = EditAt(append(list, 0), C(Rs(1), Ds(1), Is("!"), Rs(1)), body)
+		default:
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(ops, ops2) {
+			t.Fatalf("%s: tree form disagrees; got %s, expected %s, err: %q", c.Name, ops2, ops, err)
+		}
+	}
+
+	ops, _ := NewDoc().InsertAt(Path{0}, Leaf('a'))
+	if expected := C(Is("a")); !reflect.DeepEqual(ops, expected) {
+		t.Fatalf("expected top-level InsertAt to match NewInsert; got %s, expected %s", ops, expected)
+	}
+}
+
+func TestPathErrors(t *testing.T) {
+	d := paras("ab", "cd")
+
+	bad := map[string]func() (Ops, error){
+		"empty insert path": func() (Ops, error) { return d.InsertAt(nil, Leaf('x')) },
+		"empty delete path": func() (Ops, error) { return d.DeleteAt(Path{}, 1) },
+		"insert past end":   func() (Ops, error) { return d.InsertAt(Path{0, 1, 3}, Leaf('x')) },
+		"missing branch":    func() (Ops, error) { return d.InsertAt(Path{0, 2, 0}, Leaf('x')) },
+		"descend into rune": func() (Ops, error) { return d.InsertAt(Path{0, 1, 0, 0}, Leaf('x')) },
+		"delete past end":   func() (Ops, error) { return d.DeleteAt(Path{0, 0, 1}, 2) },
+		"negative delete":   func() (Ops, error) { return d.DeleteAt(Path{0, 0, 1}, -1) },
+		"bad edit":          func() (Ops, error) { return d.EditAt(Path{0, 0}, C(Rs(3))) },
+		"edit rune":         func() (Ops, error) { return d.EditAt(Path{0, 0, 0}, C(Rs(0))) },
+		"not a branch":      func() (Ops, error) { return InsertAt(Path{0}, Leaf('x'), Leaf('y')) },
+	}
+	for name, f := range bad {
+		if ops, err := f(); err == nil {
+			t.Errorf("%s: expected error, got ops: %s", name, ops)
+		}
+	}
+
+	// Apply refuses With ops past the end of a branch rather than descending
+	// into the branch itself.
+	body := Branch(Trees{para("ab")})
+	if err := Apply(W(C(Rs(1), Ws(C(Rs(1))))), &body); err == nil {
+		t.Fatalf("expected error applying with past end, got body: %s", body.String())
+	}
+}
+
+func TestPathCompose(t *testing.T) {
+	d := paras("ab", "cd")
+	d1 := d.Snapshot()
+
+	steps := []func(d *Doc) (Ops, error){
+		func(d *Doc) (Ops, error) { return d.InsertAt(Path{0, 1, 2}, Text(AsRunes("ef"))) },
+		func(d *Doc) (Ops, error) { return d.InsertAt(Path{0, 0}, para("xy")) },
+		func(d *Doc) (Ops, error) { return d.DeleteAt(Path{0, 1, 0}, 1) },
+		func(d *Doc) (Ops, error) { return d.EditAt(Path{0, 2}, C(Rs(2), Is("!"), Rs(2))) },
+	}
+
+	comp := Ops{}
+	for i, step := range steps {
+		ops, err := step(d1)
+		if err != nil {
+			t.Fatalf("step %d failed, err: %q", i, err)
+		}
+		if err := d1.Apply(ops); err != nil {
+			t.Fatalf("step %d failed to apply, err: %q", i, err)
+		}
+		if comp, err = Compose(comp, ops); err != nil {
+			t.Fatalf("step %d failed to compose, err: %q", i, err)
+		}
+	}
+
+	d2 := d.Snapshot()
+	if err := d2.Apply(comp); err != nil {
+		t.Fatalf("unable to apply composed ops: %s, err: %q", comp, err)
+	}
+	if Hash(d1.Body()) != Hash(d2.Body()) {
+		t.Fatalf("composed ops diverged;\n\tcomp: %s\n\td1: %s\n\td2: %s", comp, d1.String(), d2.String())
+	}
+	samePars(t, "compose", d1, "xy", "b", "cd!ef")
+}
+
+func TestPathTransform(t *testing.T) {
+	base := paras("ab", "cd", "ef")
+
+	edits := map[string]func(d *Doc) (Ops, error){
+		"insert in para 0":   func(d *Doc) (Ops, error) { return d.InsertAt(Path{0, 0, 1}, Leaf('x')) },
+		"insert in para 1":   func(d *Doc) (Ops, error) { return d.InsertAt(Path{0, 1, 1}, Leaf('y')) },
+		"append to para 1":   func(d *Doc) (Ops, error) { return d.InsertAt(Path{0, 1, 2}, Text(AsRunes("zz"))) },
+		"insert para":        func(d *Doc) (Ops, error) { return d.InsertAt(Path{0, 1}, para("new")) },
+		"delete para 1":      func(d *Doc) (Ops, error) { return d.DeleteAt(Path{0, 1}, 1) },
+		"delete in para 1":   func(d *Doc) (Ops, error) { return d.DeleteAt(Path{0, 1, 0}, 2) },
+		"format para 2":      func(d *Doc) (Ops, error) { return d.EditAt(Path{0, 2}, C(Rs(1), Fs(1, ital))) },
+		"retitle para 0":     func(d *Doc) (Ops, error) { return d.EditAt(Path{0, 0}, C(Ds(2), Is("AB"))) },
+		"delete all paras":   func(d *Doc) (Ops, error) { return d.DeleteAt(Path{0, 0}, 3) },
+		"insert before list": func(d *Doc) (Ops, error) { return d.InsertAt(Path{0}, para("top")) },
+	}
+
+	for na, fa := range edits {
+		for nb, fb := range edits {
+			a, err := fa(base)
+			if err != nil {
+				t.Fatalf("%s failed, err: %q", na, err)
+			}
+			b, err := fb(base)
+			if err != nil {
+				t.Fatalf("%s failed, err: %q", nb, err)
+			}
+			a1, b1, err := Transform(a, b)
+			if err != nil {
+				t.Fatalf("transform %s, %s failed, err: %q", na, nb, err)
+			}
+
+			d1, d2 := base.Snapshot(), base.Snapshot()
+			for _, os := range []Ops{a, b1} {
+				if err := d1.Apply(os); err != nil {
+					t.Fatalf("%s, %s: apply failed, os: %s, err: %q", na, nb, os, err)
+				}
+			}
+			for _, os := range []Ops{b, a1} {
+				if err := d2.Apply(os); err != nil {
+					t.Fatalf("%s, %s: apply failed, os: %s, err: %q", na, nb, os, err)
+				}
+			}
+			if Hash(d1.Body()) != Hash(d2.Body()) {
+				t.Fatalf("docs diverged, %s, %s;\n\td1: %s\n\td2: %s", na, nb, d1.String(), d2.String())
+			}
+		}
+	}
+
+	// concurrent edits to different paragraphs both survive
+	a, _ := base.InsertAt(Path{0, 0, 2}, Leaf('!'))
+	b, _ := base.DeleteAt(Path{0, 2, 0}, 1)
+	_, b1, err := Transform(a, b)
+	if err != nil {
+		t.Fatalf("transform failed, err: %q", err)
+	}
+	d := base.Snapshot()
+	d.Apply(a)
+	d.Apply(b1)
+	samePars(t, "transform", d, "ab!", "cd", "f")
+}
@@ -0,0 +1,205 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot
+
+import (
+	"sort"
+
+	"github.com/juju/errors"
+)
+
+// Span is the composition of a run of consecutive writes, together with what
+// TransformSpan needs to know about the sites that wrote them. The zero Span
+// is the empty run.
+type Span struct {
+	Ops         Ops
+	n           int    // the number of composed writes
+	first, last string // the least and greatest of their sites
+	moves       bool   // whether any of them moves kids
+}
+
+// NewSpan returns the span of the single write ops, written by site.
+func NewSpan(ops Ops, site string) Span {
+	return Span{Ops: ops, n: 1, first: site, last: site, moves: hasMoves(ops)}
+}
+
+// ComposeSpan returns the span of the writes all, written by sites.
+func ComposeSpan(all []Ops, sites []string) (Span, error) {
+	if len(all) != len(sites) {
+		return Span{}, errors.Errorf("ComposeSpan failed, %d writes but %d sites", len(all), len(sites))
+	}
+	ret := Span{}
+	for i, ops := range all {
+		var err error
+		ret, err = ret.Append(NewSpan(ops, sites[i]))
+		if err != nil {
+			return Span{}, errors.Annotatef(err, "ComposeSpan failed, write: %d", i)
+		}
+	}
+	return ret, nil
+}
+
+// Len returns the number of writes composed into s.
+func (s Span) Len() int {
+	return s.n
+}
+
+// Append returns the span of the writes of s followed by those of t.
+func (s Span) Append(t Span) (Span, error) {
+	switch {
+	case s.n == 0:
+		return t, nil
+	case t.n == 0:
+		return s, nil
+	}
+	ops, err := Compose(s.Ops, t.Ops)
+	if err != nil {
+		return Span{}, errors.Trace(err)
+	}
+	ret := Span{Ops: ops, n: s.n + t.n, first: s.first, last: s.last, moves: s.moves || t.moves}
+	if t.first < ret.first {
+		ret.first = t.first
+	}
+	if t.last > ret.last {
+		ret.last = t.last
+	}
+	return ret, nil
+}
+
+// TransformAll rebases the write client, written by site, over the writes
+// concurrent, written by sites, each of which applies to the result of the
+// writes before it. It returns (client', concurrent') such that
+// Compose(ComposeAll(concurrent), client') == Compose(client, concurrent'),
+// where client' is what rebasing client over each write in turn with
+// TransformSites gives.
+func TransformAll(client Ops, site string, concurrent []Ops, sites []string) (Ops, Ops, error) {
+	s, err := ComposeSpan(concurrent, sites)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	return TransformSpan(client, site, s, concurrent, sites)
+}
+
+// TransformSpan is like TransformAll, but takes s, the span of concurrent,
+// for callers that cache the composed writes of their history. When it can,
+// it rebases client over s in a single transform.
+//
+// SUBTLE: rebasing over a composition can order inserts differently than
+// rebasing over its parts. Over Compose([D1], [Ix]) = [Ix D1], [R1 Ia]
+// becomes [R1 Ia], but over [D1] and then [Ix], it becomes [Ia R1]: once
+// the kids between two inserts have been deleted, the inserts tie, and ties
+// are broken by site, write by write. So s is only used when s deletes none
+// of the kids next to client's inserts, or deleted along with them, and,
+// wherever s inserts where client does, site sorts before or after all of
+// the sites of s. Otherwise, and when kids are moved, client is rebased over
+// each write in turn.
+func TransformSpan(client Ops, site string, s Span, concurrent []Ops, sites []string) (Ops, Ops, error) {
+	if len(concurrent) != s.n || len(sites) != s.n {
+		return nil, nil, errors.Errorf("TransformSpan failed, span of %d writes but %d writes and %d sites", s.n, len(concurrent), len(sites))
+	}
+
+	aFirst := site <= s.first
+	if !s.moves && !hasMoves(client) && rebasable(client, s.Ops, aFirst || site > s.last) {
+		a, b, err := transform(client, s.Ops, aFirst)
+		return a, b, errors.Trace(err)
+	}
+
+	rest := Ops{}
+	for i, ops := range concurrent {
+		var ops2 Ops
+		var err error
+		client, ops2, err = TransformSites(client, ops, site, sites[i])
+		if err != nil {
+			return nil, nil, errors.Annotatef(err, "TransformSpan failed, write: %d", i)
+		}
+		rest, err = Compose(rest, ops2)
+		if err != nil {
+			return nil, nil, errors.Annotatef(err, "TransformSpan failed, write: %d", i)
+		}
+	}
+	return client, rest, nil
+}
+
+// rebasable reports whether rebasing client over comp, a composition of
+// writes, orders inserts as rebasing it over each of the writes in turn
+// would; see TransformSpan. tie reports whether all of the writes' sites
+// order client's inserts the same way against theirs.
+func rebasable(client, comp Ops, tie bool) bool {
+	// the positions of comp's deletes, inserts, and Withs in the tree that
+	// client and comp apply to
+	var dels [][2]int
+	var ins []int
+	withs := map[int]Ops{}
+	pos := 0
+	for i := range comp {
+		o := &comp[i]
+		switch {
+		case o.IsInsert():
+			ins = append(ins, pos)
+		case o.IsDelete():
+			dels = append(dels, [2]int{pos, pos + o.Len()})
+			pos += o.Len()
+		case o.IsWith():
+			withs[pos] = o.Kids
+			pos++
+		default:
+			pos += o.Len()
+		}
+	}
+	// deleted and inserted report whether comp deletes any of the kids, or
+	// inserts at any of the positions, in [from, to)
+	deleted := func(from, to int) bool {
+		k := sort.Search(len(dels), func(k int) bool { return dels[k][1] > from })
+		return k < len(dels) && dels[k][0] < to
+	}
+	inserted := func(from, to int) bool {
+		k := sort.SearchInts(ins, from)
+		return k < len(ins) && ins[k] < to
+	}
+
+	pos = 0
+	for i := 0; i < len(client); {
+		o := &client[i]
+		switch {
+		case o.IsInsert() || o.IsDelete():
+			// Normalize reorders runs of inserts and deletes, so the inserts
+			// of a run may end up anywhere in it
+			from, inserts := pos, false
+			for ; i < len(client) && (client[i].IsInsert() || client[i].IsDelete()); i++ {
+				if client[i].IsInsert() {
+					inserts = true
+				} else {
+					pos += client[i].Len()
+				}
+			}
+			if !inserts {
+				continue
+			}
+			if deleted(from-1, pos+1) || inserted(from, pos+1) && (pos > from || !tie) {
+				return false
+			}
+		case o.IsWith():
+			if kids, ok := withs[pos]; ok && !rebasable(o.Kids, kids, tie) {
+				return false
+			}
+			pos++
+			i++
+		default:
+			pos += o.Len()
+			i++
+		}
+	}
+	return true
+}
+
+// hasMoves reports whether os, or any of the ops nested in it, moves kids.
+func hasMoves(os Ops) bool {
+	for i := range os {
+		o := &os[i]
+		if o.IsMove() || o.IsPlace() || o.IsWith() && hasMoves(o.Kids) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot_test
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mstone/focus/ot"
+	"github.com/mstone/focus/ot/ottest"
+)
+
+// transformEach rebases client over each of concurrent in turn.
+func transformEach(client ot.Ops, site string, concurrent []ot.Ops, sites []string) (ot.Ops, error) {
+	for i, ops := range concurrent {
+		var err error
+		client, _, err = ot.TransformSites(client, ops, site, sites[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+func TestTransformAll(t *testing.T) {
+	// over [D1] and then [Ix], [R1 Ia] ties with x, so it may not be rebased
+	// over their composition, [Ix D1]
+	concurrent := []ot.Ops{ot.Ds(1), ot.Is("x")}
+	client := ot.C(ot.Rs(1), ot.Is("a"))
+	for _, site := range []string{"a", "c"} {
+		expected, err := transformEach(client, site, concurrent, []string{"b", "b"})
+		if err != nil {
+			t.Fatalf("transform failed, err: %q", err)
+		}
+		got, _, err := ot.TransformAll(client, site, concurrent, []string{"b", "b"})
+		if err != nil {
+			t.Fatalf("TransformAll failed, err: %q", err)
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("site %q: expected %s, got %s", site, expected, got)
+		}
+	}
+	comp, _ := ot.ComposeAll(concurrent)
+	if ot.Rebasable(client, comp, true) {
+		t.Errorf("expected %s not to be rebasable over %s", client, comp)
+	}
+
+	// inserts away from the concurrent writes are rebasable
+	concurrent = []ot.Ops{ot.C(ot.Rs(1), ot.Is("x"), ot.Rs(3)), ot.C(ot.Rs(3), ot.Ds(1), ot.Rs(1))}
+	comp, _ = ot.ComposeAll(concurrent)
+	if client := ot.C(ot.Rs(4), ot.Is("a")); !ot.Rebasable(client, comp, false) {
+		t.Errorf("expected %s to be rebasable over %s", client, comp)
+	}
+}
+
+// TestTransformAllRandom checks that TransformAll rebases writes exactly as
+// rebasing them over each concurrent write in turn does, and that its
+// results commute.
+func TestTransformAllRandom(t *testing.T) {
+	seed := time.Now().UnixNano()
+	r := rand.New(rand.NewSource(seed))
+	sites := []string{"1", "2", "3", "4"}
+	for _, c := range []ottest.Config{ottest.DefaultConfig, {MaxDepth: 3, MaxKids: 6}} {
+		for i := 0; i < 1000; i++ {
+			cs := c.Sequential(r, 1+r.Intn(4))
+			client := c.RandOps(r, cs.Doc)
+			site := sites[r.Intn(len(sites))]
+			ss := make([]string, len(cs.Ops))
+			for k := range ss {
+				ss[k] = sites[r.Intn(len(sites))]
+			}
+
+			expected, err := transformEach(client, site, cs.Ops, ss)
+			if err != nil {
+				t.Fatalf("transform failed, seed: %d, case: %s, err: %q", seed, cs, err)
+			}
+			client1, concurrent1, err := ot.TransformAll(client, site, cs.Ops, ss)
+			if err != nil {
+				t.Fatalf("TransformAll failed, seed: %d, case: %s, client: %s, err: %q", seed, cs, client, err)
+			}
+			if (len(client1) > 0 || len(expected) > 0) && !reflect.DeepEqual(client1, expected) {
+				t.Fatalf("TransformAll mismatch, seed: %d, case: %s, client: %s, site: %s, sites: %v;\n\tgot: %s\n\texpected: %s", seed, cs, client, site, ss, client1, expected)
+			}
+
+			comp, err := ot.ComposeAll(cs.Ops)
+			if err != nil {
+				t.Fatalf("compose failed, seed: %d, case: %s, err: %q", seed, cs, err)
+			}
+			x, err1 := ottest.ApplyOps(cs.Doc, comp)
+			if err1 == nil {
+				x, err1 = ottest.ApplyOps(x, client1)
+			}
+			y, err2 := ottest.ApplyOps(cs.Doc, client)
+			if err2 == nil {
+				y, err2 = ottest.ApplyOps(y, concurrent1)
+			}
+			if err1 != nil || err2 != nil {
+				t.Fatalf("apply failed, seed: %d, case: %s, client: %s, err1: %q, err2: %q", seed, cs, client, err1, err2)
+			}
+			if ot.Hash(x) != ot.Hash(y) {
+				t.Fatalf("TransformAll results don't commute, seed: %d, case: %s, client: %s;\n\tclient': %s\n\tconcurrent': %s", seed, cs, client, client1, concurrent1)
+			}
+		}
+	}
+}
+
+// typing returns a doc of size runes and n writes that type a rune apiece
+// into its middle, and a write, concurrent with them, at its end.
+func typing(size, n int) (ot.Ops, []ot.Ops, []string) {
+	concurrent := make([]ot.Ops, n)
+	sites := make([]string, n)
+	for i := range concurrent {
+		concurrent[i] = ot.C(ot.Rs(size/2+i), ot.Is("x"), ot.Rs(size-size/2))
+		sites[i] = "b"
+	}
+	return ot.C(ot.Rs(size), ot.Is("a")), concurrent, sites
+}
+
+func BenchmarkTransformSpan(b *testing.B) {
+	client, concurrent, sites := typing(1<<10, 1<<10)
+	s, err := ot.ComposeSpan(concurrent, sites)
+	if err != nil {
+		b.Fatalf("compose failed, err: %q", err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ot.TransformSpan(client, "a", s, concurrent, sites)
+	}
+}
+
+func BenchmarkTransformEach(b *testing.B) {
+	client, concurrent, sites := typing(1<<10, 1<<10)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		transformEach(client, "a", concurrent, sites)
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot
+
+import (
+	"github.com/juju/errors"
+)
+
+// The recursive implementations of compose1, transform1, and Normalize that
+// the current iterative versions replaced, kept as references for the
+// equivalence tests in iter_test.go.
+
+func composeRec(as, bs Ops) (Ops, error) {
+	cs, err := compose1Rec(as, bs)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return normalizeRec(cs)
+}
+
+func transformRec(as, bs Ops) (Ops, Ops, error) {
+	if bs.Empty() {
+		return as.Clone(), bs.Clone(), nil
+	}
+	r1, r2, err := transform1Rec(as, bs)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	if r1, err = normalizeRec(r1); err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	if r2, err = normalizeRec(r2); err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	return r1, r2, nil
+}
+
+func addDeleteOpRec(d Op, os Ops) Ops {
+	if len(os) > 0 && os.First().IsInsert() {
+		ret := Ops{}
+		ret = append(ret, os.First().Clone())
+		ret = append(ret, addDeleteOpRec(d, os.Rest())...)
+		return ret
+	} else {
+		ret := Ops{}
+		ret = append(ret, d)
+		ret = append(ret, os...)
+		return ret
+	}
+}
+
+func compose1Rec(as, bs Ops) (Ops, error) {
+	ret := Ops{}
+	rest := Ops{}
+	hcs := Ops{}
+	oc := Op{}
+	var err error
+	var sa, sb Op
+
+	a := 0
+	b := 0
+	la := len(as)
+	lb := len(bs)
+
+	switch {
+	case a == la && b == lb:
+		break
+	case la > 0 && as[a].IsZero():
+		ret, err = compose1Rec(as[a+1:], bs)
+	case lb > 0 && bs[b].IsZero():
+		ret, err = compose1Rec(as, bs[b+1:])
+	case a == la:
+		ret = bs.Clone()
+	case b == lb:
+		ret = as.Clone()
+	case la > 0 && as[a].IsDelete():
+		// run insertions, then delete, then apply remaining effects
+		rest, err = compose1Rec(as[a+1:], bs)
+		if err != nil {
+			break
+		}
+		ret = addDeleteOpRec(as[a].Clone(), rest)
+	case lb > 0 && bs[b].IsInsert():
+		// as[a] is insert, retain, or empty so insert then apply remaining effects
+		rest, err = compose1Rec(as, bs[b+1:])
+		if err != nil {
+			break
+		}
+		ret = append(ret, bs[b].Clone())
+		ret = append(ret, rest...)
+	case la > 0 && lb > 0:
+		// do as much as we can, then recurse in a new hypothetical world
+		oa := as[a]
+		ob := bs[b]
+
+		sa, sb, err = shortenOps(oa, ob)
+		if err != nil {
+			break
+		}
+
+		has := Ops{}
+		has = append(has, sa)
+		has = append(has, as[a+1:]...)
+
+		hbs := Ops{}
+		hbs = append(hbs, sb)
+		hbs = append(hbs, bs[b+1:]...)
+
+		minlen := min(oa.Len(), ob.Len())
+		switch {
+		case oa.IsRetain() && ob.IsRetain():
+			ret = append(ret, F(minlen, oa.Attrs.Compose(ob.Attrs)))
+		case oa.IsRetain() && ob.IsDelete():
+			ret = append(ret, D(minlen))
+		case oa.IsRetain() && ob.IsWith():
+			ret = append(ret, Wf(ob.Kids.Clone(), oa.Attrs.Compose(ob.Attrs)))
+		case oa.IsInsert() && ob.IsRetain():
+			oc, _, err = oa.SplitAt(minlen)
+			if err != nil {
+				err = errors.Trace(err)
+				break
+			}
+			oc.Body = oc.Body.WithAttrs(oc.Body.Attrs.Apply(ob.Attrs))
+			ret = append(ret, oc)
+		case oa.IsInsert() && ob.IsDelete():
+			// insertion then deletion cancels
+		case oa.IsInsert() && ob.IsWith():
+			oc, _, err = oa.SplitAt(minlen)
+			if err != nil {
+				err = errors.Trace(err)
+				break
+			}
+			ta := oc.Body.Clone()
+			err = Apply(ob, &ta)
+			if err != nil {
+				err = errors.Trace(err)
+				break
+			}
+			ret = append(ret, It(ta))
+		case oa.IsWith() && ob.IsWith():
+			kc := Ops{}
+			kc, err = composeRec(oa.Kids, ob.Kids)
+			if err != nil {
+				err = errors.Trace(err)
+				break
+			}
+			ret = append(ret, Wf(kc, oa.Attrs.Compose(ob.Attrs)))
+		case oa.IsWith() && ob.IsRetain():
+			ret = append(ret, Wf(oa.Kids.Clone(), oa.Attrs.Compose(ob.Attrs)))
+		case oa.IsWith() && ob.IsDelete():
+			ret = append(ret, D(minlen))
+		default:
+			err = errors.Errorf("compose1 error: impossible case\n\tas: %s\n\tbs: %s", as.String(), bs.String())
+		}
+		if err != nil {
+			break
+		}
+
+		hcs, err = compose1Rec(has, hbs)
+		if err != nil {
+			break
+		}
+
+		ret = append(ret, hcs...)
+	}
+	return ret, errors.Trace(err)
+}
+
+func transform1Rec(as, bs Ops) (Ops, Ops, error) {
+	a := 0
+	b := 0
+
+	var ra, rb, sa, sb Ops
+	var ta, tb Op
+
+	la := len(as)
+	lb := len(bs)
+
+	var err error
+
+	switch {
+	case a == la && b == lb:
+		break
+	case la > 0 && as.First().IsZero():
+		sa, sb, err = transform1Rec(as[a+1:], bs)
+	case lb > 0 && bs.First().IsZero():
+		sa, sb, err = transform1Rec(as, bs[b+1:])
+	case la > 0 && as.First().IsInsert():
+		oa := as.First()
+		ra.Insert(oa.Body)
+		rb.Retain(oa.Len())
+		sa, sb, err = transform1Rec(as[a+1:], bs)
+		if err != nil {
+			err = errors.Trace(err)
+			break
+		}
+	case lb > 0 && bs.First().IsInsert():
+		ob := bs.First()
+		ra.Retain(ob.Len())
+		rb.Insert(ob.Body)
+		sa, sb, err = transform1Rec(as, bs[b+1:])
+		if err != nil {
+			err = errors.Trace(err)
+			break
+		}
+	case la > 0 && lb > 0:
+		oa := as.First()
+		ob := bs.First()
+		minlen := min(oa.Len(), ob.Len())
+		ta, tb, err = shortenOps(*oa, *ob)
+		if err != nil {
+			err = errors.Trace(err)
+			break
+		}
+
+		has := Ops{}
+		if !ta.IsZero() {
+			has = append(has, ta)
+		}
+		has = append(has, as[a+1:]...)
+
+		hbs := Ops{}
+		if !tb.IsZero() {
+			hbs = append(hbs, tb)
+		}
+		hbs = append(hbs, bs[b+1:]...)
+
+		switch {
+		case oa.IsRetain() && ob.IsRetain():
+			ra.Format(minlen, oa.Attrs)
+			rb.Format(minlen, oa.Attrs.Transform(ob.Attrs))
+		case oa.IsWith() && ob.IsWith():
+			var ka, kb Ops
+			ka, kb, err = transformRec(oa.Kids, ob.Kids)
+			if err != nil {
+				err = errors.Trace(err)
+				break
+			}
+			ra.FormatWith(ka, oa.Attrs)
+			rb.FormatWith(kb, oa.Attrs.Transform(ob.Attrs))
+		case oa.IsRetain() && ob.IsWith():
+			ra.Format(minlen, oa.Attrs)
+			rb.FormatWith(ob.Kids, oa.Attrs.Transform(ob.Attrs))
+		case oa.IsWith() && ob.IsRetain():
+			ra.FormatWith(oa.Kids, oa.Attrs)
+			rb.Format(minlen, oa.Attrs.Transform(ob.Attrs))
+		case oa.IsDelete() && ob.IsDelete():
+			// No action required; both sides have already D(minlen)
+		case oa.IsDelete() && ob.IsRetain():
+			fallthrough
+		case oa.IsDelete() && ob.IsWith():
+			ra.Delete(minlen)
+		case oa.IsRetain() && ob.IsDelete():
+			fallthrough
+		case oa.IsWith() && ob.IsDelete():
+			rb.Delete(minlen)
+		}
+		sa, sb, err = transform1Rec(has, hbs)
+		if err != nil {
+			err = errors.Trace(err)
+			break
+		}
+	default:
+		err = errors.Errorf("transform failed, as: %s, bs: %s", as.String(), bs.String())
+		if err != nil {
+			break
+		}
+	}
+
+	ret1 := append(ra, sa...)
+	ret2 := append(rb, sb...)
+	if err != nil {
+		err = errors.Annotatef(err, "transform failed, as: %s, bs: %s", as.String(), bs.String())
+	}
+	return ret1, ret2, errors.Trace(err)
+}
+
+func normalizeRec(os Ops) (Ops, error) {
+	swap := func(a, b *Op) {
+		*a, *b = *b, *a
+	}
+
+	ret := os.Clone()
+
+	for i := 0; i < len(ret)-1; i++ {
+		if ret[i].IsDelete() && ret[i+1].IsInsert() {
+			swap(&ret[i], &ret[i+1])
+		}
+	}
+
+	ret2 := Ops{}
+	for _, o := range ret {
+		switch {
+		case o.IsZero():
+			continue
+		case o.IsInsert():
+			ret2.Insert(o.Body)
+		case o.IsDelete():
+			ret2.Delete(o.Size)
+		case o.IsRetain():
+			ret2.Format(o.Size, o.Attrs)
+		case o.IsWith():
+			ret2.FormatWith(o.Kids, o.Attrs)
+		default:
+			return nil, errors.Errorf("normalize got bad op: %s", o.String())
+		}
+	}
+
+	return ret2, nil
+}
@@ -0,0 +1,293 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot
+
+import (
+	"github.com/juju/errors"
+
+	"github.com/mstone/focus/ot/textpos"
+)
+
+// ropeChunk is the number of positions below which adjacent rope leaves are
+// merged into a single leaf.
+const ropeChunk = 512
+
+// rope is a persistent, height-balanced sequence of kids. Leaves hold packed
+// chunks of kids; interior nodes hold the concatenation of their children.
+//
+// Ropes are never modified once built, so splitting and joining them shares
+// all untouched structure with the originals and old versions cost nothing to
+// keep. The nil rope is empty.
+//
+// Each rope also counts the lines and UTF-16 code units of its kids so that
+// Doc can convert between positions and lines and columns without scanning;
+// see package textpos.
+type rope struct {
+	left, right *rope
+	kids        Trees
+	size        int
+	depth       int
+	counts      textpos.Counts
+}
+
+func ropeLeaf(kids Trees) *rope {
+	return ropeLeafPacked(kids.pack())
+}
+
+// ropeLeafPacked is like ropeLeaf but takes ownership of kids, which must
+// already be packed. Kids longer than ropeChunk are split across several
+// leaves so that counting them need only be done once.
+func ropeLeafPacked(kids Trees) *rope {
+	n := kids.Len()
+	switch {
+	case n == 0:
+		return nil
+	case n > ropeChunk:
+		l, r := kids.splitAt(n / 2)
+		return ropeNode(ropeLeafPacked(l), ropeLeafPacked(r))
+	}
+	counts := textpos.Counts{}
+	for _, k := range kids {
+		counts = counts.Add(k.counts())
+	}
+	return &rope{
+		kids:   kids,
+		size:   n,
+		depth:  1,
+		counts: counts,
+	}
+}
+
+func ropeNode(l, r *rope) *rope {
+	switch {
+	case l == nil:
+		return r
+	case r == nil:
+		return l
+	}
+	d := l.depth
+	if r.depth > d {
+		d = r.depth
+	}
+	return &rope{
+		left:   l,
+		right:  r,
+		size:   l.size + r.size,
+		depth:  d + 1,
+		counts: l.counts.Add(r.counts),
+	}
+}
+
+func (r *rope) Len() int {
+	if r == nil {
+		return 0
+	}
+	return r.size
+}
+
+// Counts returns the counts of all of r's kids.
+func (r *rope) Counts() textpos.Counts {
+	if r == nil {
+		return textpos.Counts{}
+	}
+	return r.counts
+}
+
+// prefix returns the counts of the longest prefix of r whose measure in unit u
+// is at most n; see textpos.Text.
+func (r *rope) prefix(u textpos.Unit, n int) textpos.Counts {
+	ret := textpos.Counts{}
+	for r != nil && !r.isLeaf() {
+		if l := ret.Add(r.left.Counts()); l.In(u) <= n {
+			ret = l
+			r = r.right
+		} else {
+			r = r.left
+		}
+	}
+	if r == nil {
+		return ret
+	}
+	for _, k := range r.kids {
+		if next := ret.Add(k.counts()); next.In(u) <= n {
+			ret = next
+			continue
+		}
+		if !k.IsText() {
+			break
+		}
+		for _, c := range k.Text {
+			next := ret.Add(textpos.OfRune(c))
+			if next.In(u) > n {
+				break
+			}
+			ret = next
+		}
+		break
+	}
+	return ret
+}
+
+func (r *rope) height() int {
+	if r == nil {
+		return 0
+	}
+	return r.depth
+}
+
+func (r *rope) isLeaf() bool {
+	return r != nil && r.left == nil && r.right == nil
+}
+
+// balanceRope joins l and r, whose heights differ by at most two, with a
+// single or double rotation if necessary.
+func balanceRope(l, r *rope) *rope {
+	switch {
+	case l.height() > r.height()+1:
+		if l.left.height() >= l.right.height() {
+			return ropeNode(l.left, ropeNode(l.right, r))
+		}
+		return ropeNode(ropeNode(l.left, l.right.left), ropeNode(l.right.right, r))
+	case r.height() > l.height()+1:
+		if r.right.height() >= r.left.height() {
+			return ropeNode(ropeNode(l, r.left), r.right)
+		}
+		return ropeNode(ropeNode(l, r.left.left), ropeNode(r.left.right, r.right))
+	default:
+		return ropeNode(l, r)
+	}
+}
+
+// joinRopes returns the concatenation of a and b.
+//
+// SUBTLE: small leaves are pushed down the spine of the other rope so that
+// they can merge with its outermost leaf; otherwise, a long run of one-rune
+// edits would leave behind one leaf per rune.
+func joinRopes(a, b *rope) *rope {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	case a.isLeaf() && b.isLeaf() && a.size+b.size <= ropeChunk:
+		kids := make(Trees, 0, len(a.kids)+len(b.kids))
+		kids = append(kids, a.kids...)
+		kids = append(kids, b.kids...)
+		return ropeLeaf(kids)
+	case a.height() > b.height()+1, !a.isLeaf() && b.isLeaf() && b.size < ropeChunk:
+		return balanceRope(a.left, joinRopes(a.right, b))
+	case b.height() > a.height()+1, a.isLeaf() && !b.isLeaf() && a.size < ropeChunk:
+		return balanceRope(joinRopes(a, b.left), b.right)
+	default:
+		return ropeNode(a, b)
+	}
+}
+
+// splitRope splits r into its first n positions and the rest.
+func splitRope(r *rope, n int) (*rope, *rope) {
+	switch {
+	case r == nil:
+		return nil, nil
+	case n <= 0:
+		return nil, r
+	case n >= r.size:
+		return r, nil
+	case r.isLeaf():
+		l, rr := r.kids.splitAt(n)
+		return ropeLeafPacked(l), ropeLeafPacked(rr)
+	case n <= r.left.size:
+		ll, lr := splitRope(r.left, n)
+		return ll, joinRopes(lr, r.right)
+	default:
+		rl, rr := splitRope(r.right, n-r.left.size)
+		return joinRopes(r.left, rl), rr
+	}
+}
+
+// appendTo appends the kids of r to ts.
+func (r *rope) appendTo(ts Trees) Trees {
+	switch {
+	case r == nil:
+		return ts
+	case r.isLeaf():
+		return append(ts, r.kids...)
+	default:
+		return r.right.appendTo(r.left.appendTo(ts))
+	}
+}
+
+// Trees returns the kids held by r. The kids are shared with r and must not be
+// modified.
+func (r *rope) Trees() Trees {
+	return r.appendTo(make(Trees, 0, r.Len()))
+}
+
+func (r *rope) String() string {
+	return r.Trees().String()
+}
+
+// slice returns the kids of r between positions i and j.
+func (r *rope) slice(i, j int) Trees {
+	_, rr := splitRope(r, i)
+	rr, _ = splitRope(rr, j-i)
+	return rr.Trees()
+}
+
+// apply returns the rope that results from applying os to r.
+func (r *rope) apply(os Ops) (*rope, error) {
+	var ret *rope
+	rest := r
+
+	mv, err := moved(os, r, true)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	for _, o := range os {
+		switch {
+		case o.IsZero():
+			continue
+		case o.IsInsert():
+			ret = joinRopes(ret, ropeLeaf(Trees{o.Body.Clone()}))
+		case o.IsPlace():
+			k, ok := mv[o.Id]
+			if !ok {
+				return nil, errors.Errorf("rope.apply failed, place without move; o: %s", o.String())
+			}
+			delete(mv, o.Id)
+			ret = joinRopes(ret, ropeLeaf(Trees{k}))
+		case o.IsRetain(), o.IsDelete(), o.IsMove():
+			if o.Len() > rest.Len() {
+				return nil, errors.Errorf("rope.apply failed, op past end; o: %s, rest: %d", o.String(), rest.Len())
+			}
+			var l *rope
+			l, rest = splitRope(rest, o.Len())
+			switch {
+			case o.IsRetain() && len(o.Attrs) > 0:
+				ret = joinRopes(ret, ropeLeaf(l.Trees().format(o.Attrs)))
+			case o.IsRetain():
+				ret = joinRopes(ret, l)
+			}
+		case o.IsWith():
+			if rest.Len() == 0 {
+				return nil, errors.Errorf("rope.apply failed, with past end; o: %s", o.String())
+			}
+			var l *rope
+			l, rest = splitRope(rest, 1)
+			k := l.Trees()[0].Clone()
+			err := Apply(o, &k)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			ret = joinRopes(ret, ropeLeafPacked(Trees{k}))
+		default:
+			return nil, errors.Errorf("rope.apply failed, bad op: %s", o.String())
+		}
+	}
+
+	if len(mv) > 0 {
+		return nil, errors.Errorf("rope.apply failed, move without place; os: %s", os.String())
+	}
+	return joinRopes(ret, rest), nil
+}
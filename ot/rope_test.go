@@ -0,0 +1,195 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/mstone/focus/ot/textpos"
+)
+
+// checkRope checks that r is height-balanced and that its cached sizes,
+// depths, and counts are correct.
+func checkRope(t *testing.T, r *rope) {
+	switch {
+	case r == nil:
+		return
+	case r.isLeaf():
+		if r.size != r.kids.Len() || r.size == 0 || r.depth != 1 {
+			t.Fatalf("bad rope leaf; size: %d, depth: %d, kids: %s", r.size, r.depth, r.kids.String())
+		}
+		counts := textpos.Counts{}
+		for _, k := range r.kids {
+			counts = counts.Add(k.counts())
+		}
+		if r.counts != counts {
+			t.Fatalf("bad rope leaf counts; counts: %+v, expected: %+v, kids: %s", r.counts, counts, r.kids.String())
+		}
+	default:
+		checkRope(t, r.left)
+		checkRope(t, r.right)
+		lh, rh := r.left.height(), r.right.height()
+		if lh-rh > 1 || rh-lh > 1 {
+			t.Fatalf("unbalanced rope; left: %d, right: %d", lh, rh)
+		}
+		if lh < rh {
+			lh = rh
+		}
+		if r.size != r.left.Len()+r.right.Len() || r.depth != 1+lh {
+			t.Fatalf("bad rope node; size: %d, depth: %d", r.size, r.depth)
+		}
+		if r.counts != r.left.Counts().Add(r.right.Counts()) {
+			t.Fatalf("bad rope node counts; counts: %+v", r.counts)
+		}
+	}
+}
+
+func TestRopeSplitJoin(t *testing.T) {
+	s := strings.Repeat("abcdefghij", 200)
+	var r *rope
+	for _, c := range s {
+		r = joinRopes(r, ropeLeaf(Trees{Leaf(c)}))
+	}
+	checkRope(t, r)
+	if r.Len() != len(s) || AsString(Branch(r.Trees()).Kids[0].Runes()) != s {
+		t.Fatalf("bad join; got len %d", r.Len())
+	}
+
+	for _, n := range []int{0, 1, 7, ropeChunk, len(s) / 2, len(s) - 1, len(s)} {
+		a, b := splitRope(r, n)
+		checkRope(t, a)
+		checkRope(t, b)
+		if a.Len() != n || b.Len() != len(s)-n {
+			t.Fatalf("bad split at %d; got %d, %d", n, a.Len(), b.Len())
+		}
+		c := joinRopes(a, b)
+		checkRope(t, c)
+		if c.String() != r.String() {
+			t.Fatalf("bad rejoin at %d", n)
+		}
+	}
+}
+
+func TestRopeApply(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		d := NewDoc()
+		body := Branch(nil)
+		for j := 0; j < 50; j++ {
+			ops := d.GetRandomOps(1 + rand.Intn(20))
+			if rand.Intn(4) == 0 && d.Len() > 0 {
+				// occasionally insert and then edit a nested branch
+				pos := rand.Intn(d.Len())
+				ops = C(Rs(pos), Ops{It(Branch(Trees{Text(AsRunes("xyz"))}))}, Rs(d.Len()-pos))
+				err := d.Apply(ops)
+				if err != nil {
+					t.Fatalf("Apply fail, ops: %s, err: %q", ops, err)
+				}
+				err = Apply(W(ops), &body)
+				if err != nil {
+					t.Fatalf("Tree Apply fail, ops: %s, err: %q", ops, err)
+				}
+				ops = C(Rs(pos), Ws(C(Rs(1), Ds(1), Rs(1))), Rs(d.Len()-pos-1))
+			}
+			err := d.Apply(ops)
+			if err != nil {
+				t.Fatalf("Apply fail, ops: %s, err: %q", ops, err)
+			}
+			err = Apply(W(ops), &body)
+			if err != nil {
+				t.Fatalf("Tree Apply fail, ops: %s, err: %q", ops, err)
+			}
+			checkRope(t, d.body)
+			if d.String() != body.String() {
+				t.Fatalf("rope and tree disagree; ops: %s\n\trope: %s\n\ttree: %s", ops, d.String(), body.String())
+			}
+		}
+	}
+}
+
+func TestDocSnapshot(t *testing.T) {
+	d := NewDoc()
+	d.Apply(C(Is("hello")))
+	s := d.Snapshot()
+	d.Apply(C(Rs(5), Is(" world")))
+	s.Apply(C(Ds(1), Is("j"), Rs(4)))
+
+	if AsString(d.Body().Kids[0].Runes()) != "hello world" {
+		t.Fatalf("bad doc; got %s", d.String())
+	}
+	if AsString(s.Body().Kids[0].Runes()) != "jello" {
+		t.Fatalf("bad snapshot; got %s", s.String())
+	}
+}
+
+func TestDocApplyPastEnd(t *testing.T) {
+	d := NewDoc()
+	d.Apply(C(Is("ab")))
+	for _, ops := range []Ops{Rs(3), Ds(3), C(Rs(2), Ws(nil))} {
+		if err := d.Apply(ops); err == nil {
+			t.Fatalf("expected error applying %s to %s", ops, d.String())
+		}
+	}
+	if d.String() != "[a b]" {
+		t.Fatalf("failed apply modified doc; got %s", d.String())
+	}
+}
+
+func newBenchDoc(size int) *Doc {
+	d := NewDoc()
+	d.Apply(C(Is(strings.Repeat("abcdefghijklmnopqrstuvwxyz\n", size/27+1)[:size])))
+	return d
+}
+
+// benchmarkDocTyping applies b.N runs of one-rune edits at random
+// positions to a document of the given size.
+func benchmarkDocTyping(b *testing.B, size, edits int, keep bool) {
+	r := rand.New(rand.NewSource(0))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		d := newBenchDoc(size)
+		var versions []*Doc
+		b.StartTimer()
+		for j := 0; j < edits; j++ {
+			n := d.Len()
+			pos := r.Intn(n)
+			if j%4 == 3 {
+				d.Apply(C(Rs(pos), Ds(1), Rs(n-pos-1)))
+			} else {
+				d.Apply(C(Rs(pos), Is("x"), Rs(n-pos)))
+			}
+			if keep {
+				versions = append(versions, d.Snapshot())
+			}
+		}
+	}
+}
+
+func BenchmarkDocTyping(b *testing.B) {
+	for _, size := range []int{1 << 10, 1 << 20} {
+		for _, edits := range []int{1000, 10000} {
+			b.Run(fmt.Sprintf("size=%d/edits=%d", size, edits), func(b *testing.B) {
+				benchmarkDocTyping(b, size, edits, false)
+			})
+		}
+	}
+}
+
+func BenchmarkDocTypingVersions(b *testing.B) {
+	benchmarkDocTyping(b, 1<<20, 10000, true)
+}
+
+func BenchmarkDocApplyPaste(b *testing.B) {
+	d := newBenchDoc(1 << 20)
+	paste := strings.Repeat("pasted text ", 1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s := d.Snapshot()
+		s.Apply(C(Rs(1<<19), Is(paste), Rs(1<<19)))
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot_test
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/mstone/focus/ot"
+	"github.com/mstone/focus/ot/ottest"
+)
+
+func TestTransformSites(t *testing.T) {
+	x, y := ot.Is("x"), ot.Is("y")
+	cases := []struct {
+		SiteX, SiteY string
+		Expected     string
+	}{
+		{"a", "b", "[x y]"},
+		{"b", "a", "[y x]"},
+		{"a", "a", "[x y]"}, // x is as
+	}
+	for _, c := range cases {
+		x1, y1, err := ot.TransformSites(x, y, c.SiteX, c.SiteY)
+		if err != nil {
+			t.Fatalf("transform failed, err: %q", err)
+		}
+		d1, d2 := ot.NewDoc(), ot.NewDoc()
+		if err := d1.Apply(x); err != nil {
+			t.Fatalf("apply failed, err: %q", err)
+		}
+		if err := d1.Apply(y1); err != nil {
+			t.Fatalf("apply failed, err: %q", err)
+		}
+		if err := d2.Apply(y); err != nil {
+			t.Fatalf("apply failed, err: %q", err)
+		}
+		if err := d2.Apply(x1); err != nil {
+			t.Fatalf("apply failed, err: %q", err)
+		}
+		if d1.String() != c.Expected || d2.String() != c.Expected {
+			t.Fatalf("sites %q, %q: expected %s, got %s and %s", c.SiteX, c.SiteY, c.Expected, d1.String(), d2.String())
+		}
+	}
+}
+
+// TestTransformSitesSymmetric checks that the order of the arguments of
+// TransformSites does not matter. Since conflicting attribute patches and
+// moves are resolved in favor of as, they are not generated.
+func TestTransformSitesSymmetric(t *testing.T) {
+	seed := time.Now().UnixNano()
+	r := rand.New(rand.NewSource(seed))
+	c := ottest.Config{MaxDepth: 3, MaxKids: 6}
+	for i := 0; i < 2000; i++ {
+		cs := c.Concurrent(r, 2)
+		a, b := cs.Ops[0], cs.Ops[1]
+		_, b1, err := ot.TransformSites(a, b, "1", "2")
+		if err != nil {
+			t.Fatalf("transform failed, seed: %d, case: %s, err: %q", seed, cs, err)
+		}
+		b2, _, err := ot.TransformSites(b, a, "2", "1")
+		if err != nil {
+			t.Fatalf("transform failed, seed: %d, case: %s, err: %q", seed, cs, err)
+		}
+		x, err := ottest.ApplyOps(cs.Doc, a)
+		if err != nil {
+			t.Fatalf("apply failed, seed: %d, case: %s, err: %q", seed, cs, err)
+		}
+		y1, err1 := ottest.ApplyOps(x, b1)
+		y2, err2 := ottest.ApplyOps(x, b2)
+		if err1 != nil || err2 != nil {
+			t.Fatalf("apply failed, seed: %d, case: %s, err1: %q, err2: %q", seed, cs, err1, err2)
+		}
+		if ot.Hash(y1) != ot.Hash(y2) {
+			t.Fatalf("argument order matters, seed: %d, case: %s;\n\tb': %s\n\tb'': %s", seed, cs, b1, b2)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/juju/errors"
+)
+
+// controllerState is the serialized form of a Controller; see MarshalState.
+type controllerState struct {
+	State     State
+	ServerRev int
+	ServerDoc Tree
+	ClientDoc Tree
+	First     Ops   `json:",omitempty"`
+	Rest      []Ops `json:",omitempty"` // composed on restore
+	Undo      []Ops `json:",omitempty"`
+	Redo      []Ops `json:",omitempty"`
+	Base      *Tree `json:",omitempty"` // while recovering
+	Seq       int   `json:",omitempty"`
+}
+
+// A StateStore saves the serialized state of a Controller so that it can be
+// restored, with its pending writes, after the client restarts.
+type StateStore interface {
+	// SaveState replaces the saved state with state.
+	SaveState(state []byte) error
+	// LoadState returns the saved state, or nil if there is none.
+	LoadState() ([]byte, error)
+}
+
+// AttachStore makes c save its state to store whenever it changes. Errors
+// from store are returned by the Controller method that changed the state
+// but do not break c.
+func (c *Controller) AttachStore(store StateStore) {
+	c.store = store
+}
+
+// save saves c's state to c's store, if any.
+func (c *Controller) save() error {
+	if c.store == nil {
+		return nil
+	}
+	state, err := c.MarshalState()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(c.store.SaveState(state))
+}
+
+// MarshalState returns the serialized state of c: the server doc and its
+// revision, the client doc, the pending writes, and the undo and redo
+// stacks. See RestoreController.
+func (c *Controller) MarshalState() ([]byte, error) {
+	cs := controllerState{
+		State:     c.state,
+		ServerRev: c.serverRev,
+		ServerDoc: c.serverDoc.Body(),
+		ClientDoc: c.clientDoc.Body(),
+		First:     c.first,
+		Undo:      c.undo,
+		Redo:      c.redo,
+		Seq:       c.seq,
+	}
+	if c.rest != nil {
+		cs.Rest = []Ops{c.rest}
+	}
+	if c.base != nil {
+		base := c.base.Body()
+		cs.Base = &base
+	}
+	bs, err := json.Marshal(cs)
+	return bs, errors.Trace(err)
+}
+
+// RestoreController returns a Controller with the serialized state, as
+// returned by MarshalState, that sends to sender and delivers to receiver.
+// Since receiver is taken to start out empty, the client doc is first
+// delivered to it as a single insert.
+//
+// Writes that were pending when state was saved are resubmitted, against the
+// saved server revision, by Resubmit once the client has reopened the doc at
+// ServerRev(). Controllers that were broken when saved are restored broken,
+// ready to Recover.
+func RestoreController(state []byte, sender Sender, receiver Receiver) (*Controller, error) {
+	cs := controllerState{}
+	if err := json.Unmarshal(state, &cs); err != nil {
+		return nil, errors.Annotatef(err, "RestoreController failed, bad state")
+	}
+	if !cs.ServerDoc.IsBranch() || !cs.ClientDoc.IsBranch() {
+		return nil, errors.Errorf("RestoreController failed, missing docs")
+	}
+	switch cs.State {
+	case CS_SYNCED, CS_WAIT_ONE, CS_WAIT_MANY, CS_BROKEN, CS_RECOVERING, CS_HOLDING:
+	default:
+		return nil, errors.Errorf("RestoreController failed, bad state: %d", cs.State)
+	}
+
+	c := NewController(sender, receiver)
+	c.state = cs.State
+	c.serverRev = cs.ServerRev
+	c.first = cs.First
+	c.undo = cs.Undo
+	c.redo = cs.Redo
+	c.seq = cs.Seq
+
+	var err error
+	if len(cs.Rest) > 0 {
+		if c.rest, err = ComposeAll(cs.Rest); err != nil {
+			return nil, errors.Annotatef(err, "RestoreController failed, bad pending writes")
+		}
+	}
+	if c.serverDoc, _, err = docOf(cs.ServerDoc); err != nil {
+		return nil, errors.Annotatef(err, "RestoreController failed, bad server doc")
+	}
+	var ins Ops
+	if c.clientDoc, ins, err = docOf(cs.ClientDoc); err != nil {
+		return nil, errors.Annotatef(err, "RestoreController failed, bad client doc")
+	}
+	switch c.state {
+	case CS_BROKEN:
+		c.err = errors.Errorf("controller was broken when saved")
+	case CS_RECOVERING:
+		if cs.Base == nil || !cs.Base.IsBranch() {
+			return nil, errors.Errorf("RestoreController failed, missing base doc")
+		}
+		if c.base, _, err = docOf(*cs.Base); err != nil {
+			return nil, errors.Annotatef(err, "RestoreController failed, bad base doc")
+		}
+	}
+
+	if len(ins) > 0 {
+		c.client.Recv(ins)
+	}
+	return c, nil
+}
+
+// docOf returns a Doc holding the kids of the branch t together with the ops
+// that insert them into an empty doc.
+func docOf(t Tree) (*Doc, Ops, error) {
+	ins := Ops{}
+	for _, k := range t.Kids {
+		ins.Insert(k)
+	}
+	d := NewDoc()
+	if err := d.Apply(ins); err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	return d, ins, nil
+}
+
+// Resubmit resends c's pending write, if any, with its seq, against c's
+// server revision; clients call it after reopening the doc at ServerRev()
+// with Seq(), e.g., following a reconnect or a RestoreController. If the
+// server applied the write before the connection dropped but the ack was
+// lost, the server acks the write among those that catch the client up and
+// ignores the resubmitted copy. Held writes are sent at once.
+func (c *Controller) Resubmit() {
+	switch c.state {
+	case CS_WAIT_ONE, CS_WAIT_MANY:
+		c.conn.Send(c.serverRev, c.hash(), c.seq, c.first)
+	case CS_HOLDING:
+		c.Flush()
+	}
+}
+
+// FileStore is a StateStore that saves state to the file at its path.
+type FileStore string
+
+// SaveState writes state to a temporary file beside the store's file and
+// then renames it into place so that a crash never leaves a partial state.
+func (f FileStore) SaveState(state []byte) error {
+	path := string(f)
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err := tmp.Write(state); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return errors.Trace(err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.Rename(tmp.Name(), path))
+}
+
+func (f FileStore) LoadState() ([]byte, error) {
+	state, err := ioutil.ReadFile(string(f))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return state, errors.Trace(err)
+}
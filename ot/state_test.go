@@ -0,0 +1,226 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestControllerState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "focus-state")
+	if err != nil {
+		t.Fatalf("unable to make temp dir, err: %q", err)
+	}
+	defer os.RemoveAll(dir)
+	store := FileStore(filepath.Join(dir, "state.json"))
+
+	if state, err := store.LoadState(); err != nil || state != nil {
+		t.Fatalf("expected no saved state, got: %q, err: %q", state, err)
+	}
+
+	c := &testClient{doc: NewDoc()}
+	st := NewController(c, c)
+	st.AttachStore(store)
+
+	// "ab" is acked at rev 1; "c" and "d" are written while offline
+	c.write(st, NewInsert(0, 0, "ab"))
+	if err := st.OnServerAck(1, NewInsert(0, 0, "ab")); err != nil {
+		t.Fatalf("ack failed, err: %q", err)
+	}
+	c.write(st, NewInsert(2, 2, "c"))
+	c.write(st, NewInsert(3, 3, "d"))
+
+	// a reloaded client starts out empty and receives the saved client doc
+	saved, err := store.LoadState()
+	if err != nil {
+		t.Fatalf("load failed, err: %q", err)
+	}
+	c2 := &testClient{doc: NewDoc()}
+	st2, err := RestoreController(saved, c2, c2)
+	if err != nil {
+		t.Fatalf("restore failed, err: %q", err)
+	}
+	st2.AttachStore(store)
+	if c2.doc.String() != "[a b c d]" || st2.ServerRev() != 1 || st2.IsSynchronized() {
+		t.Fatalf("unexpected restored controller: %s, doc: %s", st2, c2.doc.String())
+	}
+	if !st2.CanUndo() {
+		t.Fatalf("expected restored undo stack")
+	}
+
+	// on reconnect, the pending write is resubmitted against rev 1
+	st2.Resubmit()
+	if len(c2.sent) != 1 || c2.sent[0].Rev != 1 || !reflect.DeepEqual(c2.sent[0].Ops, C(Rs(2), Is("c"))) {
+		t.Fatalf("unexpected resubmit: %v", c2.sent)
+	}
+	if err := st2.OnServerWrite(2, "", NewInsert(2, 0, "x")); err != nil {
+		t.Fatalf("write failed, err: %q", err)
+	}
+	if err := st2.OnServerAck(3, NewInsert(3, 3, "c")); err != nil {
+		t.Fatalf("ack failed, err: %q", err)
+	}
+	if err := st2.OnServerAck(4, c2.lastSent(t)); err != nil {
+		t.Fatalf("ack failed, err: %q", err)
+	}
+	if c2.doc.String() != "[x a b c d]" || !st2.IsSynchronized() {
+		t.Fatalf("unexpected controller: %s, doc: %s", st2, c2.doc.String())
+	}
+
+	// the store follows the controller
+	saved, _ = store.LoadState()
+	c3 := &testClient{doc: NewDoc()}
+	st3, err := RestoreController(saved, c3, c3)
+	if err != nil {
+		t.Fatalf("restore failed, err: %q", err)
+	}
+	if c3.doc.String() != "[x a b c d]" || st3.ServerRev() != 4 || !st3.IsSynchronized() {
+		t.Fatalf("unexpected restored controller: %s, doc: %s", st3, c3.doc.String())
+	}
+
+	for _, bad := range []string{``, `{}`, `{"State":9,"ServerDoc":[],"ClientDoc":[]}`} {
+		if _, err := RestoreController([]byte(bad), c3, c3); err == nil {
+			t.Fatalf("expected restore of %q to fail", bad)
+		}
+	}
+}
+
+func TestControllerStateHolding(t *testing.T) {
+	c := &testClient{doc: NewDoc()}
+	st := NewController(c, c)
+	st.AttachWindow(time.Second, nil)
+	c.write(st, NewInsert(0, 0, "ab"))
+
+	// held writes are sent once the client reopens the doc
+	saved, err := st.MarshalState()
+	if err != nil {
+		t.Fatalf("marshal failed, err: %q", err)
+	}
+	c2 := &testClient{doc: NewDoc()}
+	st2, err := RestoreController(saved, c2, c2)
+	if err != nil {
+		t.Fatalf("restore failed, err: %q", err)
+	}
+	st2.Resubmit()
+	if len(c2.sent) != 1 || !reflect.DeepEqual(c2.sent[0].Ops, C(Is("ab"))) {
+		t.Fatalf("unexpected resubmit: %v", c2.sent)
+	}
+
+	// states saved with a list of queued writes are restored composed
+	saved, err = json.Marshal(controllerState{
+		State:     CS_WAIT_MANY,
+		ServerDoc: Branch(nil),
+		ClientDoc: Branch(Trees{Text([]rune("abc"))}),
+		First:     C(Is("a")),
+		Rest:      []Ops{C(Rs(1), Is("b")), C(Rs(2), Is("c"))},
+	})
+	if err != nil {
+		t.Fatalf("marshal failed, err: %q", err)
+	}
+	c3 := &testClient{doc: NewDoc()}
+	st3, err := RestoreController(saved, c3, c3)
+	if err != nil {
+		t.Fatalf("restore failed, err: %q", err)
+	}
+	if err := st3.OnServerAck(1, C(Is("a"))); err != nil {
+		t.Fatalf("ack failed, err: %q", err)
+	}
+	if !reflect.DeepEqual(c3.lastSent(t), C(Rs(1), Is("bc"))) {
+		t.Fatalf("expected composed send, got %v", c3.sent)
+	}
+}
+
+func TestControllerStateRecovering(t *testing.T) {
+	c := &testClient{doc: NewDoc()}
+	st := NewController(c, c)
+	c.write(st, NewInsert(0, 0, "ab"))
+	if err := st.OnServerAck(1, NewInsert(0, 0, "ab")); err != nil {
+		t.Fatalf("ack failed, err: %q", err)
+	}
+	st.Recover()
+	c.write(st, NewInsert(2, 2, "c"))
+
+	// a client reloaded while recovering recovers again on startup, keeping
+	// the saved base, so only "c" is replayed
+	saved, err := st.MarshalState()
+	if err != nil {
+		t.Fatalf("marshal failed, err: %q", err)
+	}
+	c2 := &testClient{doc: NewDoc()}
+	st2, err := RestoreController(saved, c2, c2)
+	if err != nil {
+		t.Fatalf("restore failed, err: %q", err)
+	}
+	if !st2.IsBroken() {
+		t.Fatalf("expected recovering controller, got: %s", st2)
+	}
+	st2.Recover()
+	if err := st2.OnServerWrite(1, "", NewInsert(0, 0, "ab")); err != nil {
+		t.Fatalf("reopen failed, err: %q", err)
+	}
+	if c2.doc.String() != "[a b c]" {
+		t.Fatalf("expected [a b c], got %s", c2.doc.String())
+	}
+	if len(c2.sent) != 1 || !reflect.DeepEqual(c2.sent[0].Ops, C(Rs(2), Is("c"))) {
+		t.Fatalf("unexpected replay: %v", c2.sent)
+	}
+}
+
+func TestControllerSeq(t *testing.T) {
+	c := &testClient{doc: NewDoc()}
+	st := NewController(c, c)
+	if st.Seq() != 0 {
+		t.Fatalf("expected no pending seq, got %d", st.Seq())
+	}
+
+	// writes are numbered as they are sent
+	c.write(st, NewInsert(0, 0, "a"))
+	c.write(st, NewInsert(1, 1, "b"))
+	if c.sent[0].Seq != 1 || st.Seq() != 1 {
+		t.Fatalf("expected seq 1, sent: %v, pending: %d", c.sent, st.Seq())
+	}
+	if err := st.OnServerAck(1, NewInsert(0, 0, "a")); err != nil {
+		t.Fatalf("ack failed, err: %q", err)
+	}
+	if c.sent[1].Seq != 2 || st.Seq() != 2 {
+		t.Fatalf("expected seq 2, sent: %v, pending: %d", c.sent, st.Seq())
+	}
+
+	// a restored controller resubmits its pending write with the same seq
+	// and numbers later writes after it
+	saved, err := st.MarshalState()
+	if err != nil {
+		t.Fatalf("marshal failed, err: %q", err)
+	}
+	c2 := &testClient{doc: NewDoc()}
+	st2, err := RestoreController(saved, c2, c2)
+	if err != nil {
+		t.Fatalf("restore failed, err: %q", err)
+	}
+	if st2.Seq() != 2 {
+		t.Fatalf("expected restored seq 2, got %d", st2.Seq())
+	}
+	st2.Resubmit()
+	if len(c2.sent) != 1 || c2.sent[0].Seq != 2 {
+		t.Fatalf("unexpected resubmit: %v", c2.sent)
+	}
+
+	// if the server already applied the write, it acks the write while
+	// catching the client up, so the client ends up with a single copy
+	if err := st2.OnServerAck(2, NewInsert(1, 1, "b")); err != nil {
+		t.Fatalf("ack failed, err: %q", err)
+	}
+	if c2.doc.String() != "[a b]" || !st2.IsSynchronized() || st2.Seq() != 0 {
+		t.Fatalf("unexpected controller: %s, doc: %s", st2, c2.doc.String())
+	}
+	c2.write(st2, NewInsert(2, 2, "c"))
+	if c2.sent[len(c2.sent)-1].Seq != 3 {
+		t.Fatalf("expected seq 3, sent: %v", c2.sent)
+	}
+}
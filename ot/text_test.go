@@ -0,0 +1,141 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTextRuns(t *testing.T) {
+	root := AsRuneTree("hello")
+	if len(root.Kids) != 1 || !root.Kids[0].IsText() {
+		t.Fatalf("expected a single text run; got %#v", root)
+	}
+	if root.Kids.Len() != 5 {
+		t.Fatalf("expected len 5; got %d", root.Kids.Len())
+	}
+
+	// insert in the middle of a run
+	d := NewDoc()
+	d.Apply(C(Is("hello")))
+	d.Apply(C(Rs(2), Is("XY"), Rs(3)))
+	if AsString(d.Body().Kids[0].Runes()) != "heXYllo" || len(d.Body().Kids) != 1 {
+		t.Fatalf("bad insert; got %s", d.String())
+	}
+
+	// delete across the boundary between a run and a branch
+	d.Apply(C(Rs(7), Ops{It(Branch(nil))}, Is("world")))
+	if d.Len() != 13 || len(d.Body().Kids) != 3 {
+		t.Fatalf("bad branch insert; got %s", d.String())
+	}
+	d.Apply(C(Rs(5), Ds(4), Rs(4)))
+	if d.Len() != 9 || len(d.Body().Kids) != 1 || AsString(d.Body().Kids[0].Runes()) != "heXYlorld" {
+		t.Fatalf("bad delete; got %s", d.String())
+	}
+
+	// with into a branch that sits between runs
+	d = NewDoc()
+	d.Apply(C(Is("ab"), Ops{It(Branch(Trees{Text(AsRunes("xyz"))}))}, Is("cd")))
+	d.Apply(C(Rs(2), Ws(C(Rs(1), Ds(1), Rs(1))), Rs(2)))
+	if d.String() != "[a b [x z] c d]" {
+		t.Fatalf("bad with; got %s", d.String())
+	}
+}
+
+func TestTextSplitAt(t *testing.T) {
+	tx := Text(AsRunes("abc"))
+	l, r, err := tx.SplitAt(1)
+	if err != nil {
+		t.Fatalf("unable to split text, err: %q", err)
+	}
+	if !l.IsLeaf() || l.Leaf != 'a' || !r.IsText() || AsString(r.Text) != "bc" {
+		t.Fatalf("bad text split; l: %s, r: %s", l.String(), r.String())
+	}
+
+	b := Branch(Trees{Leaf('a'), Text(AsRunes("bcd")), Branch(nil), Leaf('e')})
+	if len(b.Kids) != 3 || b.Kids.Len() != 6 {
+		t.Fatalf("expected packed kids; got %#v", b.Kids)
+	}
+	l, r, err = b.SplitAt(2)
+	if err != nil {
+		t.Fatalf("unable to split branch, err: %q", err)
+	}
+	if l.String() != "[a b]" || r.String() != "[c d [] e]" {
+		t.Fatalf("bad branch split; l: %s, r: %s", l.String(), r.String())
+	}
+
+	_, _, err = tx.SplitAt(4)
+	if err == nil {
+		t.Fatalf("expected error splitting past end of text")
+	}
+}
+
+func TestTextZipper(t *testing.T) {
+	root := Branch(Trees{Text(AsRunes("ab")), Branch(nil), Text(AsRunes("cd"))})
+	z := NewZipper(&root, 0, 10)
+
+	z.Skip(1)
+	if !z.Current().IsText() || !z.CanSkip(3) || z.CanSkip(4) {
+		t.Fatalf("bad zipper at 1; current: %s", z.Current().String())
+	}
+
+	z.Skip(1)
+	if !z.Current().IsBranch() {
+		t.Fatalf("expected branch at 2; current: %s", z.Current().String())
+	}
+
+	z.Insert(Leaf('x'))
+	z.Delete(1)
+	z.Skip(1)
+	z.Delete(1)
+	if root.String() != "[a b [] d]" {
+		t.Fatalf("bad zipper edits; root: %s", root.String())
+	}
+}
+
+func TestTextCompose(t *testing.T) {
+	a := C(Is("hello"))
+	b := C(Rs(1), Ds(3), Is("ipp"), Rs(1))
+	c, err := Compose(a, b)
+	if err != nil {
+		t.Fatalf("unable to compose, err: %q", err)
+	}
+	if !reflect.DeepEqual(c, C(Is("hippo"))) {
+		t.Fatalf("bad compose; got %s", c.String())
+	}
+
+	a = C(Rs(2), Is("ab"), Rs(2))
+	b = C(Rs(1), Is("xy"), Rs(3))
+	a1, b1, err := Transform(a, b)
+	if err != nil {
+		t.Fatalf("unable to transform, err: %q", err)
+	}
+	d1, d2 := NewDoc(), NewDoc()
+	d1.Apply(C(Is("1234")))
+	d2.Apply(C(Is("1234")))
+	d1.Apply(a)
+	d1.Apply(b1)
+	d2.Apply(b)
+	d2.Apply(a1)
+	if d1.String() != d2.String() {
+		t.Fatalf("bad transform; d1: %s, d2: %s", d1.String(), d2.String())
+	}
+}
+
+func benchmarkDocEdits(b *testing.B, size int) {
+	d := NewDoc()
+	d.Apply(C(Is(strings.Repeat("x", size))))
+	r := rand.New(rand.NewSource(0))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		n := r.Intn(d.Len())
+		d.Apply(C(Rs(n), Is("y"), Rs(d.Len()-n)))
+	}
+}
+
+func BenchmarkDocEdits1K(b *testing.B)   { benchmarkDocEdits(b, 1<<10) }
+func BenchmarkDocEdits100K(b *testing.B) { benchmarkDocEdits(b, 100<<10) }
@@ -0,0 +1,154 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+// Package textpos converts between the ways of locating a position in text:
+// rune offsets, which ops count in; lines and columns; and UTF-16 offsets,
+// which ACE and browsers count in.
+package textpos
+
+import (
+	"unicode/utf16"
+)
+
+// Unit is a unit in which Counts measure text.
+type Unit int
+
+const (
+	U_RUNES Unit = iota
+	U_UTF16
+	U_LINES
+)
+
+// Counts measures a span of text.
+type Counts struct {
+	Runes int // positions, as counted by ops
+	UTF16 int // UTF-16 code units
+	Lines int // newlines
+}
+
+// Of returns the counts of rs.
+func Of(rs []rune) Counts {
+	c := Counts{}
+	for _, r := range rs {
+		c = c.Add(OfRune(r))
+	}
+	return c
+}
+
+// OfRune returns the counts of the single rune r.
+func OfRune(r rune) Counts {
+	c := Counts{Runes: 1, UTF16: 1}
+	if utf16.IsSurrogate(r) {
+		return c
+	}
+	if r >= 0x10000 && r <= 0x10ffff {
+		c.UTF16 = 2
+	}
+	if r == '\n' {
+		c.Lines = 1
+	}
+	return c
+}
+
+func (c Counts) Add(d Counts) Counts {
+	return Counts{
+		Runes: c.Runes + d.Runes,
+		UTF16: c.UTF16 + d.UTF16,
+		Lines: c.Lines + d.Lines,
+	}
+}
+
+// In returns the measure of c in unit u.
+func (c Counts) In(u Unit) int {
+	switch u {
+	case U_UTF16:
+		return c.UTF16
+	case U_LINES:
+		return c.Lines
+	default:
+		return c.Runes
+	}
+}
+
+// Text is text that can be measured in each Unit, like *ot.Doc, whose line
+// index answers in time logarithmic in the size of the doc.
+type Text interface {
+	// Prefix returns the counts of the longest prefix of the text whose
+	// measure in unit u is at most n.
+	Prefix(u Unit, n int) Counts
+}
+
+// Runes is a Text held in a slice, which it measures by scanning.
+type Runes []rune
+
+func (rs Runes) Prefix(u Unit, n int) Counts {
+	c := Counts{}
+	for _, r := range rs {
+		next := c.Add(OfRune(r))
+		if next.In(u) > n {
+			break
+		}
+		c = next
+	}
+	return c
+}
+
+// Pos locates a position by its line and column, both counted from 0.
+// Columns count UTF-16 code units, as ACE's do.
+type Pos struct {
+	Line, Col int
+}
+
+// lineStart returns the counts of the text before line.
+func lineStart(t Text, line int) Counts {
+	if line <= 0 {
+		return Counts{}
+	}
+	c := t.Prefix(U_LINES, line-1)
+	if c.Lines < line-1 {
+		return c
+	}
+	// step over the newline that ends line-1, if any
+	next := t.Prefix(U_RUNES, c.Runes+1)
+	if next.Lines < line {
+		return c
+	}
+	return next
+}
+
+// ToPos returns the line and column of the rune offset off in t.
+func ToPos(t Text, off int) Pos {
+	c := t.Prefix(U_RUNES, off)
+	return Pos{
+		Line: c.Lines,
+		Col:  c.UTF16 - lineStart(t, c.Lines).UTF16,
+	}
+}
+
+// FromPos returns the rune offset of p in t. Lines past the end of t clamp to
+// the end of t, columns past the end of their line clamp to its end, and
+// columns that split a surrogate pair round down.
+func FromPos(t Text, p Pos) int {
+	start := lineStart(t, p.Line)
+	end := t.Prefix(U_LINES, start.Lines)
+	col := p.Col
+	if col < 0 {
+		col = 0
+	}
+	u := start.UTF16 + col
+	if u > end.UTF16 {
+		u = end.UTF16
+	}
+	return t.Prefix(U_UTF16, u).Runes
+}
+
+// ToUTF16 returns the UTF-16 offset of the rune offset off in t.
+func ToUTF16(t Text, off int) int {
+	return t.Prefix(U_RUNES, off).UTF16
+}
+
+// FromUTF16 returns the rune offset of the UTF-16 offset u in t, rounding
+// down offsets that split a surrogate pair.
+func FromUTF16(t Text, u int) int {
+	return t.Prefix(U_UTF16, u).Runes
+}
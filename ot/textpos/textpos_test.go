@@ -0,0 +1,118 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package textpos_test
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/mstone/focus/ot"
+	"github.com/mstone/focus/ot/textpos"
+)
+
+func TestConvert(t *testing.T) {
+	pos := func(line, col int) textpos.Pos { return textpos.Pos{Line: line, Col: col} }
+	// 😀 takes two UTF-16 code units
+	text := textpos.Runes("ab\n😀c\n\nd")
+	cases := []struct {
+		Off   int
+		Pos   textpos.Pos
+		UTF16 int
+	}{
+		{0, pos(0, 0), 0},
+		{2, pos(0, 2), 2},
+		{3, pos(1, 0), 3},
+		{4, pos(1, 2), 5},
+		{5, pos(1, 3), 6},
+		{6, pos(2, 0), 7},
+		{7, pos(3, 0), 8},
+		{8, pos(3, 1), 9},
+	}
+	for _, c := range cases {
+		if p := textpos.ToPos(text, c.Off); p != c.Pos {
+			t.Errorf("ToPos(%d): expected %+v, got %+v", c.Off, c.Pos, p)
+		}
+		if off := textpos.FromPos(text, c.Pos); off != c.Off {
+			t.Errorf("FromPos(%+v): expected %d, got %d", c.Pos, c.Off, off)
+		}
+		if u := textpos.ToUTF16(text, c.Off); u != c.UTF16 {
+			t.Errorf("ToUTF16(%d): expected %d, got %d", c.Off, c.UTF16, u)
+		}
+		if off := textpos.FromUTF16(text, c.UTF16); off != c.Off {
+			t.Errorf("FromUTF16(%d): expected %d, got %d", c.UTF16, c.Off, off)
+		}
+	}
+
+	clamped := []struct {
+		Pos textpos.Pos
+		Off int
+	}{
+		{pos(0, 10), 2}, // past the end of the line
+		{pos(1, 1), 3},  // within a surrogate pair
+		{pos(9, 0), 8},  // past the last line
+		{pos(0, -1), 0},
+	}
+	for _, c := range clamped {
+		if off := textpos.FromPos(text, c.Pos); off != c.Off {
+			t.Errorf("FromPos(%+v): expected %d, got %d", c.Pos, c.Off, off)
+		}
+	}
+	if off := textpos.FromUTF16(text, 4); off != 3 {
+		t.Errorf("FromUTF16(4): expected 3, got %d", off)
+	}
+}
+
+// TestDoc checks that the line index of ot.Doc agrees with scanning the doc's
+// text as it is edited.
+func TestDoc(t *testing.T) {
+	seed := time.Now().UnixNano()
+	r := rand.New(rand.NewSource(seed))
+	alphabet := []rune("ab\n\n😀é")
+
+	doc := ot.NewDoc()
+	text := []rune{}
+	for i := 0; i < 300; i++ {
+		var ops ot.Ops
+		if n := len(text); n > 0 && r.Intn(3) == 0 {
+			pos := r.Intn(n)
+			del := 1 + r.Intn(n-pos)
+			if del > 50 {
+				del = 50
+			}
+			ops = ot.NewDelete(n, pos, del)
+			text = append(text[:pos:pos], text[pos+del:]...)
+		} else {
+			ins := make([]rune, 1+r.Intn(700))
+			for j := range ins {
+				ins[j] = alphabet[r.Intn(len(alphabet))]
+			}
+			pos := r.Intn(n + 1)
+			ops = ot.NewInsert(n, pos, string(ins))
+			text = append(text[:pos:pos], append(ins, text[pos:]...)...)
+		}
+		if err := doc.Apply(ops); err != nil {
+			t.Fatalf("apply failed, seed: %d, ops: %s, err: %q", seed, ops, err)
+		}
+
+		scan := textpos.Runes(text)
+		if c := doc.Counts(); c != textpos.Of(text) {
+			t.Fatalf("bad counts, seed: %d, expected: %+v, got: %+v", seed, textpos.Of(text), c)
+		}
+		for j := 0; j < 20; j++ {
+			u := textpos.Unit(r.Intn(3))
+			n := r.Intn(len(text) + 2)
+			if got, want := doc.Prefix(u, n), scan.Prefix(u, n); got != want {
+				t.Fatalf("bad prefix, seed: %d, unit: %d, n: %d, expected: %+v, got: %+v", seed, u, n, want, got)
+			}
+			off := r.Intn(len(text) + 1)
+			if got, want := textpos.ToPos(doc, off), textpos.ToPos(scan, off); got != want {
+				t.Fatalf("bad pos, seed: %d, off: %d, expected: %+v, got: %+v", seed, off, want, got)
+			}
+			if back := textpos.FromPos(doc, textpos.ToPos(doc, off)); back != off {
+				t.Fatalf("pos did not round-trip, seed: %d, off: %d, got: %d", seed, off, back)
+			}
+		}
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"strings"
 
 	"github.com/juju/errors"
+
+	"github.com/mstone/focus/ot/textpos"
 )
 
 type TreeTag int
@@ -16,21 +18,47 @@ const (
 	T_NIL TreeTag = iota
 	T_LEAF
 	T_BRANCH
+	T_TEXT
+)
+
+// Node types for typed branches in structured documents. Any string may be
+// used as a node type; these are the ones that focus's editors understand.
+const (
+	N_PARAGRAPH = "paragraph"
+	N_HEADING   = "heading"
+	N_LIST      = "list"
+	N_LIST_ITEM = "list-item"
+	N_TABLE     = "table"
+	N_ROW       = "row"
+	N_CELL      = "cell"
 )
 
 type Tree struct {
 	Tag  TreeTag
 	Leaf rune
+	// Text holds the runes of a T_TEXT run. Text runs act like a sequence of
+	// leaves, one per rune; their runes are shared between clones and must
+	// never be modified in place.
+	Text []rune
 	Kids Trees
+	// Attrs holds the tree's rich-text attributes, if any.
+	Attrs Attrs
+	// Type holds the node type of a typed branch, like N_PARAGRAPH or
+	// N_CELL, or "" for plain branches. Unlike Attrs, types cannot be changed
+	// by ops: to change a node's type, replace the node.
+	Type string
 }
 
 type Trees []Tree
 
 func (t Tree) Clone() Tree {
 	return Tree{
-		Tag:  t.Tag,
-		Leaf: t.Leaf,
-		Kids: t.Kids.Clone(),
+		Tag:   t.Tag,
+		Leaf:  t.Leaf,
+		Text:  t.Text,
+		Kids:  t.Kids.Clone(),
+		Attrs: t.Attrs,
+		Type:  t.Type,
 	}
 }
 
@@ -49,15 +77,36 @@ func (t *Tree) String() string {
 	switch {
 	case t == nil:
 		return "nil"
+	case len(t.Attrs) > 0:
+		t2 := *t
+		t2.Attrs = nil
+		return t2.String() + t.Attrs.String()
 	case t.Tag == T_LEAF:
-		return AsString([]rune{t.Leaf})
+		return leafString(t.Leaf)
+	case t.Tag == T_TEXT:
+		ks := make([]string, len(t.Text))
+		for k, v := range t.Text {
+			ks[k] = leafString(v)
+		}
+		return strings.Join(ks, " ")
 	case t.Tag == T_BRANCH:
-		return t.Kids.String()
+		return t.Type + t.Kids.String()
 	default:
 		panic(errors.Errorf("String(): tree with unknown tag, t: %#v", t))
 	}
 }
 
+// leafString prints a leaf, escaping the runes that would otherwise read as
+// the brackets of a branch, the quotes of a text insert, or an escape, so
+// that ParseOps can tell them apart.
+func leafString(r rune) string {
+	switch r {
+	case '[', ']', '"', '\\':
+		return `\` + string(r)
+	}
+	return AsString([]rune{r})
+}
+
 func (ts Trees) String() string {
 	ks := make([]string, len(ts))
 	for k, v := range ts {
@@ -73,6 +122,9 @@ func (t Tree) Len() int {
 	case t.Tag == T_LEAF:
 		// return len(t.Body)
 		return 1
+	case t.Tag == T_TEXT:
+		// SUBTLE: text runs take up 1 space per rune, just like the leaves they stand for.
+		return len(t.Text)
 	case t.Tag == T_BRANCH:
 		// SUBTLE(mistone): Tree nodes take up 1 space for transformation purposes -- they act like individual letters from a countably infinite alphabet, which can be recursed into.
 		return 1
@@ -81,8 +133,23 @@ func (t Tree) Len() int {
 	}
 }
 
+// counts returns the counts of t as a kid of a textual doc: its runes, if
+// any, and otherwise a single UTF-16 code unit.
+func (t Tree) counts() textpos.Counts {
+	switch t.Tag {
+	case T_LEAF:
+		return textpos.OfRune(t.Leaf)
+	case T_TEXT:
+		return textpos.Of(t.Text)
+	case T_BRANCH:
+		return textpos.Counts{Runes: 1, UTF16: 1}
+	default:
+		return textpos.Counts{}
+	}
+}
+
 func (t *Tree) IsZero() bool {
-	return t.Tag == T_NIL && t.Leaf == 0 && t.Kids == nil
+	return t.Tag == T_NIL && t.Leaf == 0 && t.Text == nil && t.Kids == nil && t.Attrs == nil && t.Type == ""
 }
 
 func (t *Tree) IsLeaf() bool {
@@ -92,6 +159,13 @@ func (t *Tree) IsLeaf() bool {
 	return t.Tag == T_LEAF
 }
 
+func (t *Tree) IsText() bool {
+	if t == nil {
+		return false
+	}
+	return t.Tag == T_TEXT
+}
+
 func (t *Tree) IsBranch() bool {
 	if t == nil {
 		return false
@@ -99,6 +173,23 @@ func (t *Tree) IsBranch() bool {
 	return t.Tag == T_BRANCH
 }
 
+// HasRunes reports whether t is a leaf or a text run.
+func (t *Tree) HasRunes() bool {
+	return t.IsLeaf() || t.IsText()
+}
+
+// Runes returns the runes of a leaf or text run, or nil for other trees.
+func (t *Tree) Runes() []rune {
+	switch {
+	case t.IsLeaf():
+		return []rune{t.Leaf}
+	case t.IsText():
+		return t.Text
+	default:
+		return nil
+	}
+}
+
 func Zt() Tree {
 	return Tree{}
 }
@@ -110,15 +201,62 @@ func Leaf(leaf rune) Tree {
 	}
 }
 
+// WithAttrs returns a copy of t whose attributes are attrs, less any keys with
+// empty values.
+func (t Tree) WithAttrs(attrs Attrs) Tree {
+	t.Attrs = Attrs(nil).Apply(attrs)
+	return t
+}
+
+// Text returns a text run holding a copy of rs. Empty runs are nil trees and
+// single-rune runs are leaves.
+func Text(rs []rune) Tree {
+	return text(CloneRunes(rs))
+}
+
+// text is like Text but takes ownership of rs.
+func text(rs []rune) Tree {
+	switch len(rs) {
+	case 0:
+		return Zt()
+	case 1:
+		return Leaf(rs[0])
+	default:
+		return Tree{
+			Tag:  T_TEXT,
+			Text: rs[:len(rs):len(rs)],
+		}
+	}
+}
+
+// Branch returns a branch holding a copy of kids, with adjacent leaves and
+// text runs merged into maximal text runs.
 func Branch(kids Trees) Tree {
 	return Tree{
 		Tag:  T_BRANCH,
-		Kids: kids.Clone(),
+		Kids: kids.Clone().pack(),
 	}
 }
 
+// Node returns a typed branch of type typ with attributes attrs holding a
+// copy of kids; see Branch.
+func Node(typ string, attrs Attrs, kids Trees) Tree {
+	return Branch(kids).WithAttrs(attrs).WithType(typ)
+}
+
+// WithType returns a copy of t whose node type is typ.
+func (t Tree) WithType(typ string) Tree {
+	t.Type = typ
+	return t
+}
+
+// Len returns the tree-len of ts; i.e., the number of positions that ts occupies.
 func (ts Trees) Len() int {
-	return len(ts)
+	n := 0
+	for k := range ts {
+		n += ts[k].Len()
+	}
+	return n
 }
 
 func (ts Trees) First() *Tree {
@@ -137,10 +275,105 @@ func (ts Trees) Empty() bool {
 	return len(ts) == 0
 }
 
+// pack returns ts with nil trees dropped and with each group of adjacent
+// leaves and text runs with equal attributes merged into a single text run.
+// Trees are not cloned.
+func (ts Trees) pack() Trees {
+	if len(ts) == 0 {
+		return nil
+	}
+	ret := make(Trees, 0, len(ts))
+	for i := 0; i < len(ts); {
+		if !ts[i].HasRunes() {
+			if ts[i].Len() > 0 {
+				ret = append(ret, ts[i])
+			}
+			i++
+			continue
+		}
+		j, n := i, 0
+		for ; j < len(ts) && ts[j].HasRunes() && ts[j].Attrs.Equal(ts[i].Attrs); j++ {
+			n += ts[j].Len()
+		}
+		if j-i == 1 {
+			ret = append(ret, ts[i])
+		} else {
+			attrs := ts[i].Attrs
+			rs := make([]rune, 0, n)
+			for ; i < j; i++ {
+				rs = append(rs, ts[i].Runes()...)
+			}
+			t := text(rs)
+			t.Attrs = attrs
+			ret = append(ret, t)
+		}
+		i = j
+	}
+	if len(ret) == 0 {
+		return nil
+	}
+	return ret
+}
+
+// locate returns the index of the kid of ts that holds position n together
+// with the offset of n within that kid.
+func (ts Trees) locate(n int) (int, int) {
+	for k := range ts {
+		l := ts[k].Len()
+		if n < l {
+			return k, n
+		}
+		n -= l
+	}
+	return len(ts), n
+}
+
+// splitAt splits ts at position n, splitting a text run if necessary. The
+// resulting slices share trees (but not backing arrays) with ts.
+func (ts Trees) splitAt(n int) (Trees, Trees) {
+	k, off := ts.locate(n)
+	if off == 0 || k == len(ts) {
+		return ts[:k:k], ts[k:]
+	}
+	rs, attrs := ts[k].Text, ts[k].Attrs
+	l := make(Trees, k+1)
+	copy(l, ts[:k])
+	l[k] = text(rs[:off])
+	l[k].Attrs = attrs
+	r := make(Trees, len(ts)-k)
+	r[0] = text(rs[off:])
+	r[0].Attrs = attrs
+	copy(r[1:], ts[k+1:])
+	return l, r
+}
+
+// splice returns a packed copy of ts in which the del positions starting at
+// pos have been replaced by ins.
+func (ts Trees) splice(pos, del int, ins Trees) Trees {
+	l, r := ts.splitAt(pos)
+	_, r = r.splitAt(del)
+	ret := make(Trees, 0, len(l)+len(ins)+len(r))
+	ret = append(ret, l...)
+	ret = append(ret, ins...)
+	ret = append(ret, r...)
+	return ret.pack()
+}
+
+// format returns a copy of ts in which the attributes of each kid have been
+// patched with attrs.
+func (ts Trees) format(attrs Attrs) Trees {
+	ret := make(Trees, len(ts))
+	for k, v := range ts {
+		ret[k] = v
+		ret[k].Attrs = v.Attrs.Apply(attrs)
+	}
+	return ret
+}
+
 func (t Tree) SplitAt(n int) (Tree, Tree, error) {
 	switch {
-	// case t.IsLeaf():
-	// 	return t.splitAtLeaf(n)
+	case t.IsText():
+		return t.splitAtText(n)
 	case t.IsBranch():
 		return t.splitAtBranch(n)
 	default:
@@ -148,29 +381,34 @@ func (t Tree) SplitAt(n int) (Tree, Tree, error) {
 	}
 }
 
-// func (t Tree) splitAtLeaf(n int) (Tree, Tree, error) {
-// 	if !t.IsLeaf() || n > len(t.Body) {
-// 		return Tree{}, Tree{}, errors.Errorf("Tree.splitAtLeaf failed, t: %s, n: %d", t.String(), n)
-// 	}
-// 	l, r := CloneRunes(t.Body[:n]), CloneRunes(t.Body[n:])
-// 	return Leaf(l), Leaf(r), nil
-
-// }
+func (t Tree) splitAtText(n int) (Tree, Tree, error) {
+	if !t.IsText() || n < 0 || n > len(t.Text) {
+		return Tree{}, Tree{}, errors.Errorf("Tree.splitAtText failed, t: %s, n: %d", t.String(), n)
+	}
+	l, r := text(t.Text[:n]), text(t.Text[n:])
+	if l.Len() > 0 {
+		l.Attrs = t.Attrs
+	}
+	if r.Len() > 0 {
+		r.Attrs = t.Attrs
+	}
+	return l, r, nil
+}
 
 func (t Tree) splitAtBranch(n int) (Tree, Tree, error) {
-	if !t.IsBranch() || n > len(t.Kids) {
+	if !t.IsBranch() || n > t.Kids.Len() {
 		return Tree{}, Tree{}, errors.Errorf("Tree.splitAtBranch failed, t: %s, n: %d", t.String(), n)
 	}
-	l, r := t.Kids[:n].Clone(), t.Kids[n:].Clone()
-	return Branch(l), Branch(r), nil
+	l, r := t.Kids.splitAt(n)
+	return Branch(l).WithAttrs(t.Attrs).WithType(t.Type), Branch(r).WithAttrs(t.Attrs).WithType(t.Type), nil
 }
 
 func (ts Trees) SplitAt(n int) (Trees, Trees, error) {
-	if n > len(ts) {
+	if n > ts.Len() {
 		return nil, nil, errors.Errorf("Trees.SplitAt failed, t: %s, n: %d", ts.String(), n)
 	}
-	l, r := ts[:n].Clone(), ts[n:].Clone()
-	return l, r, nil
+	l, r := ts.splitAt(n)
+	return l.Clone(), r.Clone(), nil
 }
 
 func (ts *Trees) insertUltimate(t Tree) {
@@ -210,10 +448,14 @@ func (z *Zipper) Skip(n int) {
 	z.ns[len(z.ns)-1] = z.Index() + n
 }
 
+// Current returns the kid of the parent that holds the caret's position
+// (which, for text runs, is the whole run), or the parent when the caret is
+// at the end of the parent's kids.
 func (z *Zipper) Current() *Tree {
 	p, n := z.Parent(), z.Index()
-	if 0 <= n && n < len(p.Kids) {
-		return &z.Parent().Kids[z.Index()]
+	if 0 <= n && n < p.Kids.Len() {
+		k, _ := p.Kids.locate(n)
+		return &p.Kids[k]
 	} else {
 		return z.Parent()
 	}
@@ -237,7 +479,7 @@ func (z *Zipper) Depth() int {
 
 func (z *Zipper) CanSkip(n int) bool {
 	p, n := z.Parent(), z.Index()+n
-	return 0 <= n && n < len(p.Kids)
+	return 0 <= n && n < p.Kids.Len()
 }
 
 func (z *Zipper) HasDown() bool {
@@ -251,21 +493,28 @@ func (z *Zipper) HasDown() bool {
 // Insert inserts t to the right of the current caret but does not move the caret.
 func (z *Zipper) Insert(t Tree) {
 	p, n := z.Parent(), z.Index()
-	ks := p.Kids
-	p.Kids = make([]Tree, len(ks)+1)
-	copy(p.Kids[:n], ks[:n])
-	p.Kids[n] = t
-	copy(p.Kids[n+1:len(p.Kids)], ks[n:])
+	p.Kids = p.Kids.splice(n, 0, Trees{t})
 }
 
 func (z *Zipper) Retain(n int) {
 	z.Skip(n)
 }
 
+// Format patches the attributes of the n kids to the right of the caret with
+// attrs and then moves the caret past them.
+func (z *Zipper) Format(n int, attrs Attrs) {
+	p, i := z.Parent(), z.Index()
+	l, r := p.Kids.splitAt(i)
+	m, r := r.splitAt(n)
+	ret := make(Trees, 0, len(l)+len(m)+len(r))
+	ret = append(ret, l...)
+	ret = append(ret, m.format(attrs)...)
+	ret = append(ret, r...)
+	p.Kids = ret.pack()
+	z.Skip(n)
+}
+
 func (z *Zipper) Delete(n int) {
 	p, i := z.Parent(), z.Index()
-	ks := p.Kids
-	p.Kids = make([]Tree, len(ks)-n)
-	copy(p.Kids[:i], ks[:i])
-	copy(p.Kids[i:len(p.Kids)], ks[i+n:])
+	p.Kids = p.Kids.splice(i, n, nil)
 }
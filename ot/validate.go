@@ -0,0 +1,158 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot
+
+import (
+	"github.com/juju/errors"
+)
+
+// InputLen returns the number of positions that ops expects the branch it
+// modifies to have. With ops count as a single position; their kids are
+// checked against the kid they modify by Validate.
+func InputLen(ops Ops) int {
+	n := 0
+	for _, o := range ops {
+		if o.IsRetain() || o.IsDelete() || o.IsWith() || o.IsMove() {
+			n += o.Len()
+		}
+	}
+	return n
+}
+
+// OutputLen returns the number of positions that the branch modified by ops
+// will have after ops have been applied.
+func OutputLen(ops Ops) int {
+	n := 0
+	for _, o := range ops {
+		if o.IsRetain() || o.IsInsert() || o.IsWith() || o.IsPlace() {
+			n += o.Len()
+		}
+	}
+	return n
+}
+
+// Validate checks that ops are well-formed and that they can be applied to
+// the branch t: that ops span exactly the kids of t, that each With op
+// modifies a branch, recursively, and that each move is paired with exactly
+// one place.
+func Validate(ops Ops, t Tree) error {
+	if !t.IsBranch() {
+		return errors.Errorf("Validate failed, expected branch; ops: %s, t: %s", ops.String(), t.String())
+	}
+	return validate(ops, t.Kids)
+}
+
+func validate(ops Ops, kids kidSeq) error {
+	size := kids.Len()
+	pos := 0
+	pairs := map[moveKey]bool{}
+
+	for i := range ops {
+		o := &ops[i]
+		switch {
+		case o.IsZero():
+			continue
+		case o.IsInsert():
+			if len(o.Kids) != 0 || len(o.Attrs) != 0 {
+				return errors.Errorf("validate failed, malformed insert: %#v", *o)
+			}
+			if err := validateTree(o.Body); err != nil {
+				return errors.Annotatef(err, "validate failed, bad insert at pos: %d", pos)
+			}
+		case o.IsRetain(), o.IsDelete():
+			if len(o.Kids) != 0 || !o.Body.IsZero() || (o.IsDelete() && len(o.Attrs) != 0) {
+				return errors.Errorf("validate failed, malformed op: %#v", *o)
+			}
+			if pos+o.Len() > size {
+				return errors.Errorf("validate failed, op past end; pos: %d, o: %s, len: %d", pos, o.String(), size)
+			}
+			pos += o.Len()
+		case o.IsWith():
+			if o.Size != 0 || !o.Body.IsZero() {
+				return errors.Errorf("validate failed, malformed with: %#v", *o)
+			}
+			if pos >= size {
+				return errors.Errorf("validate failed, with past end; pos: %d, o: %s, len: %d", pos, o.String(), size)
+			}
+			k := kids.slice(pos, pos+1)[0]
+			if !k.IsBranch() {
+				return errors.Errorf("validate failed, with on non-branch; pos: %d, o: %s, kid: %s", pos, o.String(), k.String())
+			}
+			if err := validate(o.Kids, k.Kids); err != nil {
+				return errors.Annotatef(err, "validate failed, bad with at pos: %d", pos)
+			}
+			pos++
+		case o.Tag == O_MOVE:
+			if o.Id <= 0 || o.Size != 0 || !o.Body.IsZero() {
+				return errors.Errorf("validate failed, malformed move: %#v", *o)
+			}
+			if pos >= size {
+				return errors.Errorf("validate failed, move past end; pos: %d, o: %s, len: %d", pos, o.String(), size)
+			}
+			if len(o.Kids) > 0 {
+				k := kids.slice(pos, pos+1)[0]
+				if !k.IsBranch() {
+					return errors.Errorf("validate failed, move modifies non-branch; pos: %d, o: %s, kid: %s", pos, o.String(), k.String())
+				}
+				if err := validate(o.Kids, k.Kids); err != nil {
+					return errors.Annotatef(err, "validate failed, bad move at pos: %d", pos)
+				}
+			}
+			if pairs[moveKey{o.Tag, o.Id}] {
+				return errors.Errorf("validate failed, duplicate move: %s", o.String())
+			}
+			pairs[moveKey{o.Tag, o.Id}] = true
+			pos++
+		case o.Tag == O_PLACE:
+			if o.Id <= 0 || o.Size != 0 || !o.Body.IsZero() || len(o.Kids) != 0 || len(o.Attrs) != 0 {
+				return errors.Errorf("validate failed, malformed place: %#v", *o)
+			}
+			if pairs[moveKey{o.Tag, o.Id}] {
+				return errors.Errorf("validate failed, duplicate place: %s", o.String())
+			}
+			pairs[moveKey{o.Tag, o.Id}] = true
+		default:
+			return errors.Errorf("validate failed, bad op: %#v", *o)
+		}
+	}
+
+	if pos != size {
+		return errors.Errorf("validate failed, ops span %d of %d positions; ops: %s", pos, size, ops.String())
+	}
+	for k := range pairs {
+		other := moveKey{O_MOVE + O_PLACE - k.Tag, k.Id}
+		if !pairs[other] {
+			return errors.Errorf("validate failed, unpaired move or place: %d; ops: %s", k.Id, ops.String())
+		}
+	}
+	return nil
+}
+
+// validateTree checks that t is a well-formed, non-empty tree.
+func validateTree(t Tree) error {
+	switch {
+	case t.Type != "" && !t.IsBranch():
+		return errors.Errorf("validateTree failed, typed non-branch: %#v", t)
+	case t.IsLeaf():
+		if t.Text != nil || t.Kids != nil {
+			return errors.Errorf("validateTree failed, malformed leaf: %#v", t)
+		}
+	case t.IsText():
+		if len(t.Text) == 0 || t.Kids != nil {
+			return errors.Errorf("validateTree failed, malformed text: %#v", t)
+		}
+	case t.IsBranch():
+		if t.Text != nil {
+			return errors.Errorf("validateTree failed, malformed branch: %#v", t)
+		}
+		for _, k := range t.Kids {
+			if err := validateTree(k); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	default:
+		return errors.Errorf("validateTree failed, bad tree: %#v", t)
+	}
+	return nil
+}
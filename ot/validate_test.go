@@ -0,0 +1,79 @@
+// Copyright 2016 Akamai Technologies, Inc.
+// Please see the accompanying LICENSE file for licensing information.
+
+package ot
+
+import (
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	// [a b [c d] e]
+	root := Branch(Trees{Text(AsRunes("ab")), Branch(Trees{Text(AsRunes("cd"))}), Leaf('e')})
+
+	valid := []Ops{
+		C(Rs(4)),
+		C(Is("x"), Rs(4), Is("y")),
+		C(Ds(2), Ws(C(Rs(1), Ds(1), Is("z"))), Rs(1)),
+		C(Rs(2), Ops{Wf(Rs(2), Attrs{"heading": "1"})}, Fs(1, Attrs{"bold": "true"})),
+		C(Zs(), Rs(2), Ops{It(Branch(nil))}, Rs(2), Zs()),
+	}
+	for idx, ops := range valid {
+		if err := Validate(ops, root); err != nil {
+			t.Errorf("valid %d failed; ops: %s, err: %q", idx, ops, err)
+		}
+	}
+
+	invalid := []Ops{
+		nil,
+		C(Rs(3)),
+		C(Rs(5)),
+		C(Rs(3), Ds(2)),
+		C(Ws(nil), Rs(3)),
+		C(Rs(2), Ws(Rs(3)), Rs(1)),
+		C(Rs(2), Ws(Rs(2)), Rs(1), Ws(nil)),
+		C(Rs(4), Ops{Op{Tag: O_INSERT, Body: Tree{Tag: T_BRANCH, Kids: Trees{Zt()}}}}),
+		C(Rs(4), Ops{Op{Tag: O_INSERT, Body: Tree{Tag: T_TEXT, Text: []rune{}}}}),
+		C(Rs(4), Ops{Op{Tag: O_RETAIN}}),
+		C(Rs(4), Ops{Op{Tag: O_DELETE, Size: 1}}),
+		C(Rs(3), Ops{Op{Tag: O_DELETE, Size: -1, Kids: Rs(1)}}),
+		C(Rs(4), Ops{Op{Tag: OpTag(17)}}),
+	}
+	for idx, ops := range invalid {
+		if err := Validate(ops, root); err == nil {
+			t.Errorf("invalid %d failed; expected error for ops: %s", idx, ops)
+		}
+	}
+
+	if err := Validate(Rs(1), Leaf('a')); err == nil {
+		t.Errorf("expected error validating against a leaf")
+	}
+}
+
+func TestInputOutputLen(t *testing.T) {
+	ops := C(Rs(2), Is("abc"), Ds(3), Ws(C(Is("xyz"), Rs(1))), Ops{It(Branch(nil))}, Rs(1))
+	if n := InputLen(ops); n != 7 {
+		t.Errorf("expected InputLen 7, got %d", n)
+	}
+	if n := OutputLen(ops); n != 8 {
+		t.Errorf("expected OutputLen 8, got %d", n)
+	}
+}
+
+func TestRandomValidate(t *testing.T) {
+	d := NewDoc()
+	for i := 0; i < 500; i++ {
+		ops := d.GetRandomOps(1 + i%10)
+		if err := d.Validate(ops); err != nil {
+			t.Fatalf("Validate fail, ops: %s, doc: %s, err: %q", ops, d.String(), err)
+		}
+		if n := InputLen(ops); n != d.Len() {
+			t.Fatalf("InputLen fail, ops: %s, got: %d, expected: %d", ops, n, d.Len())
+		}
+		n := OutputLen(ops)
+		d.Apply(ops)
+		if n != d.Len() {
+			t.Fatalf("OutputLen fail, ops: %s, got: %d, expected: %d", ops, n, d.Len())
+		}
+	}
+}
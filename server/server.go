@@ -18,6 +18,7 @@ import (
 	"github.com/gorilla/websocket"
 
 	"github.com/mstone/focus/internal/server"
+	"github.com/mstone/focus/ot/interop"
 	"github.com/mstone/focus/store"
 )
 
@@ -108,6 +109,17 @@ func (s *Server) configure() error {
 	}
 
 	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		// clients that speak ot.js or ShareJS text0 ops select a codec
+		// with, e.g., /ws?codec=text0
+		var codec interop.Codec
+		if name := r.URL.Query().Get("codec"); name != "" {
+			var err error
+			if codec, err = interop.Lookup(name); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
 		ws, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			log.Error("server unable to upgrade incoming websocket connection", "err", err)
@@ -116,7 +128,11 @@ func (s *Server) configure() error {
 
 		ws2 := WSConn{ws}
 
-		_, err = s.s.Connect(ws2)
+		if codec != nil {
+			_, err = s.s.ConnectCodec(ws2, codec)
+		} else {
+			_, err = s.s.Connect(ws2)
+		}
 		if err != nil {
 			log.Error("server unable to connect new conn", "err", err)
 			return
@@ -14,7 +14,9 @@ import (
 	log "gopkg.in/inconshreveable/log15.v2"
 
 	im "github.com/mstone/focus/internal/msgs"
+	"github.com/mstone/focus/msg"
 	"github.com/mstone/focus/ot"
+	"github.com/mstone/focus/ot/jsonot"
 )
 
 type Store struct {
@@ -44,23 +46,32 @@ func (st *Store) readLoop() {
 		case im.Loaddoc:
 			st.onLoadDoc(v.Reply, v.Name)
 		case im.Storedoc:
-			st.onStoreDoc(v.Reply, v.Name)
+			st.onStoreDoc(v.Reply, v.Name, v.Type)
 		case im.Storewrite:
-			st.onStoreWrite(v.Reply, v.DocId, v.Rev, v.Ops)
+			var ops interface{} = v.Ops
+			if v.Json != nil {
+				ops = v.Json
+			}
+			st.onStoreWrite(v.Reply, v.DocId, v.Rev, v.Site, v.Seq, ops)
 		}
 	}
 }
 
 type loadDoc struct {
-	Ok      bool
-	StoreId int64
-	History []ot.Ops
+	Ok          bool
+	StoreId     int64
+	Type        string
+	History     []ot.Ops
+	JsonHistory []jsonot.Ops
+	Sites       []string
+	Seqs        []int
 }
 
 func (st *Store) onLoadDoc(reply chan im.Loaddocresp, name string) {
 	ldBox, err := transact2(st.db, func(tx *sqlx.Tx) (interface{}, error) {
 		var id int64
-		err := tx.QueryRow("SELECT id FROM document WHERE name = ?", name).Scan(&id)
+		var typ string
+		err := tx.QueryRow("SELECT id, type FROM document WHERE name = ?", name).Scan(&id, &typ)
 		switch {
 		case err == sql.ErrNoRows:
 			return loadDoc{Ok: false}, nil
@@ -68,22 +79,39 @@ func (st *Store) onLoadDoc(reply chan im.Loaddocresp, name string) {
 			log.Error("unable to select document", "name", name, "err", err)
 			return nil, err
 		}
-		rows, err := tx.Query("SELECT body FROM operation WHERE document_id = ? ORDER BY revision_number ASC", id)
+		rows, err := tx.Query("SELECT body, site, seq FROM operation WHERE document_id = ? ORDER BY revision_number ASC", id)
 		if err != nil {
 			log.Error("unable to select document operations", "name", name, "id", id, "err", err)
 			return nil, err
 		}
 		defer rows.Close()
-		ld := loadDoc{}
+		ld := loadDoc{Type: typ}
 		for rows.Next() {
-			var body string
-			err = rows.Scan(&body)
+			var body, site string
+			var seq int
+			err = rows.Scan(&body, &site, &seq)
 			if err != nil {
 				log.Error("unable to scan document operation", "name", name, "id", id, "err", err)
 				return nil, err
 			}
+			ld.Sites = append(ld.Sites, site)
+			ld.Seqs = append(ld.Seqs, seq)
+			if typ == msg.DT_JSON {
+				ops := jsonot.Ops{}
+				err = json.Unmarshal([]byte(body), &ops)
+				if err != nil {
+					log.Error("unable to unmarshal document operation", "name", name, "id", id, "body", body, "err", err)
+					return nil, err
+				}
+				ld.JsonHistory = append(ld.JsonHistory, ops)
+				continue
+			}
 			ops := ot.Ops{}
 			err = json.Unmarshal([]byte(body), &ops)
+			if err != nil {
+				log.Error("unable to unmarshal document operation", "name", name, "id", id, "body", body, "err", err)
+				return nil, err
+			}
 			ld.History = append(ld.History, ops.Clone())
 		}
 		ld.Ok = true
@@ -97,16 +125,20 @@ func (st *Store) onLoadDoc(reply chan im.Loaddocresp, name string) {
 	}
 	ld := ldBox.(loadDoc)
 	reply <- im.Loaddocresp{
-		Err:     nil,
-		Ok:      ld.Ok,
-		StoreId: ld.StoreId,
-		History: ld.History,
+		Err:         nil,
+		Ok:          ld.Ok,
+		StoreId:     ld.StoreId,
+		Type:        ld.Type,
+		History:     ld.History,
+		JsonHistory: ld.JsonHistory,
+		Sites:       ld.Sites,
+		Seqs:        ld.Seqs,
 	}
 }
 
-func (st *Store) onStoreDoc(reply chan im.Storedocresp, name string) {
+func (st *Store) onStoreDoc(reply chan im.Storedocresp, name string, typ string) {
 	idBox, err := transact2(st.db, func(tx *sqlx.Tx) (interface{}, error) {
-		res, err := tx.Exec("INSERT INTO document (id, name) VALUES (?, ?)", nil, name)
+		res, err := tx.Exec("INSERT INTO document (id, name, type) VALUES (?, ?, ?)", nil, name, typ)
 		if err != nil {
 			log.Error("unable to insert store doc", "name", name, "err", err)
 			return nil, err
@@ -125,14 +157,16 @@ func (st *Store) onStoreDoc(reply chan im.Storedocresp, name string) {
 	}
 }
 
-func (st *Store) onStoreWrite(reply chan im.Storewriteresp, docId int64, rev int, ops ot.Ops) {
+// onStoreWrite stores ops, which are either ot.Ops or jsonot.Ops, written by
+// the client with the given site as its write numbered seq.
+func (st *Store) onStoreWrite(reply chan im.Storewriteresp, docId int64, rev int, site string, seq int, ops interface{}) {
 	idBox, err := transact2(st.db, func(tx *sqlx.Tx) (interface{}, error) {
 		opsBytes, err := json.Marshal(ops)
 		if err != nil {
 			log.Error("unable to marshal ops", "ops", ops, "err", err)
 			return nil, err
 		}
-		res, err := tx.Exec("INSERT INTO operation (id, document_id, author_id, revision_number, body) VALUES (?, ?, ?, ?, ?)", nil, docId, nil, rev, string(opsBytes))
+		res, err := tx.Exec("INSERT INTO operation (id, document_id, author_id, revision_number, body, site, seq) VALUES (?, ?, ?, ?, ?, ?, ?)", nil, docId, nil, rev, string(opsBytes), site, seq)
 		if err != nil {
 			log.Error("unable to insert ops", "ops", ops, "err", err)
 			return nil, err
@@ -261,5 +295,38 @@ func (s *Store) Reset() error {
 		})
 		log.Info("store finished migration 1")
 	}
+	if userVersion < 2 {
+		log.Info("store applying migration 2")
+		transact(s.db, func(tx *sqlx.Tx) error {
+			tx.MustExec(`ALTER TABLE document ADD COLUMN type TEXT NOT NULL DEFAULT ''`)
+			tx.MustExec(`
+				PRAGMA user_version = 2;
+				`)
+			return nil
+		})
+		log.Info("store finished migration 2")
+	}
+	if userVersion < 3 {
+		log.Info("store applying migration 3")
+		transact(s.db, func(tx *sqlx.Tx) error {
+			tx.MustExec(`ALTER TABLE operation ADD COLUMN site TEXT NOT NULL DEFAULT ''`)
+			tx.MustExec(`
+				PRAGMA user_version = 3;
+				`)
+			return nil
+		})
+		log.Info("store finished migration 3")
+	}
+	if userVersion < 4 {
+		log.Info("store applying migration 4")
+		transact(s.db, func(tx *sqlx.Tx) error {
+			tx.MustExec(`ALTER TABLE operation ADD COLUMN seq INTEGER NOT NULL DEFAULT 0`)
+			tx.MustExec(`
+				PRAGMA user_version = 4;
+				`)
+			return nil
+		})
+		log.Info("store finished migration 4")
+	}
 	return nil
 }
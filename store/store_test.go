@@ -4,11 +4,17 @@
 package store
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/mattn/go-sqlite3"
 	log "gopkg.in/inconshreveable/log15.v2"
+
+	im "github.com/mstone/focus/internal/msgs"
+	"github.com/mstone/focus/msg"
+	"github.com/mstone/focus/ot"
+	"github.com/mstone/focus/ot/jsonot"
 )
 
 func mkTestStore(t *testing.T) *Store {
@@ -38,3 +44,122 @@ func TestStore(t *testing.T) {
 	// empty
 	log.Info("store test begin")
 }
+
+func TestLoadLegacyOps(t *testing.T) {
+	t.Parallel()
+
+	s := mkTestStore(t)
+
+	repl := make(chan im.Storedocresp, 1)
+	s.Msgs() <- im.Storedoc{Reply: repl, Name: "/legacy"}
+	sd := <-repl
+	if sd.Err != nil {
+		t.Fatalf("unable to store doc, err: %q", sd.Err)
+	}
+
+	legacy := `[{"Tag":1,"Size":0,"Body":{"Tag":1,"Leaf":104,"Kids":null},"Kids":null},{"Tag":1,"Size":0,"Body":{"Tag":1,"Leaf":105,"Kids":null},"Kids":null}]`
+	_, err := s.db.Exec("INSERT INTO operation (id, document_id, author_id, revision_number, body) VALUES (?, ?, ?, ?, ?)", nil, sd.StoreId, nil, 1, legacy)
+	if err != nil {
+		t.Fatalf("unable to insert legacy op, err: %q", err)
+	}
+
+	replw := make(chan im.Storewriteresp, 1)
+	s.Msgs() <- im.Storewrite{Reply: replw, DocId: sd.StoreId, Rev: 2, Site: "a", Seq: 3, Ops: ot.C(ot.Rs(2), ot.Is("!"))}
+	sw := <-replw
+	if sw.Err != nil {
+		t.Fatalf("unable to store write, err: %q", sw.Err)
+	}
+
+	repll := make(chan im.Loaddocresp, 1)
+	s.Msgs() <- im.Loaddoc{Reply: repll, Name: "/legacy"}
+	ld := <-repll
+	if ld.Err != nil || !ld.Ok {
+		t.Fatalf("unable to load doc, ok: %t, err: %q", ld.Ok, ld.Err)
+	}
+
+	expected := []ot.Ops{ot.C(ot.Is("h"), ot.Is("i")), ot.C(ot.Rs(2), ot.Is("!"))}
+	if !reflect.DeepEqual(ld.History, expected) {
+		t.Fatalf("expected history %s, got %s", expected, ld.History)
+	}
+	// legacy ops have no site or seq
+	if sites := []string{"", "a"}; !reflect.DeepEqual(ld.Sites, sites) {
+		t.Fatalf("expected sites %q, got %q", sites, ld.Sites)
+	}
+	if seqs := []int{0, 3}; !reflect.DeepEqual(ld.Seqs, seqs) {
+		t.Fatalf("expected seqs %v, got %v", seqs, ld.Seqs)
+	}
+}
+
+func TestStoreAttrs(t *testing.T) {
+	t.Parallel()
+
+	s := mkTestStore(t)
+
+	repl := make(chan im.Storedocresp, 1)
+	s.Msgs() <- im.Storedoc{Reply: repl, Name: "/attrs"}
+	sd := <-repl
+	if sd.Err != nil {
+		t.Fatalf("unable to store doc, err: %q", sd.Err)
+	}
+
+	history := []ot.Ops{
+		ot.C(ot.Ia("hi", ot.Attrs{"author": "1"})),
+		ot.C(ot.Fs(1, ot.Attrs{"bold": "true"}), ot.Fs(1, ot.Attrs{"author": ""})),
+	}
+	for i, ops := range history {
+		replw := make(chan im.Storewriteresp, 1)
+		s.Msgs() <- im.Storewrite{Reply: replw, DocId: sd.StoreId, Rev: i + 1, Ops: ops}
+		sw := <-replw
+		if sw.Err != nil {
+			t.Fatalf("unable to store write, err: %q", sw.Err)
+		}
+	}
+
+	repll := make(chan im.Loaddocresp, 1)
+	s.Msgs() <- im.Loaddoc{Reply: repll, Name: "/attrs"}
+	ld := <-repll
+	if ld.Err != nil || !ld.Ok {
+		t.Fatalf("unable to load doc, ok: %t, err: %q", ld.Ok, ld.Err)
+	}
+
+	if !reflect.DeepEqual(ld.History, history) {
+		t.Fatalf("expected history %s, got %s", history, ld.History)
+	}
+}
+
+func TestStoreJson(t *testing.T) {
+	t.Parallel()
+
+	s := mkTestStore(t)
+
+	repl := make(chan im.Storedocresp, 1)
+	s.Msgs() <- im.Storedoc{Reply: repl, Name: "/json", Type: msg.DT_JSON}
+	sd := <-repl
+	if sd.Err != nil {
+		t.Fatalf("unable to store doc, err: %q", sd.Err)
+	}
+
+	history := []jsonot.Ops{
+		{jsonot.Set(jsonot.Path{"l"}, []interface{}{"a", 1.0})},
+		{jsonot.Move(jsonot.Path{"l", 0}, 1), jsonot.Text(jsonot.Path{"l", 1}, ot.C(ot.Rs(1), ot.Is("b")))},
+	}
+	for i, ops := range history {
+		replw := make(chan im.Storewriteresp, 1)
+		s.Msgs() <- im.Storewrite{Reply: replw, DocId: sd.StoreId, Rev: i + 1, Json: ops}
+		sw := <-replw
+		if sw.Err != nil {
+			t.Fatalf("unable to store write, err: %q", sw.Err)
+		}
+	}
+
+	repll := make(chan im.Loaddocresp, 1)
+	s.Msgs() <- im.Loaddoc{Reply: repll, Name: "/json"}
+	ld := <-repll
+	if ld.Err != nil || !ld.Ok {
+		t.Fatalf("unable to load doc, ok: %t, err: %q", ld.Ok, ld.Err)
+	}
+
+	if ld.Type != msg.DT_JSON || len(ld.History) != 0 || !reflect.DeepEqual(ld.JsonHistory, history) {
+		t.Fatalf("expected json history %s, got type: %q, history: %s, json history: %s", history, ld.Type, ld.History, ld.JsonHistory)
+	}
+}